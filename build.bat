@@ -11,41 +11,41 @@ echo === Building for Windows ===
 set GOOS=windows
 set GOARCH=386
 echo Building for %GOOS% %GOARCH%...
-go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%.exe"
+go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%.exe" ./cmd/lightlang
 
 set GOARCH=amd64
 echo Building for %GOOS% %GOARCH%...
-go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%.exe"
+go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%.exe" ./cmd/lightlang
 
 set GOARCH=arm64
 echo Building for %GOOS% %GOARCH%...
-go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%.exe"
+go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%.exe" ./cmd/lightlang
 
 echo.
 echo === Building for Linux ===
 set GOOS=linux
 set GOARCH=386
 echo Building for %GOOS% %GOARCH%...
-go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%"
+go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%" ./cmd/lightlang
 
 set GOARCH=amd64
 echo Building for %GOOS% %GOARCH%...
-go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%"
+go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%" ./cmd/lightlang
 
 set GOARCH=arm64
 echo Building for %GOOS% %GOARCH%...
-go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%"
+go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%" ./cmd/lightlang
 
 echo.
 echo === Building for macOS ===
 set GOOS=darwin
 set GOARCH=amd64
 echo Building for %GOOS% %GOARCH%...
-go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%"
+go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%" ./cmd/lightlang
 
 set GOARCH=arm64
 echo Building for %GOOS% %GOARCH%...
-go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%"
+go build -trimpath -o "%OUTPUT_DIR%\%PROJECT_NAME%_%GOOS%_%GOARCH%" ./cmd/lightlang
 
 echo.
 echo =============================
@@ -0,0 +1,234 @@
+package builtins
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AssertionError is a failed assertion, which the test runner reports as
+// a failure rather than as a crash of the test.
+type AssertionError struct {
+	Msg string
+}
+
+func (e *AssertionError) Error() string { return e.Msg }
+
+func assertFail(format string, args ...interface{}) error {
+	return &AssertionError{Msg: fmt.Sprintf(format, args...)}
+}
+
+func valuesEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !valuesEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			other, ok := bv[k]
+			if !ok || !valuesEqual(v, other) {
+				return false
+			}
+		}
+		return true
+	case float64, int, int64:
+		switch b.(type) {
+		case float64, int, int64:
+			return toFloat64(a) == toFloat64(b)
+		}
+		return false
+	}
+	defer func() { recover() }()
+	return a == b
+}
+
+func formatValue(val interface{}) string {
+	if s, ok := val.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	if val == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+// lineDiff marks the lines of want missing from got with "- " and those
+// added with "+ ", keeping the rest with "  ".
+func lineDiff(want, got string) string {
+	a, b := strings.Split(want, "\n"), strings.Split(got, "\n")
+	var sb strings.Builder
+	if len(a)*len(b) > 1<<20 {
+		for _, l := range a {
+			sb.WriteString("- " + l + "\n")
+		}
+		for _, l := range b {
+			sb.WriteString("+ " + l + "\n")
+		}
+		return sb.String()
+	}
+	// lcs[i][j] is the longest common run of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			sb.WriteString("  " + a[i] + "\n")
+			i, j = i+1, j+1
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			sb.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
+
+// mismatch describes how got differs from want: both values for numbers
+// and strings, or a diff of them pretty-printed for arrays and tables.
+func mismatch(got, want interface{}) string {
+	switch want.(type) {
+	case []interface{}, map[string]interface{}:
+		switch got.(type) {
+		case []interface{}, map[string]interface{}:
+			return "values differ (- want, + got)\n" + strings.TrimSuffix(lineDiff(Repr(want, "  "), Repr(got, "  ")), "\n")
+		}
+	}
+	return fmt.Sprintf("expected %s, got %s", formatValue(want), formatValue(got))
+}
+
+// withoutPosition takes off where a runtime error happened, which the
+// parser adds, leaving what went wrong.
+func withoutPosition(err error) error {
+	if _, ok := err.(interface{ Snippet(string) string }); ok && errors.Unwrap(err) != nil {
+		return errors.Unwrap(err)
+	}
+	return err
+}
+
+func assertMessage(args []interface{}, idx int, fallback string) string {
+	if len(args) > idx {
+		return fmt.Sprintf("%v", args[idx])
+	}
+	return fallback
+}
+
+func truthAssert(name string, want bool) BuiltinFunc {
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("%s expects 1 or 2 arguments (value, message)", name)
+		}
+		if Truthy(args[0]) != want {
+			msg := fmt.Sprintf("expected %v, got %s", want, formatValue(args[0]))
+			if len(args) == 2 {
+				msg = fmt.Sprintf("%v: %s", args[1], msg)
+			}
+			return nil, assertFail("%s failed: %s", name, msg)
+		}
+		return nil, nil
+	}
+}
+
+var assertBuiltins = map[string]BuiltinFunc{
+	"assert": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("assert expects 1 or 2 arguments (cond, message)")
+		}
+		cond := args[0]
+		if !Truthy(cond) {
+			return nil, assertFail("%s", assertMessage(args, 1, "assertion failed"))
+		}
+		return nil, nil
+	},
+
+	"assert_eq": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("assert_eq expects 2 or 3 arguments (actual, expected, message)")
+		}
+		if !valuesEqual(args[0], args[1]) {
+			msg := mismatch(args[0], args[1])
+			if len(args) == 3 {
+				msg = fmt.Sprintf("%v: %s", args[2], msg)
+			}
+			return nil, assertFail("assert_eq failed: %s", msg)
+		}
+		return nil, nil
+	},
+
+	"assert_ne": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("assert_ne expects 2 or 3 arguments (actual, unexpected, message)")
+		}
+		if valuesEqual(args[0], args[1]) {
+			msg := fmt.Sprintf("did not expect %s", formatValue(args[0]))
+			if len(args) == 3 {
+				msg = fmt.Sprintf("%v: %s", args[2], msg)
+			}
+			return nil, assertFail("assert_ne failed: %s", msg)
+		}
+		return nil, nil
+	},
+
+	"assert_true":  truthAssert("assert_true", true),
+	"assert_false": truthAssert("assert_false", false),
+
+	// assert_error calls fn and fails unless it stops with an error, whose
+	// message must contain the text given, if any. It returns the message.
+	"assert_error": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) < 1 || len(args) > 2 || !isFunction(args[0]) {
+			return nil, fmt.Errorf("assert_error expects 1 or 2 arguments (function, text)")
+		}
+		if ctx.CallFunction == nil {
+			return nil, fmt.Errorf("assert_error is not available here")
+		}
+		_, err := ctx.CallFunction(args[0], nil)
+		if err == nil {
+			return nil, assertFail("assert_error failed: expected an error")
+		}
+		var exit *ExitError
+		var inner *AssertionError
+		if errors.As(err, &exit) || errors.As(err, &inner) {
+			return nil, err
+		}
+		msg := withoutPosition(err).Error()
+		if len(args) == 2 {
+			want, ok := args[1].(string)
+			if !ok {
+				return nil, fmt.Errorf("assert_error text must be string")
+			}
+			if !strings.Contains(msg, want) {
+				return nil, assertFail("assert_error failed: expected an error containing %q, got %q", want, msg)
+			}
+		}
+		return msg, nil
+	},
+}
+
+func init() {
+	register(assertBuiltins)
+}
@@ -0,0 +1,89 @@
+package builtins
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// benchmarkTime is how long benchmark runs a function unless told
+// otherwise.
+const benchmarkTime = time.Second
+
+var benchmarkBuiltins = map[string]BuiltinFunc{
+	// benchmark calls fn repeatedly, a set number of "iterations" or for
+	// "ms" milliseconds, a second unless given, and reports the time and
+	// the Go allocations each call took on average.
+	"benchmark": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) < 1 || len(args) > 2 || !isFunction(args[0]) {
+			return nil, fmt.Errorf("benchmark expects 1 or 2 arguments (function, options)")
+		}
+		target, iterations := benchmarkTime, 0
+		if len(args) == 2 {
+			opts, ok := args[1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("benchmark options must be table")
+			}
+			if n, ok := opts["iterations"].(float64); ok {
+				if n < 1 {
+					return nil, fmt.Errorf("benchmark iterations must be at least 1")
+				}
+				iterations = int(n)
+			}
+			if ms, ok := opts["ms"].(float64); ok {
+				if ms <= 0 {
+					return nil, fmt.Errorf("benchmark ms must be positive")
+				}
+				target = time.Duration(ms * float64(time.Millisecond))
+			}
+		}
+		if ctx.CallFunction == nil {
+			return nil, fmt.Errorf("benchmark is not available here")
+		}
+		call := func(n int) error {
+			for range n {
+				if _, err := ctx.CallFunction(args[0], nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := call(1); err != nil {
+			return nil, err
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		total := 0
+		var elapsed time.Duration
+		if iterations > 0 {
+			start := time.Now()
+			if err := call(iterations); err != nil {
+				return nil, err
+			}
+			elapsed, total = time.Since(start), iterations
+		} else {
+			// batches double so timing costs little next to the calls
+			for batch := 1; elapsed < target; batch = min(2*batch, 1<<20) {
+				start := time.Now()
+				if err := call(batch); err != nil {
+					return nil, err
+				}
+				elapsed += time.Since(start)
+				total += batch
+			}
+		}
+		runtime.ReadMemStats(&after)
+		n := float64(total)
+		return map[string]interface{}{
+			"iterations":    n,
+			"ns_per_op":     float64(elapsed.Nanoseconds()) / n,
+			"allocs_per_op": float64(after.Mallocs-before.Mallocs) / n,
+			"bytes_per_op":  float64(after.TotalAlloc-before.TotalAlloc) / n,
+			"total_ms":      float64(elapsed.Nanoseconds()) / 1e6,
+		}, nil
+	},
+}
+
+func init() {
+	register(benchmarkBuiltins)
+}
@@ -0,0 +1,113 @@
+package builtins
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// A bigint is a whole number of any size, held as a *big.Int. Arithmetic
+// and comparisons between a bigint and a bigint or a whole number give
+// exact results.
+
+// toBig converts a bigint or a whole number.
+func toBig(val interface{}) (*big.Int, bool) {
+	switch v := val.(type) {
+	case *big.Int:
+		return v, true
+	case float64:
+		if v != math.Trunc(v) || math.IsInf(v, 0) {
+			return nil, false
+		}
+		n, _ := big.NewFloat(v).Int(nil)
+		return n, true
+	}
+	return nil, false
+}
+
+func isBig(a, b interface{}) bool {
+	_, aBig := a.(*big.Int)
+	_, bBig := b.(*big.Int)
+	return aBig || bBig
+}
+
+// bigArith does a op b when a or b is a bigint. Division rounds toward
+// zero.
+func bigArith(op byte, a, b interface{}) (interface{}, bool, error) {
+	if !isBig(a, b) {
+		return nil, false, nil
+	}
+	x, xok := toBig(a)
+	y, yok := toBig(b)
+	if !xok || !yok {
+		return nil, true, fmt.Errorf("cannot use %s %c %s, a bigint needs a bigint or a whole number", Repr(a, ""), op, Repr(b, ""))
+	}
+	r := new(big.Int)
+	switch op {
+	case '+':
+		r.Add(x, y)
+	case '-':
+		r.Sub(x, y)
+	case '*':
+		r.Mul(x, y)
+	case '/':
+		if y.Sign() == 0 {
+			return nil, true, fmt.Errorf("div by zero")
+		}
+		r.Quo(x, y)
+	default:
+		return nil, false, nil
+	}
+	return r, true, nil
+}
+
+// bigCompare compares a and b when one is a bigint and the other a bigint
+// or a number.
+func bigCompare(a, b interface{}) (int, bool) {
+	if !isBig(a, b) {
+		return 0, false
+	}
+	x, xok := bigFloat(a)
+	y, yok := bigFloat(b)
+	if !xok || !yok {
+		return 0, false
+	}
+	return x.Cmp(y), true
+}
+
+func bigFloat(val interface{}) (*big.Float, bool) {
+	switch v := val.(type) {
+	case *big.Int:
+		return new(big.Float).SetInt(v), true
+	case float64:
+		if math.IsNaN(v) {
+			return nil, false
+		}
+		return big.NewFloat(v), true
+	}
+	return nil, false
+}
+
+var bigintBuiltins = map[string]BuiltinFunc{
+	"bigint": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("bigint expects 1 argument (string or whole number)")
+		}
+		if s, ok := args[0].(string); ok {
+			n, ok := new(big.Int).SetString(s, 0)
+			if !ok {
+				return nil, fmt.Errorf("bigint: invalid number %q", s)
+			}
+			return n, nil
+		}
+		n, ok := toBig(args[0])
+		if !ok {
+			return nil, fmt.Errorf("bigint requires a string or a whole number, got %s", Repr(args[0], ""))
+		}
+		return n, nil
+	},
+}
+
+func init() {
+	register(bigintBuiltins)
+}
@@ -1,18 +1,51 @@
 package builtins
 
 import (
-	"bufio"
 	"fmt"
 	"io/ioutil"
 	"math"
+	"math/big"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
-type BuiltinFunc func(args []interface{}) (interface{}, error)
+type BuiltinFunc func(ctx *Context, args []interface{}) (interface{}, error)
+
+// ExitError stops the VM and asks the host to exit the process with Code.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func exitBuiltin(name string) BuiltinFunc {
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		code := 0
+		if len(args) == 1 {
+			c, ok := args[0].(float64)
+			if !ok {
+				return nil, fmt.Errorf("%s code must be number", name)
+			}
+			code = int(c)
+		} else if len(args) > 1 {
+			return nil, fmt.Errorf("%s expects 0 or 1 argument (code)", name)
+		}
+		return nil, &ExitError{Code: code}
+	}
+}
+
+func register(funcs map[string]BuiltinFunc) {
+	for name, fn := range funcs {
+		Builtins[name] = fn
+	}
+}
 
 func toFloat64(val interface{}) float64 {
 	if v, ok := val.(float64); ok {
@@ -30,43 +63,68 @@ func toFloat64(val interface{}) float64 {
 	return 0.0
 }
 
+// Truthy is the test of if, while, not, and and or: nil, false, 0 and ""
+// are false and every other value, empty arrays and tables included, is
+// true.
+func Truthy(val interface{}) bool {
+	switch v := val.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64, int, int64, int32:
+		return toFloat64(v) != 0
+	}
+	return true
+}
+
 var Builtins = map[string]BuiltinFunc{
-	"print": func(args []interface{}) (interface{}, error) {
-		fmt.Print(fmt.Sprintln(args...))
+	"print": func(ctx *Context, args []interface{}) (interface{}, error) {
+		parts := make([]string, len(args))
+		for i, arg := range args {
+			s, err := ctx.Display(arg)
+			if err != nil {
+				return nil, err
+			}
+			parts[i] = s
+		}
+		fmt.Fprintln(ctx.Stdout, strings.Join(parts, " "))
 		return nil, nil
 	},
 
-	"input": func(args []interface{}) (interface{}, error) {
+	"exit": exitBuiltin("exit"),
+
+	"input": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) > 1 {
 			return nil, fmt.Errorf("input expects 0 or 1 argument (prompt)")
 		}
 
 		if len(args) == 1 {
 			if prompt, ok := args[0].(string); ok {
-				fmt.Print(prompt)
+				fmt.Fprint(ctx.Stdout, prompt)
 			} else {
 				return nil, fmt.Errorf("input prompt must be string")
 			}
 		}
 
-		reader := bufio.NewReader(os.Stdin)
-		text, err := reader.ReadString('\n')
+		text, err := readInput(ctx.Stdin)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read input: %v", err)
 		}
 
-		text = strings.TrimSuffix(text, "\n")
 		text = strings.TrimSuffix(text, "\r")
 
 		return text, nil
 	},
 
-	"args": func(args []interface{}) (interface{}, error) {
+	"args": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) > 0 {
 			return nil, fmt.Errorf("args expects 0 arguments")
 		}
 
-		cmdArgs := os.Args[1:]
+		cmdArgs := scriptArgs()[1:]
 		result := make([]interface{}, len(cmdArgs))
 		for i, arg := range cmdArgs {
 			result[i] = arg
@@ -75,7 +133,7 @@ var Builtins = map[string]BuiltinFunc{
 		return result, nil
 	},
 
-	"range": func(args []interface{}) (interface{}, error) {
+	"range": func(ctx *Context, args []interface{}) (interface{}, error) {
 		switch len(args) {
 		case 1:
 			end := int(toFloat64(args[0]))
@@ -121,7 +179,7 @@ var Builtins = map[string]BuiltinFunc{
 		}
 	},
 
-	"pairs": func(args []interface{}) (interface{}, error) {
+	"pairs": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("pairs expects 1 argument")
 		}
@@ -134,6 +192,12 @@ var Builtins = map[string]BuiltinFunc{
 				pairs = append(pairs, pair)
 			}
 			return pairs, nil
+		case *OrderedTable:
+			pairs := make([]interface{}, 0, v.Len())
+			for _, key := range v.Keys() {
+				pairs = append(pairs, []interface{}{key, v.Get(key)})
+			}
+			return pairs, nil
 		case []interface{}:
 			pairs := make([]interface{}, len(v))
 			for i, val := range v {
@@ -145,7 +209,7 @@ var Builtins = map[string]BuiltinFunc{
 		}
 	},
 
-	"ipairs": func(args []interface{}) (interface{}, error) {
+	"ipairs": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("ipairs expects 1 argument")
 		}
@@ -162,7 +226,7 @@ var Builtins = map[string]BuiltinFunc{
 		}
 	},
 
-	"len": func(args []interface{}) (interface{}, error) {
+	"len": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("len expects 1 argument")
 		}
@@ -172,20 +236,22 @@ var Builtins = map[string]BuiltinFunc{
 		case map[string]interface{}:
 			return float64(len(v)), nil
 		case string:
-			return float64(len(v)), nil
+			return float64(utf8.RuneCountInString(v)), nil
+		case interface{ Len() int }:
+			return float64(v.Len()), nil
 		default:
 			return nil, fmt.Errorf("len invalid type")
 		}
 	},
 
-	"type": func(args []interface{}) (interface{}, error) {
+	"type": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("type expects 1 argument")
 		}
 		return fmt.Sprintf("%T", args[0]), nil
 	},
 
-	"push": func(args []interface{}) (interface{}, error) {
+	"push": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 2 {
 			return nil, fmt.Errorf("push expects 2 arguments (table, value)")
 		}
@@ -197,7 +263,7 @@ var Builtins = map[string]BuiltinFunc{
 		}
 	},
 
-	"sqrt": func(args []interface{}) (interface{}, error) {
+	"sqrt": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("sqrt expects 1 argument")
 		}
@@ -207,7 +273,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("sqrt requires number")
 	},
 
-	"abs": func(args []interface{}) (interface{}, error) {
+	"abs": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("abs expects 1 argument")
 		}
@@ -217,7 +283,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("abs requires number")
 	},
 
-	"pow": func(args []interface{}) (interface{}, error) {
+	"pow": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 2 {
 			return nil, fmt.Errorf("pow expects 2 arguments (base, exponent)")
 		}
@@ -229,7 +295,7 @@ var Builtins = map[string]BuiltinFunc{
 		return math.Pow(base, exp), nil
 	},
 
-	"sin": func(args []interface{}) (interface{}, error) {
+	"sin": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("sin expects 1 argument")
 		}
@@ -239,7 +305,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("sin requires number")
 	},
 
-	"cos": func(args []interface{}) (interface{}, error) {
+	"cos": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("cos expects 1 argument")
 		}
@@ -249,7 +315,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("cos requires number")
 	},
 
-	"tan": func(args []interface{}) (interface{}, error) {
+	"tan": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("tan expects 1 argument")
 		}
@@ -259,7 +325,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("tan requires number")
 	},
 
-	"log": func(args []interface{}) (interface{}, error) {
+	"log": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("log expects 1 argument")
 		}
@@ -269,7 +335,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("log requires number")
 	},
 
-	"exp": func(args []interface{}) (interface{}, error) {
+	"exp": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("exp expects 1 argument")
 		}
@@ -279,7 +345,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("exp requires number")
 	},
 
-	"floor": func(args []interface{}) (interface{}, error) {
+	"floor": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("floor expects 1 argument")
 		}
@@ -289,7 +355,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("floor requires number")
 	},
 
-	"clamp": func(args []interface{}) (interface{}, error) {
+	"clamp": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 3 {
 			return nil, fmt.Errorf("clamp expects 3 arguments (value, min, max)")
 		}
@@ -310,7 +376,7 @@ var Builtins = map[string]BuiltinFunc{
 		return val, nil
 	},
 
-	"lerp": func(args []interface{}) (interface{}, error) {
+	"lerp": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 3 {
 			return nil, fmt.Errorf("lerp expects 3 arguments (a, b, t)")
 		}
@@ -325,7 +391,7 @@ var Builtins = map[string]BuiltinFunc{
 		return a + t*(b-a), nil
 	},
 
-	"ceil": func(args []interface{}) (interface{}, error) {
+	"ceil": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("ceil expects 1 argument")
 		}
@@ -335,7 +401,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("ceil requires number")
 	},
 
-	"round": func(args []interface{}) (interface{}, error) {
+	"round": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("round expects 1 argument")
 		}
@@ -345,7 +411,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("round requires number")
 	},
 
-	"max": func(args []interface{}) (interface{}, error) {
+	"max": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) < 1 {
 			return nil, fmt.Errorf("max expects at least 1 argument")
 		}
@@ -362,7 +428,7 @@ var Builtins = map[string]BuiltinFunc{
 		return maxVal, nil
 	},
 
-	"min": func(args []interface{}) (interface{}, error) {
+	"min": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) < 1 {
 			return nil, fmt.Errorf("min expects at least 1 argument")
 		}
@@ -379,7 +445,7 @@ var Builtins = map[string]BuiltinFunc{
 		return minVal, nil
 	},
 
-	"substr": func(args []interface{}) (interface{}, error) {
+	"substr": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 3 {
 			return nil, fmt.Errorf("substr expects 3 arguments (string, start, length)")
 		}
@@ -390,26 +456,46 @@ var Builtins = map[string]BuiltinFunc{
 			return nil, fmt.Errorf("substr requires (string, number, number)")
 		}
 
+		runes := []rune(str)
 		s := int(start)
 		l := int(length)
-		if s < 0 || s >= len(str) || l < 0 {
+		if s < 0 || s >= len(runes) || l < 0 {
 			return "", nil
 		}
-		if s+l > len(str) {
-			l = len(str) - s
+		if s+l > len(runes) {
+			l = len(runes) - s
 		}
-		return str[s : s+l], nil
+		return string(runes[s : s+l]), nil
 	},
 
-	"concat": func(args []interface{}) (interface{}, error) {
+	"concat": func(ctx *Context, args []interface{}) (interface{}, error) {
 		result := ""
 		for _, arg := range args {
-			result += fmt.Sprintf("%v", arg)
+			s, err := ctx.Display(arg)
+			if err != nil {
+				return nil, err
+			}
+			result += s
 		}
 		return result, nil
 	},
 
-	"upper": func(args []interface{}) (interface{}, error) {
+	"bytes_of": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("bytes_of expects 1 argument (string)")
+		}
+		str, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("bytes_of requires a string")
+		}
+		bytes := make([]interface{}, len(str))
+		for i := 0; i < len(str); i++ {
+			bytes[i] = float64(str[i])
+		}
+		return bytes, nil
+	},
+
+	"upper": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("upper expects 1 argument")
 		}
@@ -419,7 +505,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("upper requires string")
 	},
 
-	"lower": func(args []interface{}) (interface{}, error) {
+	"lower": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("lower expects 1 argument")
 		}
@@ -429,7 +515,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("lower requires string")
 	},
 
-	"split": func(args []interface{}) (interface{}, error) {
+	"split": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 && len(args) != 2 {
 			return nil, fmt.Errorf("split expects 1 or 2 arguments")
 		}
@@ -452,20 +538,23 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("split requires string")
 	},
 
-	"find": func(args []interface{}) (interface{}, error) {
+	"find": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 2 {
 			return nil, fmt.Errorf("find expects 2 arguments (string, substring)")
 		}
 		if s, ok1 := args[0].(string); ok1 {
 			if sub, ok2 := args[1].(string); ok2 {
 				index := strings.Index(s, sub)
+				if index > 0 {
+					index = utf8.RuneCountInString(s[:index])
+				}
 				return float64(index), nil
 			}
 		}
 		return nil, fmt.Errorf("find requires strings")
 	},
 
-	"replace": func(args []interface{}) (interface{}, error) {
+	"replace": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 3 {
 			return nil, fmt.Errorf("replace expects 3 arguments (string, old, new)")
 		}
@@ -479,7 +568,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("replace requires strings")
 	},
 
-	"pop": func(args []interface{}) (interface{}, error) {
+	"pop": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("pop expects 1 argument (array)")
 		}
@@ -494,7 +583,7 @@ var Builtins = map[string]BuiltinFunc{
 		}
 	},
 
-	"keys": func(args []interface{}) (interface{}, error) {
+	"keys": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("keys expects 1 argument")
 		}
@@ -505,12 +594,18 @@ var Builtins = map[string]BuiltinFunc{
 				keys = append(keys, k)
 			}
 			return keys, nil
+		case *OrderedTable:
+			keys := make([]interface{}, 0, m.Len())
+			for _, k := range m.Keys() {
+				keys = append(keys, k)
+			}
+			return keys, nil
 		default:
 			return nil, fmt.Errorf("keys requires map")
 		}
 	},
 
-	"tick": func(args []interface{}) (interface{}, error) {
+	"tick": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 0 {
 			return nil, fmt.Errorf("tick expects 0 arguments")
 		}
@@ -518,14 +613,14 @@ var Builtins = map[string]BuiltinFunc{
 		return float64(now.Unix()) + float64(now.Nanosecond())/1e9, nil
 	},
 
-	"time": func(args []interface{}) (interface{}, error) {
+	"time": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 0 {
 			return nil, fmt.Errorf("time expects 0 arguments")
 		}
 		return float64(time.Now().Unix()), nil
 	},
 
-	"date": func(args []interface{}) (interface{}, error) {
+	"date": func(ctx *Context, args []interface{}) (interface{}, error) {
 		now := time.Now()
 		if len(args) == 0 {
 			return map[string]interface{}{
@@ -568,7 +663,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, fmt.Errorf("date expects 0 or 1 argument")
 	},
 
-	"wait": func(args []interface{}) (interface{}, error) {
+	"wait": func(ctx *Context, args []interface{}) (interface{}, error) {
 		var seconds float64 = 0
 		if len(args) == 1 {
 			if s, ok := args[0].(float64); ok {
@@ -581,11 +676,15 @@ var Builtins = map[string]BuiltinFunc{
 		}
 
 		duration := time.Duration(seconds * float64(time.Second))
-		time.Sleep(duration)
+		if ctx.Sleep == nil {
+			time.Sleep(duration)
+		} else if err := ctx.Sleep(duration); err != nil {
+			return nil, err
+		}
 		return seconds, nil
 	},
 
-	"random": func(args []interface{}) (interface{}, error) {
+	"random": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) > 2 {
 			return nil, fmt.Errorf("random expects 0, 1, or 2 arguments")
 		}
@@ -615,14 +714,21 @@ var Builtins = map[string]BuiltinFunc{
 		return min + float64(rand.Intn(int(max-min))), nil
 	},
 
-	"tostring": func(args []interface{}) (interface{}, error) {
+	"tostring": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("tostring() expects 1 argument")
 		}
-		return fmt.Sprintf("%v", args[0]), nil
+		return ctx.Display(args[0])
 	},
 
-	"tonumber": func(args []interface{}) (interface{}, error) {
+	"bool": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("bool() expects 1 argument")
+		}
+		return Truthy(args[0]), nil
+	},
+
+	"tonumber": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("tonumber() expects 1 argument")
 		}
@@ -636,11 +742,16 @@ var Builtins = map[string]BuiltinFunc{
 				return nil, fmt.Errorf("cannot convert string to number")
 			}
 			return f, nil
+		case *big.Int:
+			f, _ := new(big.Float).SetInt(v).Float64()
+			return f, nil
+		case *Decimal:
+			return strconv.ParseFloat(v.String(), 64)
 		default:
 			return nil, fmt.Errorf("cannot convert to number")
 		}
 	},
-	"writefile": func(args []interface{}) (interface{}, error) {
+	"writefile": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 2 {
 			return nil, fmt.Errorf("writefile expects 2 arguments (filename, content)")
 		}
@@ -649,8 +760,14 @@ var Builtins = map[string]BuiltinFunc{
 		if !ok1 {
 			return nil, fmt.Errorf("writefile filename must be string")
 		}
+		if err := ctx.checkWrite("writefile", filename); err != nil {
+			return nil, err
+		}
 
-		content := fmt.Sprintf("%v", args[1])
+		content, err := ctx.Display(args[1])
+		if err != nil {
+			return nil, err
+		}
 
 		dir := filepath.Dir(filename)
 		if dir != "" && dir != "." {
@@ -659,15 +776,14 @@ var Builtins = map[string]BuiltinFunc{
 			}
 		}
 
-		err := ioutil.WriteFile(filename, []byte(content), 0644)
-		if err != nil {
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
 			return nil, fmt.Errorf("failed to write file: %v", err)
 		}
 
 		return nil, nil
 	},
 
-	"readfile": func(args []interface{}) (interface{}, error) {
+	"readfile": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("readfile expects 1 argument (filename)")
 		}
@@ -676,6 +792,9 @@ var Builtins = map[string]BuiltinFunc{
 		if !ok {
 			return nil, fmt.Errorf("readfile filename must be string")
 		}
+		if err := ctx.checkRead("readfile", filename); err != nil {
+			return nil, err
+		}
 
 		data, err := ioutil.ReadFile(filename)
 		if err != nil {
@@ -685,7 +804,7 @@ var Builtins = map[string]BuiltinFunc{
 		return string(data), nil
 	},
 
-	"makedir": func(args []interface{}) (interface{}, error) {
+	"makedir": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("makedir expects 1 argument (dirname)")
 		}
@@ -694,6 +813,9 @@ var Builtins = map[string]BuiltinFunc{
 		if !ok {
 			return nil, fmt.Errorf("makedir dirname must be string")
 		}
+		if err := ctx.checkWrite("makedir", dirname); err != nil {
+			return nil, err
+		}
 
 		err := os.MkdirAll(dirname, 0755)
 		if err != nil {
@@ -703,7 +825,7 @@ var Builtins = map[string]BuiltinFunc{
 		return nil, nil
 	},
 
-	"gotodir": func(args []interface{}) (interface{}, error) {
+	"gotodir": func(ctx *Context, args []interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("gotodir expects 1 argument (dirname)")
 		}
@@ -712,6 +834,9 @@ var Builtins = map[string]BuiltinFunc{
 		if !ok {
 			return nil, fmt.Errorf("gotodir dirname must be string")
 		}
+		if err := ctx.checkRead("gotodir", dirname); err != nil {
+			return nil, err
+		}
 
 		info, err := os.Stat(dirname)
 		if err != nil {
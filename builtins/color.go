@@ -0,0 +1,102 @@
+package builtins
+
+import (
+	"fmt"
+	"lightlang/term"
+	"os"
+)
+
+// colorOn tells whether the color builtins style text: when stdout is a
+// terminal and $NO_COLOR is not set, or when $FORCE_COLOR is.
+func (c *Context) colorOn() bool {
+	if _, ok := os.LookupEnv("FORCE_COLOR"); ok {
+		return true
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	f, ok := c.Stdout.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// style wraps text in an escape sequence that turns a style on and the one
+// that turns only that style off, so styles nest.
+func (c *Context) style(on, off string, val interface{}) (interface{}, error) {
+	s, err := c.Display(val)
+	if err != nil || !c.colorOn() {
+		return s, err
+	}
+	return "\x1b[" + on + "m" + s + "\x1b[" + off + "m", nil
+}
+
+var colorNames = map[string]int{
+	"black": 0, "red": 1, "green": 2, "yellow": 3, "blue": 4, "magenta": 5, "cyan": 6, "white": 7,
+}
+
+// colorCode gives the sequence for a color named in colorNames, or "gray",
+// or numbered 0 to 255, as a foreground or with base 40 a background.
+func colorCode(name string, val interface{}, base int) (string, error) {
+	switch v := val.(type) {
+	case string:
+		if v == "gray" {
+			return fmt.Sprint(base + 60), nil
+		}
+		if n, ok := colorNames[v]; ok {
+			return fmt.Sprint(base + n), nil
+		}
+		return "", fmt.Errorf("%s: unknown color %q", name, v)
+	case float64:
+		if v < 0 || v > 255 || v != float64(int(v)) {
+			return "", fmt.Errorf("%s color must be 0 to 255", name)
+		}
+		return fmt.Sprintf("%d;5;%d", base+8, int(v)), nil
+	}
+	return "", fmt.Errorf("%s color must be name or number", name)
+}
+
+func styleBuiltin(name, on, off string) BuiltinFunc {
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument (text)", name)
+		}
+		return ctx.style(on, off, args[0])
+	}
+}
+
+// paintBuiltin makes color.fg and color.bg, which take a color and text.
+func paintBuiltin(name string, base int) BuiltinFunc {
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s expects 2 arguments (color, text)", name)
+		}
+		code, err := colorCode(name, args[0], base)
+		if err != nil {
+			return nil, err
+		}
+		return ctx.style(code, fmt.Sprint(base+9), args[1])
+	}
+}
+
+var colorBuiltins = map[string]BuiltinFunc{
+	"color.fg":        paintBuiltin("color.fg", 30),
+	"color.bg":        paintBuiltin("color.bg", 40),
+	"color.bold":      styleBuiltin("color.bold", "1", "22"),
+	"color.dim":       styleBuiltin("color.dim", "2", "22"),
+	"color.italic":    styleBuiltin("color.italic", "3", "23"),
+	"color.underline": styleBuiltin("color.underline", "4", "24"),
+
+	"color.enabled": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("color.enabled expects 0 arguments")
+		}
+		return ctx.colorOn(), nil
+	},
+}
+
+func init() {
+	for name, n := range colorNames {
+		colorBuiltins["color."+name] = styleBuiltin("color."+name, fmt.Sprint(30+n), "39")
+	}
+	colorBuiltins["color.gray"] = styleBuiltin("color.gray", "90", "39")
+	register(colorBuiltins)
+}
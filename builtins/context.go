@@ -0,0 +1,95 @@
+package builtins
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Context is what builtins see of the VM that runs them. Each VM passes its
+// own, so VMs with different output or permissions can run side by side.
+type Context struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Perms limits the IO builtins when set. It is nil for the test runner,
+	// the repl and embedders that do not set it, which allows everything.
+	Perms *Permissions
+	// Files holds files bundled with the program. Reading a path looks
+	// there first.
+	Files fs.FS
+	// Log sets the level and format of the log builtins, or when nil
+	// DefaultLogger does.
+	Log *Logger
+	// Snapshots is set by the test runner for assert_snapshot.
+	Snapshots *Snapshots
+	// CallFunction invokes a lightlang function value from inside a builtin.
+	CallFunction func(fn interface{}, args []interface{}) (interface{}, error)
+	// Stats reports the counters of the VM for vmstats.
+	Stats func() VMStats
+	// Values counts the values the program can still reach, for memstats.
+	Values func() ValueCounts
+	// Eval compiles code into the running program and runs it; Load
+	// compiles it into a function without running it.
+	Eval, Load func(code string) (interface{}, error)
+	// AtExit registers a function to call when the program ends, either a
+	// lightlang function or a Go func() error.
+	AtExit func(fn interface{})
+	// OnSignal registers a function to call when the process gets the
+	// signal called name, such as "INT".
+	OnSignal func(name string, fn interface{}) error
+	// SetTimer schedules fn to run after ms milliseconds, and every ms
+	// after that if repeat is set, and returns an id for CancelTimer.
+	SetTimer    func(fn interface{}, ms float64, repeat bool) int
+	CancelTimer func(id int) bool
+	// Sleep waits for d, stopping early with an error when the run is
+	// cancelled or reaches its time limit. Without it wait sleeps plainly.
+	Sleep func(d time.Duration) error
+}
+
+// readInput reads up to a newline one byte at a time, so nothing after the
+// line is taken from r.
+func readInput(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF && len(line) > 0 {
+			return string(line), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// open opens a bundled file, or else the one at path on disk.
+func (c *Context) open(path string) (io.ReadCloser, error) {
+	if c.Files != nil {
+		name := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "./")
+		if fs.ValidPath(name) {
+			if f, err := c.Files.Open(name); err == nil {
+				return f, nil
+			}
+		}
+	}
+	return os.Open(path)
+}
+
+func (c *Context) readFile(path string) ([]byte, error) {
+	f, err := c.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
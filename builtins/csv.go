@@ -0,0 +1,241 @@
+package builtins
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type csvOptions struct {
+	delimiter rune
+	header    bool
+	columns   []string
+}
+
+func parseCSVOptions(name string, val interface{}) (csvOptions, error) {
+	opts := csvOptions{delimiter: ','}
+	if val == nil {
+		return opts, nil
+	}
+	tbl, ok := val.(map[string]interface{})
+	if !ok {
+		return opts, fmt.Errorf("%s options must be table", name)
+	}
+	if d, ok := tbl["delimiter"]; ok {
+		s, ok := d.(string)
+		if !ok || utf8.RuneCountInString(s) != 1 {
+			return opts, fmt.Errorf("%s delimiter must be a single character", name)
+		}
+		opts.delimiter, _ = utf8.DecodeRuneInString(s)
+	}
+	switch h := tbl["header"].(type) {
+	case nil:
+	case bool:
+		opts.header = h
+	case []interface{}:
+		opts.header = true
+		for _, c := range h {
+			opts.columns = append(opts.columns, fmt.Sprintf("%v", c))
+		}
+	default:
+		return opts, fmt.Errorf("%s header must be bool or array", name)
+	}
+	return opts, nil
+}
+
+func readCSV(r io.Reader, opts csvOptions) ([]interface{}, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = opts.delimiter
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse csv: %v", err)
+	}
+
+	if !opts.header {
+		rows := make([]interface{}, len(records))
+		for i, rec := range records {
+			row := make([]interface{}, len(rec))
+			for j, field := range rec {
+				row[j] = field
+			}
+			rows[i] = row
+		}
+		return rows, nil
+	}
+
+	// given columns name the fields of every record, else the first
+	// record does
+	header := opts.columns
+	if header == nil {
+		if len(records) == 0 {
+			return []interface{}{}, nil
+		}
+		header, records = records[0], records[1:]
+	}
+	rows := make([]interface{}, 0, len(records))
+	for _, rec := range records {
+		row := make(map[string]interface{}, len(header))
+		for j, col := range header {
+			if j < len(rec) {
+				row[col] = rec[j]
+			} else {
+				row[col] = nil
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// csvField is how csv_write writes a value: nil as an empty field, numbers
+// in full without an exponent and anything else as print shows it.
+func csvField(ctx *Context, val interface{}) (string, error) {
+	switch v := val.(type) {
+	case nil:
+		return "", nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return ctx.Display(val)
+}
+
+var csvBuiltins = map[string]BuiltinFunc{
+	"csv_parse": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("csv_parse expects 1 or 2 arguments (string, options)")
+		}
+		str, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("csv_parse requires string")
+		}
+		var optVal interface{}
+		if len(args) == 2 {
+			optVal = args[1]
+		}
+		opts, err := parseCSVOptions("csv_parse", optVal)
+		if err != nil {
+			return nil, err
+		}
+		return readCSV(strings.NewReader(str), opts)
+	},
+
+	"csv_read": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("csv_read expects 1 or 2 arguments (path, options)")
+		}
+		path, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("csv_read path must be string")
+		}
+		if err := ctx.checkRead("csv_read", path); err != nil {
+			return nil, err
+		}
+		var optVal interface{}
+		if len(args) == 2 {
+			optVal = args[1]
+		}
+		opts, err := parseCSVOptions("csv_read", optVal)
+		if err != nil {
+			return nil, err
+		}
+		f, err := ctx.open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %v", err)
+		}
+		defer f.Close()
+		return readCSV(f, opts)
+	},
+
+	"csv_write": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("csv_write expects 2 or 3 arguments (path, rows, options)")
+		}
+		path, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("csv_write path must be string")
+		}
+		if err := ctx.checkWrite("csv_write", path); err != nil {
+			return nil, err
+		}
+		rows, ok := args[1].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("csv_write rows must be array")
+		}
+		var optVal interface{}
+		if len(args) == 3 {
+			optVal = args[2]
+		}
+		opts, err := parseCSVOptions("csv_write", optVal)
+		if err != nil {
+			return nil, err
+		}
+
+		columns := opts.columns
+		if opts.header && columns == nil && len(rows) > 0 {
+			if first, ok := rows[0].(map[string]interface{}); ok {
+				for key := range first {
+					columns = append(columns, key)
+				}
+				sort.Strings(columns)
+			}
+		}
+
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create file: %v", err)
+		}
+		defer f.Close()
+
+		writer := csv.NewWriter(f)
+		writer.Comma = opts.delimiter
+		if opts.header && columns != nil {
+			if err := writer.Write(columns); err != nil {
+				return nil, fmt.Errorf("failed to write csv: %v", err)
+			}
+		}
+		for _, row := range rows {
+			var record []string
+			switch r := row.(type) {
+			case []interface{}:
+				for _, field := range r {
+					s, err := csvField(ctx, field)
+					if err != nil {
+						return nil, err
+					}
+					record = append(record, s)
+				}
+			case map[string]interface{}:
+				if columns == nil {
+					return nil, fmt.Errorf("csv_write table rows require a header")
+				}
+				for _, col := range columns {
+					s, err := csvField(ctx, r[col])
+					if err != nil {
+						return nil, err
+					}
+					record = append(record, s)
+				}
+			default:
+				return nil, fmt.Errorf("csv_write rows must be arrays or tables")
+			}
+			if err := writer.Write(record); err != nil {
+				return nil, fmt.Errorf("failed to write csv: %v", err)
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return nil, fmt.Errorf("failed to write csv: %v", err)
+		}
+		return float64(len(rows)), nil
+	},
+}
+
+func init() {
+	register(csvBuiltins)
+}
@@ -0,0 +1,271 @@
+package builtins
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal is an exact decimal number, n / 10^scale. Adding, subtracting
+// and multiplying decimals never rounds; dividing rounds to a number of
+// places.
+type Decimal struct {
+	n     *big.Int
+	scale int
+}
+
+// DivisionPlaces is how many places after the point / keeps when it
+// divides decimals. decimal.div chooses its own.
+const DivisionPlaces = 28
+
+func (d *Decimal) String() string {
+	digits := new(big.Int).Abs(d.n).String()
+	sign := ""
+	if d.n.Sign() < 0 {
+		sign = "-"
+	}
+	if d.scale == 0 {
+		return sign + digits
+	}
+	if len(digits) <= d.scale {
+		digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+	}
+	cut := len(digits) - d.scale
+	return sign + digits[:cut] + "." + digits[cut:]
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// rescale gives the digits of d with scale places after the point, which
+// must be at least d.scale.
+func (d *Decimal) rescale(scale int) *big.Int {
+	if scale == d.scale {
+		return d.n
+	}
+	return new(big.Int).Mul(d.n, pow10(scale-d.scale))
+}
+
+// parseDecimal reads numbers like 12, -0.05 and 1.5e3.
+func parseDecimal(s string) (*Decimal, bool) {
+	mant, exp := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return nil, false
+		}
+		mant, exp = s[:i], e
+	}
+	whole, frac, _ := strings.Cut(mant, ".")
+	digits := whole + frac
+	if strings.TrimLeft(digits, "+-") == "" || strings.ContainsAny(frac, "+-") {
+		return nil, false
+	}
+	n, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return nil, false
+	}
+	d := &Decimal{n: n, scale: len(frac) - exp}
+	if d.scale < 0 {
+		d = &Decimal{n: d.rescale(0), scale: 0}
+	}
+	return d, true
+}
+
+// toDecimal converts a decimal, a bigint or a number. Numbers are taken as
+// the shortest decimal that reads back as them, so 0.1 is 0.1.
+func toDecimal(val interface{}) (*Decimal, bool) {
+	switch v := val.(type) {
+	case *Decimal:
+		return v, true
+	case *big.Int:
+		return &Decimal{n: v}, true
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, false
+		}
+		return parseDecimal(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return nil, false
+}
+
+func isDecimal(a, b interface{}) bool {
+	_, aDec := a.(*Decimal)
+	_, bDec := b.(*Decimal)
+	return aDec || bDec
+}
+
+// roundingModes round the quotient q of num / den, whose remainder is r,
+// to a whole number. half compares twice the remainder with the divisor.
+var roundingModes = map[string]func(q *big.Int, r, den *big.Int) bool{
+	"half_even": func(q, r, den *big.Int) bool {
+		c := half(r, den)
+		return c > 0 || c == 0 && q.Bit(0) == 1
+	},
+	"half_up":   func(q, r, den *big.Int) bool { return half(r, den) >= 0 },
+	"half_down": func(q, r, den *big.Int) bool { return half(r, den) > 0 },
+	"up":        func(q, r, den *big.Int) bool { return true },
+	"down":      func(q, r, den *big.Int) bool { return false },
+	"ceil":      func(q, r, den *big.Int) bool { return r.Sign()*den.Sign() > 0 },
+	"floor":     func(q, r, den *big.Int) bool { return r.Sign()*den.Sign() < 0 },
+}
+
+func half(r, den *big.Int) int {
+	twice := new(big.Int).Lsh(new(big.Int).Abs(r), 1)
+	return twice.Cmp(new(big.Int).Abs(den))
+}
+
+// divide gives num / den rounded to places after the point. A mode says
+// whether to move a result that is not exact away from zero.
+func divide(num, den *big.Int, places int, mode string) (*Decimal, error) {
+	away, ok := roundingModes[mode]
+	if !ok {
+		return nil, fmt.Errorf("unknown rounding mode %q", mode)
+	}
+	if den.Sign() == 0 {
+		return nil, fmt.Errorf("div by zero")
+	}
+	q, r := new(big.Int).QuoRem(new(big.Int).Mul(num, pow10(places)), den, new(big.Int))
+	if r.Sign() != 0 && away(q, r, den) {
+		if num.Sign()*den.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return &Decimal{n: q, scale: places}, nil
+}
+
+// trim drops zeros after the point, keeping at least scale places.
+func (d *Decimal) trim(scale int) *Decimal {
+	n, s := new(big.Int).Set(d.n), d.scale
+	ten, r := big.NewInt(10), new(big.Int)
+	for s > scale {
+		q, _ := new(big.Int).QuoRem(n, ten, r)
+		if r.Sign() != 0 {
+			break
+		}
+		n, s = q, s-1
+	}
+	return &Decimal{n: n, scale: s}
+}
+
+func decimalDiv(x, y *Decimal, places int, mode string) (*Decimal, error) {
+	return divide(x.rescale(max(x.scale, y.scale)), y.rescale(max(x.scale, y.scale)), places, mode)
+}
+
+// decimalArith does a op b when a or b is a decimal. / keeps
+// DivisionPlaces places, without the zeros at the end.
+func decimalArith(op byte, a, b interface{}) (interface{}, bool, error) {
+	x, xok := toDecimal(a)
+	y, yok := toDecimal(b)
+	if !xok || !yok {
+		return nil, true, fmt.Errorf("cannot use %s %c %s, a decimal needs a decimal or a number", Repr(a, ""), op, Repr(b, ""))
+	}
+	scale := max(x.scale, y.scale)
+	switch op {
+	case '+':
+		return &Decimal{n: new(big.Int).Add(x.rescale(scale), y.rescale(scale)), scale: scale}, true, nil
+	case '-':
+		return &Decimal{n: new(big.Int).Sub(x.rescale(scale), y.rescale(scale)), scale: scale}, true, nil
+	case '*':
+		return &Decimal{n: new(big.Int).Mul(x.n, y.n), scale: x.scale + y.scale}, true, nil
+	case '/':
+		d, err := decimalDiv(x, y, DivisionPlaces, "half_even")
+		if err != nil {
+			return nil, true, err
+		}
+		return d.trim(scale), true, nil
+	}
+	return nil, false, nil
+}
+
+func decimalCompare(a, b interface{}) (int, bool) {
+	x, xok := toDecimal(a)
+	y, yok := toDecimal(b)
+	if !xok || !yok {
+		return 0, false
+	}
+	scale := max(x.scale, y.scale)
+	return x.rescale(scale).Cmp(y.rescale(scale)), true
+}
+
+// decimalArg converts argument i of a decimal builtin.
+func decimalArg(name string, args []interface{}, i int) (*Decimal, error) {
+	if s, ok := args[i].(string); ok {
+		if d, ok := parseDecimal(s); ok {
+			return d, nil
+		}
+		return nil, fmt.Errorf("%s: invalid decimal %q", name, s)
+	}
+	if d, ok := toDecimal(args[i]); ok {
+		return d, nil
+	}
+	return nil, fmt.Errorf("%s requires a decimal, a string or a number, got %s", name, Repr(args[i], ""))
+}
+
+// roundingArgs reads the places and optional mode that follow the numbers
+// of decimal.div and decimal.round.
+func roundingArgs(name string, args []interface{}) (int, string, error) {
+	places, ok := args[0].(float64)
+	if !ok || places < 0 || places != math.Trunc(places) {
+		return 0, "", fmt.Errorf("%s places must be a whole number of at least 0", name)
+	}
+	mode := "half_even"
+	if len(args) > 1 {
+		if mode, ok = args[1].(string); !ok {
+			return 0, "", fmt.Errorf("%s mode must be string", name)
+		}
+	}
+	return int(places), mode, nil
+}
+
+var decimalBuiltins = map[string]BuiltinFunc{
+	"decimal": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("decimal expects 1 argument (string or number)")
+		}
+		return decimalArg("decimal", args, 0)
+	},
+
+	"decimal.div": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) < 3 || len(args) > 4 {
+			return nil, fmt.Errorf("decimal.div expects 3 or 4 arguments (a, b, places, mode)")
+		}
+		x, err := decimalArg("decimal.div", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decimalArg("decimal.div", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		places, mode, err := roundingArgs("decimal.div", args[2:])
+		if err != nil {
+			return nil, err
+		}
+		return decimalDiv(x, y, places, mode)
+	},
+
+	"decimal.round": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) < 2 || len(args) > 3 {
+			return nil, fmt.Errorf("decimal.round expects 2 or 3 arguments (d, places, mode)")
+		}
+		d, err := decimalArg("decimal.round", args, 0)
+		if err != nil {
+			return nil, err
+		}
+		places, mode, err := roundingArgs("decimal.round", args[1:])
+		if err != nil {
+			return nil, err
+		}
+		return divide(d.n, pow10(d.scale), places, mode)
+	},
+}
+
+func init() {
+	register(decimalBuiltins)
+}
@@ -0,0 +1,146 @@
+package builtins
+
+import "fmt"
+
+// Deque is a double-ended queue on a ring buffer, so adding and removing
+// at either end does not move the other elements.
+type Deque struct {
+	buf  []interface{}
+	head int
+	n    int
+}
+
+func (d *Deque) Len() int { return d.n }
+
+func (d *Deque) String() string { return fmt.Sprintf("<queue of %d>", d.n) }
+
+// grow doubles the buffer when it is full, unwrapping the elements to
+// the start of it.
+func (d *Deque) grow() {
+	if d.n < len(d.buf) {
+		return
+	}
+	buf := make([]interface{}, max(2*len(d.buf), 8))
+	for i := range d.n {
+		buf[i] = d.buf[(d.head+i)%len(d.buf)]
+	}
+	d.buf, d.head = buf, 0
+}
+
+func (d *Deque) PushBack(x interface{}) {
+	d.grow()
+	d.buf[(d.head+d.n)%len(d.buf)] = x
+	d.n++
+}
+
+func (d *Deque) PushFront(x interface{}) {
+	d.grow()
+	d.head = (d.head - 1 + len(d.buf)) % len(d.buf)
+	d.buf[d.head] = x
+	d.n++
+}
+
+func (d *Deque) PopFront() interface{} {
+	if d.n == 0 {
+		return nil
+	}
+	x := d.buf[d.head]
+	d.buf[d.head] = nil
+	d.head = (d.head + 1) % len(d.buf)
+	d.n--
+	return x
+}
+
+func (d *Deque) PopBack() interface{} {
+	if d.n == 0 {
+		return nil
+	}
+	i := (d.head + d.n - 1) % len(d.buf)
+	x := d.buf[i]
+	d.buf[i] = nil
+	d.n--
+	return x
+}
+
+func (d *Deque) Front() interface{} {
+	if d.n == 0 {
+		return nil
+	}
+	return d.buf[d.head]
+}
+
+func (d *Deque) Back() interface{} {
+	if d.n == 0 {
+		return nil
+	}
+	return d.buf[(d.head+d.n-1)%len(d.buf)]
+}
+
+func toDeque(name string, val interface{}) (*Deque, error) {
+	d, ok := val.(*Deque)
+	if !ok {
+		return nil, fmt.Errorf("%s requires queue", name)
+	}
+	return d, nil
+}
+
+// dequeAdd makes push_back and push_front, which return the queue.
+func dequeAdd(name string, add func(d *Deque, x interface{})) BuiltinFunc {
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s expects 2 arguments (queue, value)", name)
+		}
+		d, err := toDeque(name, args[0])
+		if err != nil {
+			return nil, err
+		}
+		add(d, args[1])
+		return d, nil
+	}
+}
+
+// dequeTake makes the builtins that take or look at an end of a queue,
+// giving nil when it is empty.
+func dequeTake(name string, take func(d *Deque) interface{}) BuiltinFunc {
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument (queue)", name)
+		}
+		d, err := toDeque(name, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return take(d), nil
+	}
+}
+
+var dequeBuiltins = map[string]BuiltinFunc{
+	// queue makes an empty queue, or one holding the elements of an array.
+	"queue": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) > 1 {
+			return nil, fmt.Errorf("queue expects 0 or 1 argument (array)")
+		}
+		d := &Deque{}
+		if len(args) == 1 {
+			arr, ok := args[0].([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("queue requires array")
+			}
+			for _, x := range arr {
+				d.PushBack(x)
+			}
+		}
+		return d, nil
+	},
+
+	"push_back":  dequeAdd("push_back", (*Deque).PushBack),
+	"push_front": dequeAdd("push_front", (*Deque).PushFront),
+	"pop_front":  dequeTake("pop_front", (*Deque).PopFront),
+	"pop_back":   dequeTake("pop_back", (*Deque).PopBack),
+	"peek_front": dequeTake("peek_front", (*Deque).Front),
+	"peek_back":  dequeTake("peek_back", (*Deque).Back),
+}
+
+func init() {
+	register(dequeBuiltins)
+}
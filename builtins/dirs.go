@@ -0,0 +1,188 @@
+package builtins
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// entryTable describes a file for listdir, walk and stat: its name, path,
+// type ("file", "dir", "link" or "other"), size in bytes and the time it
+// was modified, in seconds.
+func entryTable(path string, info fs.FileInfo) map[string]interface{} {
+	typ := "other"
+	switch mode := info.Mode(); {
+	case mode.IsRegular():
+		typ = "file"
+	case mode.IsDir():
+		typ = "dir"
+	case mode&fs.ModeSymlink != 0:
+		typ = "link"
+	}
+	return map[string]interface{}{
+		"name":     info.Name(),
+		"path":     path,
+		"type":     typ,
+		"size":     float64(info.Size()),
+		"modified": float64(info.ModTime().Unix()),
+	}
+}
+
+func stringArgs(name, want string, args []interface{}, n int) ([]string, error) {
+	if len(args) != n {
+		return nil, fmt.Errorf("%s expects %d argument(s) (%s)", name, n, want)
+	}
+	out := make([]string, n)
+	for i, arg := range args {
+		s, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s %s must be string", name, want)
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+var dirBuiltins = map[string]BuiltinFunc{
+	// listdir describes the entries of a directory, sorted by name.
+	"listdir": func(ctx *Context, args []interface{}) (interface{}, error) {
+		p, err := stringArgs("listdir", "path", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.checkRead("listdir", p[0]); err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(p[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to list directory: %v", err)
+		}
+		result := make([]interface{}, 0, len(entries))
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			result = append(result, entryTable(filepath.Join(p[0], e.Name()), info))
+		}
+		return result, nil
+	},
+
+	// walk calls fn with a description of each file and directory under
+	// path, in lexical order. When fn returns false for a directory, walk
+	// does not go into it.
+	"walk": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 || !isFunction(args[1]) {
+			return nil, fmt.Errorf("walk expects 2 arguments (path, function)")
+		}
+		root, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("walk path must be string")
+		}
+		if err := ctx.checkRead("walk", root); err != nil {
+			return nil, err
+		}
+		if ctx.CallFunction == nil {
+			return nil, fmt.Errorf("walk is not available here")
+		}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path == root {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			res, err := ctx.CallFunction(args[1], []interface{}{entryTable(path, info)})
+			if err != nil {
+				return err
+			}
+			if res == false && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		return nil, err
+	},
+
+	"stat": func(ctx *Context, args []interface{}) (interface{}, error) {
+		p, err := stringArgs("stat", "path", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.checkRead("stat", p[0]); err != nil {
+			return nil, err
+		}
+		info, err := os.Lstat(p[0])
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat: %v", err)
+		}
+		return entryTable(p[0], info), nil
+	},
+
+	// mkdir makes a directory and any parents it needs.
+	"mkdir": func(ctx *Context, args []interface{}) (interface{}, error) {
+		p, err := stringArgs("mkdir", "path", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.checkWrite("mkdir", p[0]); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(p[0], 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory: %v", err)
+		}
+		return nil, nil
+	},
+
+	// remove deletes a file or an empty directory, or with true as second
+	// argument a directory and everything in it.
+	"remove": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("remove expects 1 or 2 arguments (path, recursive)")
+		}
+		p, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("remove path must be string")
+		}
+		if err := ctx.checkWrite("remove", p); err != nil {
+			return nil, err
+		}
+		remove := os.Remove
+		if len(args) == 2 && Truthy(args[1]) {
+			remove = os.RemoveAll
+		}
+		if err := remove(p); err != nil {
+			return nil, fmt.Errorf("failed to remove: %v", err)
+		}
+		return nil, nil
+	},
+
+	"rename": func(ctx *Context, args []interface{}) (interface{}, error) {
+		p, err := stringArgs("rename", "paths", args, 2)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range p {
+			if err := ctx.checkWrite("rename", path); err != nil {
+				return nil, err
+			}
+		}
+		if err := os.Rename(p[0], p[1]); err != nil {
+			return nil, fmt.Errorf("failed to rename: %v", err)
+		}
+		return nil, nil
+	},
+}
+
+func init() {
+	register(dirBuiltins)
+}
@@ -0,0 +1,40 @@
+package builtins
+
+import "fmt"
+
+// codeArg checks the argument and the permission of eval and load.
+func codeArg(ctx *Context, name string, args []interface{}) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("%s expects 1 argument (code)", name)
+	}
+	code, ok := args[0].(string)
+	if !ok {
+		return "", fmt.Errorf("%s code must be string", name)
+	}
+	if ctx.Eval == nil || ctx.Load == nil {
+		return "", fmt.Errorf("%s is not available here", name)
+	}
+	return code, ctx.checkEval(name)
+}
+
+var evalBuiltins = map[string]BuiltinFunc{
+	"eval": func(ctx *Context, args []interface{}) (interface{}, error) {
+		code, err := codeArg(ctx, "eval", args)
+		if err != nil {
+			return nil, err
+		}
+		return ctx.Eval(code)
+	},
+
+	"load": func(ctx *Context, args []interface{}) (interface{}, error) {
+		code, err := codeArg(ctx, "load", args)
+		if err != nil {
+			return nil, err
+		}
+		return ctx.Load(code)
+	},
+}
+
+func init() {
+	register(evalBuiltins)
+}
@@ -0,0 +1,99 @@
+package builtins
+
+import "fmt"
+
+// An emitter is a table whose __handlers field maps event names to the
+// arrays of functions listening to them.
+
+func emitterArg(name string, args []interface{}, min int) (map[string]interface{}, string, error) {
+	if len(args) < min {
+		return nil, "", fmt.Errorf("%s expects at least %d arguments", name, min)
+	}
+	e, ok := args[0].(map[string]interface{})
+	if !ok {
+		return nil, "", fmt.Errorf("%s requires an emitter", name)
+	}
+	handlers, ok := e["__handlers"].(map[string]interface{})
+	if !ok {
+		return nil, "", fmt.Errorf("%s requires an emitter", name)
+	}
+	event, ok := args[1].(string)
+	if !ok {
+		return nil, "", fmt.Errorf("%s event name must be string", name)
+	}
+	return handlers, event, nil
+}
+
+func sameFunction(a, b interface{}) bool {
+	fa, ok1 := a.(map[string]interface{})
+	fb, ok2 := b.(map[string]interface{})
+	return ok1 && ok2 && isFunction(fa) && isFunction(fb) && fa["entry"] == fb["entry"]
+}
+
+var eventBuiltins = map[string]BuiltinFunc{
+	"events.emitter": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("events.emitter expects no arguments")
+		}
+		return map[string]interface{}{"__handlers": map[string]interface{}{}}, nil
+	},
+
+	"events.on": func(ctx *Context, args []interface{}) (interface{}, error) {
+		handlers, event, err := emitterArg("events.on", args, 3)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) != 3 || !isFunction(args[2]) {
+			return nil, fmt.Errorf("events.on expects 3 arguments (emitter, event, function)")
+		}
+		list, _ := handlers[event].([]interface{})
+		handlers[event] = append(list, args[2])
+		return nil, nil
+	},
+
+	"events.off": func(ctx *Context, args []interface{}) (interface{}, error) {
+		handlers, event, err := emitterArg("events.off", args, 2)
+		if err != nil {
+			return nil, err
+		}
+		switch len(args) {
+		case 2:
+			delete(handlers, event)
+		case 3:
+			list, _ := handlers[event].([]interface{})
+			kept := make([]interface{}, 0, len(list))
+			for _, fn := range list {
+				if !sameFunction(fn, args[2]) {
+					kept = append(kept, fn)
+				}
+			}
+			handlers[event] = kept
+		default:
+			return nil, fmt.Errorf("events.off expects 2 or 3 arguments (emitter, event, function)")
+		}
+		return nil, nil
+	},
+
+	"events.emit": func(ctx *Context, args []interface{}) (interface{}, error) {
+		handlers, event, err := emitterArg("events.emit", args, 2)
+		if err != nil {
+			return nil, err
+		}
+		if ctx.CallFunction == nil {
+			return nil, fmt.Errorf("events.emit is not available here")
+		}
+		// handlers added or removed by a handler take effect next time
+		list, _ := handlers[event].([]interface{})
+		list = append([]interface{}(nil), list...)
+		for _, fn := range list {
+			if _, err := ctx.CallFunction(fn, args[2:]); err != nil {
+				return nil, err
+			}
+		}
+		return float64(len(list)), nil
+	},
+}
+
+func init() {
+	register(eventBuiltins)
+}
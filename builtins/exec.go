@@ -0,0 +1,79 @@
+package builtins
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+func buildCommand(ctx *Context, name string, args []interface{}) (*exec.Cmd, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("%s expects 1 or 2 arguments (cmd, args)", name)
+	}
+	cmdName, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("%s cmd must be string", name)
+	}
+	if err := ctx.checkRun(name); err != nil {
+		return nil, err
+	}
+	var cmdArgs []string
+	if len(args) == 2 {
+		arr, ok := args[1].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s args must be array", name)
+		}
+		for _, a := range arr {
+			cmdArgs = append(cmdArgs, fmt.Sprintf("%v", a))
+		}
+	}
+	return exec.Command(cmdName, cmdArgs...), nil
+}
+
+func exitCode(err error) (float64, error) {
+	if err == nil {
+		return 0, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return float64(exitErr.ExitCode()), nil
+	}
+	return 0, fmt.Errorf("failed to run command: %v", err)
+}
+
+var execBuiltins = map[string]BuiltinFunc{
+	"exec": func(ctx *Context, args []interface{}) (interface{}, error) {
+		cmd, err := buildCommand(ctx, "exec", args)
+		if err != nil {
+			return nil, err
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		code, err := exitCode(cmd.Run())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"stdout": stdout.String(),
+			"stderr": stderr.String(),
+			"code":   code,
+		}, nil
+	},
+
+	"exec_stream": func(ctx *Context, args []interface{}) (interface{}, error) {
+		cmd, err := buildCommand(ctx, "exec_stream", args)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stdin = ctx.Stdin
+		cmd.Stdout = ctx.Stdout
+		cmd.Stderr = ctx.Stderr
+		return exitCode(cmd.Run())
+	},
+}
+
+func init() {
+	register(execBuiltins)
+}
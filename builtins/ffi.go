@@ -0,0 +1,356 @@
+//go:build cgo && unix && (amd64 || arm64)
+
+package builtins
+
+/*
+#cgo linux LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+// Integer and pointer arguments go in the integer registers and doubles in
+// the float ones, each in order, so a function of up to 6 of the first and
+// 8 of the second can be called through one of these.
+typedef uintptr_t (*ffi_int_fn)(uintptr_t, uintptr_t, uintptr_t, uintptr_t, uintptr_t, uintptr_t,
+	double, double, double, double, double, double, double, double);
+typedef double (*ffi_double_fn)(uintptr_t, uintptr_t, uintptr_t, uintptr_t, uintptr_t, uintptr_t,
+	double, double, double, double, double, double, double, double);
+
+static uintptr_t ffi_call_int(void *fn, uintptr_t *i, double *d) {
+	return ((ffi_int_fn)fn)(i[0], i[1], i[2], i[3], i[4], i[5], d[0], d[1], d[2], d[3], d[4], d[5], d[6], d[7]);
+}
+
+static double ffi_call_double(void *fn, uintptr_t *i, double *d) {
+	return ((ffi_double_fn)fn)(i[0], i[1], i[2], i[3], i[4], i[5], d[0], d[1], d[2], d[3], d[4], d[5], d[6], d[7]);
+}
+
+// dlerror is per thread, so it is read in the same call as dlopen.
+static void *ffi_dlopen(const char *path, const char **err) {
+	void *handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+	if (handle == NULL) {
+		*err = dlerror();
+	}
+	return handle;
+}
+
+static char *ffi_string(uintptr_t p) {
+	return (char *)p;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+const (
+	ffiMaxInts    = 6
+	ffiMaxDoubles = 8
+)
+
+// FFILibrary is a shared library opened by ffi_open.
+type FFILibrary struct {
+	Path   string
+	handle unsafe.Pointer
+	mu     sync.Mutex
+	closed bool
+}
+
+// FFIFunc is a function of a library declared by ffi_func.
+type FFIFunc struct {
+	Name   string
+	lib    *FFILibrary
+	fn     unsafe.Pointer
+	ret    string
+	params []string
+}
+
+// FFIBuffer is C memory made by ffi_buffer, for functions that fill in
+// or read a buffer. It is freed once unreachable.
+type FFIBuffer struct {
+	ptr  unsafe.Pointer
+	size int
+}
+
+func newFFIBuffer(size int) *FFIBuffer {
+	b := &FFIBuffer{ptr: C.calloc(C.size_t(max(size, 1)), 1), size: size}
+	runtime.SetFinalizer(b, func(b *FFIBuffer) { C.free(b.ptr) })
+	return b
+}
+
+func (b *FFIBuffer) bytes() []byte {
+	return unsafe.Slice((*byte)(b.ptr), b.size)
+}
+
+func ffiType(name, t string, param bool) error {
+	switch t {
+	case "int", "int64", "double", "pointer", "string":
+		return nil
+	case "void":
+		if !param {
+			return nil
+		}
+	case "buffer":
+		if param {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: unknown type %q, use int, int64, double, pointer, string, buffer or void", name, t)
+}
+
+// call marshals args to registers, calls the function and converts what
+// it returns.
+func (f *FFIFunc) call(args []interface{}) (interface{}, error) {
+	if len(args) != len(f.params) {
+		return nil, fmt.Errorf("ffi_call: %s expects %d arguments, got %d", f.Name, len(f.params), len(args))
+	}
+	var ints [ffiMaxInts]C.uintptr_t
+	var doubles [ffiMaxDoubles]C.double
+	ni, nd := 0, 0
+	var keep []*FFIBuffer
+	for i, t := range f.params {
+		arg := args[i]
+		if t == "double" {
+			n, ok := arg.(float64)
+			if !ok {
+				return nil, fmt.Errorf("ffi_call: argument %d of %s must be number", i+1, f.Name)
+			}
+			doubles[nd] = C.double(n)
+			nd++
+			continue
+		}
+		var word uintptr
+		switch t {
+		case "int", "int64":
+			n, ok := arg.(float64)
+			if !ok {
+				return nil, fmt.Errorf("ffi_call: argument %d of %s must be number", i+1, f.Name)
+			}
+			word = uintptr(int64(n))
+		case "string":
+			s, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("ffi_call: argument %d of %s must be string", i+1, f.Name)
+			}
+			cs := C.CString(s)
+			defer C.free(unsafe.Pointer(cs))
+			word = uintptr(unsafe.Pointer(cs))
+		case "buffer":
+			b, ok := arg.(*FFIBuffer)
+			if !ok {
+				return nil, fmt.Errorf("ffi_call: argument %d of %s must be a buffer from ffi_buffer", i+1, f.Name)
+			}
+			keep = append(keep, b)
+			word = uintptr(b.ptr)
+		case "pointer":
+			switch p := arg.(type) {
+			case nil:
+			case float64:
+				word = uintptr(p)
+			case *FFIBuffer:
+				keep = append(keep, p)
+				word = uintptr(p.ptr)
+			default:
+				return nil, fmt.Errorf("ffi_call: argument %d of %s must be a number, buffer or nil", i+1, f.Name)
+			}
+		}
+		ints[ni] = C.uintptr_t(word)
+		ni++
+	}
+
+	defer runtime.KeepAlive(keep)
+	f.lib.mu.Lock()
+	defer f.lib.mu.Unlock()
+	if f.lib.closed {
+		return nil, fmt.Errorf("ffi_call: %s is from closed library '%s'", f.Name, f.lib.Path)
+	}
+	if f.ret == "double" {
+		return float64(C.ffi_call_double(f.fn, &ints[0], &doubles[0])), nil
+	}
+	word := C.ffi_call_int(f.fn, &ints[0], &doubles[0])
+	switch f.ret {
+	case "int":
+		return float64(int32(word)), nil
+	case "int64":
+		return float64(int64(word)), nil
+	case "pointer":
+		return float64(word), nil
+	case "string":
+		if word == 0 {
+			return nil, nil
+		}
+		return C.GoString(C.ffi_string(word)), nil
+	}
+	return nil, nil
+}
+
+func toFFILibrary(name string, val interface{}) (*FFILibrary, error) {
+	lib, ok := val.(*FFILibrary)
+	if !ok {
+		return nil, fmt.Errorf("%s requires library from ffi_open", name)
+	}
+	return lib, nil
+}
+
+func toFFIBuffer(name string, val interface{}) (*FFIBuffer, error) {
+	b, ok := val.(*FFIBuffer)
+	if !ok {
+		return nil, fmt.Errorf("%s requires buffer from ffi_buffer", name)
+	}
+	return b, nil
+}
+
+var ffiBuiltins = map[string]BuiltinFunc{
+	"ffi_open": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("ffi_open expects 1 argument (path)")
+		}
+		path, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("ffi_open path must be string")
+		}
+		if err := ctx.checkFFI("ffi_open"); err != nil {
+			return nil, err
+		}
+		cpath := C.CString(path)
+		defer C.free(unsafe.Pointer(cpath))
+		var cerr *C.char
+		handle := C.ffi_dlopen(cpath, &cerr)
+		if handle == nil {
+			return nil, fmt.Errorf("ffi_open: %s", C.GoString(cerr))
+		}
+		return &FFILibrary{Path: path, handle: handle}, nil
+	},
+
+	"ffi_func": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 3 && len(args) != 4 {
+			return nil, fmt.Errorf("ffi_func expects 3 or 4 arguments (library, name, return type, parameter types)")
+		}
+		lib, err := toFFILibrary("ffi_func", args[0])
+		if err != nil {
+			return nil, err
+		}
+		name, ok := args[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("ffi_func name must be string")
+		}
+		ret, ok := args[2].(string)
+		if !ok {
+			return nil, fmt.Errorf("ffi_func return type must be string")
+		}
+		if err := ffiType("ffi_func", ret, false); err != nil {
+			return nil, err
+		}
+		var params []string
+		if len(args) == 4 {
+			list, ok := args[3].([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("ffi_func parameter types must be array")
+			}
+			ints, doubles := 0, 0
+			for _, p := range list {
+				t, ok := p.(string)
+				if !ok {
+					return nil, fmt.Errorf("ffi_func parameter types must be strings")
+				}
+				if err := ffiType("ffi_func", t, true); err != nil {
+					return nil, err
+				}
+				if t == "double" {
+					doubles++
+				} else {
+					ints++
+				}
+				params = append(params, t)
+			}
+			if ints > ffiMaxInts || doubles > ffiMaxDoubles {
+				return nil, fmt.Errorf("ffi_func: %s takes too many arguments, at most %d doubles and %d others", name, ffiMaxDoubles, ffiMaxInts)
+			}
+		}
+
+		lib.mu.Lock()
+		defer lib.mu.Unlock()
+		if lib.closed {
+			return nil, fmt.Errorf("ffi_func on closed library '%s'", lib.Path)
+		}
+		cname := C.CString(name)
+		defer C.free(unsafe.Pointer(cname))
+		fn := C.dlsym(lib.handle, cname)
+		if fn == nil {
+			return nil, fmt.Errorf("ffi_func: no function %s in '%s'", name, lib.Path)
+		}
+		return &FFIFunc{Name: name, lib: lib, fn: fn, ret: ret, params: params}, nil
+	},
+
+	"ffi_call": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("ffi_call expects at least 1 argument (function, args...)")
+		}
+		f, ok := args[0].(*FFIFunc)
+		if !ok {
+			return nil, fmt.Errorf("ffi_call requires function from ffi_func")
+		}
+		return f.call(args[1:])
+	},
+
+	"ffi_close": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("ffi_close expects 1 argument (library)")
+		}
+		lib, err := toFFILibrary("ffi_close", args[0])
+		if err != nil {
+			return nil, err
+		}
+		lib.mu.Lock()
+		defer lib.mu.Unlock()
+		if !lib.closed {
+			lib.closed = true
+			C.dlclose(lib.handle)
+		}
+		return nil, nil
+	},
+
+	"ffi_buffer": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("ffi_buffer expects 1 argument (size or data)")
+		}
+		switch x := args[0].(type) {
+		case float64:
+			if x < 0 || x != float64(int(x)) {
+				return nil, fmt.Errorf("ffi_buffer size must be a whole number")
+			}
+			return newFFIBuffer(int(x)), nil
+		case string:
+			b := newFFIBuffer(len(x))
+			copy(b.bytes(), x)
+			return b, nil
+		}
+		return nil, fmt.Errorf("ffi_buffer expects a size or a string")
+	},
+
+	"ffi_read": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("ffi_read expects 1 or 2 arguments (buffer, length)")
+		}
+		b, err := toFFIBuffer("ffi_read", args[0])
+		if err != nil {
+			return nil, err
+		}
+		data := b.bytes()
+		if len(args) == 2 {
+			n, ok := args[1].(float64)
+			if !ok || n < 0 || int(n) > len(data) {
+				return nil, fmt.Errorf("ffi_read length must be a number from 0 to %d", len(data))
+			}
+			data = data[:int(n)]
+		}
+		return string(data), nil
+	},
+}
+
+func init() {
+	register(ffiBuiltins)
+}
@@ -0,0 +1,16 @@
+//go:build !(cgo && unix && (amd64 || arm64))
+
+package builtins
+
+import "fmt"
+
+// The ffi builtins need cgo on a 64-bit unix; elsewhere they only say so.
+func init() {
+	ffiBuiltins := map[string]BuiltinFunc{}
+	for _, name := range []string{"ffi_open", "ffi_func", "ffi_call", "ffi_close", "ffi_buffer", "ffi_read"} {
+		ffiBuiltins[name] = func(ctx *Context, args []interface{}) (interface{}, error) {
+			return nil, fmt.Errorf("%s is not available: lightlang was built without cgo or for an unsupported platform", name)
+		}
+	}
+	register(ffiBuiltins)
+}
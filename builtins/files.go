@@ -0,0 +1,258 @@
+package builtins
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type FileHandle struct {
+	Path   string
+	file   *os.File
+	reader *bufio.Reader
+	closed bool
+}
+
+func (h *FileHandle) Close() error {
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+	return h.file.Close()
+}
+
+// unread moves the file back over what the reader buffered but the program
+// has not read yet, so that a write in r+ mode lands where reading stopped.
+func (h *FileHandle) unread() error {
+	if n := h.reader.Buffered(); n > 0 {
+		if _, err := h.file.Seek(-int64(n), io.SeekCurrent); err != nil {
+			return err
+		}
+		h.reader.Reset(h.file)
+	}
+	return nil
+}
+
+func toFileHandle(name string, val interface{}) (*FileHandle, error) {
+	h, ok := val.(*FileHandle)
+	if !ok {
+		return nil, fmt.Errorf("%s requires file handle", name)
+	}
+	if h.closed {
+		return nil, fmt.Errorf("%s on closed file '%s'", name, h.Path)
+	}
+	return h, nil
+}
+
+func readLine(r *bufio.Reader) (string, bool, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false, err
+	}
+	if line == "" && err == io.EOF {
+		return "", false, nil
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return line, true, nil
+}
+
+var fileBuiltins = map[string]BuiltinFunc{
+	"read_file": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("read_file expects 1 argument (path)")
+		}
+		path, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("read_file path must be string")
+		}
+		if err := ctx.checkRead("read_file", path); err != nil {
+			return nil, err
+		}
+		data, err := ctx.readFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %v", err)
+		}
+		return string(data), nil
+	},
+
+	"write_file": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("write_file expects 2 arguments (path, data)")
+		}
+		path, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("write_file path must be string")
+		}
+		if err := ctx.checkWrite("write_file", path); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("%v", args[1])), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write file: %v", err)
+		}
+		return nil, nil
+	},
+
+	"append_file": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("append_file expects 2 arguments (path, data)")
+		}
+		path, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("append_file path must be string")
+		}
+		if err := ctx.checkWrite("append_file", path); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %v", err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(fmt.Sprintf("%v", args[1])); err != nil {
+			return nil, fmt.Errorf("failed to append file: %v", err)
+		}
+		return nil, nil
+	},
+
+	"open": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("open expects 1 or 2 arguments (path, mode)")
+		}
+		path, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("open path must be string")
+		}
+		mode := "r"
+		if len(args) == 2 {
+			if mode, ok = args[1].(string); !ok {
+				return nil, fmt.Errorf("open mode must be string")
+			}
+		}
+
+		var flag int
+		switch mode {
+		case "r":
+			flag = os.O_RDONLY
+		case "w":
+			flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		case "a":
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		case "r+":
+			flag = os.O_RDWR
+		default:
+			return nil, fmt.Errorf("open mode must be one of r, w, a, r+")
+		}
+		if mode == "r" || mode == "r+" {
+			if err := ctx.checkRead("open", path); err != nil {
+				return nil, err
+			}
+		}
+		if mode != "r" {
+			if err := ctx.checkWrite("open", path); err != nil {
+				return nil, err
+			}
+		}
+
+		f, err := os.OpenFile(path, flag, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %v", err)
+		}
+		return &FileHandle{Path: path, file: f, reader: bufio.NewReader(f)}, nil
+	},
+
+	"read": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("read expects 1 or 2 arguments (handle, count)")
+		}
+		h, err := toFileHandle("read", args[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 1 {
+			data, err := io.ReadAll(h.reader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read file: %v", err)
+			}
+			return string(data), nil
+		}
+		count, ok := args[1].(float64)
+		if !ok || count < 0 {
+			return nil, fmt.Errorf("read count must be a positive number")
+		}
+		buf := make([]byte, int(count))
+		n, err := io.ReadFull(h.reader, buf)
+		if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			return nil, nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("failed to read file: %v", err)
+		}
+		return string(buf[:n]), nil
+	},
+
+	"readline": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("readline expects 1 argument (handle)")
+		}
+		h, err := toFileHandle("readline", args[0])
+		if err != nil {
+			return nil, err
+		}
+		line, ok, err := readLine(h.reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %v", err)
+		}
+		if !ok {
+			return nil, nil
+		}
+		return line, nil
+	},
+
+	// lines gives a stream that reads the lines of a path or file handle
+	// one at a time.
+	"lines": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("lines expects 1 argument (handle or path)")
+		}
+		return lineStream(ctx, "lines", args[0])
+	},
+
+	"write": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("write expects 2 arguments (handle, data)")
+		}
+		h, err := toFileHandle("write", args[0])
+		if err != nil {
+			return nil, err
+		}
+		if err := h.unread(); err != nil {
+			return nil, fmt.Errorf("failed to write file: %v", err)
+		}
+		n, err := h.file.WriteString(fmt.Sprintf("%v", args[1]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to write file: %v", err)
+		}
+		return float64(n), nil
+	},
+
+	"close": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("close expects 1 argument (handle)")
+		}
+		c, ok := args[0].(io.Closer)
+		if !ok {
+			return nil, fmt.Errorf("close requires handle")
+		}
+		if err := c.Close(); err != nil {
+			return nil, fmt.Errorf("failed to close: %v", err)
+		}
+		return nil, nil
+	},
+}
+
+func init() {
+	register(fileBuiltins)
+}
@@ -0,0 +1,218 @@
+package builtins
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Args, when set, are the program name and arguments that os.args and
+// flags.parse see instead of the process's. The command line sets them
+// to the script and what follows it.
+var Args []string
+
+func scriptArgs() []string {
+	if Args != nil {
+		return Args
+	}
+	return os.Args
+}
+
+// Flag is an option declared with flags.string, flags.number or
+// flags.bool, for flags.parse.
+type Flag struct {
+	Name, Short, Kind, Help string
+	Default                 interface{}
+}
+
+func (f *Flag) String() string { return fmt.Sprintf("<flag --%s>", f.Name) }
+
+// set reads the text given for the flag.
+func (f *Flag) set(text string) (interface{}, error) {
+	switch f.Kind {
+	case "number":
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("--%s expects a number, got %q", f.Name, text)
+		}
+		return n, nil
+	case "bool":
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("--%s expects true or false, got %q", f.Name, text)
+		}
+		return b, nil
+	}
+	return text, nil
+}
+
+func flagBuiltin(kind string) BuiltinFunc {
+	name := "flags." + kind
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 4 {
+			return nil, fmt.Errorf("%s expects 4 arguments (name, short, default, help)", name)
+		}
+		f := &Flag{Kind: kind, Default: args[2]}
+		var ok1, ok2, ok3 bool
+		f.Name, ok1 = args[0].(string)
+		f.Short, ok2 = args[1].(string)
+		f.Help, ok3 = args[3].(string)
+		if !ok1 || !ok2 || !ok3 || f.Name == "" {
+			return nil, fmt.Errorf("%s name, short and help must be strings", name)
+		}
+		if f.Name == "args" || f.Name == "help" || f.Short == "h" {
+			return nil, fmt.Errorf("%s cannot declare %q, which flags.parse uses", name, f.Name)
+		}
+		if len([]rune(f.Short)) > 1 {
+			return nil, fmt.Errorf("%s short name must be one character or empty", name)
+		}
+		var ok bool
+		switch kind {
+		case "string":
+			_, ok = f.Default.(string)
+		case "number":
+			_, ok = f.Default.(float64)
+		case "bool":
+			_, ok = f.Default.(bool)
+		}
+		if !ok && f.Default != nil {
+			return nil, fmt.Errorf("%s default must be %s", name, kind)
+		}
+		return f, nil
+	}
+}
+
+// flagUsage is what --help prints.
+func flagUsage(program string, flags []*Flag) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Usage: %s [options] [args...]\n\nOptions:\n", program)
+	rows := make([][2]string, 0, len(flags)+1)
+	for _, f := range flags {
+		left := "    --" + f.Name
+		if f.Short != "" {
+			left = "-" + f.Short + ", --" + f.Name
+		}
+		if f.Kind != "bool" {
+			left += " " + f.Kind
+		}
+		help := f.Help
+		if f.Default != nil && f.Default != false && f.Default != "" {
+			help += fmt.Sprintf(" (default %s)", formatValue(f.Default))
+		}
+		rows = append(rows, [2]string{left, help})
+	}
+	rows = append(rows, [2]string{"-h, --help", "show this help"})
+	width := 0
+	for _, r := range rows {
+		width = max(width, len(r[0]))
+	}
+	for _, r := range rows {
+		fmt.Fprintf(&sb, "  %-*s  %s\n", width, r[0], r[1])
+	}
+	return sb.String()
+}
+
+var flagBuiltins = map[string]BuiltinFunc{
+	"flags.string": flagBuiltin("string"),
+	"flags.number": flagBuiltin("number"),
+	"flags.bool":   flagBuiltin("bool"),
+
+	// flags.parse reads the script's arguments, or the array given, into a
+	// table of the declared flags, with the other arguments under "args".
+	// --help prints the options and exits, and a bad flag prints the
+	// problem with them and exits with status 2.
+	"flags.parse": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("flags.parse expects 1 or 2 arguments (flags, argv)")
+		}
+		list, ok := args[0].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("flags.parse requires array of flags")
+		}
+		flags := make([]*Flag, len(list))
+		byName := make(map[string]*Flag)
+		for i, x := range list {
+			f, ok := x.(*Flag)
+			if !ok {
+				return nil, fmt.Errorf("flags.parse requires array of flags")
+			}
+			flags[i] = f
+			byName["--"+f.Name] = f
+			if f.Short != "" {
+				byName["-"+f.Short] = f
+			}
+		}
+		argv := scriptArgs()
+		program, argv := filepath.Base(argv[0]), argv[1:]
+		if len(args) == 2 {
+			given, ok := args[1].([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("flags.parse argv must be array of strings")
+			}
+			argv = make([]string, len(given))
+			for i, a := range given {
+				if argv[i], ok = a.(string); !ok {
+					return nil, fmt.Errorf("flags.parse argv must be array of strings")
+				}
+			}
+		}
+		result := make(map[string]interface{}, len(flags)+1)
+		for _, f := range flags {
+			result[f.Name] = f.Default
+		}
+		fail := func(format string, a ...interface{}) (interface{}, error) {
+			fmt.Fprintf(ctx.Stderr, "%s: %s\n%s", program, fmt.Sprintf(format, a...), flagUsage(program, flags))
+			return nil, &ExitError{Code: 2}
+		}
+		var rest []interface{}
+		for i := 0; i < len(argv); i++ {
+			arg := argv[i]
+			if arg == "--" {
+				for _, a := range argv[i+1:] {
+					rest = append(rest, a)
+				}
+				break
+			}
+			if arg == "-h" || arg == "--help" {
+				fmt.Fprint(ctx.Stdout, flagUsage(program, flags))
+				return nil, &ExitError{Code: 0}
+			}
+			if len(arg) < 2 || arg[0] != '-' {
+				rest = append(rest, arg)
+				continue
+			}
+			key, text, hasText := strings.Cut(arg, "=")
+			f := byName[key]
+			if f == nil {
+				return fail("unknown flag %s", key)
+			}
+			if !hasText {
+				if f.Kind == "bool" {
+					result[f.Name] = true
+					continue
+				}
+				if i+1 == len(argv) {
+					return fail("--%s needs a value", f.Name)
+				}
+				i++
+				text = argv[i]
+			}
+			val, err := f.set(text)
+			if err != nil {
+				return fail("%v", err)
+			}
+			result[f.Name] = val
+		}
+		if rest == nil {
+			rest = []interface{}{}
+		}
+		result["args"] = rest
+		return result, nil
+	},
+}
+
+func init() {
+	register(flagBuiltins)
+}
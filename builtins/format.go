@@ -0,0 +1,191 @@
+package builtins
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Display is how print, tostring and joining strings show a value: a
+// string as it is and anything else as Repr writes it, except that a table
+// with a __tostring function is shown as what that returns for it.
+func (c *Context) Display(val interface{}) (string, error) {
+	if s, ok := val.(string); ok {
+		return s, nil
+	}
+	r := reprWriter{str: c.tostring}
+	r.write(val, 0)
+	return r.sb.String(), r.err
+}
+
+// tostring calls the __tostring function of t, if it has one.
+func (c *Context) tostring(t map[string]interface{}) (string, bool, error) {
+	fn := t["__tostring"]
+	if !isFunction(fn) || c == nil || c.CallFunction == nil {
+		return "", false, nil
+	}
+	res, err := c.CallFunction(fn, []interface{}{t})
+	if err != nil {
+		return "", true, err
+	}
+	s, ok := res.(string)
+	if !ok {
+		return "", true, fmt.Errorf("__tostring must return a string, got %s", Repr(res, ""))
+	}
+	return s, true, nil
+}
+
+// Repr writes val the way a program would: strings quoted, arrays as
+// [1, 2] and tables as {"a": 1} with sorted keys. A non-empty indent puts
+// each element on its own line. An array or table inside itself is
+// written [...] or {...}.
+func Repr(val interface{}, indent string) string {
+	r := reprWriter{indent: indent}
+	r.write(val, 0)
+	return r.sb.String()
+}
+
+type reprWriter struct {
+	sb     strings.Builder
+	indent string
+	// open are the arrays and tables being written, to stop at cycles
+	open []uintptr
+	// str, if set, gives the text of tables that choose their own
+	str func(t map[string]interface{}) (string, bool, error)
+	err error
+}
+
+func (r *reprWriter) write(val interface{}, depth int) {
+	switch v := val.(type) {
+	case nil:
+		r.sb.WriteString("nil")
+	case string:
+		r.sb.WriteString(strconv.Quote(v))
+	case []interface{}:
+		if len(v) == 0 {
+			r.sb.WriteString("[]")
+			return
+		}
+		if !r.enter(reflect.ValueOf(v).Pointer()) {
+			r.sb.WriteString("[...]")
+			return
+		}
+		r.sb.WriteByte('[')
+		for i, e := range v {
+			r.item(i, depth+1)
+			r.write(e, depth+1)
+		}
+		r.close(']', depth)
+	case map[string]interface{}:
+		if isFunction(v) {
+			fmt.Fprintf(&r.sb, "<function@%v>", v["entry"])
+			return
+		}
+		if r.str != nil && r.err == nil {
+			s, ok, err := r.str(v)
+			if err != nil {
+				r.err = err
+			}
+			if ok {
+				r.sb.WriteString(s)
+				return
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		r.table(reflect.ValueOf(v).Pointer(), keys, func(k string) interface{} { return v[k] }, depth)
+	case *OrderedTable:
+		r.table(reflect.ValueOf(v).Pointer(), v.Keys(), v.Get, depth)
+	default:
+		fmt.Fprint(&r.sb, v)
+	}
+}
+
+// table writes the keys of the table at p in order.
+func (r *reprWriter) table(p uintptr, keys []string, get func(k string) interface{}, depth int) {
+	if len(keys) == 0 {
+		r.sb.WriteString("{}")
+		return
+	}
+	if !r.enter(p) {
+		r.sb.WriteString("{...}")
+		return
+	}
+	r.sb.WriteByte('{')
+	for i, k := range keys {
+		r.item(i, depth+1)
+		r.sb.WriteString(strconv.Quote(k))
+		r.sb.WriteString(": ")
+		r.write(get(k), depth+1)
+	}
+	r.close('}', depth)
+}
+
+func (r *reprWriter) enter(p uintptr) bool {
+	if slices.Contains(r.open, p) {
+		return false
+	}
+	r.open = append(r.open, p)
+	return true
+}
+
+// item starts the i-th element of an array or table.
+func (r *reprWriter) item(i, depth int) {
+	if i > 0 {
+		r.sb.WriteByte(',')
+		if r.indent == "" {
+			r.sb.WriteByte(' ')
+		}
+	}
+	if r.indent != "" {
+		r.sb.WriteByte('\n')
+		r.sb.WriteString(strings.Repeat(r.indent, depth))
+	}
+}
+
+func (r *reprWriter) close(c byte, depth int) {
+	r.open = r.open[:len(r.open)-1]
+	if r.indent != "" {
+		r.sb.WriteByte('\n')
+		r.sb.WriteString(strings.Repeat(r.indent, depth))
+	}
+	r.sb.WriteByte(c)
+}
+
+var formatBuiltins = map[string]BuiltinFunc{
+	"repr": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("repr expects 1 or 2 arguments (value, indent)")
+		}
+		indent := ""
+		if len(args) == 2 {
+			switch v := args[1].(type) {
+			case string:
+				indent = v
+			case float64:
+				indent = strings.Repeat(" ", max(int(v), 0))
+			default:
+				return nil, fmt.Errorf("repr indent must be string or number")
+			}
+		}
+		return Repr(args[0], indent), nil
+	},
+
+	"dump": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("dump expects 1 argument")
+		}
+		fmt.Fprintln(ctx.Stdout, Repr(args[0], "  "))
+		return nil, nil
+	},
+}
+
+func init() {
+	register(formatBuiltins)
+}
@@ -0,0 +1,50 @@
+package builtins
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+)
+
+func hashBuiltin(name string, newHash func() hash.Hash) BuiltinFunc {
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument", name)
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("%s requires string", name)
+		}
+		h := newHash()
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+}
+
+var hashBuiltins = map[string]BuiltinFunc{
+	"sha256": hashBuiltin("sha256", sha256.New),
+	"sha1":   hashBuiltin("sha1", sha1.New),
+	"md5":    hashBuiltin("md5", md5.New),
+
+	"hmac_sha256": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("hmac_sha256 expects 2 arguments (key, message)")
+		}
+		key, ok1 := args[0].(string)
+		msg, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("hmac_sha256 requires strings")
+		}
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(msg))
+		return hex.EncodeToString(mac.Sum(nil)), nil
+	},
+}
+
+func init() {
+	register(hashBuiltins)
+}
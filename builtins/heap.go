@@ -0,0 +1,218 @@
+package builtins
+
+import (
+	"container/heap"
+	"fmt"
+	"slices"
+)
+
+// Heap is a binary min-heap of values, in the default order of sort or by
+// a comparison function. It is changed in place by heap_push and heap_pop.
+type Heap struct {
+	items []interface{}
+	less  func(a, b interface{}) (bool, error)
+	// err is the first error of a comparison function
+	err error
+}
+
+func (h *Heap) Len() int           { return len(h.items) }
+func (h *Heap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *Heap) Push(x interface{}) { h.items = append(h.items, x) }
+
+func (h *Heap) Pop() interface{} {
+	n := len(h.items) - 1
+	x := h.items[n]
+	h.items[n] = nil
+	h.items = h.items[:n]
+	return x
+}
+
+func (h *Heap) Less(i, j int) bool {
+	if h.err != nil {
+		return false
+	}
+	ok, err := h.less(h.items[i], h.items[j])
+	h.err = err
+	return ok
+}
+
+func (h *Heap) String() string { return fmt.Sprintf("<heap of %d>", len(h.items)) }
+
+// check returns the error of a comparison made by the last change.
+func (h *Heap) check() error {
+	err := h.err
+	h.err = nil
+	return err
+}
+
+func defaultLess(a, b interface{}) (bool, error) {
+	return compareValues(a, b) < 0, nil
+}
+
+// PriorityQueue gives back items lowest priority first, and items of the
+// same priority in the order they were added.
+type PriorityQueue struct {
+	h   Heap
+	seq int
+}
+
+type pqEntry struct {
+	item, priority interface{}
+	seq            int
+}
+
+func newPriorityQueue() *PriorityQueue {
+	return &PriorityQueue{h: Heap{less: func(a, b interface{}) (bool, error) {
+		x, y := a.(pqEntry), b.(pqEntry)
+		if c := compareValues(x.priority, y.priority); c != 0 {
+			return c < 0, nil
+		}
+		return x.seq < y.seq, nil
+	}}}
+}
+
+func (q *PriorityQueue) Len() int { return q.h.Len() }
+
+func (q *PriorityQueue) String() string { return fmt.Sprintf("<priority queue of %d>", q.h.Len()) }
+
+func toHeap(name string, val interface{}) (*Heap, error) {
+	h, ok := val.(*Heap)
+	if !ok {
+		return nil, fmt.Errorf("%s requires heap", name)
+	}
+	return h, nil
+}
+
+func toQueue(name string, val interface{}) (*PriorityQueue, error) {
+	q, ok := val.(*PriorityQueue)
+	if !ok {
+		return nil, fmt.Errorf("%s requires priority queue", name)
+	}
+	return q, nil
+}
+
+var heapBuiltins = map[string]BuiltinFunc{
+	// heap makes a heap, with the elements of an array if one is given,
+	// ordered by a function that is true when its first argument comes
+	// out before its second if one is given.
+	"heap": func(ctx *Context, args []interface{}) (interface{}, error) {
+		h := &Heap{less: defaultLess}
+		for _, arg := range args {
+			switch v := arg.(type) {
+			case []interface{}:
+				h.items = append(h.items, v...)
+			default:
+				if !isFunction(v) || ctx.CallFunction == nil {
+					return nil, fmt.Errorf("heap expects an array, a function or both")
+				}
+				h.less = func(a, b interface{}) (bool, error) {
+					res, err := ctx.CallFunction(v, []interface{}{a, b})
+					return Truthy(res), err
+				}
+			}
+		}
+		heap.Init(h)
+		return h, h.check()
+	},
+
+	"heap_push": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("heap_push expects 2 arguments (heap, value)")
+		}
+		h, err := toHeap("heap_push", args[0])
+		if err != nil {
+			return nil, err
+		}
+		heap.Push(h, args[1])
+		return nil, h.check()
+	},
+
+	// heap_pop removes and returns the first value, or nil when the heap
+	// is empty.
+	"heap_pop": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("heap_pop expects 1 argument (heap)")
+		}
+		h, err := toHeap("heap_pop", args[0])
+		if err != nil || h.Len() == 0 {
+			return nil, err
+		}
+		x := heap.Pop(h)
+		return x, h.check()
+	},
+
+	"heap_peek": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("heap_peek expects 1 argument (heap)")
+		}
+		h, err := toHeap("heap_peek", args[0])
+		if err != nil || h.Len() == 0 {
+			return nil, err
+		}
+		return h.items[0], nil
+	},
+
+	"pqueue": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("pqueue expects no arguments")
+		}
+		return newPriorityQueue(), nil
+	},
+
+	"pq_push": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 3 {
+			return nil, fmt.Errorf("pq_push expects 3 arguments (queue, item, priority)")
+		}
+		q, err := toQueue("pq_push", args[0])
+		if err != nil {
+			return nil, err
+		}
+		heap.Push(&q.h, pqEntry{item: args[1], priority: args[2], seq: q.seq})
+		q.seq++
+		return nil, nil
+	},
+
+	// pq_pop removes and returns the item of lowest priority, or nil when
+	// the queue is empty.
+	"pq_pop": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("pq_pop expects 1 argument (queue)")
+		}
+		q, err := toQueue("pq_pop", args[0])
+		if err != nil || q.Len() == 0 {
+			return nil, err
+		}
+		return heap.Pop(&q.h).(pqEntry).item, nil
+	},
+
+	"pq_peek": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("pq_peek expects 1 argument (queue)")
+		}
+		q, err := toQueue("pq_peek", args[0])
+		if err != nil || q.Len() == 0 {
+			return nil, err
+		}
+		return q.h.items[0].(pqEntry).item, nil
+	},
+
+	// bsearch finds x in an array sorted in the default order of sort. It
+	// returns the index of the first element that is not less than x,
+	// which is len(arr) when there is none, so x is there when
+	// arr[i] == x.
+	"bsearch": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("bsearch expects 2 arguments (array, value)")
+		}
+		arr, ok := args[0].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("bsearch requires array")
+		}
+		i, _ := slices.BinarySearchFunc(arr, args[1], compareValues)
+		return float64(i), nil
+	},
+}
+
+func init() {
+	register(heapBuiltins)
+}
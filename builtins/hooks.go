@@ -0,0 +1,73 @@
+package builtins
+
+import "fmt"
+
+// isFunction tells whether val is a lightlang function value.
+func isFunction(val interface{}) bool {
+	fn, ok := val.(map[string]interface{})
+	return ok && fn["type"] == "function" && fn["entry"] != nil
+}
+
+func timerBuiltin(name string, repeat bool) BuiltinFunc {
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 || !isFunction(args[0]) {
+			return nil, fmt.Errorf("%s expects 2 arguments (function, ms)", name)
+		}
+		ms, ok := args[1].(float64)
+		if !ok || ms < 0 || repeat && ms == 0 {
+			return nil, fmt.Errorf("%s ms must be a positive number", name)
+		}
+		if ctx.SetTimer == nil {
+			return nil, fmt.Errorf("%s is not available here", name)
+		}
+		return float64(ctx.SetTimer(args[0], ms, repeat)), nil
+	}
+}
+
+var hookBuiltins = map[string]BuiltinFunc{
+	"atexit": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 || !isFunction(args[0]) {
+			return nil, fmt.Errorf("atexit expects 1 argument (function)")
+		}
+		if ctx.AtExit == nil {
+			return nil, fmt.Errorf("atexit is not available here")
+		}
+		ctx.AtExit(args[0])
+		return nil, nil
+	},
+
+	"on_signal": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 || !isFunction(args[1]) {
+			return nil, fmt.Errorf("on_signal expects 2 arguments (name, function)")
+		}
+		name, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("on_signal name must be string")
+		}
+		if ctx.OnSignal == nil {
+			return nil, fmt.Errorf("on_signal is not available here")
+		}
+		return nil, ctx.OnSignal(name, args[1])
+	},
+
+	"set_timeout":  timerBuiltin("set_timeout", false),
+	"set_interval": timerBuiltin("set_interval", true),
+
+	"cancel": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("cancel expects 1 argument (timer id)")
+		}
+		id, ok := args[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("cancel timer id must be number")
+		}
+		if ctx.CancelTimer == nil {
+			return nil, fmt.Errorf("cancel is not available here")
+		}
+		return ctx.CancelTimer(int(id)), nil
+	},
+}
+
+func init() {
+	register(hookBuiltins)
+}
@@ -0,0 +1,158 @@
+package builtins
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultHTTPTimeout = 30 * time.Second
+
+func toHeaders(name string, val interface{}) (map[string]string, error) {
+	if val == nil {
+		return nil, nil
+	}
+	tbl, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s headers must be table", name)
+	}
+	headers := make(map[string]string, len(tbl))
+	for k, v := range tbl {
+		headers[k] = fmt.Sprintf("%v", v)
+	}
+	return headers, nil
+}
+
+func fromHeaders(h http.Header) map[string]interface{} {
+	headers := make(map[string]interface{}, len(h))
+	for k, v := range h {
+		headers[strings.ToLower(k)] = strings.Join(v, ", ")
+	}
+	return headers
+}
+
+func doRequest(ctx *Context, name, method, url, body string, headers map[string]string, timeout time.Duration) (interface{}, error) {
+	var bodyReader io.Reader
+	if body != "" {
+		bodyReader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, bodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %v", err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return ctx.checkURL(name, r.URL.String())
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	return map[string]interface{}{
+		"status":  float64(resp.StatusCode),
+		"headers": fromHeaders(resp.Header),
+		"body":    string(data),
+	}, nil
+}
+
+var httpBuiltins = map[string]BuiltinFunc{
+	"http_get": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("http_get expects 1 or 2 arguments (url, headers)")
+		}
+		url, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("http_get url must be string")
+		}
+		if err := ctx.checkURL("http_get", url); err != nil {
+			return nil, err
+		}
+		var headerVal interface{}
+		if len(args) == 2 {
+			headerVal = args[1]
+		}
+		headers, err := toHeaders("http_get", headerVal)
+		if err != nil {
+			return nil, err
+		}
+		return doRequest(ctx, "http_get", "GET", url, "", headers, defaultHTTPTimeout)
+	},
+
+	"http_post": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) < 2 || len(args) > 3 {
+			return nil, fmt.Errorf("http_post expects 2 or 3 arguments (url, body, headers)")
+		}
+		url, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("http_post url must be string")
+		}
+		if err := ctx.checkURL("http_post", url); err != nil {
+			return nil, err
+		}
+		body := fmt.Sprintf("%v", args[1])
+		var headerVal interface{}
+		if len(args) == 3 {
+			headerVal = args[2]
+		}
+		headers, err := toHeaders("http_post", headerVal)
+		if err != nil {
+			return nil, err
+		}
+		return doRequest(ctx, "http_post", "POST", url, body, headers, defaultHTTPTimeout)
+	},
+
+	"http_request": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("http_request expects 1 argument (options)")
+		}
+		opts, ok := args[0].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("http_request options must be table")
+		}
+		url, ok := opts["url"].(string)
+		if !ok {
+			return nil, fmt.Errorf("http_request requires url")
+		}
+		if err := ctx.checkURL("http_request", url); err != nil {
+			return nil, err
+		}
+		method := "GET"
+		if m, ok := opts["method"].(string); ok {
+			method = strings.ToUpper(m)
+		}
+		body := ""
+		if b, ok := opts["body"]; ok && b != nil {
+			body = fmt.Sprintf("%v", b)
+		}
+		headers, err := toHeaders("http_request", opts["headers"])
+		if err != nil {
+			return nil, err
+		}
+		timeout := defaultHTTPTimeout
+		if t, ok := opts["timeout"].(float64); ok {
+			timeout = time.Duration(t * float64(time.Second))
+		}
+		return doRequest(ctx, "http_request", method, url, body, headers, timeout)
+	},
+}
+
+func init() {
+	register(httpBuiltins)
+}
@@ -0,0 +1,198 @@
+package builtins
+
+import (
+	"fmt"
+	"math"
+)
+
+// The vector and matrix builtins convert their arrays to []float64 once and
+// do the loops in Go. A vector is an array of numbers and a matrix an array
+// of rows of the same length.
+
+func floats(name string, val interface{}) ([]float64, error) {
+	arr, ok := val.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s requires array of numbers, got %s", name, Repr(val, ""))
+	}
+	out := make([]float64, len(arr))
+	for i, e := range arr {
+		f, ok := e.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%s requires array of numbers, element %d is %s", name, i, Repr(e, ""))
+		}
+		out[i] = f
+	}
+	return out, nil
+}
+
+func matrix(name string, val interface{}) ([][]float64, error) {
+	arr, ok := val.([]interface{})
+	if !ok || len(arr) == 0 {
+		return nil, fmt.Errorf("%s requires a matrix, an array of rows", name)
+	}
+	rows := make([][]float64, len(arr))
+	for i, r := range arr {
+		row, err := floats(name, r)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && len(row) != len(rows[0]) {
+			return nil, fmt.Errorf("%s: row %d has %d columns, row 0 has %d", name, i, len(row), len(rows[0]))
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+func fromFloats(fs []float64) []interface{} {
+	out := make([]interface{}, len(fs))
+	for i, f := range fs {
+		out[i] = f
+	}
+	return out
+}
+
+func fromMatrix(rows [][]float64) []interface{} {
+	out := make([]interface{}, len(rows))
+	for i, row := range rows {
+		out[i] = fromFloats(row)
+	}
+	return out
+}
+
+// elementwise makes a builtin applying op to the elements of two vectors
+// of the same length, or of a vector and a number.
+func elementwise(name string, op func(a, b float64) float64) BuiltinFunc {
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s expects 2 arguments (vector, vector or number)", name)
+		}
+		a, err := floats(name, args[0])
+		if err != nil {
+			return nil, err
+		}
+		out := make([]float64, len(a))
+		if s, ok := args[1].(float64); ok {
+			for i := range a {
+				out[i] = op(a[i], s)
+			}
+			return fromFloats(out), nil
+		}
+		b, err := floats(name, args[1])
+		if err != nil {
+			return nil, err
+		}
+		if len(a) != len(b) {
+			return nil, fmt.Errorf("%s: lengths differ (%d and %d)", name, len(a), len(b))
+		}
+		for i := range a {
+			out[i] = op(a[i], b[i])
+		}
+		return fromFloats(out), nil
+	}
+}
+
+func dot(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+var linalgBuiltins = map[string]BuiltinFunc{
+	"vec_add": elementwise("vec_add", func(a, b float64) float64 { return a + b }),
+	"vec_sub": elementwise("vec_sub", func(a, b float64) float64 { return a - b }),
+	"vec_mul": elementwise("vec_mul", func(a, b float64) float64 { return a * b }),
+	"vec_div": elementwise("vec_div", func(a, b float64) float64 { return a / b }),
+
+	"dot": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("dot expects 2 arguments (vector, vector)")
+		}
+		a, err := floats("dot", args[0])
+		if err != nil {
+			return nil, err
+		}
+		b, err := floats("dot", args[1])
+		if err != nil {
+			return nil, err
+		}
+		if len(a) != len(b) {
+			return nil, fmt.Errorf("dot: lengths differ (%d and %d)", len(a), len(b))
+		}
+		return dot(a, b), nil
+	},
+
+	"norm": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("norm expects 1 argument (vector)")
+		}
+		a, err := floats("norm", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return math.Sqrt(dot(a, a)), nil
+	},
+
+	"transpose": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("transpose expects 1 argument (matrix)")
+		}
+		m, err := matrix("transpose", args[0])
+		if err != nil {
+			return nil, err
+		}
+		t := make([][]float64, len(m[0]))
+		for j := range t {
+			t[j] = make([]float64, len(m))
+			for i := range m {
+				t[j][i] = m[i][j]
+			}
+		}
+		return fromMatrix(t), nil
+	},
+
+	// matmul multiplies two matrices, or a matrix and a vector, which
+	// gives a vector.
+	"matmul": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("matmul expects 2 arguments (matrix, matrix or vector)")
+		}
+		a, err := matrix("matmul", args[0])
+		if err != nil {
+			return nil, err
+		}
+		if v, err := floats("matmul", args[1]); err == nil {
+			if len(v) != len(a[0]) {
+				return nil, fmt.Errorf("matmul: %d columns but a vector of %d", len(a[0]), len(v))
+			}
+			out := make([]float64, len(a))
+			for i, row := range a {
+				out[i] = dot(row, v)
+			}
+			return fromFloats(out), nil
+		}
+		b, err := matrix("matmul", args[1])
+		if err != nil {
+			return nil, err
+		}
+		if len(a[0]) != len(b) {
+			return nil, fmt.Errorf("matmul: %d columns but %d rows", len(a[0]), len(b))
+		}
+		out := make([][]float64, len(a))
+		for i, row := range a {
+			out[i] = make([]float64, len(b[0]))
+			for k, x := range row {
+				for j, y := range b[k] {
+					out[i][j] += x * y
+				}
+			}
+		}
+		return fromMatrix(out), nil
+	},
+}
+
+func init() {
+	register(linalgBuiltins)
+}
@@ -0,0 +1,133 @@
+package builtins
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Logger sets what the log builtins write: messages below Level are
+// dropped, and JSON writes an object per line instead of key=value text.
+type Logger struct {
+	Level slog.Level
+	JSON  bool
+}
+
+// DefaultLogger is used by VMs that do not set their own. It starts from
+// $LIGHTLANG_LOG_LEVEL and $LIGHTLANG_LOG_FORMAT, and the command line
+// changes it with --log-level and --log-json.
+var DefaultLogger = Logger{Level: slog.LevelInfo}
+
+// ParseLogLevel reads a level such as "debug", "info", "warn" or "error".
+func ParseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return level, nil
+}
+
+func (c *Context) logger() *Logger {
+	if c.Log != nil {
+		return c.Log
+	}
+	return &DefaultLogger
+}
+
+// logValue turns a field into something slog writes well: text shows
+// tables and arrays as print does, JSON keeps them as objects and arrays.
+func (c *Context) logValue(val interface{}, json bool) (interface{}, error) {
+	switch v := val.(type) {
+	case nil, bool, float64, string:
+		return v, nil
+	case []interface{}:
+		if !json {
+			break
+		}
+		out := make([]interface{}, len(v))
+		for i, x := range v {
+			var err error
+			if out[i], err = c.logValue(x, json); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]interface{}:
+		if !json {
+			break
+		}
+		out := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			var err error
+			if out[k], err = c.logValue(x, json); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return c.Display(val)
+}
+
+// logBuiltin makes log.debug, log.info, log.warn and log.error, which
+// take a message and optionally a table of fields to write with it.
+func logBuiltin(name string, level slog.Level) BuiltinFunc {
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("%s expects 1 or 2 arguments (message, fields)", name)
+		}
+		l := ctx.logger()
+		if level < l.Level {
+			return nil, nil
+		}
+		msg, err := ctx.Display(args[0])
+		if err != nil {
+			return nil, err
+		}
+		var attrs []slog.Attr
+		if len(args) == 2 && args[1] != nil {
+			fields, ok := args[1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s fields must be table", name)
+			}
+			keys := make([]string, 0, len(fields))
+			for k := range fields {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+			for _, k := range keys {
+				v, err := ctx.logValue(fields[k], l.JSON)
+				if err != nil {
+					return nil, err
+				}
+				attrs = append(attrs, slog.Any(k, v))
+			}
+		}
+		opts := &slog.HandlerOptions{Level: l.Level}
+		var h slog.Handler = slog.NewTextHandler(ctx.Stderr, opts)
+		if l.JSON {
+			h = slog.NewJSONHandler(ctx.Stderr, opts)
+		}
+		slog.New(h).LogAttrs(context.Background(), level, msg, attrs...)
+		return nil, nil
+	}
+}
+
+var logBuiltins = map[string]BuiltinFunc{
+	"log.debug": logBuiltin("log.debug", slog.LevelDebug),
+	"log.info":  logBuiltin("log.info", slog.LevelInfo),
+	"log.warn":  logBuiltin("log.warn", slog.LevelWarn),
+	"log.error": logBuiltin("log.error", slog.LevelError),
+}
+
+func init() {
+	if s := os.Getenv("LIGHTLANG_LOG_LEVEL"); s != "" {
+		if level, err := ParseLogLevel(s); err == nil {
+			DefaultLogger.Level = level
+		}
+	}
+	DefaultLogger.JSON = strings.EqualFold(os.Getenv("LIGHTLANG_LOG_FORMAT"), "json")
+	register(logBuiltins)
+}
@@ -0,0 +1,298 @@
+package builtins
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// msgpackMaxDepth stops tables that contain themselves.
+const msgpackMaxDepth = 512
+
+// msgpackEncode appends x in MessagePack. Whole numbers become integers
+// and other numbers doubles; table keys are sorted so equal tables encode
+// the same.
+func msgpackEncode(buf []byte, x interface{}, depth int) ([]byte, error) {
+	if depth > msgpackMaxDepth {
+		return nil, fmt.Errorf("msgpack_encode: value is nested too deeply")
+	}
+	switch x := x.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if x {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case float64:
+		if x == math.Trunc(x) && x >= math.MinInt64 && x < math.MaxInt64 {
+			return msgpackInt(buf, int64(x)), nil
+		}
+		buf = append(buf, 0xcb)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(x)), nil
+	case string:
+		buf = msgpackHeader(buf, len(x), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		return append(buf, x...), nil
+	case []interface{}:
+		buf = msgpackHeader(buf, len(x), 0x90, 15, 0, 0xdc, 0xdd)
+		var err error
+		for _, elem := range x {
+			if buf, err = msgpackEncode(buf, elem, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf = msgpackHeader(buf, len(x), 0x80, 15, 0, 0xde, 0xdf)
+		var err error
+		for _, k := range keys {
+			buf, _ = msgpackEncode(buf, k, depth+1)
+			if buf, err = msgpackEncode(buf, x[k], depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case *OrderedTable:
+		buf = msgpackHeader(buf, x.Len(), 0x80, 15, 0, 0xde, 0xdf)
+		var err error
+		for _, k := range x.Keys() {
+			buf, _ = msgpackEncode(buf, k, depth+1)
+			if buf, err = msgpackEncode(buf, x.Get(k), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("msgpack_encode cannot encode %T", x)
+}
+
+func msgpackInt(buf []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n <= 127:
+		return append(buf, byte(n))
+	case n < 0 && n >= -32:
+		return append(buf, byte(n))
+	case n >= 0 && n <= math.MaxUint8:
+		return append(buf, 0xcc, byte(n))
+	case n >= 0 && n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(n))
+	case n >= 0 && n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(n))
+	case n >= 0:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), uint64(n))
+	case n >= math.MinInt8:
+		return append(buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(n))
+}
+
+// msgpackHeader appends the type and length of a string, array or map: a
+// fix type when n fits in its low bits, or else the 8, 16 or 32 bit form.
+// Arrays and maps have no 8 bit form.
+func msgpackHeader(buf []byte, n int, fix byte, fixMax int, b8, b16, b32 byte) []byte {
+	switch {
+	case n <= fixMax:
+		return append(buf, fix|byte(n))
+	case b8 != 0 && n <= math.MaxUint8:
+		return append(buf, b8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, b16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, b32), uint32(n))
+}
+
+type msgpackDecoder struct {
+	data string
+	pos  int
+}
+
+func (d *msgpackDecoder) next(n int) (string, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return "", fmt.Errorf("msgpack_decode: data ends early")
+	}
+	s := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return s, nil
+}
+
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	s, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for i := 0; i < size; i++ {
+		n = n<<8 | uint64(s[i])
+	}
+	return n, nil
+}
+
+// decode reads one value. Binary data becomes a string like text does, and
+// map keys become table keys.
+func (d *msgpackDecoder) decode(depth int) (interface{}, error) {
+	if depth > msgpackMaxDepth {
+		return nil, fmt.Errorf("msgpack_decode: data is nested too deeply")
+	}
+	s, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	b := s[0]
+	switch {
+	case b <= 0x7f:
+		return float64(b), nil
+	case b >= 0xe0:
+		return float64(int8(b)), nil
+	case b&0xe0 == 0xa0:
+		return d.next(int(b & 0x1f))
+	case b&0xf0 == 0x90:
+		return d.array(int(b&0x0f), depth)
+	case b&0xf0 == 0x80:
+		return d.table(int(b&0x0f), depth)
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.uint(1 << (b - 0xcc))
+		return float64(n), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := d.uint(size)
+		// sign extend from the top bit of size bytes
+		shift := 64 - 8*size
+		return float64(int64(n<<shift) >> shift), err
+	case 0xca:
+		n, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.uint(8)
+		return math.Float64frombits(n), err
+	case 0xd9, 0xda, 0xdb, 0xc4, 0xc5, 0xc6:
+		size := 1 << (b - 0xd9)
+		if b <= 0xc6 {
+			size = 1 << (b - 0xc4)
+		}
+		n, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(d.data)) {
+			return nil, fmt.Errorf("msgpack_decode: data ends early")
+		}
+		return d.next(int(n))
+	case 0xdc, 0xdd, 0xde, 0xdf:
+		size := 2
+		if b == 0xdd || b == 0xdf {
+			size = 4
+		}
+		n, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(d.data)) {
+			return nil, fmt.Errorf("msgpack_decode: data ends early")
+		}
+		if b <= 0xdd {
+			return d.array(int(n), depth)
+		}
+		return d.table(int(n), depth)
+	}
+	return nil, fmt.Errorf("msgpack_decode: unsupported type 0x%02x", b)
+}
+
+func (d *msgpackDecoder) array(n int, depth int) (interface{}, error) {
+	// every element takes at least a byte
+	if n > len(d.data)-d.pos {
+		return nil, fmt.Errorf("msgpack_decode: data ends early")
+	}
+	arr := make([]interface{}, n)
+	for i := range arr {
+		elem, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = elem
+	}
+	return arr, nil
+}
+
+func (d *msgpackDecoder) table(n int, depth int) (interface{}, error) {
+	if n > len(d.data)-d.pos {
+		return nil, fmt.Errorf("msgpack_decode: data ends early")
+	}
+	t := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch k := k.(type) {
+		case string:
+			t[k] = v
+		case float64:
+			t[strconv.FormatFloat(k, 'g', -1, 64)] = v
+		case bool, nil:
+			t[fmt.Sprint(k)] = v
+		default:
+			return nil, fmt.Errorf("msgpack_decode: map keys must be strings, numbers or booleans")
+		}
+	}
+	return t, nil
+}
+
+var msgpackBuiltins = map[string]BuiltinFunc{
+	"msgpack_encode": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("msgpack_encode expects 1 argument (value)")
+		}
+		buf, err := msgpackEncode(nil, args[0], 0)
+		if err != nil {
+			return nil, err
+		}
+		return string(buf), nil
+	},
+
+	"msgpack_decode": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("msgpack_decode expects 1 argument (data)")
+		}
+		data, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack_decode requires string")
+		}
+		d := &msgpackDecoder{data: data}
+		val, err := d.decode(0)
+		if err != nil {
+			return nil, err
+		}
+		if d.pos != len(data) {
+			return nil, fmt.Errorf("msgpack_decode: %d bytes left after the value", len(data)-d.pos)
+		}
+		return val, nil
+	},
+}
+
+func init() {
+	register(msgpackBuiltins)
+}
@@ -0,0 +1,188 @@
+package builtins
+
+import (
+	"fmt"
+	"io"
+	"net"
+)
+
+const defaultRecvSize = 4096
+
+func recvSize(name string, args []interface{}) (int, error) {
+	if len(args) < 2 {
+		return defaultRecvSize, nil
+	}
+	n, ok := args[1].(float64)
+	if !ok || n <= 0 {
+		return 0, fmt.Errorf("%s size must be a positive number", name)
+	}
+	return int(n), nil
+}
+
+var netBuiltins = map[string]BuiltinFunc{
+	"tcp_connect": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("tcp_connect expects 1 argument (addr)")
+		}
+		addr, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("tcp_connect addr must be string")
+		}
+		if err := ctx.checkNet("tcp_connect", addr); err != nil {
+			return nil, err
+		}
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect: %v", err)
+		}
+		return conn, nil
+	},
+
+	"tcp_listen": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("tcp_listen expects 1 argument (addr)")
+		}
+		addr, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("tcp_listen addr must be string")
+		}
+		if err := ctx.checkNet("tcp_listen", addr); err != nil {
+			return nil, err
+		}
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen: %v", err)
+		}
+		return ln, nil
+	},
+
+	"accept": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("accept expects 1 argument (listener)")
+		}
+		ln, ok := args[0].(net.Listener)
+		if !ok {
+			return nil, fmt.Errorf("accept requires listener")
+		}
+		conn, err := ln.Accept()
+		if err != nil {
+			return nil, fmt.Errorf("failed to accept: %v", err)
+		}
+		return conn, nil
+	},
+
+	"udp_socket": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) > 1 {
+			return nil, fmt.Errorf("udp_socket expects 0 or 1 argument (addr)")
+		}
+		addr := ":0"
+		if len(args) == 1 {
+			a, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("udp_socket addr must be string")
+			}
+			addr = a
+		}
+		if err := ctx.checkNet("udp_socket", addr); err != nil {
+			return nil, err
+		}
+		local, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid udp address: %v", err)
+		}
+		conn, err := net.ListenUDP("udp", local)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open udp socket: %v", err)
+		}
+		return conn, nil
+	},
+
+	"send": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("send expects 2 or 3 arguments (conn, data, addr)")
+		}
+		data := []byte(fmt.Sprintf("%v", args[1]))
+		switch conn := args[0].(type) {
+		case *WebSocket:
+			if err := conn.Send(string(data)); err != nil {
+				return nil, fmt.Errorf("failed to send: %v", err)
+			}
+			return float64(len(data)), nil
+		case *net.UDPConn:
+			if len(args) != 3 {
+				return nil, fmt.Errorf("send on udp socket requires addr")
+			}
+			addr, ok := args[2].(string)
+			if !ok {
+				return nil, fmt.Errorf("send addr must be string")
+			}
+			if err := ctx.checkNet("send", addr); err != nil {
+				return nil, err
+			}
+			remote, err := net.ResolveUDPAddr("udp", addr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid udp address: %v", err)
+			}
+			n, err := conn.WriteToUDP(data, remote)
+			if err != nil {
+				return nil, fmt.Errorf("failed to send: %v", err)
+			}
+			return float64(n), nil
+		case net.Conn:
+			n, err := conn.Write(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to send: %v", err)
+			}
+			return float64(n), nil
+		default:
+			return nil, fmt.Errorf("send requires connection")
+		}
+	},
+
+	"recv": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("recv expects 1 or 2 arguments (conn, size)")
+		}
+		size, err := recvSize("recv", args)
+		if err != nil {
+			return nil, err
+		}
+		if ws, ok := args[0].(*WebSocket); ok {
+			msg, ok, err := ws.Recv()
+			if err != nil {
+				return nil, fmt.Errorf("failed to receive: %v", err)
+			}
+			if !ok {
+				return nil, nil
+			}
+			return msg, nil
+		}
+		buf := make([]byte, size)
+		switch conn := args[0].(type) {
+		case *net.UDPConn:
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return nil, fmt.Errorf("failed to receive: %v", err)
+			}
+			return map[string]interface{}{
+				"data": string(buf[:n]),
+				"addr": addr.String(),
+			}, nil
+		case net.Conn:
+			n, err := conn.Read(buf)
+			if n == 0 && err == io.EOF {
+				return nil, nil
+			}
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("failed to receive: %v", err)
+			}
+			return string(buf[:n]), nil
+		default:
+			return nil, fmt.Errorf("recv requires connection")
+		}
+	},
+}
+
+func init() {
+	register(netBuiltins)
+}
@@ -0,0 +1,20 @@
+package builtins
+
+// Arith does a op b for +, -, * and / when a or b is a bigint or a
+// decimal, and reports whether it did. A decimal with a bigint gives a
+// decimal.
+func Arith(op byte, a, b interface{}) (interface{}, bool, error) {
+	if isDecimal(a, b) {
+		return decimalArith(op, a, b)
+	}
+	return bigArith(op, a, b)
+}
+
+// Compare compares a and b when one is a bigint or a decimal and the other
+// a number of any kind, giving -1, 0 or 1.
+func Compare(a, b interface{}) (int, bool) {
+	if isDecimal(a, b) {
+		return decimalCompare(a, b)
+	}
+	return bigCompare(a, b)
+}
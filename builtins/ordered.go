@@ -0,0 +1,65 @@
+package builtins
+
+import "fmt"
+
+// OrderedTable is a table that keeps its keys in the order they were
+// first set. pairs, keys, print and msgpack_encode go through it in that
+// order instead of sorting or in map order.
+type OrderedTable struct {
+	keys []string
+	m    map[string]interface{}
+}
+
+func NewOrderedTable() *OrderedTable {
+	return &OrderedTable{m: make(map[string]interface{})}
+}
+
+func (t *OrderedTable) Get(key string) interface{} {
+	return t.m[key]
+}
+
+func (t *OrderedTable) Set(key string, val interface{}) {
+	if _, ok := t.m[key]; !ok {
+		t.keys = append(t.keys, key)
+	}
+	t.m[key] = val
+}
+
+func (t *OrderedTable) Len() int { return len(t.keys) }
+
+// Keys are the keys of t in order. They must not be changed.
+func (t *OrderedTable) Keys() []string { return t.keys }
+
+var orderedBuiltins = map[string]BuiltinFunc{
+	// ordered_table makes an empty ordered table, or one with the [key,
+	// value] pairs of an array, like pairs gives.
+	"ordered_table": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) > 1 {
+			return nil, fmt.Errorf("ordered_table expects 0 or 1 argument (pairs)")
+		}
+		t := NewOrderedTable()
+		if len(args) == 0 {
+			return t, nil
+		}
+		pairs, ok := args[0].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("ordered_table requires array of [key, value] pairs")
+		}
+		for i, p := range pairs {
+			pair, ok := p.([]interface{})
+			if !ok || len(pair) != 2 {
+				return nil, fmt.Errorf("ordered_table: element %d is not a [key, value] pair", i)
+			}
+			key, ok := pair[0].(string)
+			if !ok {
+				key = Repr(pair[0], "")
+			}
+			t.Set(key, pair[1])
+		}
+		return t, nil
+	},
+}
+
+func init() {
+	register(orderedBuiltins)
+}
@@ -0,0 +1,79 @@
+package builtins
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+var osBuiltins = map[string]BuiltinFunc{
+	"os.env": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("os.env expects 1 argument (name)")
+		}
+		name, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("os.env name must be string")
+		}
+		if err := ctx.checkEnv("os.env"); err != nil {
+			return nil, err
+		}
+		if val, ok := os.LookupEnv(name); ok {
+			return val, nil
+		}
+		return nil, nil
+	},
+
+	"os.setenv": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("os.setenv expects 2 arguments (name, value)")
+		}
+		name, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("os.setenv name must be string")
+		}
+		if err := ctx.checkEnv("os.setenv"); err != nil {
+			return nil, err
+		}
+		if err := os.Setenv(name, fmt.Sprintf("%v", args[1])); err != nil {
+			return nil, fmt.Errorf("failed to set environment variable: %v", err)
+		}
+		return nil, nil
+	},
+
+	"os.args": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("os.args expects 0 arguments")
+		}
+		argv := scriptArgs()
+		result := make([]interface{}, len(argv)-1)
+		for i, arg := range argv[1:] {
+			result[i] = arg
+		}
+		return result, nil
+	},
+
+	"os.exit": exitBuiltin("os.exit"),
+
+	"os.getcwd": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("os.getcwd expects 0 arguments")
+		}
+		dir, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get working directory: %v", err)
+		}
+		return dir, nil
+	},
+
+	"os.platform": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("os.platform expects 0 arguments")
+		}
+		return runtime.GOOS, nil
+	},
+}
+
+func init() {
+	register(osBuiltins)
+}
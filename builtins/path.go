@@ -0,0 +1,90 @@
+package builtins
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// pathArg checks the single path argument of the path builtins.
+func pathArg(name string, args []interface{}) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("%s expects 1 argument (path)", name)
+	}
+	p, ok := args[0].(string)
+	if !ok {
+		return "", fmt.Errorf("%s path must be string", name)
+	}
+	return p, nil
+}
+
+// pathFunc makes a builtin of a func from path/filepath.
+func pathFunc(name string, fn func(string) string) BuiltinFunc {
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		p, err := pathArg(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return fn(p), nil
+	}
+}
+
+var pathBuiltins = map[string]BuiltinFunc{
+	"path.join": func(ctx *Context, args []interface{}) (interface{}, error) {
+		parts := make([]string, len(args))
+		for i, arg := range args {
+			s, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("path.join parts must be strings")
+			}
+			parts[i] = s
+		}
+		return filepath.Join(parts...), nil
+	},
+
+	"path.dir":   pathFunc("path.dir", filepath.Dir),
+	"path.base":  pathFunc("path.base", filepath.Base),
+	"path.ext":   pathFunc("path.ext", filepath.Ext),
+	"path.clean": pathFunc("path.clean", filepath.Clean),
+
+	"path.abs": func(ctx *Context, args []interface{}) (interface{}, error) {
+		p, err := pathArg("path.abs", args)
+		if err != nil {
+			return nil, err
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, fmt.Errorf("path.abs: %v", err)
+		}
+		return abs, nil
+	},
+
+	// glob gives the paths matching a pattern like "logs/*.txt". It needs
+	// read access to the directory the pattern starts in.
+	"glob": func(ctx *Context, args []interface{}) (interface{}, error) {
+		pattern, err := pathArg("glob", args)
+		if err != nil {
+			return nil, err
+		}
+		dir := pattern
+		if i := strings.IndexAny(pattern, "*?["); i >= 0 {
+			dir = pattern[:i]
+		}
+		if err := ctx.checkRead("glob", filepath.Dir(dir+"x")); err != nil {
+			return nil, err
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("glob: %v", err)
+		}
+		result := make([]interface{}, len(matches))
+		for i, m := range matches {
+			result[i] = m
+		}
+		return result, nil
+	},
+}
+
+func init() {
+	register(pathBuiltins)
+}
@@ -0,0 +1,176 @@
+package builtins
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// ErrPermissionDenied is returned, wrapped, when a builtin needs a
+// permission the program was not given.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Grant allows a kind of access everywhere, or to the paths or hosts in
+// Only.
+type Grant struct {
+	All  bool
+	Only []string
+}
+
+// Permissions are what the IO builtins may do, see Context.Perms. run
+// builds them from its --allow-* flags.
+type Permissions struct {
+	Read, Write, Net Grant
+	Run, Env, Eval   bool
+	FFI              bool
+}
+
+func denied(name, access, flag string) error {
+	return fmt.Errorf("%s: %w: %s, run again with %s", name, ErrPermissionDenied, access, flag)
+}
+
+func (g Grant) allowsPath(path string) bool {
+	if g.All {
+		return true
+	}
+	abs, err := resolvePath(path)
+	if err != nil {
+		return false
+	}
+	for _, dir := range g.Only {
+		dir, err := resolvePath(dir)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(dir, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePath makes path absolute and resolves its symlinks, so a link in
+// an allowed directory cannot reach outside it. The part of path that does
+// not exist yet, like a file about to be written, is kept as it is.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err == nil {
+		return resolved, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	dir, base := filepath.Split(abs)
+	if dir == abs || base == "" {
+		return abs, nil
+	}
+	parent, err := resolvePath(filepath.Clean(dir))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, base), nil
+}
+
+// allowsHost matches host:port against entries that are a host, which
+// allows any port, or a host:port.
+func (g Grant) allowsHost(host, port string) bool {
+	if g.All {
+		return true
+	}
+	for _, h := range g.Only {
+		if h == host || h == net.JoinHostPort(host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Context) checkRead(name, path string) error {
+	if c.Perms == nil || c.Perms.Read.allowsPath(path) {
+		return nil
+	}
+	return denied(name, fmt.Sprintf("read access to %q", path), "--allow-read")
+}
+
+func (c *Context) checkWrite(name, path string) error {
+	if c.Perms == nil || c.Perms.Write.allowsPath(path) {
+		return nil
+	}
+	return denied(name, fmt.Sprintf("write access to %q", path), "--allow-write")
+}
+
+// checkNet checks an address like host:port; an empty host is the local
+// machine.
+func (c *Context) checkNet(name, addr string) error {
+	if c.Perms == nil || c.Perms.Net.All {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	if c.Perms.Net.allowsHost(host, port) {
+		return nil
+	}
+	return denied(name, fmt.Sprintf("network access to %q", addr), "--allow-net")
+}
+
+// checkURL checks the host of a url for the http and websocket builtins.
+func (c *Context) checkURL(name, rawURL string) error {
+	if c.Perms == nil || c.Perms.Net.All {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https", "wss":
+			port = "443"
+		default:
+			port = "80"
+		}
+	}
+	return c.checkNet(name, net.JoinHostPort(u.Hostname(), port))
+}
+
+func (c *Context) checkRun(name string) error {
+	if c.Perms == nil || c.Perms.Run {
+		return nil
+	}
+	return denied(name, "running commands", "--allow-run")
+}
+
+func (c *Context) checkEnv(name string) error {
+	if c.Perms == nil || c.Perms.Env {
+		return nil
+	}
+	return denied(name, "environment access", "--allow-env")
+}
+
+func (c *Context) checkFFI(name string) error {
+	if c.Perms == nil || c.Perms.FFI {
+		return nil
+	}
+	return denied(name, "loading native libraries", "--allow-ffi")
+}
+
+func (c *Context) checkEval(name string) error {
+	if c.Perms == nil || c.Perms.Eval {
+		return nil
+	}
+	return denied(name, "running code from strings", "--allow-eval")
+}
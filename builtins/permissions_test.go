@@ -0,0 +1,88 @@
+package builtins
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTPRedirectToDeniedHost(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer other.Close()
+	allowed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/local" {
+			w.Write([]byte("ok"))
+			return
+		}
+		http.Redirect(w, r, other.URL, http.StatusFound)
+	}))
+	defer allowed.Close()
+
+	u, _ := url.Parse(allowed.URL)
+	ctx := &Context{Perms: &Permissions{Net: Grant{Only: []string{u.Host}}}}
+	get := httpBuiltins["http_get"]
+
+	if _, err := get(ctx, []interface{}{allowed.URL + "/local"}); err != nil {
+		t.Fatalf("allowed host: %v", err)
+	}
+	_, err := get(ctx, []interface{}{allowed.URL})
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("redirect to %s: got %v, want permission denied", other.URL, err)
+	}
+}
+
+func TestWebSocketRedirectNotFollowed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "ws://example.com/", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	ctx := &Context{Perms: &Permissions{Net: Grant{Only: []string{u.Host}}}}
+	if _, err := websocketBuiltins["ws_connect"](ctx, []interface{}{"ws://" + u.Host + "/"}); err == nil {
+		t.Fatal("ws_connect followed a redirect")
+	}
+	if _, err := websocketBuiltins["ws_connect"](ctx, []interface{}{"ws://example.com/"}); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("ws_connect to a denied host: got %v, want permission denied", err)
+	}
+}
+
+func TestAllowsPathSymlink(t *testing.T) {
+	root := t.TempDir()
+	allowed := filepath.Join(root, "allowed")
+	outside := filepath.Join(root, "outside")
+	for _, dir := range []string{allowed, outside} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(allowed, "link")); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	g := Grant{Only: []string{allowed}}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{filepath.Join(allowed, "file"), true},
+		{filepath.Join(allowed, "new", "file"), true},
+		{filepath.Join(allowed, "link", "secret"), false},
+		{filepath.Join(allowed, "link", "new"), false},
+		{filepath.Join(outside, "secret"), false},
+	}
+	for _, tt := range tests {
+		if got := g.allowsPath(tt.path); got != tt.want {
+			t.Errorf("allowsPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
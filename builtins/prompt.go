@@ -0,0 +1,188 @@
+package builtins
+
+import (
+	"fmt"
+	"io"
+	"lightlang/term"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ask shows msg and reads a line of the answer.
+func (c *Context) ask(msg string) (string, error) {
+	fmt.Fprint(c.Stdout, msg)
+	text, err := readInput(c.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read input: %v", err)
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// terminalFd gives the descriptor of stdin when it is a terminal.
+func (c *Context) terminalFd() (int, bool) {
+	f, ok := c.Stdin.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return 0, false
+	}
+	return int(f.Fd()), true
+}
+
+// readSecret reads a line without showing it, from a terminal in raw
+// mode. Backspace works; Ctrl-C and Ctrl-D give up.
+func readSecret(r io.Reader, fd int) (string, error) {
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(fd, state)
+	var line []rune
+	var pending []byte
+	buf := make([]byte, 1)
+	for {
+		if _, err := r.Read(buf); err != nil {
+			return "", err
+		}
+		switch b := buf[0]; b {
+		case '\r', '\n':
+			return string(line), nil
+		case 3, 4:
+			return "", fmt.Errorf("interrupted")
+		case 8, 127:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		default:
+			pending = append(pending, b)
+			if utf8.FullRune(pending) {
+				line = append(line, []rune(string(pending))...)
+				pending = pending[:0]
+			}
+		}
+	}
+}
+
+func promptMessage(name string, args []interface{}, min, max int) (string, error) {
+	if len(args) < min || len(args) > max {
+		return "", fmt.Errorf("%s expects %d to %d arguments", name, min, max)
+	}
+	msg, ok := args[0].(string)
+	if !ok {
+		return "", fmt.Errorf("%s message must be string", name)
+	}
+	return msg, nil
+}
+
+var promptBuiltins = map[string]BuiltinFunc{
+	// prompt asks for a line of text, giving the default when the answer
+	// is empty.
+	"prompt": func(ctx *Context, args []interface{}) (interface{}, error) {
+		msg, err := promptMessage("prompt", args, 1, 2)
+		if err != nil {
+			return nil, err
+		}
+		def := ""
+		if len(args) == 2 {
+			if def, err = ctx.Display(args[1]); err != nil {
+				return nil, err
+			}
+			msg += " [" + def + "]"
+		}
+		answer, err := ctx.ask(msg + ": ")
+		if err != nil {
+			return nil, err
+		}
+		if answer == "" {
+			return def, nil
+		}
+		return answer, nil
+	},
+
+	// confirm asks a yes or no question until it gets an answer, which an
+	// empty line takes from the default, false unless given.
+	"confirm": func(ctx *Context, args []interface{}) (interface{}, error) {
+		msg, err := promptMessage("confirm", args, 1, 2)
+		if err != nil {
+			return nil, err
+		}
+		def := len(args) == 2 && Truthy(args[1])
+		choices := " [y/N] "
+		if def {
+			choices = " [Y/n] "
+		}
+		for {
+			answer, err := ctx.ask(msg + choices)
+			if err != nil {
+				return nil, err
+			}
+			switch strings.ToLower(answer) {
+			case "":
+				return def, nil
+			case "y", "yes":
+				return true, nil
+			case "n", "no":
+				return false, nil
+			}
+		}
+	},
+
+	// password asks for a line of text without echoing it when stdin is a
+	// terminal.
+	"password": func(ctx *Context, args []interface{}) (interface{}, error) {
+		msg, err := promptMessage("password", args, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		fd, ok := ctx.terminalFd()
+		if !ok {
+			return ctx.ask(msg + ": ")
+		}
+		fmt.Fprint(ctx.Stdout, msg+": ")
+		secret, err := readSecret(ctx.Stdin, fd)
+		fmt.Fprintln(ctx.Stdout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input: %v", err)
+		}
+		return secret, nil
+	},
+
+	// choose lists options and asks for one, by number or by name, until
+	// it gets one of them, which it returns.
+	"choose": func(ctx *Context, args []interface{}) (interface{}, error) {
+		msg, err := promptMessage("choose", args, 2, 2)
+		if err != nil {
+			return nil, err
+		}
+		options, ok := args[1].([]interface{})
+		if !ok || len(options) == 0 {
+			return nil, fmt.Errorf("choose options must be non-empty array")
+		}
+		names := make([]string, len(options))
+		fmt.Fprintln(ctx.Stdout, msg)
+		for i, opt := range options {
+			if names[i], err = ctx.Display(opt); err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(ctx.Stdout, "  %d) %s\n", i+1, names[i])
+		}
+		for {
+			answer, err := ctx.ask("> ")
+			if err != nil {
+				return nil, err
+			}
+			if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+				return options[n-1], nil
+			}
+			for i, name := range names {
+				if name == answer {
+					return options[i], nil
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	register(promptBuiltins)
+}
@@ -0,0 +1,417 @@
+package builtins
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+// Generator makes random values of one kind for quickcheck, larger as size
+// grows, and smaller versions of a value to shrink a failing case with.
+type Generator struct {
+	name   string
+	gen    func(r *rand.Rand, size int) interface{}
+	shrink func(val interface{}) []interface{}
+}
+
+func (g *Generator) String() string { return fmt.Sprintf("<generator %s>", g.name) }
+
+// quickcheckRuns is how many cases quickcheck tries unless told otherwise.
+const quickcheckRuns = 100
+
+func intGenerator(lo, hi float64) *Generator {
+	return &Generator{
+		name: "int",
+		gen: func(r *rand.Rand, size int) interface{} {
+			if math.IsInf(lo, -1) {
+				return float64(r.Intn(2*size+1) - size)
+			}
+			return lo + float64(r.Int63n(int64(hi-lo)+1))
+		},
+		shrink: func(val interface{}) []interface{} {
+			x := val.(float64)
+			target := min(max(0, lo), hi)
+			var out []interface{}
+			for _, c := range []float64{target, math.Trunc((x + target) / 2), x - math.Copysign(1, x-target)} {
+				if c != x && c >= lo && c <= hi && math.Abs(c-target) < math.Abs(x-target) {
+					out = append(out, c)
+				}
+			}
+			return out
+		},
+	}
+}
+
+var numberGenerator = &Generator{
+	name: "number",
+	gen: func(r *rand.Rand, size int) interface{} {
+		return (r.Float64()*2 - 1) * float64(size)
+	},
+	shrink: func(val interface{}) []interface{} {
+		x := val.(float64)
+		if x == 0 {
+			return nil
+		}
+		out := []interface{}{0.0}
+		if t := math.Trunc(x); t != x {
+			out = append(out, t)
+		}
+		if math.Abs(x) > 1e-9 {
+			out = append(out, x/2)
+		}
+		return out
+	},
+}
+
+var boolGenerator = &Generator{
+	name: "bool",
+	gen:  func(r *rand.Rand, size int) interface{} { return r.Intn(2) == 1 },
+	shrink: func(val interface{}) []interface{} {
+		if val == true {
+			return []interface{}{false}
+		}
+		return nil
+	},
+}
+
+const stringChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 _-.,!?\n\"é€"
+
+var stringGenerator = &Generator{
+	name: "string",
+	gen: func(r *rand.Rand, size int) interface{} {
+		chars := []rune(stringChars)
+		out := make([]rune, r.Intn(size+1))
+		for i := range out {
+			out[i] = chars[r.Intn(len(chars))]
+		}
+		return string(out)
+	},
+	shrink: func(val interface{}) []interface{} {
+		s := []rune(val.(string))
+		var out []interface{}
+		for _, cut := range cuts(len(s)) {
+			out = append(out, string(without(s, cut)))
+		}
+		for i, c := range s {
+			if c != 'a' {
+				t := append([]rune{}, s...)
+				t[i] = 'a'
+				out = append(out, string(t))
+				break
+			}
+		}
+		return out
+	},
+}
+
+// cuts gives the parts to cut out of a slice of n to shrink it: all of
+// it, either half, then each element.
+func cuts(n int) [][2]int {
+	if n == 0 {
+		return nil
+	}
+	out := [][2]int{{0, n}}
+	if n > 1 {
+		out = append(out, [2]int{n / 2, n}, [2]int{0, n / 2})
+	}
+	for i := 0; i < n && i < 32; i++ {
+		out = append(out, [2]int{i, i + 1})
+	}
+	return out
+}
+
+func without[T any](x []T, cut [2]int) []T {
+	return slices.Concat(x[:cut[0]], x[cut[1]:])
+}
+
+func arrayGenerator(elem *Generator) *Generator {
+	return &Generator{
+		name: "array of " + elem.name,
+		gen: func(r *rand.Rand, size int) interface{} {
+			out := make([]interface{}, r.Intn(size+1))
+			for i := range out {
+				out[i] = elem.gen(r, size)
+			}
+			return out
+		},
+		shrink: func(val interface{}) []interface{} {
+			arr := val.([]interface{})
+			var out []interface{}
+			for _, cut := range cuts(len(arr)) {
+				out = append(out, without(arr, cut))
+			}
+			for i, x := range arr {
+				for _, smaller := range elem.shrink(x) {
+					t := append([]interface{}{}, arr...)
+					t[i] = smaller
+					out = append(out, t)
+				}
+			}
+			return out
+		},
+	}
+}
+
+func tableGenerator(value *Generator) *Generator {
+	return &Generator{
+		name: "table of " + value.name,
+		gen: func(r *rand.Rand, size int) interface{} {
+			out := make(map[string]interface{})
+			for n := r.Intn(size + 1); n > 0; n-- {
+				out[stringGenerator.gen(r, 8).(string)] = value.gen(r, size)
+			}
+			return out
+		},
+		shrink: func(val interface{}) []interface{} {
+			t := val.(map[string]interface{})
+			var out []interface{}
+			if len(t) > 0 {
+				out = append(out, map[string]interface{}{})
+			}
+			keys := make([]string, 0, len(t))
+			for k := range t {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+			for _, k := range keys {
+				without := make(map[string]interface{}, len(t))
+				for k2, v2 := range t {
+					if k2 != k {
+						without[k2] = v2
+					}
+				}
+				out = append(out, without)
+				for _, smaller := range value.shrink(t[k]) {
+					changed := make(map[string]interface{}, len(t))
+					for k2, v2 := range t {
+						changed[k2] = v2
+					}
+					changed[k] = smaller
+					out = append(out, changed)
+				}
+			}
+			return out
+		},
+	}
+}
+
+func oneOfGenerator(options []interface{}) *Generator {
+	return &Generator{
+		name: "one_of",
+		gen:  func(r *rand.Rand, size int) interface{} { return options[r.Intn(len(options))] },
+		shrink: func(val interface{}) []interface{} {
+			for i, opt := range options {
+				if valuesEqual(opt, val) {
+					return options[:i]
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// toGenerator takes a generator or the name of a simple one.
+func toGenerator(name string, val interface{}) (*Generator, error) {
+	switch v := val.(type) {
+	case *Generator:
+		return v, nil
+	case string:
+		switch v {
+		case "int":
+			return intGenerator(math.Inf(-1), math.Inf(1)), nil
+		case "number":
+			return numberGenerator, nil
+		case "bool":
+			return boolGenerator, nil
+		case "string":
+			return stringGenerator, nil
+		}
+		return nil, fmt.Errorf("%s: unknown generator %q", name, v)
+	}
+	return nil, fmt.Errorf("%s requires generators", name)
+}
+
+// copyValue copies arrays and tables all the way down, so a property that
+// changes its arguments does not change the case kept for shrinking.
+func copyValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, x := range v {
+			out[i] = copyValue(x)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			out[k] = copyValue(x)
+		}
+		return out
+	}
+	return val
+}
+
+// falsifies calls the property with a case and gives why it fails, or nil.
+func falsifies(ctx *Context, fn interface{}, args []interface{}) (error, error) {
+	copied := make([]interface{}, len(args))
+	for i, a := range args {
+		copied[i] = copyValue(a)
+	}
+	res, err := ctx.CallFunction(fn, copied)
+	var exit *ExitError
+	if errors.As(err, &exit) {
+		return nil, err
+	}
+	if err != nil {
+		return withoutPosition(err), nil
+	}
+	if res != nil && !Truthy(res) {
+		return fmt.Errorf("returned %s", Repr(res, "")), nil
+	}
+	return nil, nil
+}
+
+var quickcheckBuiltins = map[string]BuiltinFunc{
+	"gen.int": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("gen.int expects 2 arguments (min, max)")
+		}
+		lo, ok1 := args[0].(float64)
+		hi, ok2 := args[1].(float64)
+		if !ok1 || !ok2 || lo > hi || lo != math.Trunc(lo) || hi != math.Trunc(hi) {
+			return nil, fmt.Errorf("gen.int requires whole numbers min <= max")
+		}
+		return intGenerator(lo, hi), nil
+	},
+
+	"gen.array": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("gen.array expects 1 argument (element generator)")
+		}
+		elem, err := toGenerator("gen.array", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return arrayGenerator(elem), nil
+	},
+
+	"gen.table": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("gen.table expects 1 argument (value generator)")
+		}
+		value, err := toGenerator("gen.table", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return tableGenerator(value), nil
+	},
+
+	"gen.one_of": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("gen.one_of expects 1 argument (array of values)")
+		}
+		options, ok := args[0].([]interface{})
+		if !ok || len(options) == 0 {
+			return nil, fmt.Errorf("gen.one_of requires non-empty array")
+		}
+		return oneOfGenerator(options), nil
+	},
+
+	// quickcheck calls fn with random arguments from the generators, one
+	// per parameter, and fails when fn returns false or stops with an
+	// error, after shrinking the arguments to a small case that still
+	// fails. opts may set "runs" and "seed".
+	"quickcheck": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) < 2 || len(args) > 3 || !isFunction(args[0]) {
+			return nil, fmt.Errorf("quickcheck expects 2 or 3 arguments (function, generators, options)")
+		}
+		list, ok := args[1].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("quickcheck generators must be array")
+		}
+		gens := make([]*Generator, len(list))
+		for i, g := range list {
+			var err error
+			if gens[i], err = toGenerator("quickcheck", g); err != nil {
+				return nil, err
+			}
+		}
+		runs, seed := quickcheckRuns, time.Now().UnixNano()
+		if len(args) == 3 {
+			opts, ok := args[2].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("quickcheck options must be table")
+			}
+			if n, ok := opts["runs"].(float64); ok && n >= 1 {
+				runs = int(n)
+			}
+			if s, ok := opts["seed"].(float64); ok {
+				seed = int64(s)
+			}
+		}
+		if ctx.CallFunction == nil {
+			return nil, fmt.Errorf("quickcheck is not available here")
+		}
+		r := rand.New(rand.NewSource(seed))
+		for run := 0; run < runs; run++ {
+			size := 1 + run*100/runs
+			cases := make([]interface{}, len(gens))
+			for i, g := range gens {
+				cases[i] = g.gen(r, size)
+			}
+			why, err := falsifies(ctx, args[0], cases)
+			if err != nil {
+				return nil, err
+			}
+			if why == nil {
+				continue
+			}
+			original := Repr(cases, "")
+			shrinks := 0
+		shrinking:
+			for shrinks < 1000 {
+				for i, g := range gens {
+					for _, smaller := range g.shrink(cases[i]) {
+						try := append([]interface{}{}, cases...)
+						try[i] = smaller
+						w, err := falsifies(ctx, args[0], try)
+						if err != nil {
+							return nil, err
+						}
+						if w != nil {
+							cases, why = try, w
+							shrinks++
+							continue shrinking
+						}
+					}
+				}
+				break
+			}
+			msg := fmt.Sprintf("quickcheck failed after %d run(s) with seed %d, with arguments %s", run+1, seed, Repr(cases, ""))
+			if shrinks > 0 {
+				msg += fmt.Sprintf(" (shrunk from %s)", original)
+			}
+			return nil, assertFail("%s: %v", msg, why)
+		}
+		return nil, nil
+	},
+}
+
+func constGenerator(g *Generator) BuiltinFunc {
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("gen.%s expects 0 arguments", g.name)
+		}
+		return g, nil
+	}
+}
+
+func init() {
+	quickcheckBuiltins["gen.number"] = constGenerator(numberGenerator)
+	quickcheckBuiltins["gen.bool"] = constGenerator(boolGenerator)
+	quickcheckBuiltins["gen.string"] = constGenerator(stringGenerator)
+	register(quickcheckBuiltins)
+}
@@ -0,0 +1,57 @@
+package builtins
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+func randomBytes(name string, args []interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s expects 1 argument (count)", name)
+	}
+	n, ok := args[0].(float64)
+	if !ok || n < 0 {
+		return nil, fmt.Errorf("%s count must be a positive number", name)
+	}
+	buf := make([]byte, int(n))
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate random bytes: %v", err)
+	}
+	return buf, nil
+}
+
+var randomBuiltins = map[string]BuiltinFunc{
+	"uuid": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("uuid expects 0 arguments")
+		}
+		var u [16]byte
+		if _, err := rand.Read(u[:]); err != nil {
+			return nil, fmt.Errorf("failed to generate uuid: %v", err)
+		}
+		u[6] = (u[6] & 0x0F) | 0x40
+		u[8] = (u[8] & 0x3F) | 0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16]), nil
+	},
+
+	"random_bytes": func(ctx *Context, args []interface{}) (interface{}, error) {
+		buf, err := randomBytes("random_bytes", args)
+		if err != nil {
+			return nil, err
+		}
+		return string(buf), nil
+	},
+
+	"random_hex": func(ctx *Context, args []interface{}) (interface{}, error) {
+		buf, err := randomBytes("random_hex", args)
+		if err != nil {
+			return nil, err
+		}
+		return hex.EncodeToString(buf), nil
+	},
+}
+
+func init() {
+	register(randomBuiltins)
+}
@@ -0,0 +1,158 @@
+package builtins
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+func requestTable(r *http.Request) (map[string]interface{}, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	query := make(map[string]interface{})
+	for k, v := range r.URL.Query() {
+		query[k] = v[0]
+	}
+	return map[string]interface{}{
+		"method":  r.Method,
+		"path":    r.URL.Path,
+		"query":   query,
+		"headers": fromHeaders(r.Header),
+		"body":    string(body),
+		"remote":  r.RemoteAddr,
+	}, nil
+}
+
+func writeResponse(w http.ResponseWriter, res interface{}) {
+	switch r := res.(type) {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case string:
+		io.WriteString(w, r)
+	case map[string]interface{}:
+		if headers, ok := r["headers"].(map[string]interface{}); ok {
+			for k, v := range headers {
+				w.Header().Set(k, fmt.Sprintf("%v", v))
+			}
+		}
+		status := http.StatusOK
+		if s, ok := r["status"].(float64); ok {
+			status = int(s)
+		}
+		w.WriteHeader(status)
+		if body, ok := r["body"]; ok && body != nil {
+			io.WriteString(w, fmt.Sprintf("%v", body))
+		}
+	default:
+		io.WriteString(w, fmt.Sprintf("%v", r))
+	}
+}
+
+// vmTurn hands the vm to one http_serve request at a time. A websocket
+// session gives it up while it waits for a message, but as the vm's frames
+// nest, a session only takes it back once the sessions that started after
+// it have ended.
+type vmTurn struct {
+	mu       sync.Mutex
+	ended    *sync.Cond
+	sessions []*WebSocket
+}
+
+func newVMTurn() *vmTurn {
+	t := &vmTurn{}
+	t.ended = sync.NewCond(&t.mu)
+	return t
+}
+
+// session runs fn as the websocket session ws, with the turn held.
+func (t *vmTurn) session(ws *WebSocket, fn func()) {
+	ws.turn = t
+	t.sessions = append(t.sessions, ws)
+	defer func() {
+		t.sessions = t.sessions[:len(t.sessions)-1]
+		t.ended.Broadcast()
+	}()
+	fn()
+}
+
+// wait runs read, a blocking read of ws, without the turn.
+func (t *vmTurn) wait(ws *WebSocket, read func()) {
+	t.mu.Unlock()
+	read()
+	t.mu.Lock()
+	for t.sessions[len(t.sessions)-1] != ws {
+		t.ended.Wait()
+	}
+}
+
+var serverBuiltins = map[string]BuiltinFunc{
+	"http_serve": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("http_serve expects 2 arguments (addr, handler)")
+		}
+		addr, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("http_serve addr must be string")
+		}
+		if err := ctx.checkNet("http_serve", addr); err != nil {
+			return nil, err
+		}
+		handler := args[1]
+		if ctx.CallFunction == nil {
+			return nil, fmt.Errorf("http_serve requires a running vm")
+		}
+
+		// the vm is single threaded, requests are handled one at a time
+		turn := newVMTurn()
+		var exitErr *ExitError
+		var server *http.Server
+		server = &http.Server{
+			Addr: addr,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req, err := requestTable(r)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				turn.mu.Lock()
+				defer turn.mu.Unlock()
+				res, err := ctx.CallFunction(handler, []interface{}{req})
+				if errors.As(err, &exitErr) {
+					go server.Close()
+					return
+				}
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if tbl, ok := res.(map[string]interface{}); ok && tbl["websocket"] != nil {
+					ws, err := upgradeWebSocket(w, r)
+					if err != nil {
+						return
+					}
+					defer ws.Close()
+					turn.session(ws, func() {
+						ctx.CallFunction(tbl["websocket"], []interface{}{ws})
+					})
+					return
+				}
+				writeResponse(w, res)
+			}),
+		}
+		if err := server.ListenAndServe(); err != nil {
+			if exitErr != nil {
+				return nil, exitErr
+			}
+			return nil, fmt.Errorf("http server failed: %v", err)
+		}
+		return nil, nil
+	},
+}
+
+func init() {
+	register(serverBuiltins)
+}
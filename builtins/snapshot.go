@@ -0,0 +1,62 @@
+package builtins
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Snapshots is where assert_snapshot keeps the values it compares
+// against, one file per name in Dir. Missing files are written, and with
+// Update all of them are. Written counts those.
+type Snapshots struct {
+	Dir     string
+	Update  bool
+	Written int
+}
+
+var snapshotBuiltins = map[string]BuiltinFunc{
+	// assert_snapshot compares a value, pretty-printed, with the one saved
+	// under name by an earlier run, which the first run saves.
+	"assert_snapshot": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("assert_snapshot expects 2 arguments (name, value)")
+		}
+		name, ok := args[0].(string)
+		if !ok || name == "" || strings.ContainsAny(name, `/\`) || name[0] == '.' {
+			return nil, fmt.Errorf("assert_snapshot name must be a file name")
+		}
+		s := ctx.Snapshots
+		if s == nil {
+			return nil, fmt.Errorf("assert_snapshot only works under lightlang test")
+		}
+		got := Repr(args[1], "  ") + "\n"
+		path := filepath.Join(s.Dir, name+".snap")
+		want, err := os.ReadFile(path)
+		if s.Update || errors.Is(err, fs.ErrNotExist) {
+			if err := os.MkdirAll(s.Dir, 0755); err != nil {
+				return nil, fmt.Errorf("failed to write snapshot: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+				return nil, fmt.Errorf("failed to write snapshot: %v", err)
+			}
+			s.Written++
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot: %v", err)
+		}
+		if string(want) != got {
+			diff := lineDiff(strings.TrimSuffix(string(want), "\n"), strings.TrimSuffix(got, "\n"))
+			return nil, assertFail("assert_snapshot failed: %s differs from %s (- want, + got)\n%s", name, path, strings.TrimSuffix(diff, "\n"))
+		}
+		return nil, nil
+	},
+}
+
+func init() {
+	register(snapshotBuiltins)
+}
@@ -0,0 +1,137 @@
+package builtins
+
+import (
+	"cmp"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// order ranks the kinds of values sort puts apart: nil, then booleans,
+// numbers, strings and everything else.
+func order(val interface{}) int {
+	switch val.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64, *big.Int, *Decimal:
+		return 2
+	case string:
+		return 3
+	}
+	return 4
+}
+
+// compareValues is the default order of sort: values of the same kind by
+// value, false before true, and arrays, tables and the like kept as they
+// are.
+func compareValues(a, b interface{}) int {
+	if c := cmp.Compare(order(a), order(b)); c != 0 {
+		return c
+	}
+	switch av := a.(type) {
+	case bool:
+		bv := b.(bool)
+		if av == bv {
+			return 0
+		}
+		if bv {
+			return -1
+		}
+		return 1
+	case string:
+		return strings.Compare(av, b.(string))
+	case float64:
+		if bv, ok := b.(float64); ok {
+			return cmp.Compare(av, bv)
+		}
+	}
+	c, _ := Compare(a, b)
+	return c
+}
+
+// sortStable sorts a copy of arr with less, stopping at its first error.
+func sortStable(arr []interface{}, less func(a, b interface{}) (bool, error)) ([]interface{}, error) {
+	out := slices.Clone(arr)
+	var err error
+	slices.SortStableFunc(out, func(a, b interface{}) int {
+		if err != nil {
+			return 0
+		}
+		var ab, ba bool
+		if ab, err = less(a, b); err != nil || ab {
+			return -1
+		}
+		if ba, err = less(b, a); ba {
+			return 1
+		}
+		return 0
+	})
+	return out, err
+}
+
+var sortBuiltins = map[string]BuiltinFunc{
+	// sort returns a sorted copy of arr. cmp(a, b), if given, is true when
+	// a goes before b.
+	"sort": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("sort expects 1 or 2 arguments (array, cmp)")
+		}
+		arr, ok := args[0].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("sort requires array")
+		}
+		if len(args) == 1 {
+			out := slices.Clone(arr)
+			slices.SortStableFunc(out, compareValues)
+			return out, nil
+		}
+		fn := args[1]
+		if !isFunction(fn) {
+			return nil, fmt.Errorf("sort comparator must be a function")
+		}
+		if ctx.CallFunction == nil {
+			return nil, fmt.Errorf("sort with a comparator is not available here")
+		}
+		return sortStable(arr, func(a, b interface{}) (bool, error) {
+			res, err := ctx.CallFunction(fn, []interface{}{a, b})
+			return Truthy(res), err
+		})
+	},
+
+	// sort_by returns a copy of arr sorted by what key gives for each
+	// element, calling it once per element.
+	"sort_by": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 || !isFunction(args[1]) {
+			return nil, fmt.Errorf("sort_by expects 2 arguments (array, function)")
+		}
+		arr, ok := args[0].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("sort_by requires array")
+		}
+		if ctx.CallFunction == nil {
+			return nil, fmt.Errorf("sort_by is not available here")
+		}
+		type keyed struct{ key, val interface{} }
+		items := make([]keyed, len(arr))
+		for i, val := range arr {
+			key, err := ctx.CallFunction(args[1], []interface{}{val})
+			if err != nil {
+				return nil, err
+			}
+			items[i] = keyed{key, val}
+		}
+		slices.SortStableFunc(items, func(a, b keyed) int { return compareValues(a.key, b.key) })
+		out := make([]interface{}, len(items))
+		for i, it := range items {
+			out[i] = it.val
+		}
+		return out, nil
+	},
+}
+
+func init() {
+	register(sortBuiltins)
+}
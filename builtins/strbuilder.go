@@ -0,0 +1,73 @@
+package builtins
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// StringBuilder collects text to join once with build, where adding to a
+// string in a loop copies it every time.
+type StringBuilder struct {
+	sb    strings.Builder
+	runes int
+}
+
+// Len counts characters, like len of a string.
+func (b *StringBuilder) Len() int { return b.runes }
+
+// Size is the bytes held, for memory limits.
+func (b *StringBuilder) Size() int { return b.sb.Cap() }
+
+func (b *StringBuilder) String() string { return fmt.Sprintf("<string builder of %d>", b.runes) }
+
+var stringBuilderBuiltins = map[string]BuiltinFunc{
+	"string_builder": func(ctx *Context, args []interface{}) (interface{}, error) {
+		b := &StringBuilder{}
+		for _, arg := range args {
+			s, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("string_builder expects strings to start with")
+			}
+			b.sb.WriteString(s)
+			b.runes += utf8.RuneCountInString(s)
+		}
+		return b, nil
+	},
+
+	// append adds values to a builder as print shows them and returns the
+	// builder.
+	"append": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("append expects a string builder and values")
+		}
+		b, ok := args[0].(*StringBuilder)
+		if !ok {
+			return nil, fmt.Errorf("append requires string builder")
+		}
+		for _, arg := range args[1:] {
+			s, err := ctx.Display(arg)
+			if err != nil {
+				return nil, err
+			}
+			b.sb.WriteString(s)
+			b.runes += utf8.RuneCountInString(s)
+		}
+		return b, nil
+	},
+
+	"build": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("build expects 1 argument (string builder)")
+		}
+		b, ok := args[0].(*StringBuilder)
+		if !ok {
+			return nil, fmt.Errorf("build requires string builder")
+		}
+		return b.sb.String(), nil
+	},
+}
+
+func init() {
+	register(stringBuilderBuiltins)
+}
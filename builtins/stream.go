@@ -0,0 +1,292 @@
+package builtins
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Stream is a lazy sequence of values. Each stage pulls from the one
+// before it only when asked, so a pipeline over a large file or array
+// holds one value at a time until collect. A stream can be read once.
+type Stream struct {
+	next  func() (interface{}, bool, error)
+	close func() error
+	done  bool
+}
+
+func (s *Stream) String() string { return "<stream>" }
+
+// Iterator is a value for-in pulls from instead of indexing it. Next gives
+// the next value, or false at the end.
+type Iterator interface {
+	Next() (interface{}, bool, error)
+}
+
+func (s *Stream) Next() (interface{}, bool, error) { return s.pull() }
+
+// pull returns the next value, or false at the end, where the stream is
+// closed.
+func (s *Stream) pull() (interface{}, bool, error) {
+	if s.done {
+		return nil, false, nil
+	}
+	val, ok, err := s.next()
+	if !ok || err != nil {
+		if cerr := s.stop(); err == nil {
+			err = cerr
+		}
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+// stop ends the stream early, closing what it reads from.
+func (s *Stream) stop() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	if s.close != nil {
+		return s.close()
+	}
+	return nil
+}
+
+// from makes a stage reading from src, so that ending it ends src.
+func from(src *Stream, next func() (interface{}, bool, error)) *Stream {
+	return &Stream{next: next, close: src.stop}
+}
+
+func toStream(name string, val interface{}) (*Stream, error) {
+	s, ok := val.(*Stream)
+	if !ok {
+		return nil, fmt.Errorf("%s requires stream", name)
+	}
+	return s, nil
+}
+
+// stage checks the arguments of a stage that takes a stream and a
+// function.
+func stage(ctx *Context, name string, args []interface{}) (*Stream, interface{}, error) {
+	if len(args) != 2 || !isFunction(args[1]) {
+		return nil, nil, fmt.Errorf("%s expects 2 arguments (stream, function)", name)
+	}
+	s, err := toStream(name, args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if ctx.CallFunction == nil {
+		return nil, nil, fmt.Errorf("%s is not available here", name)
+	}
+	return s, args[1], nil
+}
+
+// countStage checks the arguments of take, drop and chunk.
+func countStage(name string, args []interface{}) (*Stream, int, error) {
+	if len(args) != 2 {
+		return nil, 0, fmt.Errorf("%s expects 2 arguments (stream, count)", name)
+	}
+	s, err := toStream(name, args[0])
+	if err != nil {
+		return nil, 0, err
+	}
+	n, ok := args[1].(float64)
+	if !ok || n < 0 {
+		return nil, 0, fmt.Errorf("%s count must be a positive number", name)
+	}
+	return s, int(n), nil
+}
+
+// lineStream reads the lines of the file at a path, which it closes at
+// the end, or of an open file handle.
+func lineStream(ctx *Context, name string, src interface{}) (*Stream, error) {
+	var r *bufio.Reader
+	var closer io.Closer
+	switch src := src.(type) {
+	case string:
+		if err := ctx.checkRead(name, src); err != nil {
+			return nil, err
+		}
+		f, err := ctx.open(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %v", err)
+		}
+		r, closer = bufio.NewReader(f), f
+	default:
+		h, err := toFileHandle(name, src)
+		if err != nil {
+			return nil, err
+		}
+		r = h.reader
+	}
+	s := &Stream{next: func() (interface{}, bool, error) {
+		line, ok, err := readLine(r)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read file: %v", err)
+		}
+		return line, ok, nil
+	}}
+	if closer != nil {
+		s.close = closer.Close
+	}
+	return s, nil
+}
+
+var streamBuiltins = map[string]BuiltinFunc{
+	"stream": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("stream expects 1 argument (array)")
+		}
+		arr, ok := args[0].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("stream requires array")
+		}
+		i := 0
+		return &Stream{next: func() (interface{}, bool, error) {
+			if i >= len(arr) {
+				return nil, false, nil
+			}
+			i++
+			return arr[i-1], true, nil
+		}}, nil
+	},
+
+	"stream_lines": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("stream_lines expects 1 argument (path or file handle)")
+		}
+		return lineStream(ctx, "stream_lines", args[0])
+	},
+
+	"stream.map": func(ctx *Context, args []interface{}) (interface{}, error) {
+		s, fn, err := stage(ctx, "stream.map", args)
+		if err != nil {
+			return nil, err
+		}
+		return from(s, func() (interface{}, bool, error) {
+			val, ok, err := s.pull()
+			if !ok {
+				return nil, false, err
+			}
+			res, err := ctx.CallFunction(fn, []interface{}{val})
+			return res, err == nil, err
+		}), nil
+	},
+
+	"stream.filter": func(ctx *Context, args []interface{}) (interface{}, error) {
+		s, fn, err := stage(ctx, "stream.filter", args)
+		if err != nil {
+			return nil, err
+		}
+		return from(s, func() (interface{}, bool, error) {
+			for {
+				val, ok, err := s.pull()
+				if !ok {
+					return nil, false, err
+				}
+				keep, err := ctx.CallFunction(fn, []interface{}{val})
+				if err != nil {
+					return nil, false, err
+				}
+				if Truthy(keep) {
+					return val, true, nil
+				}
+			}
+		}), nil
+	},
+
+	"stream.take": func(ctx *Context, args []interface{}) (interface{}, error) {
+		s, n, err := countStage("stream.take", args)
+		if err != nil {
+			return nil, err
+		}
+		return from(s, func() (interface{}, bool, error) {
+			if n <= 0 {
+				return nil, false, nil
+			}
+			n--
+			return s.pull()
+		}), nil
+	},
+
+	"stream.drop": func(ctx *Context, args []interface{}) (interface{}, error) {
+		s, n, err := countStage("stream.drop", args)
+		if err != nil {
+			return nil, err
+		}
+		return from(s, func() (interface{}, bool, error) {
+			for ; n > 0; n-- {
+				if _, ok, err := s.pull(); !ok {
+					return nil, false, err
+				}
+			}
+			return s.pull()
+		}), nil
+	},
+
+	"stream.chunk": func(ctx *Context, args []interface{}) (interface{}, error) {
+		s, n, err := countStage("stream.chunk", args)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("stream.chunk size must be at least 1")
+		}
+		return from(s, func() (interface{}, bool, error) {
+			chunk := make([]interface{}, 0, n)
+			for len(chunk) < n {
+				val, ok, err := s.pull()
+				if err != nil {
+					return nil, false, err
+				}
+				if !ok {
+					break
+				}
+				chunk = append(chunk, val)
+			}
+			return chunk, len(chunk) > 0, nil
+		}), nil
+	},
+
+	"stream.collect": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("stream.collect expects 1 argument (stream)")
+		}
+		s, err := toStream("stream.collect", args[0])
+		if err != nil {
+			return nil, err
+		}
+		result := make([]interface{}, 0)
+		for {
+			val, ok, err := s.pull()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return result, nil
+			}
+			result = append(result, val)
+		}
+	},
+
+	"stream.each": func(ctx *Context, args []interface{}) (interface{}, error) {
+		s, fn, err := stage(ctx, "stream.each", args)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			val, ok, err := s.pull()
+			if !ok {
+				return nil, err
+			}
+			if _, err := ctx.CallFunction(fn, []interface{}{val}); err != nil {
+				return nil, err
+			}
+		}
+	},
+}
+
+func init() {
+	register(streamBuiltins)
+}
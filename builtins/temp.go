@@ -0,0 +1,57 @@
+package builtins
+
+import (
+	"fmt"
+	"os"
+)
+
+// tempBuiltin makes tempfile and tempdir, which take an optional name
+// pattern, where the last "*" is replaced by a random string, and remove
+// what they made when the program ends if the second argument is true.
+func tempBuiltin(name string, create func(pattern string) (string, error)) BuiltinFunc {
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) > 2 {
+			return nil, fmt.Errorf("%s expects 0 to 2 arguments (pattern, cleanup)", name)
+		}
+		pattern := ""
+		if len(args) > 0 {
+			s, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("%s pattern must be string", name)
+			}
+			pattern = s
+		}
+		cleanup := len(args) == 2 && Truthy(args[1])
+		if cleanup && ctx.AtExit == nil {
+			return nil, fmt.Errorf("%s cleanup is not available here", name)
+		}
+		if err := ctx.checkWrite(name, os.TempDir()); err != nil {
+			return nil, err
+		}
+		path, err := create(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if cleanup {
+			ctx.AtExit(func() error { return os.RemoveAll(path) })
+		}
+		return path, nil
+	}
+}
+
+var tempBuiltins = map[string]BuiltinFunc{
+	"tempfile": tempBuiltin("tempfile", func(pattern string) (string, error) {
+		f, err := os.CreateTemp("", pattern)
+		if err != nil {
+			return "", err
+		}
+		return f.Name(), f.Close()
+	}),
+	"tempdir": tempBuiltin("tempdir", func(pattern string) (string, error) {
+		return os.MkdirTemp("", pattern)
+	}),
+}
+
+func init() {
+	register(tempBuiltins)
+}
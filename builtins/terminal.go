@@ -0,0 +1,307 @@
+package builtins
+
+import (
+	"fmt"
+	"io"
+	"lightlang/term"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// terminalOut gives the terminal w writes to, if it is one.
+func terminalOut(w io.Writer) (int, bool) {
+	f, ok := w.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return 0, false
+	}
+	return int(f.Fd()), true
+}
+
+// termSize gives the size of the terminal of stdout or stderr, or else
+// what $COLUMNS and $LINES say, or 80 by 24.
+func (c *Context) termSize() (cols, rows int) {
+	for _, w := range []io.Writer{c.Stdout, c.Stderr} {
+		if fd, ok := terminalOut(w); ok {
+			if cols, rows, err := term.Size(fd); err == nil && cols > 0 {
+				return cols, rows
+			}
+		}
+	}
+	cols, rows = 80, 24
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		cols = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil && n > 0 {
+		rows = n
+	}
+	return cols, rows
+}
+
+// control makes the builtins that write an escape sequence to stdout,
+// when it is a terminal. A %d in seq takes a count, which defaults to 1.
+func control(name, seq string) BuiltinFunc {
+	counted := strings.Contains(seq, "%d")
+	return func(ctx *Context, args []interface{}) (interface{}, error) {
+		n := 1.0
+		switch {
+		case counted && len(args) == 1:
+			var ok bool
+			if n, ok = args[0].(float64); !ok || n < 1 {
+				return nil, fmt.Errorf("%s count must be a positive number", name)
+			}
+		case counted && len(args) > 1:
+			return nil, fmt.Errorf("%s expects 0 or 1 argument (count)", name)
+		case !counted && len(args) != 0:
+			return nil, fmt.Errorf("%s expects 0 arguments", name)
+		}
+		if _, ok := terminalOut(ctx.Stdout); ok {
+			if counted {
+				seq = fmt.Sprintf(seq, int(n))
+			}
+			fmt.Fprint(ctx.Stdout, seq)
+		}
+		return nil, nil
+	}
+}
+
+var keyNames = map[string]string{
+	"[A": "up", "OA": "up", "[B": "down", "OB": "down", "[C": "right", "OC": "right", "[D": "left", "OD": "left",
+	"[H": "home", "OH": "home", "[1~": "home", "[F": "end", "OF": "end", "[4~": "end",
+	"[2~": "insert", "[3~": "delete", "[5~": "pageup", "[6~": "pagedown",
+}
+
+// keyName names what one read from a raw terminal gave: a character, a
+// key like "up", "enter" or "ctrl+c", or text pasted at once.
+func keyName(b []byte) string {
+	if b[0] == 27 {
+		seq := string(b[1:])
+		if name, ok := keyNames[seq]; ok {
+			return name
+		}
+		if seq == "" {
+			return "escape"
+		}
+		if utf8.RuneCountInString(seq) == 1 {
+			return "alt+" + seq
+		}
+		return string(b)
+	}
+	if len(b) == 1 {
+		switch c := b[0]; {
+		case c == '\r' || c == '\n':
+			return "enter"
+		case c == '\t':
+			return "tab"
+		case c == 127 || c == 8:
+			return "backspace"
+		case c >= 1 && c <= 26:
+			return "ctrl+" + string(rune('a'+c-1))
+		}
+	}
+	return string(b)
+}
+
+// Progress is a progress bar drawn on stderr while it is a terminal.
+type Progress struct {
+	Label        string
+	Done, Total  float64
+	last         string
+	out          io.Writer
+	width        int
+	finished, on bool
+}
+
+func (p *Progress) String() string {
+	return fmt.Sprintf("<progress %s/%s>", Repr(p.Done, ""), Repr(p.Total, ""))
+}
+
+// draw writes the bar again when what it shows has changed.
+func (p *Progress) draw() {
+	if !p.on {
+		return
+	}
+	frac := 0.0
+	if p.Total > 0 {
+		frac = min(max(p.Done/p.Total, 0), 1)
+	}
+	counts := fmt.Sprintf(" %3d%% %s/%s", int(frac*100), Repr(p.Done, ""), Repr(p.Total, ""))
+	label := p.Label
+	if label != "" {
+		label += " "
+	}
+	size := min(40, p.width-utf8.RuneCountInString(label)-len(counts)-3)
+	bar := ""
+	if size > 0 {
+		fill := int(frac * float64(size))
+		bar = "[" + strings.Repeat("#", fill) + strings.Repeat("-", size-fill) + "]"
+	}
+	line := label + bar + counts
+	if line != p.last {
+		fmt.Fprint(p.out, "\r\x1b[K"+line)
+		p.last = line
+	}
+}
+
+func toProgress(name string, args []interface{}, n int) (*Progress, error) {
+	if len(args) < 1 || len(args) > n {
+		return nil, fmt.Errorf("%s expects a progress bar and up to %d more argument(s)", name, n-1)
+	}
+	p, ok := args[0].(*Progress)
+	if !ok {
+		return nil, fmt.Errorf("%s requires progress bar", name)
+	}
+	if p.finished {
+		return nil, fmt.Errorf("%s: progress bar is done", name)
+	}
+	return p, nil
+}
+
+var terminalBuiltins = map[string]BuiltinFunc{
+	"term_size": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("term_size expects 0 arguments")
+		}
+		cols, rows := ctx.termSize()
+		return map[string]interface{}{"cols": float64(cols), "rows": float64(rows)}, nil
+	},
+
+	// cursor_move puts the cursor at a row and column, counted from 1.
+	"cursor_move": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("cursor_move expects 2 arguments (row, col)")
+		}
+		row, ok1 := args[0].(float64)
+		col, ok2 := args[1].(float64)
+		if !ok1 || !ok2 || row < 1 || col < 1 {
+			return nil, fmt.Errorf("cursor_move row and col must be positive numbers")
+		}
+		if _, ok := terminalOut(ctx.Stdout); ok {
+			fmt.Fprintf(ctx.Stdout, "\x1b[%d;%dH", int(row), int(col))
+		}
+		return nil, nil
+	},
+
+	"cursor_up":    control("cursor_up", "\x1b[%dA"),
+	"cursor_down":  control("cursor_down", "\x1b[%dB"),
+	"cursor_right": control("cursor_right", "\x1b[%dC"),
+	"cursor_left":  control("cursor_left", "\x1b[%dD"),
+	"cursor_hide":  control("cursor_hide", "\x1b[?25l"),
+	"cursor_show":  control("cursor_show", "\x1b[?25h"),
+	"clear_line":   control("clear_line", "\r\x1b[2K"),
+	"clear_screen": control("clear_screen", "\x1b[H\x1b[2J"),
+
+	// read_key waits for a key without the user pressing enter, and gives
+	// nil at the end of input. On a terminal, Ctrl-C is read as "ctrl+c"
+	// rather than stopping the program.
+	"read_key": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("read_key expects 0 arguments")
+		}
+		fd, ok := ctx.terminalFd()
+		if !ok {
+			b := make([]byte, 0, utf8.UTFMax)
+			one := make([]byte, 1)
+			for !utf8.FullRune(b) {
+				if _, err := ctx.Stdin.Read(one); err != nil {
+					if err == io.EOF {
+						return nil, nil
+					}
+					return nil, fmt.Errorf("failed to read input: %v", err)
+				}
+				b = append(b, one[0])
+			}
+			return keyName(b), nil
+		}
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return nil, fmt.Errorf("read_key: %v", err)
+		}
+		defer term.Restore(fd, state)
+		buf := make([]byte, 64)
+		n, err := ctx.Stdin.Read(buf)
+		if err == io.EOF || n == 0 {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input: %v", err)
+		}
+		return keyName(buf[:n]), nil
+	},
+
+	// progress makes a bar for total steps, drawn on stderr when it is a
+	// terminal and left out otherwise.
+	"progress": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("progress expects 1 or 2 arguments (total, label)")
+		}
+		total, ok := args[0].(float64)
+		if !ok || total < 0 {
+			return nil, fmt.Errorf("progress total must be a number of at least 0")
+		}
+		p := &Progress{Total: total, out: ctx.Stderr}
+		if len(args) == 2 {
+			if p.Label, ok = args[1].(string); !ok {
+				return nil, fmt.Errorf("progress label must be string")
+			}
+		}
+		if _, p.on = terminalOut(ctx.Stderr); p.on {
+			p.width, _ = ctx.termSize()
+		}
+		p.draw()
+		return p, nil
+	},
+
+	// progress_step moves a bar on by n steps, 1 unless given.
+	"progress_step": func(ctx *Context, args []interface{}) (interface{}, error) {
+		p, err := toProgress("progress_step", args, 2)
+		if err != nil {
+			return nil, err
+		}
+		n := 1.0
+		if len(args) == 2 {
+			var ok bool
+			if n, ok = args[1].(float64); !ok {
+				return nil, fmt.Errorf("progress_step steps must be number")
+			}
+		}
+		p.Done += n
+		p.draw()
+		return nil, nil
+	},
+
+	"progress_set": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("progress_set expects 2 arguments (progress bar, done)")
+		}
+		p, err := toProgress("progress_set", args, 2)
+		if err != nil {
+			return nil, err
+		}
+		done, ok := args[1].(float64)
+		if !ok {
+			return nil, fmt.Errorf("progress_set done must be number")
+		}
+		p.Done = done
+		p.draw()
+		return nil, nil
+	},
+
+	// progress_done ends a bar, leaving it on its own line.
+	"progress_done": func(ctx *Context, args []interface{}) (interface{}, error) {
+		p, err := toProgress("progress_done", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		p.finished = true
+		if p.on {
+			fmt.Fprintln(p.out)
+		}
+		return nil, nil
+	},
+}
+
+func init() {
+	register(terminalBuiltins)
+}
@@ -0,0 +1,152 @@
+package builtins
+
+import (
+	"fmt"
+	"net/url"
+)
+
+func queryToTable(values url.Values) map[string]interface{} {
+	result := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if len(v) == 1 {
+			result[k] = v[0]
+			continue
+		}
+		arr := make([]interface{}, len(v))
+		for i, s := range v {
+			arr[i] = s
+		}
+		result[k] = arr
+	}
+	return result
+}
+
+func tableToQuery(name string, val interface{}) (url.Values, error) {
+	tbl, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s requires table", name)
+	}
+	values := url.Values{}
+	for k, v := range tbl {
+		switch vv := v.(type) {
+		case nil:
+		case []interface{}:
+			for _, item := range vv {
+				values.Add(k, fmt.Sprintf("%v", item))
+			}
+		default:
+			values.Add(k, fmt.Sprintf("%v", vv))
+		}
+	}
+	return values, nil
+}
+
+var urlBuiltins = map[string]BuiltinFunc{
+	"url_parse": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("url_parse expects 1 argument")
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("url_parse requires string")
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid url: %v", err)
+		}
+		result := map[string]interface{}{
+			"scheme":   u.Scheme,
+			"host":     u.Host,
+			"hostname": u.Hostname(),
+			"port":     u.Port(),
+			"path":     u.Path,
+			"query":    queryToTable(u.Query()),
+			"fragment": u.Fragment,
+			"user":     nil,
+		}
+		if u.User != nil {
+			result["user"] = u.User.Username()
+			if pass, ok := u.User.Password(); ok {
+				result["password"] = pass
+			}
+		}
+		return result, nil
+	},
+
+	"url_build": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("url_build expects 1 argument")
+		}
+		tbl, ok := args[0].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("url_build requires table")
+		}
+		str := func(key string) string {
+			if v, ok := tbl[key]; ok && v != nil {
+				return fmt.Sprintf("%v", v)
+			}
+			return ""
+		}
+		u := url.URL{
+			Scheme:   str("scheme"),
+			Host:     str("host"),
+			Path:     str("path"),
+			Fragment: str("fragment"),
+		}
+		if u.Host == "" && str("hostname") != "" {
+			u.Host = str("hostname")
+			if port := str("port"); port != "" {
+				u.Host += ":" + port
+			}
+		}
+		if user := str("user"); user != "" {
+			if pass := str("password"); pass != "" {
+				u.User = url.UserPassword(user, pass)
+			} else {
+				u.User = url.User(user)
+			}
+		}
+		switch q := tbl["query"].(type) {
+		case nil:
+		case string:
+			u.RawQuery = q
+		default:
+			values, err := tableToQuery("url_build query", q)
+			if err != nil {
+				return nil, err
+			}
+			u.RawQuery = values.Encode()
+		}
+		return u.String(), nil
+	},
+
+	"query_encode": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("query_encode expects 1 argument")
+		}
+		values, err := tableToQuery("query_encode", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return values.Encode(), nil
+	},
+
+	"query_decode": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("query_decode expects 1 argument")
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("query_decode requires string")
+		}
+		values, err := url.ParseQuery(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query string: %v", err)
+		}
+		return queryToTable(values), nil
+	},
+}
+
+func init() {
+	register(urlBuiltins)
+}
@@ -0,0 +1,95 @@
+package builtins
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Equal compares numbers, booleans, strings, bigints and decimals by value
+// and tables, arrays and functions by identity, as == does in both the VM
+// and programs translated to Go.
+func Equal(a, b interface{}) bool {
+	if object(a) || object(b) {
+		if c, ok := Compare(a, b); ok {
+			return c == 0
+		}
+	}
+	switch a.(type) {
+	case nil:
+		return b == nil
+	case float64, bool, string:
+		return a == b
+	}
+	if !object(b) {
+		return false
+	}
+	ra, rb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if ra.Type() != rb.Type() {
+		return false
+	}
+	switch ra.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Pointer, reflect.Func:
+		return ra.Pointer() == rb.Pointer()
+	}
+	return ra.Interface() == rb.Interface()
+}
+
+func object(x interface{}) bool {
+	switch x.(type) {
+	case nil, float64, bool, string:
+		return false
+	}
+	return true
+}
+
+// smallKeys caches the keys of the first integer indexes.
+var smallKeys = func() [256]string {
+	var keys [256]string
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	return keys
+}()
+
+// NumberKey is TableKey for a number, without boxing it.
+func NumberKey(k float64) string {
+	if k >= 0 && k < float64(len(smallKeys)) && k == float64(int(k)) {
+		return smallKeys[int(k)]
+	}
+	return strconv.FormatFloat(k, 'g', -1, 64)
+}
+
+// TableKey converts an index to a table key. It gives the same result as
+// fmt.Sprintf("%v", index) without going through fmt for strings and
+// numbers.
+func TableKey(index interface{}) string {
+	switch k := index.(type) {
+	case string:
+		return k
+	case float64:
+		return NumberKey(k)
+	case int:
+		if k >= 0 && k < len(smallKeys) {
+			return smallKeys[k]
+		}
+		return strconv.Itoa(k)
+	}
+	return fmt.Sprintf("%v", index)
+}
+
+// RuneAt returns the character at index i of s, counted in runes.
+func RuneAt(s string, i int) (string, bool) {
+	if i < 0 {
+		return "", false
+	}
+	for pos := 0; pos < len(s); i-- {
+		_, size := utf8.DecodeRuneInString(s[pos:])
+		if i == 0 {
+			return s[pos : pos+size], true
+		}
+		pos += size
+	}
+	return "", false
+}
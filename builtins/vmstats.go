@@ -0,0 +1,107 @@
+package builtins
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// VMStats are the counters of the running VM.
+type VMStats struct {
+	Instructions  int
+	StackDepth    int
+	PeakStack     int
+	CallDepth     int
+	PeakCallDepth int
+	Globals       int
+}
+
+// ValueCounts are the values a program can still reach, by kind, and about
+// how many bytes they hold.
+type ValueCounts struct {
+	Strings, Arrays, Tables, Functions int
+	Bytes                              int
+}
+
+var vmstatsBuiltins = map[string]BuiltinFunc{
+	"vmstats": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("vmstats expects 0 arguments")
+		}
+		var s VMStats
+		if ctx.Stats != nil {
+			s = ctx.Stats()
+		}
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		return map[string]interface{}{
+			"instructions":    float64(s.Instructions),
+			"stack_depth":     float64(s.StackDepth),
+			"peak_stack":      float64(s.PeakStack),
+			"call_depth":      float64(s.CallDepth),
+			"peak_call_depth": float64(s.PeakCallDepth),
+			"globals":         float64(s.Globals),
+			"memory": map[string]interface{}{
+				"heap_alloc":   float64(mem.HeapAlloc),
+				"heap_objects": float64(mem.HeapObjects),
+				"total_alloc":  float64(mem.TotalAlloc),
+				"sys":          float64(mem.Sys),
+				"mallocs":      float64(mem.Mallocs),
+				"num_gc":       float64(mem.NumGC),
+			},
+		}, nil
+	},
+
+	// memstats reports the Go heap and collector, and the values the
+	// program itself holds.
+	"memstats": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("memstats expects 0 arguments")
+		}
+		var c ValueCounts
+		if ctx.Values != nil {
+			c = ctx.Values()
+		}
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		lastPause := 0.0
+		if mem.NumGC > 0 {
+			lastPause = float64(mem.PauseNs[(mem.NumGC+255)%256]) / 1e6
+		}
+		return map[string]interface{}{
+			"heap_alloc":     float64(mem.HeapAlloc),
+			"heap_inuse":     float64(mem.HeapInuse),
+			"heap_sys":       float64(mem.HeapSys),
+			"heap_objects":   float64(mem.HeapObjects),
+			"total_alloc":    float64(mem.TotalAlloc),
+			"sys":            float64(mem.Sys),
+			"next_gc":        float64(mem.NextGC),
+			"num_gc":         float64(mem.NumGC),
+			"pause_total_ms": float64(mem.PauseTotalNs) / 1e6,
+			"last_pause_ms":  lastPause,
+			"values": map[string]interface{}{
+				"strings":   float64(c.Strings),
+				"arrays":    float64(c.Arrays),
+				"tables":    float64(c.Tables),
+				"functions": float64(c.Functions),
+				"bytes":     float64(c.Bytes),
+			},
+		}, nil
+	},
+
+	// collect runs the Go garbage collector and returns the heap memory it
+	// freed.
+	"collect": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("collect expects 0 arguments")
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		return float64(max(int64(before.HeapAlloc)-int64(after.HeapAlloc), 0)), nil
+	},
+}
+
+func init() {
+	register(vmstatsBuiltins)
+}
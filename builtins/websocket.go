@@ -0,0 +1,308 @@
+package builtins
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+type WebSocket struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	client bool
+	closed bool
+	mu     sync.Mutex
+	// turn is set for the sessions of http_serve, which let other
+	// requests run while they wait for a message
+	turn *vmTurn
+}
+
+func websocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (ws *WebSocket) writeFrame(opcode byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	header := []byte{0x80 | opcode, 0}
+	length := len(payload)
+	switch {
+	case length < 126:
+		header[1] = byte(length)
+	case length <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+
+	// client frames have to be masked
+	if ws.client {
+		header[1] |= 0x80
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		header = append(header, mask[:]...)
+		masked := make([]byte, length)
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+
+	if _, err := ws.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (ws *WebSocket) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(ws.reader, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(ws.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+func (ws *WebSocket) Send(data string) error {
+	if ws.closed {
+		return fmt.Errorf("websocket is closed")
+	}
+	return ws.writeFrame(wsOpText, []byte(data))
+}
+
+// Recv returns the next text or binary message, ok is false once the
+// peer closed the connection.
+func (ws *WebSocket) Recv() (string, bool, error) {
+	if ws.closed {
+		return "", false, nil
+	}
+	var message []byte
+	for {
+		var fin bool
+		var opcode byte
+		var payload []byte
+		var err error
+		read := func() { fin, opcode, payload, err = ws.readFrame() }
+		if ws.turn != nil {
+			ws.turn.wait(ws, read)
+		} else {
+			read()
+		}
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				ws.closed = true
+				return "", false, nil
+			}
+			return "", false, err
+		}
+		switch opcode {
+		case wsOpPing:
+			if err := ws.writeFrame(wsOpPong, payload); err != nil {
+				return "", false, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			ws.writeFrame(wsOpClose, payload)
+			ws.closed = true
+			ws.conn.Close()
+			return "", false, nil
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			message = append(message, payload...)
+		}
+		if fin {
+			return string(message), true, nil
+		}
+	}
+}
+
+func (ws *WebSocket) Close() error {
+	if ws.closed {
+		return nil
+	}
+	ws.closed = true
+	ws.writeFrame(wsOpClose, []byte{0x03, 0xE8})
+	return ws.conn.Close()
+}
+
+// dialWebSocket connects to rawURL after checking it with ctx. Redirects
+// are not followed, so the host dialed is always the one checked.
+func dialWebSocket(ctx *Context, name, rawURL string, headers map[string]string) (*WebSocket, error) {
+	if err := ctx.checkURL(name, rawURL); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %v", err)
+	}
+
+	host := u.Host
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			host += ":80"
+		}
+		conn, err = net.Dial("tcp", host)
+	case "wss":
+		if u.Port() == "" {
+			host += ":443"
+		}
+		conn, err = tls.Dial("tcp", host, &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("websocket url must use ws:// or wss://")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %v", err)
+	}
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	var req strings.Builder
+	fmt.Fprintf(&req, "GET %s HTTP/1.1\r\n", u.RequestURI())
+	fmt.Fprintf(&req, "Host: %s\r\n", u.Host)
+	req.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n")
+	fmt.Fprintf(&req, "Sec-WebSocket-Key: %s\r\n", key)
+	for k, v := range headers {
+		fmt.Fprintf(&req, "%s: %s\r\n", k, v)
+	}
+	req.WriteString("\r\n")
+	if _, err := io.WriteString(conn, req.String()); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake failed: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: "GET"})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		if loc := resp.Header.Get("Location"); loc != "" {
+			return nil, fmt.Errorf("handshake failed: server redirected to %s, which is not followed", loc)
+		}
+		return nil, fmt.Errorf("handshake failed: server returned %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		conn.Close()
+		return nil, fmt.Errorf("handshake failed: bad accept key")
+	}
+	return &WebSocket{conn: conn, reader: reader, client: true}, nil
+}
+
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*WebSocket, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || r.Header.Get("Sec-WebSocket-Key") == "" {
+		http.Error(w, "expected websocket upgrade", http.StatusBadRequest)
+		return nil, fmt.Errorf("not a websocket request")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("connection does not support websocket upgrade")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	accept := websocketAccept(r.Header.Get("Sec-WebSocket-Key"))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", accept)
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &WebSocket{conn: conn, reader: rw.Reader}, nil
+}
+
+var websocketBuiltins = map[string]BuiltinFunc{
+	"ws_connect": func(ctx *Context, args []interface{}) (interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("ws_connect expects 1 or 2 arguments (url, headers)")
+		}
+		rawURL, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("ws_connect url must be string")
+		}
+		var headerVal interface{}
+		if len(args) == 2 {
+			headerVal = args[1]
+		}
+		headers, err := toHeaders("ws_connect", headerVal)
+		if err != nil {
+			return nil, err
+		}
+		return dialWebSocket(ctx, "ws_connect", rawURL, headers)
+	},
+}
+
+func init() {
+	register(websocketBuiltins)
+}
@@ -0,0 +1,338 @@
+package bytecode
+
+import (
+	"errors"
+	"fmt"
+	"lightlang/parser"
+	"strconv"
+	"strings"
+)
+
+// Assemble parses a textual listing into bytecode. The format is the one
+// printed by Disassemble:
+//
+//	.const                    (or "constants:")
+//	  #0 number 10
+//	  string "hi"
+//	  funcptr greet
+//	.code                     (or "code:")
+//	  greet:
+//	  CONSTANT #0
+//	  CONSTANT "inline"       (adds a constant)
+//	  JUMP_IF_FALSE done
+//	  CALL print 1            (name and argument count)
+//
+// Comments start with ';'. Jump targets and funcptr constants may name a
+// label or give an absolute instruction index.
+func Assemble(src string) ([]Instruction, []Constant, error) {
+	a := newAssembler()
+	section := ""
+	for i, raw := range strings.Split(src, "\n") {
+		a.line = i + 1
+		line := stripComment(raw)
+		if line == "" {
+			continue
+		}
+		switch strings.ToLower(line) {
+		case ".const", ".constants", "constants:":
+			section = "const"
+			continue
+		case ".code", "code:":
+			section = "code"
+			continue
+		}
+
+		var err error
+		switch section {
+		case "const":
+			err = a.constLine(line)
+		case "code":
+			err = a.codeLine(line)
+		default:
+			err = fmt.Errorf("expected .const or .code section")
+		}
+		if err != nil {
+			return nil, nil, &asmError{line: a.line, err: err}
+		}
+	}
+
+	if err := a.resolve(); err != nil {
+		return nil, nil, err
+	}
+	return a.instructions, a.constants, nil
+}
+
+// AssembleBlock assembles the code lines of a listing, without sections,
+// as instructions that go at index base of a program whose constants are
+// in constants, which literals are added to. Labels are local to src, and
+// numeric jump targets count from its start. local, when not nil, gives
+// the slot of a name used as the argument of GET_LOCAL or SET_LOCAL.
+// Instructions get the line of src they come from, and errors are
+// *parser.SourceError values with that line.
+func AssembleBlock(src string, base int, constants *[]Constant, local func(name string) (int, bool)) ([]Instruction, error) {
+	a := newAssembler()
+	a.constants, a.base, a.local, a.tagLines = *constants, base, local, true
+	err := func() error {
+		for i, raw := range strings.Split(src, "\n") {
+			a.line = i + 1
+			if line := stripComment(raw); line != "" {
+				if err := a.codeLine(line); err != nil {
+					return &asmError{line: a.line, err: err}
+				}
+			}
+		}
+		return a.resolve()
+	}()
+	var ae *asmError
+	if errors.As(err, &ae) {
+		return nil, &parser.SourceError{Pos: parser.Pos{Line: ae.line}, Err: ae.err}
+	}
+	*constants = a.constants
+	return a.instructions, nil
+}
+
+// asmError is an error on a line of a listing.
+type asmError struct {
+	line int
+	err  error
+}
+
+func (e *asmError) Error() string { return fmt.Sprintf("line %d: %v", e.line, e.err) }
+
+type fixup struct {
+	line  int
+	index int
+	label string
+	isPtr bool
+}
+
+type assembler struct {
+	instructions []Instruction
+	constants    []Constant
+	labels       map[string]int
+	ops          map[string]OpCode
+	fixups       []fixup
+	line         int
+	names        *NamePool
+	// base is added to jump targets, local resolves names of locals and
+	// tagLines gives instructions their line, for AssembleBlock.
+	base     int
+	local    func(name string) (int, bool)
+	tagLines bool
+}
+
+func newAssembler() *assembler {
+	a := &assembler{
+		labels: make(map[string]int),
+		ops:    make(map[string]OpCode, len(opNames)),
+	}
+	for op, name := range opNames {
+		a.ops[name] = op
+	}
+	return a
+}
+
+func stripComment(line string) string {
+	inString := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if inString {
+				i++
+			}
+		case '"':
+			inString = !inString
+		case ';':
+			if !inString {
+				return strings.TrimSpace(line[:i])
+			}
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+func parseLiteral(s string) (Constant, error) {
+	switch s {
+	case "nil":
+		return Constant{Value: nil, Type: "nil"}, nil
+	case "true":
+		return Constant{Value: true, Type: "bool"}, nil
+	case "false":
+		return Constant{Value: false, Type: "bool"}, nil
+	}
+	if strings.HasPrefix(s, "\"") {
+		str, err := strconv.Unquote(s)
+		if err != nil {
+			return Constant{}, fmt.Errorf("invalid string literal %s", s)
+		}
+		return Constant{Value: str, Type: "string"}, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return Constant{}, fmt.Errorf("invalid literal %s", s)
+	}
+	return Constant{Value: f, Type: "number"}, nil
+}
+
+func (a *assembler) constLine(line string) error {
+	if strings.HasPrefix(line, "#") {
+		fields := strings.SplitN(line, " ", 2)
+		idx, err := strconv.Atoi(fields[0][1:])
+		if err != nil || idx != len(a.constants) {
+			return fmt.Errorf("constant index %s out of order", fields[0])
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("missing constant type")
+		}
+		line = strings.TrimSpace(fields[1])
+	}
+
+	fields := strings.SplitN(line, " ", 2)
+	typ := fields[0]
+	value := ""
+	if len(fields) == 2 {
+		value = strings.TrimSpace(fields[1])
+	}
+
+	switch typ {
+	case "funcptr":
+		target := strings.TrimPrefix(value, "@")
+		if n, err := strconv.Atoi(target); err == nil {
+			a.constants = append(a.constants, Constant{Value: float64(n), Type: "funcptr"})
+			return nil
+		}
+		if target == "" {
+			return fmt.Errorf("funcptr needs a label or index")
+		}
+		a.fixups = append(a.fixups, fixup{line: a.line, index: len(a.constants), label: target, isPtr: true})
+		a.constants = append(a.constants, Constant{Value: float64(0), Type: "funcptr"})
+		return nil
+	case "nil":
+		a.constants = append(a.constants, Constant{Value: nil, Type: "nil"})
+		return nil
+	case "number", "string", "bool":
+		c, err := parseLiteral(value)
+		if err != nil {
+			return err
+		}
+		if c.Type != typ {
+			return fmt.Errorf("%s is not a %s", value, typ)
+		}
+		a.constants = append(a.constants, c)
+		return nil
+	}
+	return fmt.Errorf("unknown constant type %s", typ)
+}
+
+func (a *assembler) codeLine(line string) error {
+	line = strings.TrimSpace(strings.TrimPrefix(line, ">>"))
+
+	if strings.HasSuffix(line, ":") && !strings.ContainsAny(line, " \t\"") {
+		label := strings.TrimSuffix(line, ":")
+		if _, exists := a.labels[label]; exists {
+			return fmt.Errorf("duplicate label %s", label)
+		}
+		a.labels[label] = len(a.instructions)
+		return nil
+	}
+
+	fields := strings.Fields(line)
+	// skip the instruction index printed by dis
+	if _, err := strconv.Atoi(fields[0]); err == nil && len(fields) > 1 {
+		line = strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+		fields = fields[1:]
+	}
+
+	op, ok := a.ops[strings.ToUpper(fields[0])]
+	if !ok {
+		return fmt.Errorf("unknown opcode %s", fields[0])
+	}
+	arg := strings.TrimSpace(line[len(fields[0]):])
+	inst := Instruction{Op: op}
+	if a.tagLines {
+		inst.Line = a.line
+	}
+
+	if arg != "" {
+		switch op {
+		case OpConstant, OpMakeFunc:
+			if strings.HasPrefix(arg, "#") {
+				end := strings.IndexAny(arg, " \t")
+				if end < 0 {
+					end = len(arg)
+				}
+				idx, err := strconv.Atoi(arg[1:end])
+				if err != nil {
+					return fmt.Errorf("invalid constant reference %s", arg)
+				}
+				inst.Arg = idx
+				break
+			}
+			c, err := parseLiteral(arg)
+			if err != nil {
+				return err
+			}
+			a.constants = append(a.constants, c)
+			inst.Arg = len(a.constants) - 1
+		case OpJump, OpJumpIfFalse, OpJumpIfNotNil, OpJumpIfFalseOrPop, OpJumpIfTrueOrPop, OpIterNext:
+			target := strings.TrimSpace(strings.TrimPrefix(arg, "->"))
+			if end := strings.IndexAny(target, " \t"); end >= 0 {
+				target = target[:end]
+			}
+			if n, err := strconv.Atoi(target); err == nil {
+				inst.Arg = a.base + n
+				break
+			}
+			a.fixups = append(a.fixups, fixup{line: a.line, index: len(a.instructions), label: target})
+			inst.Arg = 0
+		case OpCall, OpGetGlobal, OpSetGlobal:
+			if a.names == nil {
+				a.names = NewNamePool(&a.constants)
+			}
+			if op != OpCall {
+				inst.Arg = a.names.Add(arg)
+				break
+			}
+			f := strings.Fields(arg)
+			if len(f) != 2 {
+				return fmt.Errorf("CALL expects a name and an argument count")
+			}
+			count, err := strconv.Atoi(f[1])
+			if err != nil || count < 0 || count > MaxCallArgs {
+				return fmt.Errorf("invalid argument count %s", f[1])
+			}
+			inst.Arg = CallOperand(a.names.Add(f[0]), count)
+		default:
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "slot "))
+			if err != nil && a.local != nil && (op == OpGetLocal || op == OpSetLocal) {
+				var ok bool
+				if n, ok = a.local(arg); !ok {
+					return fmt.Errorf("%s is not a local variable here", arg)
+				}
+				err = nil
+			}
+			if err != nil {
+				return fmt.Errorf("%s expects a numeric argument", op)
+			}
+			inst.Arg = n
+		}
+	}
+
+	a.instructions = append(a.instructions, inst)
+	return nil
+}
+
+func (a *assembler) resolve() error {
+	for _, f := range a.fixups {
+		target, ok := a.labels[f.label]
+		if !ok {
+			return &asmError{line: f.line, err: fmt.Errorf("undefined label %s", f.label)}
+		}
+		if f.isPtr {
+			a.constants[f.index].Value = float64(a.base + target)
+		} else {
+			a.instructions[f.index].Arg = a.base + target
+		}
+	}
+	return nil
+}
@@ -0,0 +1,861 @@
+package bytecode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"lightlang/parser"
+	"math"
+	"os"
+)
+
+const (
+	MagicHeader           = 0x4C4C4243
+	VersionMajor    uint8 = 3
+	VersionMinor    uint8 = 11
+	VersionCombined       = (VersionMajor << 4) | (VersionMinor & 0x0F)
+
+	ConstTypeNumber   = 0
+	ConstTypeString   = 1
+	ConstTypeFuncPtr  = 2
+	ConstTypeBool     = 3
+	ConstTypeNil      = 4
+	ConstFlagSmallInt = 1 << 0
+	ConstFlagShortStr = 1 << 1
+
+	// tags of instruction arguments before 3.6
+	ArgTypeConst  = 0
+	ArgTypeInt    = 1
+	ArgTypeFloat  = 2
+	ArgTypeString = 3
+
+	// HeaderFlagStripped marks files written without positions and
+	// function names.
+	HeaderFlagStripped = 1 << 0
+)
+
+var errCorruptBytecode = errors.New("bytecode file is corrupted")
+
+// format lists the parts of the layout that changed between versions, so
+// files written by older releases keep loading.
+type format struct {
+	columns   bool // 3.1: column after each line number
+	funcNames bool // 3.2: names of funcptr constants
+	flags     bool // 3.3: header flags byte
+	checksum  bool // 3.4: CRC32 of the payload in the header
+	locals    bool // 3.5: local slot count of funcptr constants
+	operands  bool // 3.6: integer operands, global names as constants
+	relJumps  bool // 3.7: jump targets relative to the next instruction
+	params    bool // 3.8: parameter count of funcptr constants
+	lang      bool // 3.9: language version of the compiler in the header
+	functions bool // 3.10: functions in a section of their own, referred to by index
+	callArgs  bool // 3.11: argument count of calls in their operand
+}
+
+func formatFor(major, minor uint8) (format, error) {
+	switch {
+	case major < VersionMajor:
+		return format{}, fmt.Errorf("bytecode version %d.%d is no longer supported, rebuild it from source", major, minor)
+	case major > VersionMajor || minor > VersionMinor:
+		return format{}, fmt.Errorf("bytecode version %d.%d is newer than this lightlang (%d.%d)", major, minor, VersionMajor, VersionMinor)
+	}
+	return format{
+		columns:   minor >= 1,
+		funcNames: minor >= 2,
+		flags:     minor >= 3,
+		checksum:  minor >= 4,
+		locals:    minor >= 5,
+		operands:  minor >= 6,
+		relJumps:  minor >= 7,
+		params:    minor >= 8,
+		lang:      minor >= 9,
+		functions: minor >= 10,
+		callArgs:  minor >= 11,
+	}, nil
+}
+
+// OldCalls reports whether calls in bytecode of version major.minor take
+// their argument count from a constant pushed before them, as they did
+// before 3.11. compiler.MigrateCalls moves the count into the operand.
+func OldCalls(major, minor uint8) bool {
+	f, err := formatFor(major, minor)
+	return err == nil && !f.callArgs
+}
+
+type BitWriter struct {
+	writer io.Writer
+	buffer byte
+	bitPos uint8
+}
+
+func NewBitWriter(w io.Writer) *BitWriter {
+	return &BitWriter{writer: w}
+}
+
+func (bw *BitWriter) WriteBits(value uint64, bits uint8) error {
+	for i := uint8(0); i < bits; i++ {
+		bit := (value >> i) & 1
+		bw.buffer |= byte(bit << bw.bitPos)
+		bw.bitPos++
+
+		if bw.bitPos == 8 {
+			if _, err := bw.writer.Write([]byte{bw.buffer}); err != nil {
+				return err
+			}
+			bw.buffer = 0
+			bw.bitPos = 0
+		}
+	}
+	return nil
+}
+
+func (bw *BitWriter) Flush() error {
+	if bw.bitPos > 0 {
+		_, err := bw.writer.Write([]byte{bw.buffer})
+		bw.bitPos = 0
+		bw.buffer = 0
+		return err
+	}
+	return nil
+}
+
+func (bw *BitWriter) WriteUint32(val uint32) error {
+	return bw.WriteBits(uint64(val), 32)
+}
+
+func (bw *BitWriter) WriteUint8(val uint8) error {
+	return bw.WriteBits(uint64(val), 8)
+}
+
+func (bw *BitWriter) WriteVarUint(val uint32) error {
+	for val >= 0x80 {
+		if err := bw.WriteBits(uint64(val&0x7F)|0x80, 8); err != nil {
+			return err
+		}
+		val >>= 7
+	}
+	return bw.WriteBits(uint64(val), 8)
+}
+
+func (bw *BitWriter) WriteVarUint16(val uint16) error {
+	if val < 0x80 {
+		return bw.WriteBits(uint64(val), 8)
+	}
+	if err := bw.WriteBits(uint64(val&0x7F)|0x80, 8); err != nil {
+		return err
+	}
+	return bw.WriteBits(uint64(val>>7), 8)
+}
+
+func (bw *BitWriter) WriteVarInt(val int32) error {
+	uval := uint32(val) << 1
+	if val < 0 {
+		uval = ^uval
+	}
+	return bw.WriteVarUint(uval)
+}
+
+type BitReader struct {
+	reader io.Reader
+	buffer byte
+	bitPos uint8
+	eof    bool
+}
+
+func NewBitReader(r io.Reader) *BitReader {
+	return &BitReader{reader: r}
+}
+
+func (br *BitReader) ReadBits(bits uint8) (uint64, error) {
+	var result uint64
+	for i := uint8(0); i < bits; i++ {
+		if br.bitPos == 0 && !br.eof {
+			var buf [1]byte
+			n, err := br.reader.Read(buf[:])
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+			if n == 0 {
+				br.eof = true
+				return 0, io.ErrUnexpectedEOF
+			}
+			br.buffer = buf[0]
+		}
+
+		bit := (br.buffer >> br.bitPos) & 1
+		result |= uint64(bit) << i
+		br.bitPos = (br.bitPos + 1) % 8
+	}
+	return result, nil
+}
+
+func (br *BitReader) ReadUint32() (uint32, error) {
+	val, err := br.ReadBits(32)
+	return uint32(val), err
+}
+
+func (br *BitReader) ReadUint8() (uint8, error) {
+	val, err := br.ReadBits(8)
+	return uint8(val), err
+}
+
+func (br *BitReader) ReadVarUint() (uint32, error) {
+	var result uint32
+	var shift uint
+	for {
+		b, err := br.ReadUint8()
+		if err != nil {
+			return 0, err
+		}
+		result |= uint32(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	return result, nil
+}
+
+func (br *BitReader) ReadVarUint16() (uint16, error) {
+	first, err := br.ReadUint8()
+	if err != nil {
+		return 0, err
+	}
+	if first < 0x80 {
+		return uint16(first), nil
+	}
+	second, err := br.ReadUint8()
+	if err != nil {
+		return 0, err
+	}
+	return uint16(first&0x7F) | (uint16(second) << 7), nil
+}
+
+type BytecodeWriter struct {
+	bitWriter *BitWriter
+}
+
+func NewBytecodeWriter(w io.Writer) *BytecodeWriter {
+	return &BytecodeWriter{
+		bitWriter: NewBitWriter(w),
+	}
+}
+
+func (bw *BytecodeWriter) WriteBytecode(instructions []Instruction, constants []Constant) error {
+	if err := bw.bitWriter.WriteUint32(MagicHeader); err != nil {
+		return err
+	}
+
+	if err := bw.bitWriter.WriteUint8(VersionCombined); err != nil {
+		return err
+	}
+
+	stripped := !hasDebugInfo(instructions, constants)
+	var flags uint8
+	if stripped {
+		flags |= HeaderFlagStripped
+	}
+	if err := bw.bitWriter.WriteUint8(flags); err != nil {
+		return err
+	}
+	if err := bw.bitWriter.WriteUint8(uint8(parser.LangVersion.Major)); err != nil {
+		return err
+	}
+	if err := bw.bitWriter.WriteUint8(uint8(parser.LangVersion.Minor)); err != nil {
+		return err
+	}
+
+	// the payload is buffered so its CRC32 can go in the header
+	var payload bytes.Buffer
+	if err := NewBytecodeWriter(&payload).writePayload(instructions, constants, stripped); err != nil {
+		return err
+	}
+	if err := bw.bitWriter.WriteUint32(crc32.ChecksumIEEE(payload.Bytes())); err != nil {
+		return err
+	}
+	_, err := bw.bitWriter.writer.Write(payload.Bytes())
+	return err
+}
+
+// writePayload writes the constants, the top level and then each function
+// with its own code. Funcptr constants hold the index of their function.
+func (bw *BytecodeWriter) writePayload(instructions []Instruction, constants []Constant, stripped bool) error {
+	funcs, index := Functions(constants)
+	top, code, err := splitFunctions(instructions, funcs)
+	if err != nil {
+		return err
+	}
+
+	if err := bw.bitWriter.WriteVarUint(uint32(len(constants))); err != nil {
+		return err
+	}
+	if err := bw.bitWriter.WriteVarUint(uint32(len(top))); err != nil {
+		return err
+	}
+	if err := bw.bitWriter.WriteVarUint(uint32(len(funcs))); err != nil {
+		return err
+	}
+
+	for i, c := range constants {
+		switch c.Type {
+		case "number":
+			if val, ok := c.Value.(int); ok && val >= -64 && val <= 63 {
+				if err := bw.bitWriter.WriteBits(uint64(ConstTypeNumber), 3); err != nil {
+					return err
+				}
+				if err := bw.bitWriter.WriteBits(1, 1); err != nil {
+					return err
+				}
+				signedVal := int8(val)
+				if err := bw.bitWriter.WriteBits(uint64(signedVal)&0x7F, 7); err != nil {
+					return err
+				}
+			} else {
+				if err := bw.bitWriter.WriteBits(uint64(ConstTypeNumber), 3); err != nil {
+					return err
+				}
+				if err := bw.bitWriter.WriteBits(0, 1); err != nil {
+					return err
+				}
+
+				var fval float64
+				if val, ok := c.Value.(float64); ok {
+					fval = val
+				} else if val, ok := c.Value.(int); ok {
+					fval = float64(val)
+				}
+
+				bits := math.Float64bits(fval)
+				for i := 0; i < 64; i++ {
+					bit := (bits >> i) & 1
+					if err := bw.bitWriter.WriteBits(bit, 1); err != nil {
+						return err
+					}
+				}
+			}
+
+		case "string":
+			str := c.Value.(string)
+			if len(str) <= 255 {
+				if err := bw.bitWriter.WriteBits(uint64(ConstTypeString), 3); err != nil {
+					return err
+				}
+				if err := bw.bitWriter.WriteBits(1, 1); err != nil {
+					return err
+				}
+				if err := bw.bitWriter.WriteBits(uint64(len(str)), 8); err != nil {
+					return err
+				}
+			} else {
+				if err := bw.bitWriter.WriteBits(uint64(ConstTypeString), 3); err != nil {
+					return err
+				}
+				if err := bw.bitWriter.WriteBits(0, 1); err != nil {
+					return err
+				}
+				if err := bw.bitWriter.WriteVarUint(uint32(len(str))); err != nil {
+					return err
+				}
+			}
+
+			for _, ch := range []byte(str) {
+				if err := bw.bitWriter.WriteBits(uint64(ch), 8); err != nil {
+					return err
+				}
+			}
+
+		case "funcptr":
+			if err := bw.bitWriter.WriteBits(uint64(ConstTypeFuncPtr), 3); err != nil {
+				return err
+			}
+			if index[i] < 0 {
+				return fmt.Errorf("constant %d: function entry %v is not a number", i, c.Value)
+			}
+			if err := bw.bitWriter.WriteVarUint(uint32(index[i])); err != nil {
+				return err
+			}
+
+		case "bool":
+			if err := bw.bitWriter.WriteBits(uint64(ConstTypeBool), 3); err != nil {
+				return err
+			}
+			var val uint64 = 0
+			if c.Value == true {
+				val = 1
+			}
+			if err := bw.bitWriter.WriteBits(val, 1); err != nil {
+				return err
+			}
+
+		case "nil":
+			if err := bw.bitWriter.WriteBits(uint64(ConstTypeNil), 3); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := bw.writeInstructions(top, 0, stripped); err != nil {
+		return err
+	}
+	for i, fn := range funcs {
+		if err := bw.bitWriter.WriteVarUint(uint32(fn.Locals)); err != nil {
+			return err
+		}
+		if err := bw.bitWriter.WriteVarUint(uint32(fn.Params)); err != nil {
+			return err
+		}
+		if !stripped {
+			if err := bw.bitWriter.WriteVarUint(uint32(len(fn.Name))); err != nil {
+				return err
+			}
+			for _, ch := range []byte(fn.Name) {
+				if err := bw.bitWriter.WriteBits(uint64(ch), 8); err != nil {
+					return err
+				}
+			}
+		}
+		if err := bw.bitWriter.WriteVarUint(uint32(len(code[i]))); err != nil {
+			return err
+		}
+		if err := bw.writeInstructions(code[i], fn.Entry, stripped); err != nil {
+			return err
+		}
+	}
+
+	return bw.bitWriter.Flush()
+}
+
+// writeInstructions writes instructions that start at index base of the
+// program. Jump targets are written relative to the next instruction.
+func (bw *BytecodeWriter) writeInstructions(instructions []Instruction, base int, stripped bool) error {
+	for i, inst := range instructions {
+		if IsJump(inst.Op) {
+			inst.Arg -= base + i + 1
+		}
+		opcode := uint64(inst.Op) & 0x7F
+		hasArg := inst.Arg != 0
+		if hasArg {
+			opcode |= 0x80
+		}
+		if err := bw.bitWriter.WriteBits(opcode, 8); err != nil {
+			return err
+		}
+
+		if !stripped {
+			if err := bw.bitWriter.WriteVarUint16(uint16(inst.Line)); err != nil {
+				return err
+			}
+			if err := bw.bitWriter.WriteVarUint16(uint16(inst.Col)); err != nil {
+				return err
+			}
+		}
+
+		if hasArg {
+			if err := bw.bitWriter.WriteVarInt(int32(inst.Arg)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type BytecodeReader struct {
+	bitReader *BitReader
+	// Major and Minor are the version of the file, set by ReadBytecode.
+	Major, Minor uint8
+	// Lang is the language version of the compiler that wrote the file,
+	// or zero for files written before 3.9.
+	Lang parser.Version
+}
+
+func NewBytecodeReader(r io.Reader) *BytecodeReader {
+	return &BytecodeReader{
+		bitReader: NewBitReader(r),
+	}
+}
+
+func (br *BytecodeReader) ReadBytecode() (instructions []Instruction, constants []Constant, err error) {
+	defer func() {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = fmt.Errorf("%w: unexpected end of data", errCorruptBytecode)
+		}
+	}()
+
+	magic, err := br.bitReader.ReadUint32()
+	if err != nil {
+		return nil, nil, err
+	}
+	if magic != MagicHeader {
+		return nil, nil, fmt.Errorf("invalid bytecode file: bad magic")
+	}
+
+	version, err := br.bitReader.ReadUint8()
+	if err != nil {
+		return nil, nil, err
+	}
+	br.Major, br.Minor = version>>4, version&0x0F
+	f, err := formatFor(br.Major, br.Minor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var flags uint8
+	if f.flags {
+		if flags, err = br.bitReader.ReadUint8(); err != nil {
+			return nil, nil, err
+		}
+	}
+	debugInfo := flags&HeaderFlagStripped == 0
+
+	if f.lang {
+		var lang [2]uint8
+		for i := range lang {
+			if lang[i], err = br.bitReader.ReadUint8(); err != nil {
+				return nil, nil, err
+			}
+		}
+		br.Lang = parser.Version{Major: int(lang[0]), Minor: int(lang[1])}
+	}
+
+	if f.checksum {
+		sum, err := br.bitReader.ReadUint32()
+		if err != nil {
+			return nil, nil, err
+		}
+		payload, err := io.ReadAll(br.bitReader.reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		if crc32.ChecksumIEEE(payload) != sum {
+			return nil, nil, fmt.Errorf("%w: checksum mismatch", errCorruptBytecode)
+		}
+		br.bitReader = NewBitReader(bytes.NewReader(payload))
+	}
+
+	constantCount, err := br.bitReader.ReadVarUint()
+	if err != nil {
+		return nil, nil, err
+	}
+	instructionCount, err := br.bitReader.ReadVarUint()
+	if err != nil {
+		return nil, nil, err
+	}
+	var functionCount uint32
+	if f.functions {
+		if functionCount, err = br.bitReader.ReadVarUint(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	constants = make([]Constant, 0, min(constantCount, maxPrealloc))
+	for i := 0; i < int(constantCount); i++ {
+		constants = append(constants, Constant{})
+		constType, err := br.bitReader.ReadBits(3)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch uint8(constType) {
+		case ConstTypeNumber:
+			isSmall, err := br.bitReader.ReadBits(1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if isSmall == 1 {
+				valBits, err := br.bitReader.ReadBits(7)
+				if err != nil {
+					return nil, nil, err
+				}
+				val := int8(valBits)
+				if valBits&0x40 != 0 {
+					val |= ^0x7F
+				}
+				constants[i] = Constant{Value: int(val), Type: "number"}
+			} else {
+				var bits uint64
+				for i := 0; i < 64; i++ {
+					bit, err := br.bitReader.ReadBits(1)
+					if err != nil {
+						return nil, nil, err
+					}
+					bits |= bit << i
+				}
+				val := math.Float64frombits(bits)
+				constants[i] = Constant{Value: val, Type: "number"}
+			}
+
+		case ConstTypeString:
+			isShort, err := br.bitReader.ReadBits(1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			var strLen uint32
+			if isShort == 1 {
+				lenBits, err := br.bitReader.ReadBits(8)
+				if err != nil {
+					return nil, nil, err
+				}
+				strLen = uint32(lenBits)
+			} else {
+				strLen, err = br.bitReader.ReadVarUint()
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+
+			strBytes, err := br.readBytes(strLen)
+			if err != nil {
+				return nil, nil, err
+			}
+			constants[i] = Constant{Value: string(strBytes), Type: "string"}
+
+		case ConstTypeFuncPtr:
+			val, err := br.bitReader.ReadVarUint()
+			if err != nil {
+				return nil, nil, err
+			}
+			constants[i] = Constant{Value: float64(val), Type: "funcptr"}
+			if f.functions {
+				// the function is filled in once it is read
+				if val >= functionCount {
+					return nil, nil, fmt.Errorf("%w: function %d out of range", errCorruptBytecode, val)
+				}
+				break
+			}
+			if f.locals {
+				locals, err := br.bitReader.ReadVarUint()
+				if err != nil {
+					return nil, nil, err
+				}
+				constants[i].Locals = int(locals)
+			}
+			if f.params {
+				params, err := br.bitReader.ReadVarUint()
+				if err != nil {
+					return nil, nil, err
+				}
+				constants[i].Params = int(params)
+			}
+			if f.funcNames && debugInfo {
+				nameLen, err := br.bitReader.ReadVarUint()
+				if err != nil {
+					return nil, nil, err
+				}
+				name, err := br.readBytes(nameLen)
+				if err != nil {
+					return nil, nil, err
+				}
+				constants[i].Name = string(name)
+			}
+
+		case ConstTypeBool:
+			val, err := br.bitReader.ReadBits(1)
+			if err != nil {
+				return nil, nil, err
+			}
+			constants[i] = Constant{Value: val == 1, Type: "bool"}
+
+		case ConstTypeNil:
+			constants[i] = Constant{Value: nil, Type: "nil"}
+		}
+	}
+
+	// older files name globals inline; they become string constants
+	var names *NamePool
+	if !f.operands {
+		names = NewNamePool(&constants)
+	}
+	instructions = make([]Instruction, 0, min(instructionCount, maxPrealloc))
+	if instructions, err = br.readInstructions(instructions, instructionCount, f, debugInfo, names); err != nil {
+		return nil, nil, err
+	}
+
+	funcs := make([]Function, 0, min(functionCount, maxPrealloc))
+	for range functionCount {
+		funcs = append(funcs, Function{})
+		fn := &funcs[len(funcs)-1]
+		fn.Entry = len(instructions)
+		locals, err := br.bitReader.ReadVarUint()
+		if err != nil {
+			return nil, nil, err
+		}
+		params, err := br.bitReader.ReadVarUint()
+		if err != nil {
+			return nil, nil, err
+		}
+		fn.Locals, fn.Params = int(locals), int(params)
+		if debugInfo {
+			nameLen, err := br.bitReader.ReadVarUint()
+			if err != nil {
+				return nil, nil, err
+			}
+			name, err := br.readBytes(nameLen)
+			if err != nil {
+				return nil, nil, err
+			}
+			fn.Name = string(name)
+		}
+		count, err := br.bitReader.ReadVarUint()
+		if err != nil {
+			return nil, nil, err
+		}
+		if instructions, err = br.readInstructions(instructions, count, f, debugInfo, names); err != nil {
+			return nil, nil, err
+		}
+	}
+	if f.functions {
+		for i, c := range constants {
+			if c.Type != "funcptr" {
+				continue
+			}
+			fn := funcs[int(c.Value.(float64))]
+			constants[i] = Constant{Value: float64(fn.Entry), Type: "funcptr", Name: fn.Name, Locals: fn.Locals, Params: fn.Params}
+		}
+	}
+
+	return instructions, constants, nil
+}
+
+// maxPrealloc caps the room made up front for the counts a file gives,
+// so a corrupt count fails at the end of the input rather than allocating
+// it all.
+const maxPrealloc = 1 << 16
+
+// readBytes reads n bytes, growing the slice as they arrive.
+func (br *BytecodeReader) readBytes(n uint32) ([]byte, error) {
+	b := make([]byte, 0, min(n, maxPrealloc))
+	for range n {
+		ch, err := br.bitReader.ReadBits(8)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, byte(ch))
+	}
+	return b, nil
+}
+
+// readInstructions reads count instructions onto the end of instructions.
+// names collects the global names of files before 3.6.
+func (br *BytecodeReader) readInstructions(instructions []Instruction, count uint32, f format, debugInfo bool, names *NamePool) ([]Instruction, error) {
+	for range count {
+		i := len(instructions)
+		opcode, err := br.bitReader.ReadBits(8)
+		if err != nil {
+			return nil, err
+		}
+
+		hasArg := (opcode & 0x80) != 0
+		opcode &^= 0x80
+
+		var line, col uint16
+		if debugInfo {
+			line, err = br.bitReader.ReadVarUint16()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if f.columns && debugInfo {
+			col, err = br.bitReader.ReadVarUint16()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var arg int
+		if hasArg && f.operands {
+			uval, err := br.bitReader.ReadVarUint()
+			if err != nil {
+				return nil, err
+			}
+			arg = decodeVarInt(uval)
+		} else if hasArg {
+			argType, err := br.bitReader.ReadBits(2)
+			if err != nil {
+				return nil, err
+			}
+
+			switch argType {
+			case ArgTypeConst:
+				idx, err := br.bitReader.ReadVarUint()
+				if err != nil {
+					return nil, err
+				}
+				arg = int(idx)
+
+			case ArgTypeInt:
+				uval, err := br.bitReader.ReadVarUint()
+				if err != nil {
+					return nil, err
+				}
+				arg = decodeVarInt(uval)
+
+			case ArgTypeFloat:
+				var bits uint64
+				for i := 0; i < 64; i++ {
+					bit, err := br.bitReader.ReadBits(1)
+					if err != nil {
+						return nil, err
+					}
+					bits |= bit << i
+				}
+				arg = int(math.Float64frombits(bits))
+
+			case ArgTypeString:
+				strLen, err := br.bitReader.ReadVarUint()
+				if err != nil {
+					return nil, err
+				}
+				strBytes, err := br.readBytes(strLen)
+				if err != nil {
+					return nil, err
+				}
+				arg = names.Add(string(strBytes))
+			}
+		}
+
+		if f.relJumps && IsJump(OpCode(opcode)) {
+			arg += i + 1
+		}
+		instructions = append(instructions, Instruction{
+			Op:   OpCode(opcode),
+			Arg:  arg,
+			Line: int(line),
+			Col:  int(col),
+		})
+	}
+	return instructions, nil
+}
+
+func decodeVarInt(uval uint32) int {
+	val := int32(uval >> 1)
+	if uval&1 != 0 {
+		val = ^val
+	}
+	return int(val)
+}
+
+func SaveBytecode(filename string, instructions []Instruction, constants []Constant) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := NewBytecodeWriter(file)
+	return writer.WriteBytecode(instructions, constants)
+}
+
+func LoadBytecode(filename string) ([]Instruction, []Constant, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	reader := NewBytecodeReader(file)
+	return reader.ReadBytecode()
+}
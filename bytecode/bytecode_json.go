@@ -0,0 +1,157 @@
+package bytecode
+
+import (
+	"encoding/json"
+	"fmt"
+	"lightlang/parser"
+)
+
+// jsonProgram is the JSON form of a program, written by "dis --json" and
+// read by "asm --json":
+//
+//	{
+//	  "version": "3.11",
+//	  "lang": "0.3",
+//	  "constants": [
+//	    {"type": "number", "value": 2},
+//	    {"type": "funcptr", "value": 1, "name": "greet", "locals": 2, "params": 1}
+//	  ],
+//	  "instructions": [
+//	    {"op": "JUMP", "arg": 4},
+//	    {"op": "CONSTANT", "arg": 0, "line": 2, "col": 5},
+//	    {"op": "CALL", "arg": "print", "args": 1},
+//	    {"op": "RETURN"}
+//	  ]
+//	}
+//
+// lang is the language version of the compiler that wrote it.
+// Constant types are number, string, bool, nil and funcptr, whose value is
+// the entry instruction, whose optional name is used in tracebacks, whose
+// locals is the number of local slots its frame needs and whose optional
+// params is its number of parameters.
+// Instruction ops are the names printed by dis. arg is a number (constant
+// index, jump target or count) or a string (global or function name) and is
+// left out when the instruction has none. args is the argument count of
+// CALL. Names are stored as string constants in the bytecode; import adds
+// them as needed. line and col are optional. Listings from before 3.11,
+// whose calls take their argument count from the stack, are refused.
+type jsonProgram struct {
+	Version      string            `json:"version"`
+	Lang         string            `json:"lang,omitempty"`
+	Constants    []jsonConstant    `json:"constants"`
+	Instructions []jsonInstruction `json:"instructions"`
+}
+
+type jsonConstant struct {
+	Type   string      `json:"type"`
+	Value  interface{} `json:"value"`
+	Name   string      `json:"name,omitempty"`
+	Locals int         `json:"locals,omitempty"`
+	Params *int        `json:"params,omitempty"`
+}
+
+type jsonInstruction struct {
+	Op   string      `json:"op"`
+	Arg  interface{} `json:"arg,omitempty"`
+	Args int         `json:"args,omitempty"`
+	Line int         `json:"line,omitempty"`
+	Col  int         `json:"col,omitempty"`
+}
+
+func MarshalProgram(instructions []Instruction, constants []Constant) ([]byte, error) {
+	prog := jsonProgram{
+		Version:      fmt.Sprintf("%d.%d", VersionMajor, VersionMinor),
+		Lang:         parser.LangVersion.String(),
+		Constants:    make([]jsonConstant, len(constants)),
+		Instructions: make([]jsonInstruction, len(instructions)),
+	}
+	for i, c := range constants {
+		prog.Constants[i] = jsonConstant{Type: c.Type, Value: c.Value, Name: c.Name, Locals: c.Locals}
+		if c.Params > 0 {
+			params := c.Params - 1
+			prog.Constants[i].Params = &params
+		}
+	}
+	for i, inst := range instructions {
+		ji := jsonInstruction{Op: inst.Op.String(), Line: inst.Line, Col: inst.Col}
+		switch {
+		case inst.Op == OpCall:
+			ji.Arg, ji.Args = ConstName(constants, CallName(inst.Arg)), CallCount(inst.Arg)
+		case IsNameOp(inst.Op):
+			ji.Arg = ConstName(constants, inst.Arg)
+		case hasOperand(inst.Op):
+			ji.Arg = inst.Arg
+		}
+		prog.Instructions[i] = ji
+	}
+	return json.MarshalIndent(prog, "", "  ")
+}
+
+func UnmarshalProgram(data []byte) ([]Instruction, []Constant, error) {
+	var prog jsonProgram
+	if err := json.Unmarshal(data, &prog); err != nil {
+		return nil, nil, err
+	}
+	var major, minor uint8
+	if _, err := fmt.Sscanf(prog.Version, "%d.%d", &major, &minor); err == nil && OldCalls(major, minor) {
+		return nil, nil, fmt.Errorf("version %s listings pass argument counts on the stack; export the program again with this lightlang", prog.Version)
+	}
+	ops := make(map[string]OpCode, len(opNames))
+	for op, name := range opNames {
+		ops[name] = op
+	}
+
+	constants := make([]Constant, len(prog.Constants))
+	for i, c := range prog.Constants {
+		ok := false
+		switch c.Type {
+		case "number", "funcptr":
+			_, ok = c.Value.(float64)
+		case "string":
+			_, ok = c.Value.(string)
+		case "bool":
+			_, ok = c.Value.(bool)
+		case "nil":
+			ok = c.Value == nil
+		default:
+			return nil, nil, fmt.Errorf("constant %d: unknown type %q", i, c.Type)
+		}
+		if !ok {
+			return nil, nil, fmt.Errorf("constant %d: invalid %s value %v", i, c.Type, c.Value)
+		}
+		constants[i] = Constant{Value: c.Value, Type: c.Type, Name: c.Name, Locals: c.Locals}
+		if c.Params != nil {
+			constants[i].Params = *c.Params + 1
+		}
+	}
+
+	names := NewNamePool(&constants)
+	instructions := make([]Instruction, len(prog.Instructions))
+	for i, inst := range prog.Instructions {
+		op, ok := ops[inst.Op]
+		if !ok {
+			return nil, nil, fmt.Errorf("instruction %d: unknown op %q", i, inst.Op)
+		}
+		var arg int
+		switch a := inst.Arg.(type) {
+		case nil:
+		case float64:
+			arg = int(a)
+		case string:
+			if !IsNameOp(op) {
+				return nil, nil, fmt.Errorf("instruction %d: %s takes a number", i, inst.Op)
+			}
+			arg = names.Add(a)
+			if op == OpCall {
+				if inst.Args < 0 || inst.Args > MaxCallArgs {
+					return nil, nil, fmt.Errorf("instruction %d: invalid argument count %d", i, inst.Args)
+				}
+				arg = CallOperand(arg, inst.Args)
+			}
+		default:
+			return nil, nil, fmt.Errorf("instruction %d: arg must be a number or a string", i)
+		}
+		instructions[i] = Instruction{Op: op, Arg: arg, Line: inst.Line, Col: inst.Col}
+	}
+	return instructions, constants, nil
+}
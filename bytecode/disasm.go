@@ -0,0 +1,175 @@
+package bytecode
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var opNames = map[OpCode]string{
+	OpConstant:         "CONSTANT",
+	OpAdd:              "ADD",
+	OpSub:              "SUB",
+	OpMul:              "MUL",
+	OpDiv:              "DIV",
+	OpCmpEq:            "CMP_EQ",
+	OpCmpNe:            "CMP_NE",
+	OpCmpLt:            "CMP_LT",
+	OpCmpLte:           "CMP_LTE",
+	OpCmpGt:            "CMP_GT",
+	OpCmpGte:           "CMP_GTE",
+	OpPop:              "POP",
+	OpSetGlobal:        "SET_GLOBAL",
+	OpGetGlobal:        "GET_GLOBAL",
+	OpSetLocal:         "SET_LOCAL",
+	OpGetLocal:         "GET_LOCAL",
+	OpMakeFunc:         "MAKE_FUNC",
+	OpCall:             "CALL",
+	OpCallIndirect:     "CALL_INDIRECT",
+	OpReturn:           "RETURN",
+	OpNop:              "NOP",
+	OpJump:             "JUMP",
+	OpJumpIfFalse:      "JUMP_IF_FALSE",
+	OpTable:            "TABLE",
+	OpArray:            "ARRAY",
+	OpSetIndex:         "SET_INDEX",
+	OpGetIndex:         "GET_INDEX",
+	OpNot:              "NOT",
+	OpHalt:             "HALT",
+	OpCheckType:        "CHECK_TYPE",
+	OpJumpIfNotNil:     "JUMP_IF_NOT_NIL",
+	OpJumpIfFalseOrPop: "JUMP_IF_FALSE_OR_POP",
+	OpJumpIfTrueOrPop:  "JUMP_IF_TRUE_OR_POP",
+	OpBitAnd:           "BIT_AND",
+	OpBitOr:            "BIT_OR",
+	OpBitXor:           "BIT_XOR",
+	OpBitNot:           "BIT_NOT",
+	OpShl:              "SHL",
+	OpShr:              "SHR",
+	OpIterNext:         "ITER_NEXT",
+}
+
+func (op OpCode) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("OP_%d", byte(op))
+}
+
+func ArgInt(arg interface{}) (int, bool) {
+	switch v := arg.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+func formatConstant(c Constant) string {
+	switch c.Type {
+	case "string":
+		return fmt.Sprintf("%q", c.Value)
+	case "funcptr":
+		return fmt.Sprintf("func@%v", c.Value)
+	case "nil":
+		return "nil"
+	}
+	return fmt.Sprintf("%v", c.Value)
+}
+
+func IsJump(op OpCode) bool {
+	return op == OpJump || op == OpJumpIfFalse || op == OpJumpIfNotNil ||
+		op == OpJumpIfFalseOrPop || op == OpJumpIfTrueOrPop || op == OpIterNext
+}
+
+// Disassemble writes a listing of instructions. Jump targets are marked with
+// ">>" and function entry points get a label line.
+func Disassemble(w io.Writer, instructions []Instruction, constants []Constant, srcmap *SourceMap) {
+	targets := make(map[int]bool)
+	entries := make(map[int]bool)
+	for _, inst := range instructions {
+		if IsJump(inst.Op) {
+			targets[inst.Arg] = true
+		}
+	}
+	for _, c := range constants {
+		if c.Type == "funcptr" {
+			if e, ok := ArgInt(c.Value); ok {
+				entries[e] = true
+			}
+		}
+	}
+
+	if len(constants) > 0 {
+		fmt.Fprintln(w, "constants:")
+		for i, c := range constants {
+			line := fmt.Sprintf("  #%-4d %-8s %s", i, c.Type, formatConstant(c))
+			if c.Name != "" {
+				line += " ; " + c.Name
+			}
+			fmt.Fprintln(w, line)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "code:")
+	for i, inst := range instructions {
+		if entries[i] {
+			fmt.Fprintf(w, "func@%d:\n", i)
+		}
+		marker := "  "
+		if targets[i] {
+			marker = ">>"
+		}
+		line := fmt.Sprintf("%s %5d  %-14s %s", marker, i, inst.Op, DescribeArg(inst, constants, len(instructions)))
+		if file, pos, ok := srcmap.Lookup(i); ok {
+			line = fmt.Sprintf("%-48s ; %s:%d", line, file, pos.Line)
+		} else if inst.Line > 0 {
+			line = fmt.Sprintf("%-48s ; line %d", line, inst.Line)
+		}
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+
+	if len(entries) > 0 {
+		var list []int
+		for e := range entries {
+			list = append(list, e)
+		}
+		sort.Ints(list)
+		fmt.Fprintf(w, "\n; %d function(s) at %v\n", len(list), list)
+	}
+}
+
+func DescribeArg(inst Instruction, constants []Constant, count int) string {
+	if !hasOperand(inst.Op) {
+		return ""
+	}
+	switch inst.Op {
+	case OpConstant, OpMakeFunc, OpCheckType:
+		if inst.Arg < 0 || inst.Arg >= len(constants) {
+			return fmt.Sprintf("#%d (out of range)", inst.Arg)
+		}
+		return fmt.Sprintf("#%d (%s)", inst.Arg, formatConstant(constants[inst.Arg]))
+	case OpGetGlobal, OpSetGlobal:
+		if name := ConstName(constants, inst.Arg); name != "" {
+			return name
+		}
+		return fmt.Sprintf("#%d (not a name)", inst.Arg)
+	case OpCall:
+		if name := ConstName(constants, CallName(inst.Arg)); name != "" {
+			return fmt.Sprintf("%s %d", name, CallCount(inst.Arg))
+		}
+		return fmt.Sprintf("#%d (not a name) %d", CallName(inst.Arg), CallCount(inst.Arg))
+	case OpJump, OpJumpIfFalse, OpJumpIfNotNil, OpJumpIfFalseOrPop, OpJumpIfTrueOrPop, OpIterNext:
+		if inst.Arg < 0 || inst.Arg > count {
+			return fmt.Sprintf("-> %d (unpatched)", inst.Arg)
+		}
+		return fmt.Sprintf("-> %d", inst.Arg)
+	case OpGetLocal, OpSetLocal:
+		return fmt.Sprintf("slot %d", inst.Arg)
+	}
+	return strconv.Itoa(inst.Arg)
+}
@@ -0,0 +1,81 @@
+package bytecode
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Function describes a function of a program: where its code starts and
+// the frame a call of it needs. Params is one more than the number of
+// parameters, 0 when not known, as for Constant.
+type Function struct {
+	Name   string
+	Entry  int
+	Params int
+	Locals int
+}
+
+// Functions returns the functions the funcptr constants of a program
+// point at, ordered by entry, and for each constant the index of its
+// function or -1. Constants sharing an entry share a function.
+func Functions(constants []Constant) ([]Function, []int) {
+	byEntry := make(map[int]*Function)
+	for _, c := range constants {
+		if c.Type != "funcptr" {
+			continue
+		}
+		entry, ok := ArgInt(c.Value)
+		if !ok {
+			continue
+		}
+		fn := byEntry[entry]
+		if fn == nil {
+			fn = &Function{Entry: entry}
+			byEntry[entry] = fn
+		}
+		if fn.Name == "" {
+			fn.Name = c.Name
+		}
+		fn.Params = max(fn.Params, c.Params)
+		fn.Locals = max(fn.Locals, c.Locals)
+	}
+	funcs := make([]Function, 0, len(byEntry))
+	for _, fn := range byEntry {
+		funcs = append(funcs, *fn)
+	}
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].Entry < funcs[j].Entry })
+
+	index := make([]int, len(constants))
+	for i, c := range constants {
+		index[i] = -1
+		if entry, ok := ArgInt(c.Value); ok && c.Type == "funcptr" {
+			index[i] = sort.Search(len(funcs), func(k int) bool { return funcs[k].Entry >= entry })
+		}
+	}
+	return funcs, index
+}
+
+// splitFunctions cuts instructions into the top level, which runs up to the
+// first function, and the code of each function, which runs up to the
+// next one.
+func splitFunctions(instructions []Instruction, funcs []Function) ([]Instruction, [][]Instruction, error) {
+	ends := make([]int, len(funcs))
+	for i, fn := range funcs {
+		if fn.Entry < 0 || fn.Entry >= len(instructions) {
+			return nil, nil, fmt.Errorf("function entry %d out of range", fn.Entry)
+		}
+		ends[i] = len(instructions)
+		if i+1 < len(funcs) {
+			ends[i] = funcs[i+1].Entry
+		}
+	}
+	top := instructions
+	if len(funcs) > 0 {
+		top = instructions[:funcs[0].Entry]
+	}
+	code := make([][]Instruction, len(funcs))
+	for i, fn := range funcs {
+		code[i] = instructions[fn.Entry:ends[i]]
+	}
+	return top, code, nil
+}
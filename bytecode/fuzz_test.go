@@ -0,0 +1,43 @@
+package bytecode_test
+
+import (
+	"bytes"
+	"lightlang/bytecode"
+	"lightlang/compiler"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// FuzzReadBytecode checks that reading any bytes, and verifying and
+// disassembling what reads, never panics. It starts from the compiled
+// programs in tests.
+func FuzzReadBytecode(f *testing.F) {
+	files, _ := filepath.Glob("../tests/*.ll")
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		builder, err := compiler.Compile(file, string(src))
+		if err != nil {
+			continue
+		}
+		instructions, constants := builder.Bytecode()
+		var buf bytes.Buffer
+		if bytecode.NewBytecodeWriter(&buf).WriteBytecode(instructions, constants) == nil {
+			f.Add(buf.Bytes())
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		instructions, constants, err := bytecode.NewBytecodeReader(bytes.NewReader(data)).ReadBytecode()
+		if err != nil {
+			return
+		}
+		if bytecode.VerifyProgram(instructions, constants) != nil {
+			return
+		}
+		var out bytes.Buffer
+		bytecode.Disassemble(&out, instructions, constants, nil)
+	})
+}
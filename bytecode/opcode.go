@@ -0,0 +1,84 @@
+package bytecode
+
+type OpCode byte
+
+const (
+	OpConstant OpCode = iota
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpCmpEq
+	OpCmpNe
+	OpCmpLt
+	OpCmpLte
+	OpCmpGt
+	OpCmpGte
+	OpPop
+	OpSetGlobal
+	OpGetGlobal
+	OpSetLocal
+	OpGetLocal
+	OpMakeFunc
+	OpCall
+	OpCallIndirect
+	OpReturn
+	OpNop
+	OpJump
+	OpJumpIfFalse
+	OpTable
+	OpArray
+	OpSetIndex
+	OpGetIndex
+	OpNot
+	OpHalt
+	// OpCheckType fails unless the value on top of the stack has the type
+	// named by its constant. Typed functions check their parameters and
+	// results with it.
+	OpCheckType
+	// OpJumpIfNotNil jumps and keeps the value on top of the stack unless
+	// it is nil, which it pops. It implements ??.
+	OpJumpIfNotNil
+	// OpJumpIfFalseOrPop jumps and keeps the value on top of the stack if
+	// it is false, and pops it otherwise; OpJumpIfTrueOrPop the other way
+	// round. They implement and and or.
+	OpJumpIfFalseOrPop
+	OpJumpIfTrueOrPop
+	// The bitwise ops work on numbers that are whole and fit in 64 bits.
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpBitNot
+	OpShl
+	OpShr
+	// OpIterNext is the step of a for-in loop. It pops a counter and the
+	// collection, and pushes the element at the counter or the next value
+	// of an iterator, or jumps when there are no more.
+	OpIterNext
+
+	// OpCount is the number of opcodes.
+	OpCount
+)
+
+// Instruction is one VM instruction. Arg is its operand: a constant index
+// (for global instructions the constant holding the name), a local slot, a
+// jump target or an element count.
+type Instruction struct {
+	Op   OpCode
+	Arg  int
+	Line int
+	Col  int
+}
+
+type Constant struct {
+	Value interface{}
+	Type  string
+	// Name is the function name of a funcptr, used in tracebacks.
+	Name string
+	// Locals is the number of local slots of a funcptr's frame.
+	Locals int
+	// Params is one more than the number of parameters of a funcptr, 0
+	// when not known. Calls of a function that knows it drop extra
+	// arguments.
+	Params int
+}
@@ -0,0 +1,96 @@
+package bytecode
+
+// hasOperand reports whether op uses Instruction.Arg. Other instructions
+// keep it at 0.
+func hasOperand(op OpCode) bool {
+	switch op {
+	case OpConstant, OpMakeFunc, OpGetGlobal, OpSetGlobal, OpCall, OpCallIndirect,
+		OpGetLocal, OpSetLocal, OpJump, OpJumpIfFalse, OpArray, OpCheckType, OpJumpIfNotNil,
+		OpJumpIfFalseOrPop, OpJumpIfTrueOrPop, OpIterNext:
+		return true
+	}
+	return false
+}
+
+// IsNameOp reports whether the operand of op is the constant holding a
+// global name.
+func IsNameOp(op OpCode) bool {
+	return op == OpGetGlobal || op == OpSetGlobal || op == OpCall
+}
+
+// UsesConstant reports whether the operand of op indexes the constants.
+func UsesConstant(op OpCode) bool {
+	return op == OpConstant || op == OpMakeFunc || op == OpCheckType || IsNameOp(op)
+}
+
+// MaxCallArgs is the most arguments a call passes. The operand of OpCall
+// holds the argument count in its low byte and the constant of the name
+// above it; that of OpCallIndirect is the argument count.
+const MaxCallArgs = 0xFF
+
+// CallOperand packs the operand of OpCall.
+func CallOperand(name, count int) int {
+	return name<<8 | count
+}
+
+// CallName and CallCount unpack the operand of OpCall.
+func CallName(arg int) int  { return arg >> 8 }
+func CallCount(arg int) int { return arg & MaxCallArgs }
+
+// ConstIndex returns the constant the operand of inst refers to, for ops
+// that use one.
+func ConstIndex(inst Instruction) int {
+	if inst.Op == OpCall {
+		return CallName(inst.Arg)
+	}
+	return inst.Arg
+}
+
+// SetConstIndex makes inst refer to constant idx, keeping the argument
+// count of a call.
+func SetConstIndex(inst *Instruction, idx int) {
+	if inst.Op == OpCall {
+		inst.Arg = CallOperand(idx, CallCount(inst.Arg))
+		return
+	}
+	inst.Arg = idx
+}
+
+// ConstName returns the global name held by constant idx, or "" if there is
+// none.
+func ConstName(constants []Constant, idx int) string {
+	if idx < 0 || idx >= len(constants) {
+		return ""
+	}
+	name, _ := constants[idx].Value.(string)
+	return name
+}
+
+// NamePool adds global names to a constant pool, reusing the string
+// constant of a name that is already there.
+type NamePool struct {
+	constants *[]Constant
+	index     map[string]int
+}
+
+func NewNamePool(constants *[]Constant) *NamePool {
+	p := &NamePool{constants: constants, index: make(map[string]int)}
+	for i, c := range *constants {
+		if s, ok := c.Value.(string); ok && c.Type == "string" {
+			if _, seen := p.index[s]; !seen {
+				p.index[s] = i
+			}
+		}
+	}
+	return p
+}
+
+func (p *NamePool) Add(name string) int {
+	if idx, ok := p.index[name]; ok {
+		return idx
+	}
+	*p.constants = append(*p.constants, Constant{Value: name, Type: "string"})
+	idx := len(*p.constants) - 1
+	p.index[name] = idx
+	return idx
+}
@@ -0,0 +1,106 @@
+package bytecode
+
+import (
+	"encoding/json"
+	"fmt"
+	"lightlang/parser"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SourceMap ties every instruction of a compiled program to the file, line
+// and column it came from. build writes it next to the bytecode as .llmap
+// so errors in bytecode files can point back at the sources.
+type SourceMap struct {
+	Version int      `json:"version"`
+	Files   []string `json:"files"`
+	// Spans has one [file, line, column] entry per instruction. file indexes
+	// Files; a line of 0 means the instruction has no position.
+	Spans [][3]int `json:"spans"`
+}
+
+const sourceMapVersion = 1
+
+// NewSourceMap builds a map for instructions whose positions all belong to
+// file.
+func NewSourceMap(instructions []Instruction, file string) *SourceMap {
+	return LinkedSourceMap(instructions, []string{file}, func(int) int { return 0 })
+}
+
+// LinkedSourceMap builds a map for a program made of several files;
+// fileOf returns the index into files of instruction ip.
+func LinkedSourceMap(instructions []Instruction, files []string, fileOf func(ip int) int) *SourceMap {
+	m := &SourceMap{Version: sourceMapVersion, Files: files, Spans: make([][3]int, len(instructions))}
+	for ip, inst := range instructions {
+		m.Spans[ip] = [3]int{fileOf(ip), inst.Line, inst.Col}
+	}
+	return m
+}
+
+// Lookup returns the source file and position of instruction ip.
+func (m *SourceMap) Lookup(ip int) (string, parser.Pos, bool) {
+	if m == nil || ip < 0 || ip >= len(m.Spans) {
+		return "", parser.Pos{}, false
+	}
+	span := m.Spans[ip]
+	if span[1] == 0 || span[0] < 0 || span[0] >= len(m.Files) {
+		return "", parser.Pos{}, false
+	}
+	return m.Files[span[0]], parser.Pos{Line: span[1], Col: span[2]}, true
+}
+
+// sourceMapPath returns the .llmap path that belongs to a bytecode file.
+func sourceMapPath(bytecode string) string {
+	return strings.TrimSuffix(bytecode, filepath.Ext(bytecode)) + ".llmap"
+}
+
+// WriteSourceMap saves the map of a bytecode file. Stripped builds get no
+// map, and a stale one from an earlier build is removed.
+func WriteSourceMap(bytecode string, m *SourceMap, strip bool) error {
+	path := sourceMapPath(bytecode)
+	if strip {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// loadSourceMap reads a .llmap file. A missing file is not an error and
+// gives a nil map.
+func loadSourceMap(path string) (*SourceMap, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m SourceMap
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid source map %s: %v", path, err)
+	}
+	if m.Version != sourceMapVersion {
+		return nil, fmt.Errorf("unsupported source map version %d in %s", m.Version, path)
+	}
+	return &m, nil
+}
+
+// ProgramSourceMap loads the source map of a bytecode file. It returns nil
+// for source files and for maps that do not match instructions.
+func ProgramSourceMap(target string, instructions []Instruction) (*SourceMap, error) {
+	if strings.HasSuffix(target, ".ll") {
+		return nil, nil
+	}
+	m, err := loadSourceMap(sourceMapPath(target))
+	if err != nil || m == nil || len(m.Spans) != len(instructions) {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,33 @@
+package bytecode
+
+// hasDebugInfo reports whether any instruction has a position or any
+// function constant a name.
+func hasDebugInfo(instructions []Instruction, constants []Constant) bool {
+	for _, inst := range instructions {
+		if inst.Line != 0 || inst.Col != 0 {
+			return true
+		}
+	}
+	for _, c := range constants {
+		if c.Name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// StripDebugInfo returns copies of a program without positions and function
+// names. The bytecode writer leaves the line table out of such programs.
+func StripDebugInfo(instructions []Instruction, constants []Constant) ([]Instruction, []Constant) {
+	insts := make([]Instruction, len(instructions))
+	for i, inst := range instructions {
+		inst.Line, inst.Col = 0, 0
+		insts[i] = inst
+	}
+	consts := make([]Constant, len(constants))
+	for i, c := range constants {
+		c.Name = ""
+		consts[i] = c
+	}
+	return insts, consts
+}
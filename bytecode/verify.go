@@ -0,0 +1,49 @@
+package bytecode
+
+import "fmt"
+
+// VerifyProgram checks that every operand of a loaded program points at
+// something that exists, so the VM can resolve jumps, constants and names
+// once at load time without checking them again while running. A jump may
+// target the end of the program, which stops it.
+func VerifyProgram(instructions []Instruction, constants []Constant) error {
+	for i, c := range constants {
+		if c.Type != "funcptr" {
+			continue
+		}
+		entry, ok := ArgInt(c.Value)
+		if !ok || entry < 0 || entry >= len(instructions) {
+			return fmt.Errorf("constant %d: function entry %v out of range", i, c.Value)
+		}
+	}
+	for i, inst := range instructions {
+		if inst.Op >= OpCount {
+			return fmt.Errorf("instruction %d: unknown opcode %d", i, inst.Op)
+		}
+		switch {
+		case IsJump(inst.Op):
+			if inst.Arg < 0 || inst.Arg > len(instructions) {
+				return fmt.Errorf("instruction %d: jump target %d out of range", i, inst.Arg)
+			}
+		case UsesConstant(inst.Op):
+			idx := ConstIndex(inst)
+			if inst.Arg < 0 || idx >= len(constants) {
+				return fmt.Errorf("instruction %d: constant #%d out of range", i, idx)
+			}
+			if IsNameOp(inst.Op) && ConstName(constants, idx) == "" {
+				return fmt.Errorf("instruction %d: constant #%d is not a name", i, idx)
+			}
+			if inst.Op == OpMakeFunc && constants[idx].Type != "funcptr" {
+				return fmt.Errorf("instruction %d: constant #%d is not a function", i, idx)
+			}
+			if inst.Op == OpCheckType && constants[idx].Type != "string" {
+				return fmt.Errorf("instruction %d: constant #%d is not a type", i, idx)
+			}
+		case hasOperand(inst.Op):
+			if inst.Arg < 0 {
+				return fmt.Errorf("instruction %d: negative operand %d", i, inst.Arg)
+			}
+		}
+	}
+	return nil
+}
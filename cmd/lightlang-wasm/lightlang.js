@@ -0,0 +1,35 @@
+// LightLang runs lightlang in the browser. Load wasm_exec.js from the Go
+// distribution first, then:
+//
+//   await LightLang.load("lightlang.wasm");
+//   const code = LightLang.compile('print("hi")');
+//   LightLang.run(code, { onPrint: (text) => console.log(text) });
+//
+// compile and run throw an Error for compile and runtime errors; run
+// returns the exit code of the program.
+const LightLang = {
+  async load(url) {
+    const go = new Go();
+    const source = await WebAssembly.instantiateStreaming(fetch(url), go.importObject);
+    go.run(source.instance);
+  },
+
+  compile(src) {
+    return LightLang.unwrap(LightLangGo.compile(src));
+  },
+
+  run(bytecode, options = {}) {
+    return LightLang.unwrap(LightLangGo.run(bytecode, options));
+  },
+
+  unwrap(result) {
+    if (result.error !== undefined) {
+      throw new Error(result.error);
+    }
+    return result.value;
+  },
+};
+
+if (typeof module !== "undefined") {
+  module.exports = LightLang;
+}
@@ -0,0 +1,113 @@
+//go:build js && wasm
+
+// Command lightlang-wasm is the compiler and VM for the browser. It sets up
+// the LightLang object of lightlang.js, see the README.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"lightlang/compiler"
+	"lightlang/lang"
+	"lightlang/parser"
+	"lightlang/vm"
+	"strings"
+	"syscall/js"
+)
+
+// printer sends what a program prints to a JS callback.
+type printer js.Value
+
+func (p printer) Write(b []byte) (int, error) {
+	if js.Value(p).Type() == js.TypeFunction {
+		js.Value(p).Invoke(string(b))
+	}
+	return len(b), nil
+}
+
+// result is how a call reports back; lightlang.js throws the error.
+func result(value interface{}, err error) interface{} {
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	return map[string]interface{}{"value": value}
+}
+
+func compile(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeString {
+		return result(nil, errors.New("compile expects the source as a string"))
+	}
+	prog, err := lang.Compile(args[0].String())
+	if err != nil {
+		return result(nil, err)
+	}
+	var buf bytes.Buffer
+	if err := bytecode.NewBytecodeWriter(&buf).WriteBytecode(prog.Instructions, prog.Constants); err != nil {
+		return result(nil, err)
+	}
+	code := js.Global().Get("Uint8Array").New(buf.Len())
+	js.CopyBytesToJS(code, buf.Bytes())
+	return result(code, nil)
+}
+
+// run runs bytecode from compile. Options are onPrint and onError, called
+// with the text of print and of errors, and input, the text input reads.
+// The program may not touch files, the network or commands.
+func run(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].Type() != js.TypeObject {
+		return result(nil, errors.New("run expects bytecode as a Uint8Array"))
+	}
+	code := make([]byte, args[0].Get("length").Int())
+	js.CopyBytesToGo(code, args[0])
+	instructions, constants, err := compiler.ReadBytecode(bytecode.NewBytecodeReader(bytes.NewReader(code)))
+	if err == nil {
+		err = bytecode.VerifyProgram(instructions, constants)
+	}
+	if err != nil {
+		return result(nil, err)
+	}
+
+	opts := js.Undefined()
+	if len(args) > 1 {
+		opts = args[1]
+	}
+	option := func(name string) js.Value {
+		if opts.Type() != js.TypeObject {
+			return js.Undefined()
+		}
+		return opts.Get(name)
+	}
+	input := ""
+	if in := option("input"); in.Type() == js.TypeString {
+		input = in.String()
+	}
+	stderr := option("onError")
+	if stderr.Type() != js.TypeFunction {
+		stderr = option("onPrint")
+	}
+	v := vm.New(
+		vm.WithStdout(printer(option("onPrint"))),
+		vm.WithStderr(printer(stderr)),
+		vm.WithStdin(strings.NewReader(input)),
+		vm.WithPermissions(&builtins.Permissions{}),
+	)
+	err = v.Run(&vm.Program{Instructions: instructions, Constants: constants})
+	var exit *builtins.ExitError
+	if errors.As(err, &exit) {
+		return result(exit.Code, nil)
+	}
+	if err != nil {
+		return result(nil, parser.WrapError(err, "", "Runtime Error", parser.Pos{}))
+	}
+	return result(0, nil)
+}
+
+func main() {
+	js.Global().Set("LightLangGo", js.ValueOf(map[string]interface{}{
+		"compile": js.FuncOf(compile),
+		"run":     js.FuncOf(run),
+	}))
+	select {}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"lightlang/vm"
+	"os"
+	"time"
+)
+
+type benchOptions struct {
+	duration time.Duration
+	save     string
+	compare  string
+	paths    []string
+}
+
+func parseBenchArgs(args []string) (benchOptions, error) {
+	opts := benchOptions{duration: time.Second}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-time", "-save", "-compare":
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			switch arg {
+			case "-time":
+				d, err := time.ParseDuration(args[i])
+				if err != nil || d <= 0 {
+					return opts, fmt.Errorf("invalid duration %q", args[i])
+				}
+				opts.duration = d
+			case "-save":
+				opts.save = args[i]
+			case "-compare":
+				opts.compare = args[i]
+			}
+		default:
+			opts.paths = append(opts.paths, arg)
+		}
+	}
+	return opts, nil
+}
+
+// runBench calls the function until target has elapsed, doubling the batch
+// size each round, and returns iterations and ns/op.
+func runBench(v *vm.VM, name string, target time.Duration) (int, float64, error) {
+	if _, err := v.Call(name); err != nil {
+		return 0, 0, err
+	}
+	total := 0
+	var elapsed time.Duration
+	batch := 1
+	for elapsed < target {
+		start := time.Now()
+		for i := 0; i < batch; i++ {
+			if _, err := v.Call(name); err != nil {
+				return 0, 0, err
+			}
+		}
+		elapsed += time.Since(start)
+		total += batch
+		if batch < 1<<20 {
+			batch *= 2
+		}
+	}
+	return total, float64(elapsed.Nanoseconds()) / float64(total), nil
+}
+
+func benchCommand(args []string) int {
+	opts, err := parseBenchArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fmt.Fprintln(os.Stderr, "Nope, do it like this: lightlang bench [-time 1s] [-save file] [-compare file] [paths...]")
+		return 2
+	}
+
+	baseline := map[string]float64{}
+	if opts.compare != "" {
+		data, err := os.ReadFile(opts.compare)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading baseline: %v\n", err)
+			return 1
+		}
+		if err := json.Unmarshal(data, &baseline); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading baseline: %v\n", err)
+			return 1
+		}
+	}
+
+	files, err := findFiles(opts.paths, "_bench.ll")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Println("no benchmark files found")
+		return 0
+	}
+
+	results := map[string]float64{}
+	failed := false
+	for _, file := range files {
+		fmt.Printf("=== %s\n", file)
+		v, benches, err := loadScript(file, "bench_", nil)
+		if err != nil {
+			fmt.Printf("  FAIL %s\n       %v\n", file, err)
+			failed = true
+			continue
+		}
+		for _, name := range benches {
+			iters, nsOp, err := runBench(v, name, opts.duration)
+			if err != nil {
+				fmt.Printf("  FAIL %s\n       %v\n", name, err)
+				failed = true
+				continue
+			}
+			key := file + ":" + name
+			results[key] = nsOp
+			line := fmt.Sprintf("  %-30s %10d iters %14.1f ns/op %14.1f ops/sec", name, iters, nsOp, 1e9/nsOp)
+			if old, ok := baseline[key]; ok && old > 0 {
+				line += fmt.Sprintf("  %+.1f%%", (nsOp-old)/old*100)
+			}
+			fmt.Println(line)
+		}
+	}
+
+	if opts.save != "" {
+		data, _ := json.MarshalIndent(results, "", "  ")
+		if err := os.WriteFile(opts.save, data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing baseline: %v\n", err)
+			return 1
+		}
+		fmt.Printf("saved baseline to %s\n", opts.save)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
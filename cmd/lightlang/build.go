@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"lightlang/bytecode"
+	"lightlang/compiler"
+	"lightlang/parser"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sourceFiles lists the .ll files of a directory, leaving out tests and
+// benchmarks. There is no import statement yet, so files are ordered by
+// path.
+func sourceFiles(dir string) ([]string, error) {
+	files, err := findFiles([]string{dir}, ".ll")
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, f := range files {
+		if strings.HasSuffix(f, "_test.ll") || strings.HasSuffix(f, "_bench.ll") {
+			continue
+		}
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+func modTime(path string) (time.Time, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return info.ModTime(), true
+}
+
+// upToDate reports whether output exists and is newer than every input.
+func upToDate(output string, inputs []string) bool {
+	outTime, ok := modTime(output)
+	if !ok {
+		return false
+	}
+	for _, in := range inputs {
+		inTime, ok := modTime(in)
+		if !ok || inTime.After(outTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// buildDir compiles every source file in dir. With an output path the files
+// are linked into one program, otherwise each file gets its own
+// .llbytecode next to it. Outputs newer than their sources are skipped.
+func buildDir(dir string, output string, force bool, strip bool) int {
+	files, err := sourceFiles(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading source directory: %v\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "No .ll files in '%s'\n", dir)
+		return 1
+	}
+
+	if output != "" {
+		if !force && upToDate(output, files) {
+			fmt.Printf("'%s' is up to date\n", output)
+			return 0
+		}
+		var source strings.Builder
+		starts := make([]int, len(files))
+		line := 1
+		for i, f := range files {
+			content, err := os.ReadFile(f)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading source file: %v\n", err)
+				return 1
+			}
+			starts[i] = line
+			text := strings.ReplaceAll(string(content), "\r\n", "\n") + "\n"
+			line += strings.Count(text, "\n")
+			source.WriteString(text)
+		}
+		// map lines in the linked source back to their files
+		fileOf := func(line int) int {
+			for i := len(starts) - 1; i > 0; i-- {
+				if line >= starts[i] {
+					return i
+				}
+			}
+			return 0
+		}
+		relocate := func(list parser.ErrorList) {
+			for _, se := range list {
+				if se.Line > 0 {
+					i := fileOf(se.Line)
+					se.File = files[i]
+					se.Line -= starts[i] - 1
+				}
+			}
+		}
+		builder, err := compiler.Compile(dir, source.String())
+		if err != nil {
+			list, _ := err.(parser.ErrorList)
+			if se, single := err.(*parser.SourceError); single {
+				list = parser.ErrorList{se}
+			}
+			relocate(list)
+			printError(err)
+			return 1
+		}
+		relocate(builder.Warnings)
+		if err := reportWarnings(builder.Warnings, readSource); err != nil {
+			printError(err)
+			return 1
+		}
+		instructions, constants := builder.Bytecode()
+		instructions, constants = compiler.OptimizeBytecode(instructions, constants, builder.SymbolTable)
+		owner := make([]int, len(instructions))
+		for ip := range instructions {
+			if inst := &instructions[ip]; inst.Line > 0 {
+				owner[ip] = fileOf(inst.Line)
+				inst.Line -= starts[owner[ip]] - 1
+			}
+		}
+		srcmap := bytecode.LinkedSourceMap(instructions, files, func(ip int) int { return owner[ip] })
+		if strip {
+			instructions, constants = bytecode.StripDebugInfo(instructions, constants)
+		}
+		if err := bytecode.SaveBytecode(output, instructions, constants); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing bytecode file: %v\n", err)
+			return 1
+		}
+		if err := bytecode.WriteSourceMap(output, srcmap, strip); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing source map: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Successfully linked %d files -> '%s'\n", len(files), output)
+		return 0
+	}
+
+	status := 0
+	built := 0
+	for _, f := range files {
+		out := strings.TrimSuffix(f, ".ll") + ".llbytecode"
+		if !force && upToDate(out, []string{f}) {
+			continue
+		}
+		if code := buildCommand(f, out, strip); code != 0 {
+			status = code
+			continue
+		}
+		built++
+	}
+	fmt.Printf("%d of %d files rebuilt\n", built, len(files))
+	return status
+}
+
+// emitGoCommand translates source into a Go program that runs it natively
+// with package gort.
+func emitGoCommand(source string, output string) int {
+	content, err := os.ReadFile(source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading source file: %v\n", err)
+		return 1
+	}
+	builder, err := compiler.Compile(source, string(content))
+	if err == nil {
+		err = reportWarnings(builder.Warnings, readSource)
+	}
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	nodes, err := parser.ParseFile(source, string(content))
+	if err != nil {
+		printError(parser.WrapError(err, source, "Parse Error", parser.Pos{}))
+		return 1
+	}
+	// line directives are read relative to the Go file
+	file := source
+	if rel, err := filepath.Rel(filepath.Dir(output), source); err == nil {
+		file = filepath.ToSlash(rel)
+	}
+	src, err := compiler.GoSource(file, nodes)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	if err := os.WriteFile(output, src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing Go file: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Successfully translated '%s' -> '%s'\n", source, output)
+	return 0
+}
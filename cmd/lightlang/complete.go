@@ -0,0 +1,106 @@
+package main
+
+import (
+	"lightlang/builtins"
+	"lightlang/vm"
+	"slices"
+	"strings"
+)
+
+var replKeywords = []string{
+	"and", "asm", "break", "const", "continue", "do", "else", "elseif", "end", "false", "for",
+	"func", "if", "in", "include", "let", "macro", "nil", "not", "or", "return", "then", "true", "type", "while",
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// complete finds the name before pos in line, with any dotted prefix, and
+// returns where it starts and the names it could be: keywords, builtins,
+// the globals of the session, and the keys of a table after a dot.
+func (r *repl) complete(line string, pos int) (int, []string) {
+	start := pos
+	for start > 0 && (isNameByte(line[start-1]) || line[start-1] == '.') {
+		start--
+	}
+	word := line[start:pos]
+	if word == "" || (word[0] >= '0' && word[0] <= '9') {
+		return start, nil
+	}
+
+	var names []string
+	for name := range builtins.Builtins {
+		names = append(names, name)
+	}
+	if !strings.Contains(word, ".") {
+		names = append(names, replKeywords...)
+		for name := range r.vm.Globals {
+			names = append(names, name)
+		}
+		for sym := r.builder.SymbolTable; sym != nil; sym = sym.Parent {
+			for name := range sym.Globals {
+				names = append(names, name)
+			}
+			for name := range sym.Locals {
+				names = append(names, name)
+			}
+		}
+	} else {
+		path := strings.Split(word, ".")
+		for _, key := range tableKeys(r.vm.Globals[path[0]], path[1:len(path)-1]) {
+			names = append(names, strings.Join(path[:len(path)-1], ".")+"."+key)
+		}
+	}
+
+	var out []string
+	for _, name := range names {
+		if strings.HasPrefix(name, word) && name != "_" {
+			out = append(out, name)
+		}
+	}
+	slices.Sort(out)
+	return start, slices.Compact(out)
+}
+
+// tableKeys follows path through the tables in val and returns the keys
+// that are names in the table at its end.
+func tableKeys(val vm.Value, path []string) []string {
+	cur := val.Interface()
+	for _, key := range path {
+		switch t := cur.(type) {
+		case map[string]interface{}:
+			cur = t[key]
+		case *builtins.OrderedTable:
+			cur = t.Get(key)
+		default:
+			return nil
+		}
+	}
+	var keys []string
+	switch t := cur.(type) {
+	case map[string]interface{}:
+		if t["type"] == "function" {
+			return nil
+		}
+		for k := range t {
+			keys = append(keys, k)
+		}
+	case *builtins.OrderedTable:
+		keys = t.Keys()
+	}
+	return slices.DeleteFunc(keys, func(k string) bool {
+		return k == "" || (k[0] >= '0' && k[0] <= '9') || strings.IndexFunc(k, func(c rune) bool { return c > 127 || !isNameByte(byte(c)) }) >= 0
+	})
+}
+
+// commonPrefix is the longest prefix that all of names share.
+func commonPrefix(names []string) string {
+	prefix := names[0]
+	for _, name := range names[1:] {
+		for !strings.HasPrefix(name, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
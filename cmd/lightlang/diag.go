@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"lightlang/parser"
+	"lightlang/term"
+	"lightlang/vm"
+	"os"
+	"strings"
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[1;31m"
+	ansiYellow = "\x1b[1;33m"
+	ansiCyan   = "\x1b[1;36m"
+	ansiBlue   = "\x1b[1;34m"
+)
+
+var useColor bool
+
+// initColor enables colors when stderr is a terminal, unless --no-color
+// was given or NO_COLOR is set.
+func initColor(noColor bool) {
+	_, noColorEnv := os.LookupEnv("NO_COLOR")
+	useColor = !noColor && !noColorEnv && term.IsTerminal(int(os.Stderr.Fd()))
+}
+
+func paint(code string, s string) string {
+	if !useColor || s == "" {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+func severityColor(s parser.Severity) string {
+	switch s {
+	case parser.SeverityWarning:
+		return ansiYellow
+	case parser.SeverityNote:
+		return ansiCyan
+	}
+	return ansiRed
+}
+
+func formatDiagnostic(se *parser.SourceError, src string) string {
+	var b strings.Builder
+	if !useColor {
+		b.WriteString(se.Error())
+	} else {
+		plain := *se
+		plain.Kind = ""
+		msg := plain.Err.Error()
+		loc := strings.TrimSuffix(plain.Error(), msg)
+		if loc != "" {
+			b.WriteString(paint(ansiBold, loc))
+		}
+		if se.Kind != "" {
+			b.WriteString(paint(severityColor(se.Severity), se.Kind+":") + " ")
+		}
+		b.WriteString(paint(ansiBold, msg))
+	}
+
+	gutter, text, marker, ok := se.SnippetParts(src)
+	if ok {
+		pad := strings.Repeat(" ", len(gutter))
+		bar := paint(ansiBlue, pad+" |")
+		fmt.Fprintf(&b, "\n%s\n%s %s\n%s %s", bar, paint(ansiBlue, gutter+" |"), text, bar, paint(severityColor(se.Severity), marker))
+	}
+	if len(se.Trace) > 0 {
+		b.WriteString("\n" + vm.FormatTraceback(se.File, se.Trace))
+	}
+	return b.String()
+}
+
+// printError prints err to stderr and, for errors in a .ll file, the
+// offending line.
+func printError(err error) {
+	printDiagnostics(os.Stderr, err, readSource)
+}
+
+// readSource returns the text of a .ll file for snippets, or "".
+func readSource(file string) string {
+	if !strings.HasSuffix(file, ".ll") {
+		return ""
+	}
+	src, _ := os.ReadFile(file)
+	return string(src)
+}
+
+func printDiagnostics(w io.Writer, err error, source func(file string) string) {
+	list, ok := err.(parser.ErrorList)
+	if !ok {
+		var se *parser.SourceError
+		if errors.As(err, &se) && se == err {
+			fmt.Fprintln(w, formatDiagnostic(se, source(se.File)))
+			return
+		}
+		fmt.Fprintln(w, err)
+		return
+	}
+	for _, se := range list {
+		fmt.Fprintln(w, formatDiagnostic(se, source(se.File)))
+	}
+	fmt.Fprintln(w, paint(ansiRed, fmt.Sprintf("%d errors", len(list))))
+}
+
+// werror turns compiler warnings into errors (--werror).
+var werror bool
+
+// reportWarnings prints warnings to stderr and, with --werror, returns an
+// error for them.
+func reportWarnings(list parser.ErrorList, source func(file string) string) error {
+	for _, w := range list {
+		fmt.Fprintln(os.Stderr, formatDiagnostic(w, source(w.File)))
+	}
+	if werror && len(list) > 0 {
+		return fmt.Errorf("%d warnings treated as errors (--werror)", len(list))
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"lightlang/parser"
+	"os"
+	"strings"
+)
+
+type funcDoc struct {
+	Name       string
+	TypeParams []string
+	Params     []string
+	// Types and Return are the declared types, empty where there are none.
+	Types  []string
+	Return string
+	Text   string
+	// ParamDocs holds "@param name description" lines keyed by name.
+	ParamDocs map[string]string
+}
+
+type fileDoc struct {
+	Path  string
+	Funcs []funcDoc
+}
+
+func collectDocs(path string) (fileDoc, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fileDoc{}, fmt.Errorf("Error reading file: %v", err)
+	}
+	nodes, err := parser.ParseFile(path, string(content))
+	if err != nil {
+		return fileDoc{}, parser.WrapError(err, path, "Parse Error", parser.Pos{})
+	}
+
+	doc := fileDoc{Path: path}
+	for _, node := range nodes {
+		fn, ok := node.(*parser.FuncDefNode)
+		if !ok || strings.HasPrefix(fn.Name, "_") {
+			continue
+		}
+		fd := funcDoc{Name: fn.Name, TypeParams: fn.TypeParams, Params: fn.Params, Types: fn.ParamTypes, Return: fn.ReturnType, ParamDocs: map[string]string{}}
+		var text []string
+		for _, line := range strings.Split(fn.Doc, "\n") {
+			if rest, ok := strings.CutPrefix(line, "@param "); ok {
+				name, desc, _ := strings.Cut(strings.TrimSpace(rest), " ")
+				fd.ParamDocs[name] = strings.TrimSpace(desc)
+				continue
+			}
+			text = append(text, line)
+		}
+		fd.Text = strings.TrimSpace(strings.Join(text, "\n"))
+		doc.Funcs = append(doc.Funcs, fd)
+	}
+	return doc, nil
+}
+
+func (f funcDoc) signature() string {
+	params := make([]string, len(f.Params))
+	for i, p := range f.Params {
+		params[i] = p
+		if i < len(f.Types) && f.Types[i] != "" {
+			params[i] += ": " + f.Types[i]
+		}
+	}
+	name := f.Name
+	if len(f.TypeParams) > 0 {
+		name += "<" + strings.Join(f.TypeParams, ", ") + ">"
+	}
+	sig := fmt.Sprintf("%s(%s)", name, strings.Join(params, ", "))
+	if f.Return != "" {
+		sig += ": " + f.Return
+	}
+	return sig
+}
+
+func writeMarkdown(w io.Writer, docs []fileDoc) {
+	for _, d := range docs {
+		fmt.Fprintf(w, "# %s\n\n", d.Path)
+		for _, fn := range d.Funcs {
+			fmt.Fprintf(w, "## %s\n\n", fn.signature())
+			if fn.Text != "" {
+				fmt.Fprintf(w, "%s\n\n", fn.Text)
+			}
+			if len(fn.Params) > 0 {
+				fmt.Fprintln(w, "Parameters:")
+				fmt.Fprintln(w)
+				for _, p := range fn.Params {
+					if desc := fn.ParamDocs[p]; desc != "" {
+						fmt.Fprintf(w, "- `%s`: %s\n", p, desc)
+					} else {
+						fmt.Fprintf(w, "- `%s`\n", p)
+					}
+				}
+				fmt.Fprintln(w)
+			}
+		}
+	}
+}
+
+func writeHTML(w io.Writer, docs []fileDoc) {
+	esc := html.EscapeString
+	fmt.Fprintln(w, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>lightlang docs</title></head>\n<body>")
+	for _, d := range docs {
+		fmt.Fprintf(w, "<h1>%s</h1>\n", esc(d.Path))
+		for _, fn := range d.Funcs {
+			fmt.Fprintf(w, "<h2 id=\"%s\"><code>%s</code></h2>\n", esc(fn.Name), esc(fn.signature()))
+			for _, para := range strings.Split(fn.Text, "\n\n") {
+				if para != "" {
+					fmt.Fprintf(w, "<p>%s</p>\n", esc(para))
+				}
+			}
+			if len(fn.Params) > 0 {
+				fmt.Fprintln(w, "<ul>")
+				for _, p := range fn.Params {
+					if desc := fn.ParamDocs[p]; desc != "" {
+						fmt.Fprintf(w, "<li><code>%s</code>: %s</li>\n", esc(p), esc(desc))
+					} else {
+						fmt.Fprintf(w, "<li><code>%s</code></li>\n", esc(p))
+					}
+				}
+				fmt.Fprintln(w, "</ul>")
+			}
+		}
+	}
+	fmt.Fprintln(w, "</body>\n</html>")
+}
+
+func docCommand(args []string) int {
+	format := "md"
+	output := ""
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-html":
+			format = "html"
+		case "-o":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "Nope, do it like this: lightlang doc [-html] [-o output] [paths...]")
+				return 2
+			}
+			i++
+			output = args[i]
+		default:
+			paths = append(paths, args[i])
+		}
+	}
+
+	files, err := findFiles(paths, ".ll")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	var docs []fileDoc
+	for _, f := range files {
+		if strings.HasSuffix(f, "_test.ll") || strings.HasSuffix(f, "_bench.ll") {
+			continue
+		}
+		d, err := collectDocs(f)
+		if err != nil {
+			printError(err)
+			return 1
+		}
+		if len(d.Funcs) > 0 {
+			docs = append(docs, d)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing docs: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if format == "html" {
+		writeHTML(w, docs)
+	} else {
+		writeMarkdown(w, docs)
+	}
+	return 0
+}
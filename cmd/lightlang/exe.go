@@ -0,0 +1,296 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"lightlang/bytecode"
+	"lightlang/compiler"
+	"lightlang/vm"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// A standalone executable is a copy of lightlang followed by a zip of the
+// program's bytecode, as main.llbytecode, and the files its manifest
+// embeds. Then comes a trailer: the length of the zip as 8 little endian
+// bytes, and exeMagic.
+const exeMagic = "LLEXE\x00\x00\x01"
+
+const exeTrailer = 8 + len(exeMagic)
+
+const exeMain = "main.llbytecode"
+
+// exeNative is in the bundles of executables built with --native, which
+// run their program with the JIT.
+const exeNative = "native"
+
+// bundled holds the files of the standalone executable being run.
+var bundled fs.FS
+
+// manifest is lightlang.json, next to the program built with --exe. Embed
+// lists globs, relative to it, of files to bundle with the program.
+type manifest struct {
+	Embed []string `json:"embed"`
+}
+
+// payload finds the bundle appended to the executable f. It returns the
+// size of the runtime before it, and a nil bundle when there is none.
+func payload(f *os.File) (int64, *zip.Reader, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, nil, err
+	}
+	size := info.Size()
+	if size < int64(exeTrailer) {
+		return size, nil, nil
+	}
+	trailer := make([]byte, exeTrailer)
+	if _, err := f.ReadAt(trailer, size-int64(exeTrailer)); err != nil {
+		return 0, nil, err
+	}
+	if string(trailer[8:]) != exeMagic {
+		return size, nil, nil
+	}
+	n := int64(binary.LittleEndian.Uint64(trailer))
+	start := size - int64(exeTrailer) - n
+	if n < 0 || start < 0 {
+		return 0, nil, fmt.Errorf("corrupt bundle trailer")
+	}
+	bundle, err := zip.NewReader(io.NewSectionReader(f, start, n), n)
+	if err != nil {
+		return 0, nil, err
+	}
+	return start, bundle, nil
+}
+
+// runEmbedded runs the program bundled with this executable, if there is
+// one. All arguments belong to the program.
+func runEmbedded() (int, bool) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, false
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return 0, false
+	}
+	_, bundle, err := payload(f)
+	if err == nil && bundle == nil {
+		f.Close()
+		return 0, false
+	}
+	var code []byte
+	if err == nil {
+		code, err = fs.ReadFile(bundle, exeMain)
+	}
+	var instructions []bytecode.Instruction
+	var constants []bytecode.Constant
+	if err == nil {
+		instructions, constants, err = compiler.ReadBytecode(bytecode.NewBytecodeReader(bytes.NewReader(code)))
+	}
+	if err == nil {
+		err = bytecode.VerifyProgram(instructions, constants)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading bundle: %v\n", err)
+		return 1, true
+	}
+	bundled = bundle
+	if _, err := fs.Stat(bundle, exeNative); err == nil {
+		vm.DefaultJIT = true
+	}
+	return runProgram(filepath.Base(exe), &vm.Program{Instructions: instructions, Constants: constants}, nil), true
+}
+
+// runtimeFor finds the lightlang that a bundle for target, as os/arch,
+// starts with: this one for the machine it runs on, or else a release
+// binary named like those of build.bat, next to it, in its builds
+// directory or in $LIGHTLANG_RUNTIMES.
+func runtimeFor(target string) (string, error) {
+	exe, err := os.Executable()
+	if err != nil || target == "" || target == runtime.GOOS+"/"+runtime.GOARCH {
+		return exe, err
+	}
+	goos, goarch, ok := strings.Cut(target, "/")
+	if !ok || goos == "" || goarch == "" || strings.Contains(goarch, "/") {
+		return "", fmt.Errorf("target must look like linux/amd64, got %q", target)
+	}
+	name := "lightlang_" + goos + "_" + goarch
+	if goos == "windows" {
+		name += ".exe"
+	}
+	var dirs []string
+	if dir := os.Getenv("LIGHTLANG_RUNTIMES"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, filepath.Dir(exe), filepath.Join(filepath.Dir(exe), "builds"))
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no lightlang runtime for %s: put %s, as built by build.bat, next to lightlang or in $LIGHTLANG_RUNTIMES", target, name)
+}
+
+// exeName is the file an executable for target is written to. Windows
+// ones end in .exe.
+func exeName(source, output, target string) string {
+	goos := runtime.GOOS
+	if target != "" {
+		goos, _, _ = strings.Cut(target, "/")
+	}
+	if output == "" {
+		output = strings.TrimSuffix(source, ".ll")
+	}
+	if goos == "windows" && !strings.EqualFold(filepath.Ext(output), ".exe") {
+		output += ".exe"
+	}
+	return output
+}
+
+// embedFiles adds the files matched by the manifest in dir to the bundle.
+func embedFiles(zw *zip.Writer, dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, "lightlang.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("lightlang.json: %v", err)
+	}
+	seen := map[string]bool{exeMain: true}
+	for _, pattern := range m.Embed {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return fmt.Errorf("lightlang.json: embed %q: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("lightlang.json: embed %q matches no files", pattern)
+		}
+		for _, match := range matches {
+			err := filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(dir, path)
+				if err != nil {
+					return err
+				}
+				name := filepath.ToSlash(rel)
+				if !fs.ValidPath(name) {
+					return fmt.Errorf("lightlang.json: cannot embed %s, it is outside %s", path, dir)
+				}
+				if seen[name] {
+					return nil
+				}
+				seen[name] = true
+				w, err := zw.Create(name)
+				if err != nil {
+					return err
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				_, err = w.Write(data)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// buildExe writes output as a copy of the lightlang runtime for target with
+// the program and the files of the manifest in dir bundled after it.
+func buildExe(output, dir, target string, native bool, instructions []bytecode.Instruction, constants []bytecode.Constant) error {
+	var bundle bytes.Buffer
+	zw := zip.NewWriter(&bundle)
+	w, err := zw.Create(exeMain)
+	if err != nil {
+		return err
+	}
+	if err := bytecode.NewBytecodeWriter(w).WriteBytecode(instructions, constants); err != nil {
+		return err
+	}
+	if native {
+		if _, err := zw.Create(exeNative); err != nil {
+			return err
+		}
+	}
+	if err := embedFiles(zw, dir); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	path, err := runtimeFor(target)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	// a standalone executable can build others, without its own program
+	size, _, err := payload(in)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return err
+	}
+	trailer := binary.LittleEndian.AppendUint64(nil, uint64(bundle.Len()))
+	trailer = append(trailer, exeMagic...)
+	_, err = io.Copy(out, io.NewSectionReader(in, 0, size))
+	if err == nil {
+		_, err = out.Write(append(bundle.Bytes(), trailer...))
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func exeCommand(source string, output string, target string, strip, native bool) int {
+	content, err := os.ReadFile(source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading source file: %v\n", err)
+		return 1
+	}
+
+	instructions, constants, err := compileSource(source, string(content))
+	if err != nil {
+		printError(err)
+		return 1
+	}
+
+	if strip {
+		instructions, constants = bytecode.StripDebugInfo(instructions, constants)
+	}
+	output = exeName(source, output, target)
+	if err := buildExe(output, filepath.Dir(source), target, native, instructions, constants); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing executable: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Successfully built '%s' -> '%s'\n", source, output)
+	return 0
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"lightlang/builtins"
+	"lightlang/vm"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// debugListen is the address given to --debug-listen.
+var debugListen string
+
+// quiet discards the output of print and input prompts (--quiet).
+var quiet bool
+
+// tracer is set by --trace and used by run.
+var tracer *vm.Tracer
+
+// profiler is set by --profile and used by run.
+var profiler *vm.Profiler
+
+// allocProfile is set by --alloc-profile.
+var allocProfile bool
+
+// takeFlag removes flag from os.Args and reports whether it was present.
+func takeFlag(flag string) bool {
+	found := false
+	args := os.Args[:1]
+	for _, arg := range os.Args[1:] {
+		if arg == flag {
+			found = true
+			continue
+		}
+		args = append(args, arg)
+	}
+	os.Args = args
+	return found
+}
+
+// takeValueFlag removes "flag value" or "flag=value" from os.Args and
+// returns the value.
+func takeValueFlag(flag string) (string, bool) {
+	value, found := "", false
+	args := os.Args[:1]
+	for i := 1; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		switch {
+		case arg == flag && i+1 < len(os.Args):
+			i++
+			value, found = os.Args[i], true
+		case strings.HasPrefix(arg, flag+"="):
+			value, found = strings.TrimPrefix(arg, flag+"="), true
+		default:
+			args = append(args, arg)
+		}
+	}
+	os.Args = args
+	return value, found
+}
+
+// takeGrantFlag removes "flag" and "flag=a,b" from os.Args and returns
+// what they grant.
+func takeGrantFlag(flag string) builtins.Grant {
+	var g builtins.Grant
+	args := os.Args[:1]
+	for _, arg := range os.Args[1:] {
+		switch {
+		case arg == flag:
+			g.All = true
+		case strings.HasPrefix(arg, flag+"="):
+			g.Only = append(g.Only, strings.Split(strings.TrimPrefix(arg, flag+"="), ",")...)
+		default:
+			args = append(args, arg)
+		}
+	}
+	os.Args = args
+	return g
+}
+
+// takeTraceFlag removes --trace or --trace=filter from os.Args and returns
+// the tracer it asks for, or nil.
+func takeTraceFlag() (*vm.Tracer, error) {
+	var t *vm.Tracer
+	var err error
+	args := os.Args[:1]
+	for _, arg := range os.Args[1:] {
+		switch {
+		case arg == "--trace":
+			t = &vm.Tracer{}
+		case strings.HasPrefix(arg, "--trace="):
+			t, err = parseTraceFilter(strings.TrimPrefix(arg, "--trace="))
+		default:
+			args = append(args, arg)
+		}
+	}
+	os.Args = args
+	if t != nil {
+		t.Out = os.Stderr
+	}
+	return t, err
+}
+
+// parseTraceFilter reads the value of --trace=, which is a function name or
+// an instruction range like 10-40.
+func parseTraceFilter(filter string) (*vm.Tracer, error) {
+	t := &vm.Tracer{}
+	if from, to, ok := strings.Cut(filter, "-"); ok {
+		a, err1 := strconv.Atoi(from)
+		b, err2 := strconv.Atoi(to)
+		if err1 != nil || err2 != nil || a < 0 || b < a {
+			return nil, fmt.Errorf("invalid trace range %q", filter)
+		}
+		t.From, t.To = a, b
+		return t, nil
+	}
+	t.Func = filter
+	return t, nil
+}
+
+// takeProfileFlag removes --profile or --profile=file from os.Args and
+// returns the profiler it asks for, or nil.
+func takeProfileFlag() *vm.Profiler {
+	var p *vm.Profiler
+	args := os.Args[:1]
+	for _, arg := range os.Args[1:] {
+		switch {
+		case arg == "--profile":
+			p = vm.NewProfiler("")
+		case strings.HasPrefix(arg, "--profile="):
+			p = vm.NewProfiler(strings.TrimPrefix(arg, "--profile="))
+		default:
+			args = append(args, arg)
+		}
+	}
+	os.Args = args
+	return p
+}
+
+// parseBytes reads sizes like 64MB, 512KB or 1048576.
+func parseBytes(s string) (int, error) {
+	units := []struct {
+		suffix string
+		size   int
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	size := 1
+	for _, u := range units {
+		if strings.HasSuffix(upper, u.suffix) {
+			upper, size = strings.TrimSuffix(upper, u.suffix), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil || n <= 0 || n*float64(size) > math.MaxInt64/2 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int(n * float64(size)), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// fuzzTarget is a Go fuzz test, seeded from tests/*.ll, that fuzz runs with
+// go test.
+type fuzzTarget struct {
+	name, pkg, fn string
+}
+
+var fuzzTargets = []fuzzTarget{
+	{"parse", "lightlang/parser", "FuzzParse"},
+	{"bytecode", "lightlang/bytecode", "FuzzReadBytecode"},
+	{"run", "lightlang/vm", "FuzzCompileRun"},
+}
+
+type fuzzOptions struct {
+	duration time.Duration
+	targets  []fuzzTarget
+}
+
+func parseFuzzArgs(args []string) (fuzzOptions, error) {
+	opts := fuzzOptions{duration: 10 * time.Second, targets: fuzzTargets}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-time", "-target":
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			if arg == "-time" {
+				d, err := time.ParseDuration(args[i])
+				if err != nil || d <= 0 {
+					return opts, fmt.Errorf("invalid duration %q", args[i])
+				}
+				opts.duration = d
+				continue
+			}
+			opts.targets = nil
+			for _, t := range fuzzTargets {
+				if t.name == args[i] {
+					opts.targets = []fuzzTarget{t}
+				}
+			}
+			if opts.targets == nil {
+				return opts, fmt.Errorf("unknown target %q, want parse, bytecode or run", args[i])
+			}
+		default:
+			return opts, fmt.Errorf("unknown argument %q", arg)
+		}
+	}
+	return opts, nil
+}
+
+// fuzzCommand runs the fuzz targets one after another with go test, each
+// for the whole duration. It needs the go command and the lightlang source,
+// where go test saves failing inputs under testdata/fuzz.
+func fuzzCommand(args []string) int {
+	opts, err := parseFuzzArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fmt.Fprintln(os.Stderr, "Nope, do it like this: lightlang fuzz [-time 10s] [-target parse|bytecode|run]")
+		return 2
+	}
+	for _, t := range opts.targets {
+		fmt.Printf("fuzzing %s for %s\n", t.name, opts.duration)
+		cmd := exec.Command("go", "test", "-run=^$", "-fuzz=^"+t.fn+"$", "-fuzztime="+opts.duration.String(), t.pkg)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			fmt.Printf("FAIL %s\n", t.name)
+			return 1
+		}
+	}
+	fmt.Printf("PASS: %d targets, no failures\n", len(opts.targets))
+	return 0
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lightlang/builtins"
+	"lightlang/compiler"
+	"lightlang/parser"
+	"lightlang/vm"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// jupyterProtocol is the version of the Jupyter messaging protocol the
+// kernel speaks.
+const jupyterProtocol = "5.3"
+
+const jupyterDelimiter = "<IDS|MSG>"
+
+// connectionInfo is the connection file Jupyter starts a kernel with.
+type connectionInfo struct {
+	Transport       string `json:"transport"`
+	IP              string `json:"ip"`
+	ShellPort       int    `json:"shell_port"`
+	IOPubPort       int    `json:"iopub_port"`
+	StdinPort       int    `json:"stdin_port"`
+	ControlPort     int    `json:"control_port"`
+	HBPort          int    `json:"hb_port"`
+	Key             string `json:"key"`
+	SignatureScheme string `json:"signature_scheme"`
+}
+
+type jupyterMessage struct {
+	identities   [][]byte
+	Header       map[string]interface{}
+	ParentHeader map[string]interface{}
+	Metadata     map[string]interface{}
+	Content      map[string]interface{}
+}
+
+type kernel struct {
+	key     []byte
+	session string
+	iopub   *zmqSocket
+	repl    *repl
+	count   int
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (k *kernel) sign(parts ...[]byte) []byte {
+	if len(k.key) == 0 {
+		return nil
+	}
+	mac := hmac.New(sha256.New, k.key)
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	return []byte(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// parse reads the frames of a message, checking its signature.
+func (k *kernel) parse(frames [][]byte) (*jupyterMessage, error) {
+	i := 0
+	for i < len(frames) && string(frames[i]) != jupyterDelimiter {
+		i++
+	}
+	if len(frames) < i+6 {
+		return nil, fmt.Errorf("message without the Jupyter frames")
+	}
+	parts := frames[i+2 : i+6]
+	if len(k.key) > 0 && !hmac.Equal(frames[i+1], k.sign(parts...)) {
+		return nil, fmt.Errorf("message with a bad signature")
+	}
+	msg := &jupyterMessage{identities: frames[:i]}
+	for j, dst := range []*map[string]interface{}{&msg.Header, &msg.ParentHeader, &msg.Metadata, &msg.Content} {
+		if err := json.Unmarshal(parts[j], dst); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// frames makes the frames of a message of type msgType answering parent.
+func (k *kernel) frames(identities [][]byte, msgType string, parent *jupyterMessage, content map[string]interface{}) [][]byte {
+	header, _ := json.Marshal(map[string]interface{}{
+		"msg_id":   newID(),
+		"session":  k.session,
+		"username": "lightlang",
+		"date":     time.Now().UTC().Format(time.RFC3339Nano),
+		"msg_type": msgType,
+		"version":  jupyterProtocol,
+	})
+	parentHeader := []byte("{}")
+	if parent != nil {
+		parentHeader, _ = json.Marshal(parent.Header)
+	}
+	body, err := json.Marshal(content)
+	if err != nil {
+		body, _ = json.Marshal(map[string]interface{}{"status": "error", "ename": "Error", "evalue": err.Error(), "traceback": []string{}})
+	}
+	parts := [][]byte{header, parentHeader, []byte("{}"), body}
+	out := append([][]byte{}, identities...)
+	out = append(out, []byte(jupyterDelimiter), k.sign(parts...))
+	return append(out, parts...)
+}
+
+func (k *kernel) reply(conn *zmqConn, msgType string, parent *jupyterMessage, content map[string]interface{}) {
+	conn.send(k.frames(parent.identities, msgType, parent, content))
+}
+
+func (k *kernel) publish(msgType string, parent *jupyterMessage, content map[string]interface{}) {
+	topic := []byte("kernel." + k.session + "." + msgType)
+	k.iopub.publish(k.frames([][]byte{topic}, msgType, parent, content))
+}
+
+// streamWriter publishes what a cell prints as stream messages, in pieces
+// of a few kilobytes so a loop that prints does not send a message a line.
+type streamWriter struct {
+	k      *kernel
+	name   string
+	parent *jupyterMessage
+	buf    bytes.Buffer
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	if w.buf.Len() >= 4096 {
+		w.flush()
+	}
+	return len(p), nil
+}
+
+func (w *streamWriter) flush() {
+	if w.buf.Len() == 0 || w.parent == nil {
+		w.buf.Reset()
+		return
+	}
+	w.k.publish("stream", w.parent, map[string]interface{}{"name": w.name, "text": w.buf.String()})
+	w.buf.Reset()
+}
+
+func (k *kernel) kernelInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"status":                 "ok",
+		"protocol_version":       jupyterProtocol,
+		"implementation":         "lightlang",
+		"implementation_version": "1.0",
+		"banner":                 "lightlang",
+		"help_links":             []interface{}{},
+		"language_info": map[string]interface{}{
+			"name":           "lightlang",
+			"version":        "1.0",
+			"mimetype":       "text/x-lightlang",
+			"file_extension": ".ll",
+		},
+	}
+}
+
+// errorContent describes err for error messages and execute replies, with
+// the diagnostic, source line and all, as traceback.
+func errorContent(err error, src string) map[string]interface{} {
+	ename, evalue := "Error", err.Error()
+	var se *parser.SourceError
+	if errors.As(err, &se) {
+		ename, evalue = se.Kind, se.Err.Error()
+	}
+	var diag bytes.Buffer
+	printDiagnostics(&diag, err, func(string) string { return src })
+	return map[string]interface{}{
+		"ename":     ename,
+		"evalue":    evalue,
+		"traceback": strings.Split(strings.TrimRight(diag.String(), "\n"), "\n"),
+	}
+}
+
+func (k *kernel) execute(conn *zmqConn, msg *jupyterMessage, stdout, stderr *streamWriter) {
+	src, _ := msg.Content["code"].(string)
+	silent, _ := msg.Content["silent"].(bool)
+	if !silent {
+		k.count++
+		k.publish("execute_input", msg, map[string]interface{}{"code": src, "execution_count": k.count})
+	}
+	stdout.parent, stderr.parent = msg, msg
+
+	ctx, cancel := context.WithCancel(context.Background())
+	k.mu.Lock()
+	k.cancel = cancel
+	k.mu.Unlock()
+	val, err := k.repl.evaluate(ctx, src)
+	k.mu.Lock()
+	k.cancel = nil
+	k.mu.Unlock()
+	cancel()
+	stdout.flush()
+	stderr.flush()
+
+	if err != nil {
+		var exit *builtins.ExitError
+		if errors.As(err, &exit) {
+			err = fmt.Errorf("the program called exit(%d)", exit.Code)
+		}
+		content := errorContent(err, src)
+		if errors.Is(err, context.Canceled) {
+			content = map[string]interface{}{"ename": "Interrupted", "evalue": "interrupted", "traceback": []string{"interrupted"}}
+		}
+		if !silent {
+			k.publish("error", msg, content)
+		}
+		content["status"] = "error"
+		content["execution_count"] = k.count
+		k.reply(conn, "execute_reply", msg, content)
+		return
+	}
+	if val.Kind != vm.KindNil && !silent {
+		data := map[string]interface{}{"text/plain": val.String()}
+		switch val.Interface().(type) {
+		case []interface{}, map[string]interface{}:
+			if b, err := json.Marshal(val.Interface()); err == nil {
+				data["application/json"] = json.RawMessage(b)
+			}
+		}
+		k.publish("execute_result", msg, map[string]interface{}{
+			"execution_count": k.count,
+			"data":            data,
+			"metadata":        map[string]interface{}{},
+		})
+	}
+	k.reply(conn, "execute_reply", msg, map[string]interface{}{
+		"status":           "ok",
+		"execution_count":  k.count,
+		"user_expressions": map[string]interface{}{},
+		"payload":          []interface{}{},
+	})
+}
+
+// complete answers a complete_request, whose cursor_pos counts code
+// points, with the names the repl would offer.
+func (k *kernel) complete(conn *zmqConn, msg *jupyterMessage) {
+	code, _ := msg.Content["code"].(string)
+	cursor := len(code)
+	if pos, ok := msg.Content["cursor_pos"].(float64); ok {
+		cursor = 0
+		for n := 0; n < int(pos) && cursor < len(code); n++ {
+			_, size := utf8.DecodeRuneInString(code[cursor:])
+			cursor += size
+		}
+	}
+	start, names := k.repl.complete(code, cursor)
+	if names == nil {
+		names = []string{}
+	}
+	k.reply(conn, "complete_reply", msg, map[string]interface{}{
+		"status":       "ok",
+		"matches":      names,
+		"cursor_start": utf8.RuneCountInString(code[:start]),
+		"cursor_end":   utf8.RuneCountInString(code[:cursor]),
+		"metadata":     map[string]interface{}{},
+	})
+}
+
+// handle answers a message from the shell or control socket. It reports
+// false once the kernel is asked to shut down.
+func (k *kernel) handle(m zmqMessage, stdout, stderr *streamWriter) bool {
+	msg, err := k.parse(m.frames)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kernel: %v\n", err)
+		return true
+	}
+	msgType, _ := msg.Header["msg_type"].(string)
+	k.publish("status", msg, map[string]interface{}{"execution_state": "busy"})
+	defer k.publish("status", msg, map[string]interface{}{"execution_state": "idle"})
+	switch msgType {
+	case "kernel_info_request":
+		k.reply(m.conn, "kernel_info_reply", msg, k.kernelInfo())
+	case "execute_request":
+		k.execute(m.conn, msg, stdout, stderr)
+	case "complete_request":
+		k.complete(m.conn, msg)
+	case "is_complete_request":
+		code, _ := msg.Content["code"].(string)
+		status := "complete"
+		if needsContinuation(code) {
+			status = "incomplete"
+		}
+		k.reply(m.conn, "is_complete_reply", msg, map[string]interface{}{"status": status, "indent": ""})
+	case "inspect_request":
+		k.reply(m.conn, "inspect_reply", msg, map[string]interface{}{"status": "ok", "found": false, "data": map[string]interface{}{}, "metadata": map[string]interface{}{}})
+	case "history_request":
+		k.reply(m.conn, "history_reply", msg, map[string]interface{}{"status": "ok", "history": []interface{}{}})
+	case "comm_info_request":
+		k.reply(m.conn, "comm_info_reply", msg, map[string]interface{}{"status": "ok", "comms": map[string]interface{}{}})
+	case "interrupt_request":
+		k.interrupt()
+		k.reply(m.conn, "interrupt_reply", msg, map[string]interface{}{"status": "ok"})
+	case "shutdown_request":
+		restart, _ := msg.Content["restart"].(bool)
+		k.reply(m.conn, "shutdown_reply", msg, map[string]interface{}{"status": "ok", "restart": restart})
+		return false
+	}
+	return true
+}
+
+// interrupt stops the cell that is running, if any.
+func (k *kernel) interrupt() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.cancel != nil {
+		k.cancel()
+	}
+}
+
+func runKernel(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var info connectionInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return fmt.Errorf("invalid connection file: %v", err)
+	}
+	if info.Transport != "" && info.Transport != "tcp" {
+		return fmt.Errorf("transport %q is not supported, only tcp", info.Transport)
+	}
+	if info.SignatureScheme != "" && info.SignatureScheme != "hmac-sha256" {
+		return fmt.Errorf("signature scheme %q is not supported, only hmac-sha256", info.SignatureScheme)
+	}
+	addr := func(port int) string { return fmt.Sprintf("%s:%d", info.IP, port) }
+	var shell, control, stdin, hb *zmqSocket
+	k := &kernel{key: []byte(info.Key), session: newID(), repl: &repl{builder: compiler.NewBuilder(), vm: vm.NewVM()}}
+	for _, s := range []struct {
+		kind string
+		port int
+		dst  **zmqSocket
+	}{{"ROUTER", info.ShellPort, &shell}, {"ROUTER", info.ControlPort, &control}, {"ROUTER", info.StdinPort, &stdin}, {"PUB", info.IOPubPort, &k.iopub}, {"REP", info.HBPort, &hb}} {
+		sock, err := listenZMQ(s.kind, addr(s.port))
+		if err != nil {
+			return err
+		}
+		defer sock.Close()
+		*s.dst = sock
+	}
+
+	stdout := &streamWriter{k: k, name: "stdout"}
+	stderr := &streamWriter{k: k, name: "stderr"}
+	k.repl.vm.Stdout, k.repl.vm.Stderr = stdout, stderr
+	k.repl.vm.Stdin = strings.NewReader("")
+	useColor = os.Getenv("NO_COLOR") == ""
+
+	// Jupyter interrupts a kernel with SIGINT unless its spec asks for
+	// interrupt_request messages
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for range interrupts {
+			k.interrupt()
+		}
+	}()
+	// control messages are answered while a cell runs, so it can be
+	// interrupted or the kernel shut down
+	done := make(chan struct{})
+	go func() {
+		for m := range control.messages {
+			if !k.handleControl(m) {
+				close(done)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case m := <-shell.messages:
+			if !k.handle(m, stdout, stderr) {
+				return nil
+			}
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// handleControl answers a control message; only those about interrupting
+// and shutting down are not queued behind the running cell.
+func (k *kernel) handleControl(m zmqMessage) bool {
+	msg, err := k.parse(m.frames)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kernel: %v\n", err)
+		return true
+	}
+	switch msg.Header["msg_type"] {
+	case "interrupt_request":
+		k.interrupt()
+		k.reply(m.conn, "interrupt_reply", msg, map[string]interface{}{"status": "ok"})
+	case "shutdown_request":
+		k.interrupt()
+		restart, _ := msg.Content["restart"].(bool)
+		k.reply(m.conn, "shutdown_reply", msg, map[string]interface{}{"status": "ok", "restart": restart})
+		return false
+	case "kernel_info_request":
+		k.reply(m.conn, "kernel_info_reply", msg, k.kernelInfo())
+	}
+	return true
+}
+
+// kernelSpecDir is where Jupyter looks for the kernels of the user.
+func kernelSpecDir() (string, error) {
+	if dir := os.Getenv("JUPYTER_DATA_DIR"); dir != "" {
+		return filepath.Join(dir, "kernels"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(home, "Library", "Jupyter", "kernels"), nil
+	case "windows":
+		return filepath.Join(os.Getenv("APPDATA"), "jupyter", "kernels"), nil
+	}
+	return filepath.Join(home, ".local", "share", "jupyter", "kernels"), nil
+}
+
+// installKernel writes the kernel spec that makes Jupyter start this
+// executable for lightlang notebooks.
+func installKernel() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	dir, err := kernelSpecDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "lightlang")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	spec, _ := json.MarshalIndent(map[string]interface{}{
+		"argv":           []string{exe, "kernel", "{connection_file}"},
+		"display_name":   "lightlang",
+		"language":       "lightlang",
+		"interrupt_mode": "signal",
+	}, "", "  ")
+	return dir, os.WriteFile(filepath.Join(dir, "kernel.json"), spec, 0644)
+}
+
+func kernelCommand(args []string) int {
+	switch {
+	case len(args) == 1 && args[0] == "install":
+		dir, err := installKernel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("installed the lightlang kernel in %s\n", dir)
+		return 0
+	case len(args) == 1:
+		if err := runKernel(args[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintln(os.Stderr, "Nope, do it like this: lightlang kernel <connection file> or lightlang kernel install")
+	return 2
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"lightlang/term"
+	"os"
+	"strings"
+)
+
+const maxHistory = 1000
+
+var errInterrupt = errors.New("interrupted")
+
+type lineEditor struct {
+	in          *bufio.Reader
+	out         io.Writer
+	fd          int
+	raw         bool
+	history     []string
+	historyFile string
+	// complete, when set, gives where the word before pos starts and what
+	// it could be completed to, for tab.
+	complete func(line string, pos int) (int, []string)
+}
+
+func newLineEditor(historyFile string) *lineEditor {
+	e := &lineEditor{
+		in:          bufio.NewReader(os.Stdin),
+		out:         os.Stdout,
+		fd:          int(os.Stdin.Fd()),
+		historyFile: historyFile,
+	}
+	e.raw = term.IsTerminal(e.fd) && term.IsTerminal(int(os.Stdout.Fd()))
+	e.loadHistory()
+	return e
+}
+
+func (e *lineEditor) loadHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	data, err := os.ReadFile(e.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+}
+
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+	if e.historyFile != "" {
+		if f, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600); err == nil {
+			fmt.Fprintln(f, line)
+			f.Close()
+		}
+	}
+}
+
+// readLine returns io.EOF on Ctrl-D and errInterrupt on Ctrl-C.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if !e.raw {
+		fmt.Fprint(e.out, prompt)
+		line, err := e.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	state, err := term.MakeRaw(e.fd)
+	if err != nil {
+		e.raw = false
+		return e.readLine(prompt)
+	}
+	defer term.Restore(e.fd, state)
+
+	line, err := e.edit(prompt)
+	fmt.Fprint(e.out, "\n")
+	if err == nil {
+		e.addHistory(line)
+	}
+	return line, err
+}
+
+func (e *lineEditor) refresh(prompt string, buf []rune, pos int) {
+	fmt.Fprintf(e.out, "\r\x1b[K%s%s", prompt, string(buf))
+	if back := len(buf) - pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+func (e *lineEditor) edit(prompt string) (string, error) {
+	var buf []rune
+	pos := 0
+	histIdx := len(e.history)
+	saved := ""
+
+	setLine := func(s string) {
+		buf = []rune(s)
+		pos = len(buf)
+	}
+
+	e.refresh(prompt, buf, pos)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			return string(buf), nil
+		case 3: // ctrl-c
+			fmt.Fprint(e.out, "^C")
+			return "", errInterrupt
+		case 4: // ctrl-d
+			if len(buf) == 0 {
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 127, 8: // backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 1: // ctrl-a
+			pos = 0
+		case 5: // ctrl-e
+			pos = len(buf)
+		case 2: // ctrl-b
+			if pos > 0 {
+				pos--
+			}
+		case 6: // ctrl-f
+			if pos < len(buf) {
+				pos++
+			}
+		case 11: // ctrl-k
+			buf = buf[:pos]
+		case 21: // ctrl-u
+			buf = buf[pos:]
+			pos = 0
+		case 23: // ctrl-w
+			start := pos
+			for start > 0 && buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && buf[start-1] != ' ' {
+				start--
+			}
+			buf = append(buf[:start], buf[pos:]...)
+			pos = start
+		case 12: // ctrl-l
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 18: // ctrl-r
+			line, accept, err := e.search(prompt)
+			if err != nil {
+				return "", err
+			}
+			if accept {
+				return line, nil
+			}
+			setLine(line)
+		case '\t':
+			if e.completeWord(prompt, &buf, &pos) {
+				break
+			}
+			buf = append(buf[:pos], append([]rune("  "), buf[pos:]...)...)
+			pos += 2
+		case 27: // escape sequences
+			seq := e.readEscape()
+			switch seq {
+			case "[A", "OA":
+				if histIdx > 0 {
+					if histIdx == len(e.history) {
+						saved = string(buf)
+					}
+					histIdx--
+					setLine(e.history[histIdx])
+				}
+			case "[B", "OB":
+				if histIdx < len(e.history) {
+					histIdx++
+					if histIdx == len(e.history) {
+						setLine(saved)
+					} else {
+						setLine(e.history[histIdx])
+					}
+				}
+			case "[C", "OC":
+				if pos < len(buf) {
+					pos++
+				}
+			case "[D", "OD":
+				if pos > 0 {
+					pos--
+				}
+			case "[H", "OH", "[1~", "[7~":
+				pos = 0
+			case "[F", "OF", "[4~", "[8~":
+				pos = len(buf)
+			case "[3~":
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if r >= 32 {
+				buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+				pos++
+			}
+		}
+		e.refresh(prompt, buf, pos)
+	}
+}
+
+// completeWord completes the word before pos as far as its candidates
+// agree, listing them when that adds nothing. It reports false when there
+// is no word to complete, so tab indents instead.
+func (e *lineEditor) completeWord(prompt string, buf *[]rune, pos *int) bool {
+	if e.complete == nil {
+		return false
+	}
+	before := string((*buf)[:*pos])
+	start, names := e.complete(before, len(before))
+	if start == len(before) {
+		return false
+	}
+	if len(names) == 0 {
+		fmt.Fprint(e.out, "\a")
+		return true
+	}
+	word := before[start:]
+	if prefix := commonPrefix(names); len(prefix) > len(word) {
+		rest := []rune(prefix[len(word):])
+		*buf = append((*buf)[:*pos], append(rest, (*buf)[*pos:]...)...)
+		*pos += len(rest)
+		return true
+	}
+	fmt.Fprint(e.out, "\r\n"+strings.Join(names, "  ")+"\r\n")
+	return true
+}
+
+func (e *lineEditor) readEscape() string {
+	first, _, err := e.in.ReadRune()
+	if err != nil {
+		return ""
+	}
+	seq := string(first)
+	if first != '[' && first != 'O' {
+		return seq
+	}
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return seq
+		}
+		seq += string(r)
+		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || r == '~' {
+			return seq
+		}
+	}
+}
+
+// search implements ctrl-r reverse incremental search over the history.
+// accept reports whether enter was pressed on a match.
+func (e *lineEditor) search(prompt string) (string, bool, error) {
+	query := ""
+	idx := len(e.history)
+	match := ""
+
+	find := func(from int) {
+		for i := from; i >= 0; i-- {
+			if strings.Contains(e.history[i], query) {
+				idx = i
+				match = e.history[i]
+				return
+			}
+		}
+	}
+
+	for {
+		fmt.Fprintf(e.out, "\r\x1b[K(reverse-i-search)`%s': %s", query, match)
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", false, err
+		}
+		switch r {
+		case '\r', '\n':
+			return match, true, nil
+		case 3, 7: // ctrl-c, ctrl-g
+			return "", false, nil
+		case 18:
+			if idx > 0 {
+				find(idx - 1)
+			}
+		case 127, 8:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				idx = len(e.history)
+				match = ""
+				find(idx - 1)
+			}
+		case 27:
+			e.readEscape()
+			return match, false, nil
+		default:
+			if r >= 32 {
+				query += string(r)
+				find(min(idx, len(e.history)-1))
+			}
+		}
+	}
+}
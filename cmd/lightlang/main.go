@@ -0,0 +1,611 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"lightlang/compiler"
+	"lightlang/parser"
+	"lightlang/vm"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func compileSource(file string, source string) ([]bytecode.Instruction, []bytecode.Constant, error) {
+	builder, err := compiler.Compile(file, source)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := reportWarnings(builder.Warnings, readSource); err != nil {
+		return nil, nil, err
+	}
+	instructions, constants := builder.Bytecode()
+	instructions, constants = compiler.OptimizeBytecode(instructions, constants, builder.SymbolTable)
+	return instructions, constants, nil
+}
+
+// loadProgram compiles a .ll file or loads a .llbytecode file.
+func loadProgram(target string) ([]bytecode.Instruction, []bytecode.Constant, error) {
+	if !strings.HasSuffix(target, ".ll") {
+		instructions, constants, err := compiler.LoadBytecode(target)
+		if err == nil {
+			err = bytecode.VerifyProgram(instructions, constants)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error loading bytecode: %v", err)
+		}
+		return instructions, constants, nil
+	}
+	content, err := os.ReadFile(target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	return compileSource(target, string(content))
+}
+
+func buildCommand(source string, output string, strip bool) int {
+	content, err := os.ReadFile(source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading source file: %v\n", err)
+		return 1
+	}
+
+	instructions, constants, err := compileSource(source, string(content))
+	if err != nil {
+		printError(err)
+		return 1
+	}
+
+	if strip {
+		instructions, constants = bytecode.StripDebugInfo(instructions, constants)
+	}
+	err = bytecode.SaveBytecode(output, instructions, constants)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing bytecode file: %v\n", err)
+		return 1
+	}
+	if err := bytecode.WriteSourceMap(output, bytecode.NewSourceMap(instructions, source), strip); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing source map: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Successfully built '%s' -> '%s'\n", source, output)
+	return 0
+}
+
+func asmCommand(source string, output string, fromJSON bool) int {
+	content, err := os.ReadFile(source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading source file: %v\n", err)
+		return 1
+	}
+
+	var instructions []bytecode.Instruction
+	var constants []bytecode.Constant
+	if fromJSON {
+		instructions, constants, err = bytecode.UnmarshalProgram(content)
+	} else {
+		instructions, constants, err = bytecode.Assemble(string(content))
+	}
+	if err == nil {
+		err = bytecode.VerifyProgram(instructions, constants)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Assembly Error: %v\n", err)
+		return 1
+	}
+
+	if err := bytecode.SaveBytecode(output, instructions, constants); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing bytecode file: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Successfully assembled '%s' -> '%s'\n", source, output)
+	return 0
+}
+
+func checkCommand(paths []string) int {
+	files, err := findFiles(paths, ".ll")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	status := 0
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading source file: %v\n", err)
+			status = 1
+			continue
+		}
+		builder, err := compiler.Compile(file, string(content))
+		if err != nil {
+			printError(err)
+			status = 1
+			continue
+		}
+		if err := reportWarnings(builder.Warnings, readSource); err != nil {
+			printError(err)
+			status = 1
+		}
+	}
+	if status == 0 {
+		fmt.Printf("%d files ok\n", len(files))
+	}
+	return status
+}
+
+func lexCommand(source string) int {
+	content, err := os.ReadFile(source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading source file: %v\n", err)
+		return 1
+	}
+
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+	for _, tok := range parser.Tokenize(text) {
+		fmt.Printf("%4d:%-4d %-10s %q\n", tok.Line, tok.Col, tok.Type, tok.Value)
+	}
+	return 0
+}
+
+// runFile runs target with perms, which nil leaves unrestricted.
+func runFile(target string, perms *builtins.Permissions) int {
+	instructions, constants, err := loadProgram(target)
+	if err != nil {
+		printError(err)
+		return 1
+	}
+	prog := &vm.Program{Instructions: instructions, Constants: constants}
+	if prog.SourceMap, err = bytecode.ProgramSourceMap(target, instructions); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading source map: %v\n", err)
+	}
+	return runProgram(target, prog, perms)
+}
+
+// runProgram runs prog, which came from target, until it ends or is
+// interrupted and returns the exit code.
+func runProgram(target string, prog *vm.Program, perms *builtins.Permissions) int {
+	v := vm.NewVM()
+	v.Perms = perms
+	v.Files = bundled
+	if quiet {
+		v.Stdout = io.Discard
+	}
+	v.Load(prog)
+	v.Trace, v.Profile = tracer, profiler
+	if allocProfile {
+		v.Allocs = vm.NewAllocProfiler()
+	}
+	loadPlugins(v)
+	if debugListen != "" {
+		var err error
+		if v.Debug, err = vm.ListenDebugger(debugListen, target); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting debugger: %v\n", err)
+			return 1
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		for range interrupts {
+			// a program with its own on_signal("INT") handler keeps running
+			if !v.HandlesSignal(os.Interrupt) {
+				// A second Ctrl-C kills a program that is stuck in a builtin.
+				signal.Stop(interrupts)
+				cancel()
+				return
+			}
+		}
+	}()
+	stopPprof, err := startPprof()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	err = v.RunContext(ctx)
+	stopPprof()
+	if v.Profile != nil {
+		v.Profile.Finish(v)
+	}
+	if v.Allocs != nil {
+		v.Allocs.Report(v.Stderr, v)
+	}
+	if err != nil {
+		var exit *builtins.ExitError
+		if errors.As(err, &exit) {
+			return exit.Code
+		}
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "interrupted")
+			return 130
+		}
+		printError(parser.WrapError(err, target, "Runtime Error", parser.Pos{}))
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	if code, ok := runEmbedded(); ok {
+		os.Exit(code)
+	}
+	// what follows "--" goes to the script, even if it looks like our flags
+	var forward []string
+	if i := slices.Index(os.Args, "--"); i >= 0 {
+		forward = slices.Clone(os.Args[i+1:])
+		os.Args = os.Args[:i]
+	}
+	initColor(takeFlag("--no-color"))
+	werror = takeFlag("--werror")
+	quiet = takeFlag("--quiet")
+	compiler.Strict = takeFlag("--strict")
+	compiler.Inline = takeFlag("--inline")
+	vm.DefaultJIT = takeFlag("--jit")
+	profiler = takeProfileFlag()
+	allocProfile = takeFlag("--alloc-profile")
+	debugListen, _ = takeValueFlag("--debug-listen")
+	cpuProfile, _ = takeValueFlag("--cpuprofile")
+	memProfile, _ = takeValueFlag("--memprofile")
+	if list, ok := takeValueFlag("--plugin"); ok {
+		if err := openPlugins(strings.Split(list, ",")); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if list, ok := takeValueFlag("--pass"); ok {
+		if err := openPasses(strings.Split(list, ",")); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if depth, ok := takeValueFlag("--max-depth"); ok {
+		n, err := strconv.Atoi(depth)
+		if err != nil || n <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid --max-depth %q\n", depth)
+			os.Exit(2)
+		}
+		vm.DefaultMaxCallDepth = n
+	}
+	if size, ok := takeValueFlag("--stack-size"); ok {
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid --stack-size %q\n", size)
+			os.Exit(2)
+		}
+		vm.DefaultStackSize = n
+	}
+	if size, ok := takeValueFlag("--max-stack"); ok {
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid --max-stack %q\n", size)
+			os.Exit(2)
+		}
+		vm.DefaultMaxStack = n
+	}
+	if vm.DefaultMaxStack > 0 && vm.DefaultStackSize > vm.DefaultMaxStack {
+		vm.DefaultStackSize = vm.DefaultMaxStack
+	}
+	if steps, ok := takeValueFlag("--max-steps"); ok {
+		n, err := strconv.Atoi(steps)
+		if err != nil || n <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid --max-steps %q\n", steps)
+			os.Exit(2)
+		}
+		vm.DefaultMaxSteps = n
+	}
+	if limit, ok := takeValueFlag("--timeout"); ok {
+		d, err := time.ParseDuration(limit)
+		if err != nil || d <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid --timeout %q\n", limit)
+			os.Exit(2)
+		}
+		vm.DefaultTimeout = d
+	}
+	if limit, ok := takeValueFlag("--max-memory"); ok {
+		n, err := parseBytes(limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --max-memory %q\n", limit)
+			os.Exit(2)
+		}
+		vm.DefaultMaxMemory = n
+	}
+	if level, ok := takeValueFlag("--log-level"); ok {
+		l, err := builtins.ParseLogLevel(level)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --log-level %q\n", level)
+			os.Exit(2)
+		}
+		builtins.DefaultLogger.Level = l
+	}
+	if takeFlag("--log-json") {
+		builtins.DefaultLogger.JSON = true
+	}
+	compiler.KeepNames = debugListen != ""
+	perms := &builtins.Permissions{
+		Read:  takeGrantFlag("--allow-read"),
+		Write: takeGrantFlag("--allow-write"),
+		Net:   takeGrantFlag("--allow-net"),
+		Run:   takeFlag("--allow-run"),
+		Env:   takeFlag("--allow-env"),
+		Eval:  takeFlag("--allow-eval"),
+		FFI:   takeFlag("--allow-ffi"),
+	}
+	var err error
+	if tracer, err = takeTraceFlag(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	if len(os.Args) < 2 {
+		printHelp()
+		return
+	}
+
+	if len(os.Args) == 2 {
+		arg := os.Args[1]
+		if arg == "help" || arg == "--help" || arg == "-h" {
+			printHelp()
+			return
+		}
+		if arg == "repl" {
+			os.Exit(newREPL().run())
+		}
+		if arg == "test" {
+			os.Exit(testCommand(nil))
+		}
+		if arg == "bench" {
+			os.Exit(benchCommand(nil))
+		}
+		if arg == "fuzz" {
+			os.Exit(fuzzCommand(nil))
+		}
+
+		builtins.Args = append([]string{arg}, forward...)
+		os.Exit(runFile(arg, perms))
+	}
+
+	command := os.Args[1]
+
+	switch command {
+	case "build":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "Nope, do it like this: lightlang build <source.ll>")
+			os.Exit(2)
+		}
+		target, cross := takeValueFlag("--target")
+		emit, _ := takeValueFlag("--emit")
+		args := os.Args[2:]
+		force, strip, exe, native := false, false, cross, false
+		for len(args) > 0 && (args[0] == "-f" || args[0] == "--strip" || args[0] == "--exe" || args[0] == "--native") {
+			switch args[0] {
+			case "-f":
+				force = true
+			case "--strip":
+				strip = true
+			case "--native":
+				exe, native = true, true
+			default:
+				exe = true
+			}
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			fmt.Fprintln(os.Stderr, "Nope, do it like this: lightlang build [-f] [--strip] [--exe|--native] [--target os/arch] [--emit=go] <source.ll|dir> [output]")
+			os.Exit(2)
+		}
+		source := args[0]
+		switch emit {
+		case "", "bytecode":
+		case "go":
+			if exe {
+				fmt.Fprintln(os.Stderr, "Nope, --emit=go and --exe do not mix: lightlang build --emit=go <source.ll> [output.go]")
+				os.Exit(2)
+			}
+			output := strings.TrimSuffix(source, ".ll") + ".go"
+			if len(args) >= 2 {
+				output = args[1]
+			}
+			os.Exit(emitGoCommand(source, output))
+		default:
+			fmt.Fprintf(os.Stderr, "Nope, --emit is bytecode or go, not %q\n", emit)
+			os.Exit(2)
+		}
+		if info, err := os.Stat(source); err == nil && info.IsDir() {
+			if exe {
+				fmt.Fprintln(os.Stderr, "Nope, --exe builds a single file: lightlang build --exe <source.ll> [output]")
+				os.Exit(2)
+			}
+			output := ""
+			if len(args) >= 2 {
+				output = args[1]
+			}
+			os.Exit(buildDir(source, output, force, strip))
+		}
+		if exe {
+			output := ""
+			if len(args) >= 2 {
+				output = args[1]
+			}
+			os.Exit(exeCommand(source, output, target, strip, native))
+		}
+		output := strings.TrimSuffix(source, ".ll") + ".llbytecode"
+		if len(args) >= 2 {
+			output = args[1]
+		}
+		os.Exit(buildCommand(source, output, strip))
+
+	case "strip":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "Nope, do it like this: lightlang strip <file.llbytecode>")
+			os.Exit(2)
+		}
+		os.Exit(stripCommand(os.Args[2]))
+
+	case "upgrade":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "Nope, do it like this: lightlang upgrade <file.llbytecode>")
+			os.Exit(2)
+		}
+		os.Exit(upgradeCommand(os.Args[2]))
+
+	case "run":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "Nope, do it like this: lightlang run <file.ll|file.llbytecode>")
+			os.Exit(2)
+		}
+		builtins.Args = append(slices.Clone(os.Args[2:]), forward...)
+		os.Exit(runFile(os.Args[2], perms))
+
+	case "kernel":
+		os.Exit(kernelCommand(os.Args[2:]))
+	case "playground":
+		os.Exit(playgroundCommand(os.Args[2:]))
+
+	case "repl":
+		r := newREPL()
+		r.session, _ = takeValueFlag("--session")
+		os.Exit(r.run())
+
+	case "dis":
+		args := os.Args[2:]
+		asJSON := len(args) > 0 && args[0] == "--json"
+		if asJSON {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			fmt.Fprintln(os.Stderr, "Nope, do it like this: lightlang dis [--json] <file.ll|file.llbytecode>")
+			os.Exit(2)
+		}
+		instructions, constants, err := loadProgram(args[0])
+		if err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		if asJSON {
+			data, err := bytecode.MarshalProgram(instructions, constants)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		srcmap, err := bytecode.ProgramSourceMap(args[0], instructions)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading source map: %v\n", err)
+		}
+		bytecode.Disassemble(os.Stdout, instructions, constants, srcmap)
+
+	case "test":
+		os.Exit(testCommand(os.Args[2:]))
+
+	case "bench":
+		os.Exit(benchCommand(os.Args[2:]))
+
+	case "fuzz":
+		os.Exit(fuzzCommand(os.Args[2:]))
+
+	case "doc":
+		os.Exit(docCommand(os.Args[2:]))
+
+	case "check":
+		os.Exit(checkCommand(os.Args[2:]))
+
+	case "lex":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "Nope, do it like this: lightlang lex <file.ll>")
+			os.Exit(2)
+		}
+		os.Exit(lexCommand(os.Args[2]))
+
+	case "asm":
+		args := os.Args[2:]
+		fromJSON := len(args) > 0 && args[0] == "--json"
+		if fromJSON {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			fmt.Fprintln(os.Stderr, "Nope, do it like this: lightlang asm [--json] <file.llasm|file.json> [output.llbytecode]")
+			os.Exit(2)
+		}
+		source := args[0]
+		output := strings.TrimSuffix(strings.TrimSuffix(source, ".llasm"), ".json") + ".llbytecode"
+		if len(args) >= 2 {
+			output = args[1]
+		}
+		os.Exit(asmCommand(source, output, fromJSON))
+
+	default:
+		if strings.HasSuffix(command, ".ll") || strings.HasSuffix(command, ".llbytecode") {
+			builtins.Args = append(slices.Clone(os.Args[1:]), forward...)
+			os.Exit(runFile(command, perms))
+		}
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
+		printHelp()
+		os.Exit(2)
+	}
+}
+
+func printHelp() {
+	fmt.Println("lightlang is a lightweight language implemented in go; portable and simple;")
+	fmt.Println("lightlang build <file.ll>	Build bytecode from source")
+	fmt.Println("lightlang build [-f] <dir> [output]	Build every file in dir, or link them into output")
+	fmt.Println("lightlang build --strip ...	Build without debug info or source map")
+	fmt.Println("lightlang build --exe <file.ll> [output]	Build a standalone executable")
+	fmt.Println("lightlang build --native <file.ll> [output]	Build a standalone executable that runs with --jit")
+	fmt.Println("lightlang build --target os/arch ...	Build a standalone executable for another platform")
+	fmt.Println("lightlang build --emit=go <file.ll> [output.go]	Translate to a Go program that runs natively")
+	fmt.Println("lightlang strip <file.llbytecode>	Remove debug info from a bytecode file")
+	fmt.Println("lightlang upgrade <file.llbytecode>	Rewrite bytecode from an older release in the current format")
+	fmt.Println("lightlang run <file.ll> or <file.llbytecode>	Run source file directly or bytecode")
+	fmt.Println("  run denies file, network, command and environment access unless allowed:")
+	fmt.Println("  --allow-read[=paths] --allow-write[=paths] --allow-net[=hosts] --allow-run --allow-env --allow-eval --allow-ffi")
+	fmt.Println("lightlang <file.ll|file.llbytecode>	Run file directly")
+	fmt.Println("  arguments after the file, or after --, are passed to the script as os.args")
+	fmt.Println("--no-color	Disable colored diagnostics (also NO_COLOR)")
+	fmt.Println("--werror	Treat compiler warnings as errors")
+	fmt.Println("--quiet	Discard what the program prints")
+	fmt.Println("--strict	Reject names that are never assigned")
+	fmt.Println("--inline	Inline calls of small functions")
+	fmt.Println("--jit	Compile functions that only compute with numbers to native closures (experimental)")
+	fmt.Println("--profile[=file.folded]	Print time spent per opcode and function, optionally writing flamegraph stacks")
+	fmt.Println("--alloc-profile	Print the lines that allocate the most tables, arrays, strings and closures")
+	fmt.Println("--cpuprofile <file>	Write a Go CPU profile of the interpreter for go tool pprof")
+	fmt.Println("--memprofile <file>	Write a Go heap profile of the interpreter when the program ends")
+	fmt.Println("--max-depth <n>	Allow at most n nested calls (default 10000)")
+	fmt.Println("--stack-size <n>	Start the operand stack with room for n values (default 8192)")
+	fmt.Println("--max-stack <n>	Let the operand stack grow to at most n values (default unlimited)")
+	fmt.Println("--max-steps <n>	Stop a run after n instructions")
+	fmt.Println("--timeout <duration>	Stop a run after a time like 5s")
+	fmt.Println("--max-memory <size>	Limit the memory a program holds, like 64MB")
+	fmt.Println("--log-level <level>	Write log messages from debug, info (default), warn or error up")
+	fmt.Println("--log-json	Write log messages as JSON lines")
+	fmt.Println("--debug-listen <addr>	Accept a debugger on addr (e.g. :4711); attaching pauses the program")
+	fmt.Println("--plugin <file.so,...>	Load Go plugins that add builtins or host functions")
+	fmt.Println("--pass <file.so,...>	Load Go plugins that add compiler passes over the syntax tree or bytecode")
+	fmt.Println("--trace[=func|from-to]	Print each executed instruction, optionally only in one function or ip range")
+	fmt.Println("lightlang check [paths...]	Report every parse and type error without building")
+	fmt.Println("lightlang repl [--session file]	Start an interactive session, resuming and saving it in file")
+	fmt.Println("lightlang kernel install	Make lightlang available in Jupyter notebooks")
+	fmt.Println("lightlang playground [-addr host:port]	Serve a web page to write and run programs in")
+	fmt.Println("lightlang dis <file.ll|file.llbytecode>	Print a bytecode listing")
+	fmt.Println("lightlang asm <file.llasm>	Assemble a listing into bytecode")
+	fmt.Println("lightlang dis --json <file> / asm --json <file.json>	Export or import bytecode as JSON")
+	fmt.Println("lightlang lex <file.ll>	Print the token stream")
+	fmt.Println("lightlang doc [-html] [-o output] [paths...]	Generate docs from /// comments")
+	fmt.Println("lightlang test [-cover] [-coverhtml file] [--update] [paths...]	Run test_* functions in *_test.ll files, optionally reporting line coverage or rewriting snapshots")
+	fmt.Println("lightlang bench [-time 1s] [-save file] [-compare file] [paths...]	Run bench_* functions in *_bench.ll files")
+	fmt.Println("lightlang fuzz [-time 10s] [-target parse|bytecode|run]	Run the Go fuzz tests of the parser, the bytecode reader and compiling and running, looking for panics")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"lightlang/compiler"
+	"lightlang/parser"
+	"lightlang/vm"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed playground.html
+var playgroundPage []byte
+
+type playgroundOptions struct {
+	addr    string
+	steps   int
+	timeout time.Duration
+	memory  int
+	output  int
+}
+
+func parsePlaygroundArgs(args []string) (playgroundOptions, error) {
+	opts := playgroundOptions{addr: "localhost:8080", steps: 50_000_000, timeout: 5 * time.Second, memory: 64 << 20, output: 1 << 20}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if i+1 >= len(args) {
+			return opts, fmt.Errorf("%s needs a value", arg)
+		}
+		i++
+		var err error
+		switch arg {
+		case "-addr":
+			opts.addr = args[i]
+		case "-steps":
+			opts.steps, err = strconv.Atoi(args[i])
+			if err == nil && opts.steps <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "-timeout":
+			opts.timeout, err = time.ParseDuration(args[i])
+			if err == nil && opts.timeout <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "-memory":
+			opts.memory, err = parseBytes(args[i])
+		case "-output":
+			opts.output, err = parseBytes(args[i])
+		default:
+			return opts, fmt.Errorf("unknown option %s", arg)
+		}
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s %q", arg, args[i])
+		}
+	}
+	return opts, nil
+}
+
+// cappedBuffer keeps the first max bytes written to it and drops the rest.
+type cappedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.Buffer.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+type playgroundRequest struct {
+	Code  string `json:"code"`
+	Input string `json:"input"`
+}
+
+type playgroundResult struct {
+	Output      string  `json:"output"`
+	Error       string  `json:"error,omitempty"`
+	Warnings    string  `json:"warnings,omitempty"`
+	Disassembly string  `json:"disassembly"`
+	ExitCode    int     `json:"exit_code"`
+	Millis      float64 `json:"duration_ms"`
+}
+
+// diagnostics formats err, or a list of them, as the command line would
+// without color.
+func diagnostics(err error, src string) string {
+	var out bytes.Buffer
+	printDiagnostics(&out, err, func(string) string { return src })
+	return strings.TrimRight(out.String(), "\n")
+}
+
+// playgroundRun compiles and runs code in a VM that may not touch files,
+// the network or commands, within the limits of opts.
+func playgroundRun(ctx context.Context, opts playgroundOptions, req playgroundRequest) playgroundResult {
+	const file = "main.ll"
+	var res playgroundResult
+	builder, err := compiler.Compile(file, req.Code)
+	if err != nil {
+		res.Error, res.ExitCode = diagnostics(err, req.Code), 1
+		return res
+	}
+	if len(builder.Warnings) > 0 {
+		res.Warnings = diagnostics(builder.Warnings, req.Code)
+	}
+	instructions, constants := builder.Bytecode()
+	instructions, constants = compiler.OptimizeBytecode(instructions, constants, builder.SymbolTable)
+	srcmap := bytecode.NewSourceMap(instructions, file)
+	var dis bytes.Buffer
+	bytecode.Disassemble(&dis, instructions, constants, srcmap)
+	res.Disassembly = dis.String()
+
+	out := &cappedBuffer{max: opts.output}
+	v := vm.New(
+		vm.WithMaxSteps(opts.steps),
+		vm.WithTimeout(opts.timeout),
+		vm.WithMaxMemory(opts.memory),
+		vm.WithPermissions(&builtins.Permissions{}),
+		vm.WithStdout(out),
+		vm.WithStderr(out),
+		vm.WithStdin(strings.NewReader(req.Input)),
+	)
+	v.Load(&vm.Program{Instructions: instructions, Constants: constants, SourceMap: srcmap})
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+	start := time.Now()
+	err = v.RunContext(ctx)
+	res.Millis = float64(time.Since(start).Microseconds()) / 1000
+	res.Output = out.String()
+	if out.truncated {
+		res.Output += fmt.Sprintf("\n[output cut at %d bytes]\n", opts.output)
+	}
+	var exit *builtins.ExitError
+	switch {
+	case errors.As(err, &exit):
+		res.ExitCode = exit.Code
+	case err != nil:
+		res.Error, res.ExitCode = diagnostics(parser.WrapError(err, file, "Runtime Error", parser.Pos{}), req.Code), 1
+	}
+	return res
+}
+
+func playgroundHandler(opts playgroundOptions) http.Handler {
+	// runs beyond one per CPU wait, so a crowd cannot starve the machine
+	slots := make(chan struct{}, runtime.NumCPU())
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(playgroundPage)
+	})
+	mux.HandleFunc("POST /run", func(w http.ResponseWriter, r *http.Request) {
+		var req playgroundRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 256<<10)).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(playgroundRun(r.Context(), opts, req))
+	})
+	return mux
+}
+
+func playgroundCommand(args []string) int {
+	opts, err := parsePlaygroundArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fmt.Fprintln(os.Stderr, "Nope, do it like this: lightlang playground [-addr localhost:8080] [-steps n] [-timeout 5s] [-memory 64MB] [-output 1MB]")
+		return 2
+	}
+	useColor = false
+	parser.ReadInclude = func(string) ([]byte, error) {
+		return nil, fmt.Errorf("files cannot be included in the playground")
+	}
+	server := &http.Server{
+		Addr:              opts.addr,
+		Handler:           playgroundHandler(opts),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Printf("playground on http://%s (%d steps, %s and %d bytes of memory per run)\n", opts.addr, opts.steps, opts.timeout, opts.memory)
+	if err := server.ListenAndServe(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
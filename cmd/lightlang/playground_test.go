@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlaygroundTimeout(t *testing.T) {
+	opts := playgroundOptions{steps: 50_000_000, timeout: 200 * time.Millisecond, memory: 64 << 20, output: 1 << 20}
+	start := time.Now()
+	res := playgroundRun(context.Background(), opts, playgroundRequest{Code: "wait(30)\nprint(\"done\")\n"})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("run took %s with a timeout of %s", elapsed, opts.timeout)
+	}
+	if res.ExitCode == 0 || !strings.Contains(res.Error, "timed out") {
+		t.Fatalf("got exit code %d and error %q, want a timeout", res.ExitCode, res.Error)
+	}
+	if strings.Contains(res.Output, "done") {
+		t.Fatalf("program ran past its timeout: %q", res.Output)
+	}
+}
+
+func TestPlaygroundCancel(t *testing.T) {
+	opts := playgroundOptions{steps: 50_000_000, timeout: time.Minute, memory: 64 << 20, output: 1 << 20}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	res := playgroundRun(ctx, opts, playgroundRequest{Code: "wait(30)\n"})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("run took %s after its request was cancelled", elapsed)
+	}
+	if res.ExitCode == 0 {
+		t.Fatal("cancelled run succeeded")
+	}
+}
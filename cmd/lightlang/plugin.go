@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"lightlang/builtins"
+	"lightlang/compiler"
+	"lightlang/vm"
+	"plugin"
+)
+
+// hostPlugins are the Register functions of plugins that add host
+// functions to each VM.
+var hostPlugins []func(*vm.VM)
+
+// openPlugins opens the Go plugins given with --plugin, separated by
+// commas. A plugin's Register is either func(map[string]builtins.BuiltinFunc),
+// called right away with builtins.Builtins so the compiler knows the new
+// builtins too, or func(*vm.VM), called by loadPlugins for every VM to add
+// host functions with v.Register.
+func openPlugins(paths []string) error {
+	for _, path := range paths {
+		p, err := plugin.Open(path)
+		if err != nil {
+			return fmt.Errorf("loading plugin: %v", err)
+		}
+		sym, err := p.Lookup("Register")
+		if err != nil {
+			return fmt.Errorf("loading plugin %s: %v", path, err)
+		}
+		switch register := sym.(type) {
+		case func(map[string]builtins.BuiltinFunc):
+			register(builtins.Builtins)
+		case func(*vm.VM):
+			hostPlugins = append(hostPlugins, register)
+		default:
+			return fmt.Errorf("loading plugin %s: Register must be func(map[string]builtins.BuiltinFunc) or func(*vm.VM)", path)
+		}
+	}
+	return nil
+}
+
+// openPasses opens the Go plugins given with --pass, separated by commas.
+// Their Register is func(func(compiler.Pass)), called right away with
+// compiler.RegisterPass.
+func openPasses(paths []string) error {
+	for _, path := range paths {
+		p, err := plugin.Open(path)
+		if err != nil {
+			return fmt.Errorf("loading pass: %v", err)
+		}
+		sym, err := p.Lookup("Register")
+		if err != nil {
+			return fmt.Errorf("loading pass %s: %v", path, err)
+		}
+		register, ok := sym.(func(func(compiler.Pass)))
+		if !ok {
+			return fmt.Errorf("loading pass %s: Register must be func(func(compiler.Pass))", path)
+		}
+		register(compiler.RegisterPass)
+	}
+	return nil
+}
+
+func loadPlugins(v *vm.VM) {
+	for _, register := range hostPlugins {
+		register(v)
+	}
+}
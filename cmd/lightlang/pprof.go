@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// cpuProfile and memProfile are the files given to --cpuprofile and
+// --memprofile.
+var cpuProfile, memProfile string
+
+// startPprof starts the Go CPU profile if one was asked for. The returned
+// function stops it and writes the heap profile.
+func startPprof() (func(), error) {
+	var cpu *os.File
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create CPU profile: %v", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("cannot start CPU profile: %v", err)
+		}
+		cpu = f
+	}
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			cpu.Close()
+		}
+		if memProfile != "" {
+			if err := writeHeapProfile(memProfile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing memory profile: %v\n", err)
+			}
+		}
+	}, nil
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// a collection first so the profile shows what is still live
+	runtime.GC()
+	return pprof.WriteHeapProfile(f)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"lightlang/compiler"
+	"lightlang/parser"
+	"lightlang/vm"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	replPrompt         = ">>> "
+	replContinuePrompt = "... "
+)
+
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".lightlang_history")
+}
+
+// needsContinuation reports whether src has unclosed blocks or brackets.
+func needsContinuation(src string) bool {
+	depth := 0
+	brackets := 0
+	var quote byte
+	word := strings.Builder{}
+
+	flush := func() {
+		switch word.String() {
+		case "func", "if", "while", "for":
+			depth++
+		case "end":
+			depth--
+		}
+		word.Reset()
+	}
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			word.WriteByte(c)
+			continue
+		}
+		flush()
+		switch c {
+		case '"', '\'':
+			quote = c
+		case '-':
+			if i+1 < len(src) && src[i+1] == '-' {
+				for i < len(src) && src[i] != '\n' {
+					i++
+				}
+			}
+		case '(', '[', '{':
+			brackets++
+		case ')', ']', '}':
+			brackets--
+		}
+	}
+	flush()
+	return quote != 0 || depth > 0 || brackets > 0
+}
+
+type repl struct {
+	editor  *lineEditor
+	builder *compiler.Builder
+	vm      *vm.VM
+	// session, when set, is opened when the repl starts, if it exists,
+	// and saved when it exits.
+	session string
+}
+
+func newREPL() *repl {
+	r := &repl{
+		editor:  newLineEditor(historyPath()),
+		builder: compiler.NewBuilder(),
+		vm:      vm.NewVM(),
+	}
+	r.editor.complete = r.complete
+	return r
+}
+
+func (r *repl) eval(src string) error {
+	val, err := r.evaluate(context.Background(), src)
+	if err != nil {
+		return err
+	}
+	if val.Kind != vm.KindNil {
+		fmt.Println(val)
+	}
+	return nil
+}
+
+// evaluate runs src in the session until it ends or ctx is done, and
+// returns the value of its last statement when that is an expression, or
+// nil.
+func (r *repl) evaluate(ctx context.Context, src string) (vm.Value, error) {
+	nodes, err := parser.Parse(src)
+	if err != nil {
+		return vm.NilValue, parser.WrapError(err, "", "Parse Error", parser.Pos{})
+	}
+	if len(nodes) == 0 {
+		return vm.NilValue, nil
+	}
+
+	start := len(r.builder.Instructions)
+	for _, node := range nodes {
+		if err := compiler.TypeCheck(node, r.builder.SymbolTable); err != nil {
+			return vm.NilValue, parser.WrapError(err, "", "Type Error", parser.Pos{})
+		}
+	}
+
+	last, echo := nodes[len(nodes)-1].(*parser.ExprStmtNode)
+	if echo {
+		nodes = nodes[:len(nodes)-1]
+	}
+	for _, node := range nodes {
+		r.builder.EmitNode(node)
+	}
+	if echo {
+		r.builder.EmitNode(last.Expr)
+		r.builder.EmitName(bytecode.OpSetGlobal, "_")
+	}
+	r.builder.Emit(bytecode.OpHalt, 0)
+	reportWarnings(r.builder.Warnings, func(string) string { return src })
+	r.builder.Warnings = nil
+
+	r.vm.Globals["_"] = vm.NilValue
+	instructions, constants := r.builder.Bytecode()
+	r.vm.Load(&vm.Program{Instructions: instructions, Constants: constants})
+	// eval and load append to the program, so the builder picks up from
+	// what ran
+	defer func() {
+		r.builder.Instructions, r.builder.Constants = r.vm.Program.Instructions, r.vm.Program.Constants
+	}()
+	if err := r.vm.RunFromContext(ctx, start); err != nil {
+		var exit *builtins.ExitError
+		if errors.As(err, &exit) {
+			return vm.NilValue, err
+		}
+		return vm.NilValue, parser.WrapError(err, "", "Runtime Error", parser.Pos{})
+	}
+	if echo {
+		return r.vm.Globals["_"], nil
+	}
+	return vm.NilValue, nil
+}
+
+func (r *repl) read() (string, error) {
+	var lines []string
+	prompt := replPrompt
+	for {
+		line, err := r.editor.readLine(prompt)
+		if err != nil {
+			if err == io.EOF && len(lines) > 0 {
+				return strings.Join(lines, "\n"), nil
+			}
+			return "", err
+		}
+		lines = append(lines, line)
+		src := strings.Join(lines, "\n")
+		if !needsContinuation(src) {
+			return src, nil
+		}
+		prompt = replContinuePrompt
+	}
+}
+
+func (r *repl) run() int {
+	fmt.Println("lightlang repl - :help for help, :quit to exit")
+	if r.session != "" {
+		if err := r.open(r.session); err == nil {
+			fmt.Printf("opened %s\n", r.session)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	code := r.loop()
+	if r.session != "" {
+		if !r.saveSession(r.session) && code == 0 {
+			code = 1
+		}
+	}
+	return code
+}
+
+// saveSession saves the session to path and reports what went wrong.
+func (r *repl) saveSession(path string) bool {
+	warning, err := r.save(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return false
+	}
+	if warning != "" {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+	return true
+}
+
+func (r *repl) loop() int {
+	for {
+		src, err := r.read()
+		if err == errInterrupt {
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			return 0
+		}
+
+		line := strings.TrimSpace(src)
+		if cmd, path, ok := strings.Cut(line, " "); ok && (cmd == ":save" || cmd == ":open") {
+			path = strings.TrimSpace(path)
+			if cmd == ":save" {
+				if r.saveSession(path) {
+					fmt.Printf("saved to %s\n", path)
+				}
+			} else if err := r.open(path); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			continue
+		}
+		switch line {
+		case "":
+			continue
+		case ":quit", ":q":
+			return 0
+		case ":help":
+			fmt.Println(":help	Show this message")
+			fmt.Println(":quit	Exit the repl (or Ctrl-D)")
+			fmt.Println(":save <file>	Save the globals and functions of the session")
+			fmt.Println(":open <file>	Replace the session with one saved before")
+			fmt.Println("Blocks and unclosed brackets continue on the next line.")
+			fmt.Println("The value of the last expression is stored in _.")
+			fmt.Println("Tab completes names, and table keys after a dot.")
+			continue
+		}
+
+		if err := r.eval(src); err != nil {
+			var exit *builtins.ExitError
+			if errors.As(err, &exit) {
+				return exit.Code
+			}
+			printDiagnostics(os.Stderr, err, func(string) string { return src })
+		}
+	}
+}
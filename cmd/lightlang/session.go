@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"lightlang/bytecode"
+	"lightlang/compiler"
+	"lightlang/vm"
+	"math"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+// sessionVersion is written in session files and checked when opening
+// them.
+const sessionVersion = 1
+
+// sessionFile is a saved repl session: the program the session built,
+// which holds its functions, and its globals. Tables, arrays and functions
+// are wrapped as {"table": ...}, {"array": ...} and {"function": entry} so
+// they can be told apart from each other and from numbers that are not
+// finite, which are {"number": "NaN"}.
+type sessionFile struct {
+	Version int                    `json:"version"`
+	Program []byte                 `json:"program"`
+	Globals map[string]interface{} `json:"globals"`
+}
+
+func encodeSessionValue(val interface{}, seen map[uintptr]bool) (interface{}, error) {
+	switch v := val.(type) {
+	case nil, bool, string:
+		return v, nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return map[string]interface{}{"number": strconv.FormatFloat(v, 'g', -1, 64)}, nil
+		}
+		return v, nil
+	case []interface{}:
+		if cap(v) > 0 {
+			p := reflect.ValueOf(v).Pointer()
+			if seen[p] {
+				return nil, fmt.Errorf("it contains itself")
+			}
+			seen[p] = true
+			defer delete(seen, p)
+		}
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			var err error
+			if out[i], err = encodeSessionValue(e, seen); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]interface{}{"array": out}, nil
+	case map[string]interface{}:
+		if v["type"] == "function" {
+			entry, ok := bytecode.ArgInt(v["entry"])
+			if !ok {
+				return nil, fmt.Errorf("it is a function without an entry")
+			}
+			return map[string]interface{}{"function": entry}, nil
+		}
+		p := reflect.ValueOf(v).Pointer()
+		if seen[p] {
+			return nil, fmt.Errorf("it contains itself")
+		}
+		seen[p] = true
+		defer delete(seen, p)
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			var err error
+			if out[k], err = encodeSessionValue(e, seen); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]interface{}{"table": out}, nil
+	}
+	return nil, fmt.Errorf("%T values cannot be saved", val)
+}
+
+func decodeSessionValue(raw interface{}) (interface{}, error) {
+	wrapped, ok := raw.(map[string]interface{})
+	if !ok {
+		return raw, nil
+	}
+	if len(wrapped) == 1 {
+		switch {
+		case wrapped["array"] != nil:
+			items, ok := wrapped["array"].([]interface{})
+			if !ok {
+				break
+			}
+			out := make([]interface{}, len(items))
+			for i, e := range items {
+				var err error
+				if out[i], err = decodeSessionValue(e); err != nil {
+					return nil, err
+				}
+			}
+			return out, nil
+		case wrapped["table"] != nil:
+			fields, ok := wrapped["table"].(map[string]interface{})
+			if !ok {
+				break
+			}
+			out := make(map[string]interface{}, len(fields))
+			for k, e := range fields {
+				var err error
+				if out[k], err = decodeSessionValue(e); err != nil {
+					return nil, err
+				}
+			}
+			return out, nil
+		case wrapped["function"] != nil:
+			if entry, ok := wrapped["function"].(float64); ok {
+				return map[string]interface{}{"type": "function", "entry": int(entry)}, nil
+			}
+		case wrapped["number"] != nil:
+			if s, ok := wrapped["number"].(string); ok {
+				return strconv.ParseFloat(s, 64)
+			}
+		}
+	}
+	return nil, fmt.Errorf("invalid value in session file")
+}
+
+// save writes the program and globals of the session to path. Globals
+// holding values that cannot be saved, such as open files, are left out
+// and named in the returned warning.
+func (r *repl) save(path string) (string, error) {
+	var program bytes.Buffer
+	instructions, constants := r.builder.Bytecode()
+	if err := bytecode.NewBytecodeWriter(&program).WriteBytecode(instructions, constants); err != nil {
+		return "", fmt.Errorf("cannot save program: %v", err)
+	}
+	file := sessionFile{Version: sessionVersion, Program: program.Bytes(), Globals: make(map[string]interface{})}
+	var skipped []string
+	for name, val := range r.vm.Globals {
+		if name == "_" {
+			continue
+		}
+		enc, err := encodeSessionValue(val.Interface(), make(map[uintptr]bool))
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s (%v)", name, err))
+			continue
+		}
+		file.Globals[name] = enc
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	if len(skipped) == 0 {
+		return "", nil
+	}
+	slices.Sort(skipped)
+	return fmt.Sprintf("not saved: %v", skipped), nil
+}
+
+// open replaces the session with the one saved in path.
+func (r *repl) open(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file sessionFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("%s is not a session file: %v", path, err)
+	}
+	if file.Version != sessionVersion {
+		return fmt.Errorf("%s is a version %d session, want %d", path, file.Version, sessionVersion)
+	}
+	instructions, constants, err := compiler.ReadBytecode(bytecode.NewBytecodeReader(bytes.NewReader(file.Program)))
+	if err != nil {
+		return fmt.Errorf("cannot load program of %s: %v", path, err)
+	}
+	if err := bytecode.VerifyProgram(instructions, constants); err != nil {
+		return fmt.Errorf("cannot load program of %s: %v", path, err)
+	}
+	globals := make(map[string]vm.Value, len(file.Globals))
+	for name, raw := range file.Globals {
+		val, err := decodeSessionValue(raw)
+		if err != nil {
+			return fmt.Errorf("%s: global %s: %v", path, name, err)
+		}
+		globals[name] = vm.ValueOf(val)
+	}
+
+	builder := compiler.NewBuilder()
+	builder.Instructions, builder.Constants = instructions, constants
+	for name := range globals {
+		builder.SymbolTable.Define(name, false)
+	}
+	r.builder = builder
+	r.vm = vm.NewVM()
+	r.vm.Load(&vm.Program{Instructions: instructions, Constants: constants})
+	r.vm.Globals = globals
+	return nil
+}
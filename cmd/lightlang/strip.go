@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"lightlang/bytecode"
+	"lightlang/compiler"
+	"os"
+)
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// stripCommand rewrites a bytecode file without debug info. A .llmap next
+// to it is left alone so it can be kept aside to decode errors later.
+func stripCommand(path string) int {
+	instructions, constants, err := compiler.LoadBytecode(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading bytecode: %v\n", err)
+		return 1
+	}
+	before := fileSize(path)
+	instructions, constants = bytecode.StripDebugInfo(instructions, constants)
+	if err := bytecode.SaveBytecode(path, instructions, constants); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing bytecode file: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Stripped '%s' (%d -> %d bytes)\n", path, before, fileSize(path))
+	return 0
+}
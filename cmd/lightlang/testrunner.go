@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"lightlang/compiler"
+	"lightlang/parser"
+	"lightlang/vm"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// findFiles collects files ending in suffix from the given paths, walking
+// directories recursively.
+func findFiles(paths []string, suffix string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir() && strings.HasSuffix(p, suffix) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// loadScript compiles a file, runs its top level and returns the VM along
+// with the names of functions with the given prefix in definition order.
+// The lines that run are recorded in cover unless it is nil.
+func loadScript(path string, prefix string, cover *vm.Coverage) (*vm.VM, []string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading file: %v", err)
+	}
+	builder, err := compiler.Compile(path, string(content))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v := vm.NewVM()
+	instructions, constants := builder.Bytecode()
+	v.Load(&vm.Program{Instructions: instructions, Constants: constants})
+	if cover != nil {
+		cover.File = path
+		v.Cover = cover
+	}
+	if err := v.RunFrom(0); err != nil {
+		return nil, nil, parser.WrapError(err, path, "Runtime Error", parser.Pos{})
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, inst := range instructions {
+		name := bytecode.ConstName(constants, inst.Arg)
+		if inst.Op != bytecode.OpSetGlobal || !strings.HasPrefix(name, prefix) || seen[name] {
+			continue
+		}
+		if _, ok := v.Globals[name].Function(); ok {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return v, names, nil
+}
+
+func testCommand(args []string) int {
+	var cover *vm.Coverage
+	coverHTML := ""
+	update := false
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-cover":
+			cover = vm.NewCoverage()
+		case "-coverhtml":
+			if i+1 >= len(args) {
+				fmt.Fprintln(os.Stderr, "Nope, do it like this: lightlang test [-cover] [-coverhtml file] [--update] [paths...]")
+				return 2
+			}
+			i++
+			coverHTML = args[i]
+			cover = vm.NewCoverage()
+		case "-update", "--update":
+			update = true
+		default:
+			paths = append(paths, args[i])
+		}
+	}
+
+	files, err := findFiles(paths, "_test.ll")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Println("no test files found")
+		return 0
+	}
+
+	// failed counts the tests whose assertions failed and errored those
+	// that stopped with any other error
+	passed, failed, errored := 0, 0, 0
+	start := time.Now()
+	for _, file := range files {
+		fmt.Printf("=== %s\n", file)
+		v, tests, err := loadScript(file, "test_", cover)
+		if err != nil {
+			fmt.Printf("  ERROR %s\n       %v\n", file, err)
+			errored++
+			continue
+		}
+		snapshots := &builtins.Snapshots{Dir: filepath.Join(filepath.Dir(file), "testdata"), Update: update}
+		v.Snapshots = snapshots
+		for _, name := range tests {
+			t0 := time.Now()
+			_, err := v.Call(name)
+			elapsed := time.Since(t0)
+			if err != nil {
+				status := "ERROR"
+				var assertion *builtins.AssertionError
+				if errors.As(err, &assertion) {
+					status = "FAIL"
+					failed++
+				} else {
+					errored++
+				}
+				msg := parser.WrapError(err, file, "", parser.Pos{}).Error()
+				fmt.Printf("  %s %s (%s)\n       %s\n", status, name, vm.FormatDuration(elapsed), strings.ReplaceAll(msg, "\n", "\n       "))
+				continue
+			}
+			fmt.Printf("  PASS %s (%s)\n", name, vm.FormatDuration(elapsed))
+			passed++
+		}
+		if snapshots.Written > 0 {
+			fmt.Printf("  wrote %d snapshot(s) to %s\n", snapshots.Written, snapshots.Dir)
+		}
+	}
+
+	status := "PASS"
+	if failed+errored > 0 {
+		status = "FAIL"
+	}
+	counts := fmt.Sprintf("%d passed, %d failed", passed, failed)
+	if errored > 0 {
+		counts += fmt.Sprintf(", %d errored", errored)
+	}
+	fmt.Printf("%s: %s (%s)\n", status, counts, vm.FormatDuration(time.Since(start)))
+	if cover != nil {
+		cover.Report(os.Stdout)
+	}
+	if coverHTML != "" {
+		if err := vm.WriteCoverHTML(coverHTML, cover); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing coverage report: %v\n", err)
+			return 1
+		}
+		fmt.Printf("wrote coverage report to %s\n", coverHTML)
+	}
+	if failed+errored > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"lightlang/bytecode"
+	"lightlang/compiler"
+	"os"
+)
+
+// upgradeCommand rewrites a bytecode file written by an older release in
+// the current format.
+func upgradeCommand(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading bytecode: %v\n", err)
+		return 1
+	}
+	reader := bytecode.NewBytecodeReader(file)
+	instructions, constants, err := compiler.ReadBytecode(reader)
+	file.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading bytecode: %v\n", err)
+		return 1
+	}
+	if reader.Major == bytecode.VersionMajor && reader.Minor == bytecode.VersionMinor {
+		fmt.Printf("'%s' is already at version %d.%d\n", path, bytecode.VersionMajor, bytecode.VersionMinor)
+		return 0
+	}
+	if err := bytecode.SaveBytecode(path, instructions, constants); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing bytecode file: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Upgraded '%s' from %d.%d to %d.%d\n", path, reader.Major, reader.Minor, bytecode.VersionMajor, bytecode.VersionMinor)
+	return 0
+}
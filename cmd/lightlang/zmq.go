@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// This is the part of ZeroMQ that a Jupyter kernel needs: ZMTP 3.0 over
+// TCP with the NULL mechanism, and ROUTER, PUB and REP sockets that only
+// listen. A ROUTER answers on the connection a message came from rather
+// than routing by identity, which is all Jupyter asks of it.
+
+const (
+	zmqMore    = 0x01
+	zmqLong    = 0x02
+	zmqCommand = 0x04
+)
+
+// zmqMessage is the frames of one message and the connection it came on.
+type zmqMessage struct {
+	conn   *zmqConn
+	frames [][]byte
+}
+
+type zmqConn struct {
+	net.Conn
+	r  *bufio.Reader
+	mu sync.Mutex
+}
+
+type zmqSocket struct {
+	kind     string
+	listener net.Listener
+	mu       sync.Mutex
+	peers    map[*zmqConn]bool
+	// messages receives what ROUTER peers send.
+	messages chan zmqMessage
+}
+
+// listenZMQ starts a socket of kind "ROUTER", "PUB" or "REP" on addr.
+func listenZMQ(kind, addr string) (*zmqSocket, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &zmqSocket{kind: kind, listener: ln, peers: make(map[*zmqConn]bool), messages: make(chan zmqMessage, 16)}
+	go s.accept()
+	return s, nil
+}
+
+func (s *zmqSocket) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(&zmqConn{Conn: conn, r: bufio.NewReader(conn)})
+	}
+}
+
+func (s *zmqSocket) serve(c *zmqConn) {
+	defer c.Close()
+	if err := c.handshake(s.kind); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.peers[c] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.peers, c)
+		s.mu.Unlock()
+	}()
+	for {
+		frames, err := c.readMessage()
+		if err != nil {
+			return
+		}
+		switch s.kind {
+		case "ROUTER":
+			s.messages <- zmqMessage{conn: c, frames: frames}
+		case "REP":
+			// the heartbeat: the envelope and all go back as they came
+			if c.send(frames) != nil {
+				return
+			}
+		}
+		// what a PUB gets is subscriptions, and it sends everything
+	}
+}
+
+// publish sends a message to every peer of a PUB socket.
+func (s *zmqSocket) publish(frames [][]byte) {
+	s.mu.Lock()
+	peers := make([]*zmqConn, 0, len(s.peers))
+	for c := range s.peers {
+		peers = append(peers, c)
+	}
+	s.mu.Unlock()
+	for _, c := range peers {
+		c.send(frames)
+	}
+}
+
+func (s *zmqSocket) Close() error { return s.listener.Close() }
+
+func (c *zmqConn) handshake(kind string) error {
+	greeting := make([]byte, 64)
+	greeting[0], greeting[9] = 0xff, 0x7f
+	greeting[10], greeting[11] = 3, 0
+	copy(greeting[12:], "NULL")
+	if _, err := c.Write(greeting); err != nil {
+		return err
+	}
+	peer := make([]byte, 64)
+	if _, err := io.ReadFull(c.r, peer); err != nil {
+		return err
+	}
+	if peer[0] != 0xff || peer[9]&1 != 1 || peer[10] < 3 || string(bytes.TrimRight(peer[12:32], "\x00")) != "NULL" {
+		return fmt.Errorf("peer does not speak ZMTP 3 with the NULL mechanism")
+	}
+	ready := []byte("\x05READY")
+	ready = appendProperty(ready, "Socket-Type", kind)
+	if err := c.writeFrame(zmqCommand, ready); err != nil {
+		return err
+	}
+	for {
+		flags, body, err := c.readFrame()
+		if err != nil {
+			return err
+		}
+		if flags&zmqCommand != 0 && bytes.HasPrefix(body, []byte("\x05READY")) {
+			return nil
+		}
+	}
+}
+
+func appendProperty(b []byte, name, value string) []byte {
+	b = append(b, byte(len(name)))
+	b = append(b, name...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(value)))
+	return append(b, value...)
+}
+
+func (c *zmqConn) readFrame() (byte, []byte, error) {
+	flags, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var size uint64
+	if flags&zmqLong != 0 {
+		var n [8]byte
+		if _, err := io.ReadFull(c.r, n[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(n[:])
+	} else {
+		n, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size = uint64(n)
+	}
+	if size > 1<<30 {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too big", size)
+	}
+	body := make([]byte, size)
+	_, err = io.ReadFull(c.r, body)
+	return flags, body, err
+}
+
+// readMessage reads the frames of the next message, answering PING
+// commands on the way.
+func (c *zmqConn) readMessage() ([][]byte, error) {
+	var frames [][]byte
+	for {
+		flags, body, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if flags&zmqCommand != 0 {
+			if bytes.HasPrefix(body, []byte("\x04PING")) && len(body) >= 7 {
+				c.mu.Lock()
+				err := c.writeFrame(zmqCommand, append([]byte("\x04PONG"), body[7:]...))
+				c.mu.Unlock()
+				if err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		frames = append(frames, body)
+		if flags&zmqMore == 0 {
+			return frames, nil
+		}
+	}
+}
+
+func (c *zmqConn) writeFrame(flags byte, body []byte) error {
+	var head []byte
+	if len(body) > 255 {
+		head = binary.BigEndian.AppendUint64([]byte{flags | zmqLong}, uint64(len(body)))
+	} else {
+		head = []byte{flags, byte(len(body))}
+	}
+	_, err := c.Write(append(head, body...))
+	return err
+}
+
+func (c *zmqConn) send(frames [][]byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, f := range frames {
+		var flags byte
+		if i < len(frames)-1 {
+			flags = zmqMore
+		}
+		if err := c.writeFrame(flags, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
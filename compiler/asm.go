@@ -0,0 +1,44 @@
+package compiler
+
+import (
+	"errors"
+	"lightlang/bytecode"
+	"lightlang/parser"
+	"strings"
+)
+
+// typeCheckAsm forgets what is known of the variables an asm block sets,
+// since the compiler does not follow what the instructions compute.
+func typeCheckAsm(n *parser.AsmNode, sym *SymbolTable) error {
+	for _, line := range strings.Split(n.Code, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && (strings.EqualFold(fields[0], "SET_LOCAL") || strings.EqualFold(fields[0], "SET_GLOBAL")) {
+			sym.setType(fields[1], "any")
+		}
+	}
+	return nil
+}
+
+// emitAsm assembles an asm block in place. Its locals are named as in the
+// source, and its instructions point at the lines they are written on.
+func (b *Builder) emitAsm(n *parser.AsmNode) {
+	instructions, err := bytecode.AssembleBlock(n.Code, len(b.Instructions), &b.Constants, func(name string) (int, bool) {
+		isLocal, idx := b.SymbolTable.Resolve(name)
+		if isLocal {
+			b.SymbolTable.Use(name)
+		}
+		return idx, isLocal
+	})
+	var se *parser.SourceError
+	if errors.As(err, &se) {
+		se.Line += n.Line
+		se.Col = n.Col
+		b.Errors = append(b.Errors, se)
+		return
+	}
+	for i := range instructions {
+		instructions[i].Line += n.Line
+		instructions[i].Col = n.Col
+	}
+	b.Instructions = append(b.Instructions, instructions...)
+}
@@ -0,0 +1,1176 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"lightlang/parser"
+	"slices"
+)
+
+type SymbolTable struct {
+	Parent    *SymbolTable
+	Locals    map[string]int
+	Globals   map[string]string
+	IsFunc    bool
+	NextLocal int
+	// Used records locals that are read, for the unused variable warning.
+	Used map[string]bool
+	// Funcs holds the signatures of global functions, in the outermost
+	// table only, see declare.
+	Funcs map[string]*Signature
+	// Types holds what TypeCheck has inferred of the variables of this
+	// scope so far, see varType.
+	Types map[string]string
+	// Return is the declared return type of the function being checked.
+	Return string
+	// TypeDefs maps the names of defined types to what they stand for, in
+	// the outermost table only.
+	TypeDefs map[string]string
+	// Consts holds the values of constants, in the outermost table only.
+	Consts map[string]interface{}
+	// changed holds globals that functions assign, and dynamic is set
+	// once the program uses eval or load; their types are not followed.
+	changed map[string]bool
+	dynamic bool
+}
+
+func NewSymbolTable(parent *SymbolTable, isFunc bool) *SymbolTable {
+	return &SymbolTable{
+		Parent:    parent,
+		Locals:    make(map[string]int, 8),
+		Globals:   make(map[string]string, 4),
+		IsFunc:    isFunc,
+		NextLocal: 0,
+		Used:      make(map[string]bool),
+	}
+}
+
+func (s *SymbolTable) Define(name string, isLocal bool) int {
+	if isLocal || s.IsFunc {
+		idx := s.NextLocal
+		s.Locals[name] = idx
+		delete(s.Used, name)
+		s.NextLocal++
+		return idx
+	}
+	s.Globals[name] = "any"
+	return -1
+}
+
+// Resolve looks name up in the enclosing scopes of the current function.
+// Locals of outer functions live in other frames and are not visible.
+func (s *SymbolTable) Resolve(name string) (bool, int) {
+	if idx, ok := s.Locals[name]; ok {
+		return true, idx
+	}
+	if s.Parent != nil && !s.IsFunc {
+		return s.Parent.Resolve(name)
+	}
+	return false, -1
+}
+
+// Use marks a local as read in the scope that declares it.
+func (s *SymbolTable) Use(name string) {
+	for t := s; t != nil; t = t.Parent {
+		if _, ok := t.Locals[name]; ok {
+			t.Used[name] = true
+			return
+		}
+	}
+}
+
+// unit is the code of a function, emitted apart from the code around it
+// and placed after the top level by link. fn is its funcptr constant.
+type unit struct {
+	code []bytecode.Instruction
+	fn   int
+}
+
+// loop collects the break and continue jumps of a loop being emitted until
+// their targets are known.
+type loop struct {
+	breaks, continues []int
+}
+
+type Builder struct {
+	Instructions []bytecode.Instruction
+	Constants    []bytecode.Constant
+	SymbolTable  *SymbolTable
+	LoopStack    []*loop
+	Warnings     parser.ErrorList
+	// Strict rejects reads of names in neither globals nor scope, see
+	// declareGlobals. The errors are collected in Errors.
+	Strict  bool
+	Errors  parser.ErrorList
+	globals map[string]bool
+	// topVars are the variables of the top level, see declareTopVars.
+	topVars map[string]bool
+	src     string
+	pos     parser.Pos
+	names   *bytecode.NamePool
+	// units are the functions emitted since the last link.
+	units []unit
+}
+
+func NewBuilder() *Builder {
+	return &Builder{
+		Instructions: make([]bytecode.Instruction, 0, 64),
+		Constants:    make([]bytecode.Constant, 0, 16),
+		SymbolTable:  NewSymbolTable(nil, false),
+		LoopStack:    make([]*loop, 0, 4),
+	}
+}
+
+func (b *Builder) AddConstant(val interface{}, typ string) int {
+	b.Constants = append(b.Constants, bytecode.Constant{Value: val, Type: typ})
+	return len(b.Constants) - 1
+}
+
+func (b *Builder) Emit(op bytecode.OpCode, arg int) {
+	b.Instructions = append(b.Instructions, bytecode.Instruction{Op: op, Arg: arg, Line: b.pos.Line, Col: b.pos.Col})
+}
+
+// EmitNode emits a statement.
+func (b *Builder) EmitNode(n parser.Node) {
+	b.emit(n)
+}
+
+// EmitName emits a global instruction for name.
+func (b *Builder) EmitName(op bytecode.OpCode, name string) {
+	b.Emit(op, b.nameConst(name))
+}
+
+// nameConst returns the constant holding name.
+func (b *Builder) nameConst(name string) int {
+	if b.names == nil {
+		b.names = bytecode.NewNamePool(&b.Constants)
+	}
+	return b.names.Add(name)
+}
+
+// emit emits the code of n, tagging its instructions with the position of
+// the innermost node they come from.
+func (b *Builder) emit(n parser.Node) {
+	if pos := n.Position(); pos.Line > 0 {
+		saved := b.pos
+		b.pos = pos
+		defer func() { b.pos = saved }()
+	}
+	switch n := n.(type) {
+	case *parser.LiteralNode:
+		b.emitLiteral(n)
+	case *parser.VariableNode:
+		b.emitVariable(n)
+	case *parser.UnaryOpNode:
+		b.emitUnaryOp(n)
+	case *parser.BinaryOpNode:
+		b.emitBinaryOp(n)
+	case *parser.ForLoopNode:
+		b.emitForLoop(n)
+	case *parser.AssignmentNode:
+		b.emitAssignment(n)
+	case *parser.IndexAssignNode:
+		b.emitIndexAssign(n)
+	case *parser.IndexAccessNode:
+		b.emitIndexAccess(n)
+	case *parser.ExprStmtNode:
+		b.emitExprStmt(n)
+	case *parser.CallNode:
+		b.emitCall(n)
+	case *parser.TableLiteralNode:
+		b.emitTableLiteral(n)
+	case *parser.WhileLoopNode:
+		b.emitWhileLoop(n)
+	case *parser.IfNode:
+		b.emitIf(n)
+	case *parser.FuncDefNode:
+		b.emitFuncDef(n)
+	case *parser.ReturnNode:
+		b.emitReturn(n)
+	case *parser.BreakNode:
+		b.emitBreak(n)
+	case *parser.ContinueNode:
+		b.emitContinue(n)
+	case *parser.AnonymousFuncNode:
+		b.emitAnonymousFunc(n)
+	case *parser.TypeDefNode:
+		// types only exist when compiling
+	case *parser.AsmNode:
+		b.emitAsm(n)
+	default:
+		panic(fmt.Sprintf("cannot emit %T", n))
+	}
+}
+
+// TypeCheck checks n against the names defined in sym. Errors point at the
+// innermost node they are found in.
+func TypeCheck(n parser.Node, sym *SymbolTable) error {
+	err := typeCheck(n, sym)
+	var se *parser.SourceError
+	if pos := n.Position(); err != nil && pos.Line > 0 && !errors.As(err, &se) {
+		return &parser.SourceError{Pos: pos, Err: err}
+	}
+	return err
+}
+
+func typeCheck(n parser.Node, sym *SymbolTable) error {
+	switch n := n.(type) {
+	case *parser.LiteralNode:
+		return typeCheckLiteral(n, sym)
+	case *parser.VariableNode:
+		return typeCheckVariable(n, sym)
+	case *parser.UnaryOpNode:
+		return typeCheckUnaryOp(n, sym)
+	case *parser.BinaryOpNode:
+		return typeCheckBinaryOp(n, sym)
+	case *parser.ForLoopNode:
+		return typeCheckForLoop(n, sym)
+	case *parser.AssignmentNode:
+		return typeCheckAssignment(n, sym)
+	case *parser.IndexAssignNode:
+		return typeCheckIndexAssign(n, sym)
+	case *parser.IndexAccessNode:
+		return typeCheckIndexAccess(n, sym)
+	case *parser.ExprStmtNode:
+		return typeCheckExprStmt(n, sym)
+	case *parser.CallNode:
+		return typeCheckCall(n, sym)
+	case *parser.TableLiteralNode:
+		return typeCheckTableLiteral(n, sym)
+	case *parser.WhileLoopNode:
+		return typeCheckWhileLoop(n, sym)
+	case *parser.IfNode:
+		return typeCheckIf(n, sym)
+	case *parser.FuncDefNode:
+		return typeCheckFuncDef(n, sym)
+	case *parser.ReturnNode:
+		return typeCheckReturn(n, sym)
+	case *parser.BreakNode:
+		return typeCheckBreak(n, sym)
+	case *parser.ContinueNode:
+		return typeCheckContinue(n, sym)
+	case *parser.AnonymousFuncNode:
+		return typeCheckAnonymousFunc(n, sym)
+	case *parser.TypeDefNode:
+		return typeCheckTypeDef(n, sym)
+	case *parser.AsmNode:
+		return typeCheckAsm(n, sym)
+	}
+	return nil
+}
+
+func (b *Builder) UpdateInstruction(idx int, arg int) {
+	if idx >= 0 && idx < len(b.Instructions) {
+		b.Instructions[idx].Arg = arg
+	}
+}
+
+// Bytecode links the program and returns it.
+func (b *Builder) Bytecode() ([]bytecode.Instruction, []bytecode.Constant) {
+	b.link()
+	return b.Instructions, b.Constants
+}
+
+// beginFunc starts a function in a unit of its own, so its code is not
+// inline with the code that defines it. It returns the instructions being
+// emitted until then, for endFunc.
+func (b *Builder) beginFunc() []bytecode.Instruction {
+	outer := b.Instructions
+	b.Instructions = make([]bytecode.Instruction, 0, 32)
+	return outer
+}
+
+// endFunc ends the function begun by beginFunc and returns its funcptr
+// constant, whose entry is set by link.
+func (b *Builder) endFunc(outer []bytecode.Instruction, name string, locals, params int) int {
+	idx := b.AddConstant(float64(0), "funcptr")
+	b.Constants[idx].Name = name
+	b.Constants[idx].Locals = locals
+	b.Constants[idx].Params = params
+	b.units = append(b.units, unit{code: b.Instructions, fn: idx})
+	b.Instructions = outer
+	return idx
+}
+
+// link places the code of the functions emitted since the last link after
+// the instructions, moving their jumps along.
+func (b *Builder) link() {
+	for _, u := range b.units {
+		base := len(b.Instructions)
+		for _, inst := range u.code {
+			if bytecode.IsJump(inst.Op) {
+				inst.Arg += base
+			}
+			b.Instructions = append(b.Instructions, inst)
+		}
+		b.Constants[u.fn].Value = float64(base)
+	}
+	b.units = nil
+}
+
+func typeCheckLiteral(n *parser.LiteralNode, sym *SymbolTable) error { return nil }
+func (b *Builder) emitLiteral(n *parser.LiteralNode) {
+	idx := b.AddConstant(n.Value, n.Type)
+	b.Emit(bytecode.OpConstant, idx)
+}
+
+func typeCheckVariable(n *parser.VariableNode, sym *SymbolTable) error { return nil }
+func (b *Builder) emitVariable(n *parser.VariableNode) {
+	if b.emitFolded(n) {
+		return
+	}
+	if isLocal, idx := b.SymbolTable.Resolve(n.Name); isLocal {
+		b.SymbolTable.Use(n.Name)
+		b.Emit(bytecode.OpGetLocal, idx)
+	} else {
+		b.checkDefined(n.Name)
+		b.EmitName(bytecode.OpGetGlobal, n.Name)
+	}
+}
+
+func typeCheckUnaryOp(n *parser.UnaryOpNode, sym *SymbolTable) error {
+	if err := TypeCheck(n.Right, sym); err != nil {
+		return err
+	}
+	if n.Op != "~" {
+		return nil
+	}
+	if err := checkNotNil(n.Right, sym); err != nil {
+		return err
+	}
+	if typ := typeOf(n.Right, sym); !sym.numeric(typ) {
+		return fmt.Errorf("cannot use ~ on %s, it needs a number", typ)
+	}
+	return nil
+}
+
+func (b *Builder) emitUnaryOp(n *parser.UnaryOpNode) {
+	if b.emitFolded(n) {
+		return
+	}
+	b.emit(n.Right)
+	switch n.Op {
+	case "not":
+		b.Emit(bytecode.OpNot, 0)
+	case "~":
+		b.Emit(bytecode.OpBitNot, 0)
+	}
+}
+
+func typeCheckBinaryOp(n *parser.BinaryOpNode, sym *SymbolTable) error {
+	if err := TypeCheck(n.Left, sym); err != nil {
+		return err
+	}
+	// in x != nil and x > 0 the right side only runs when x is set
+	restore := func() {}
+	switch n.Op {
+	case "and":
+		restore = sym.narrow(n.Left, true)
+	case "or":
+		restore = sym.narrow(n.Left, false)
+	}
+	err := TypeCheck(n.Right, sym)
+	restore()
+	if err != nil {
+		return err
+	}
+	return checkOperands(n, sym)
+}
+
+// shortCircuit are the operators whose right side only runs when the left
+// one does not decide the result, with the jump that skips it.
+var shortCircuit = map[string]bytecode.OpCode{
+	"??":  bytecode.OpJumpIfNotNil,
+	"and": bytecode.OpJumpIfFalseOrPop,
+	"or":  bytecode.OpJumpIfTrueOrPop,
+}
+
+func (b *Builder) emitBinaryOp(n *parser.BinaryOpNode) {
+	if b.emitFolded(n) {
+		return
+	}
+	if jump, ok := shortCircuit[n.Op]; ok {
+		b.emit(n.Left)
+		jumpIdx := len(b.Instructions)
+		b.Emit(jump, 0)
+		b.emit(n.Right)
+		b.Instructions[jumpIdx].Arg = len(b.Instructions)
+		return
+	}
+	b.emit(n.Left)
+	b.emit(n.Right)
+	switch n.Op {
+	case "+":
+		b.Emit(bytecode.OpAdd, 0)
+	case "-":
+		b.Emit(bytecode.OpSub, 0)
+	case "*":
+		b.Emit(bytecode.OpMul, 0)
+	case "/":
+		b.Emit(bytecode.OpDiv, 0)
+	case "==":
+		b.Emit(bytecode.OpCmpEq, 0)
+	case "!=":
+		b.Emit(bytecode.OpCmpNe, 0)
+	case "<":
+		b.Emit(bytecode.OpCmpLt, 0)
+	case "<=":
+		b.Emit(bytecode.OpCmpLte, 0)
+	case ">":
+		b.Emit(bytecode.OpCmpGt, 0)
+	case ">=":
+		b.Emit(bytecode.OpCmpGte, 0)
+	case "&":
+		b.Emit(bytecode.OpBitAnd, 0)
+	case "|":
+		b.Emit(bytecode.OpBitOr, 0)
+	case "^":
+		b.Emit(bytecode.OpBitXor, 0)
+	case "<<":
+		b.Emit(bytecode.OpShl, 0)
+	case ">>":
+		b.Emit(bytecode.OpShr, 0)
+	}
+}
+
+func typeCheckForLoop(n *parser.ForLoopNode, sym *SymbolTable) error {
+	if n.Type == "in" {
+		sym.Define(n.LoopVar, true)
+		if n.Collection != nil {
+			if err := TypeCheck(n.Collection, sym); err != nil {
+				return err
+			}
+			if typ := typeOf(n.Collection, sym); !sym.indexable(typ) {
+				return fmt.Errorf("cannot loop over %s", typ)
+			}
+		}
+		sym.forget(n)
+		if n.Collection != nil {
+			if elem := elemType(sym.resolve(typeOf(n.Collection, sym))); elem != "" {
+				sym.setType(n.LoopVar, elem)
+			}
+		}
+	} else {
+		if n.Init != nil {
+			if err := TypeCheck(n.Init, sym); err != nil {
+				return err
+			}
+		}
+		sym.forget(n.Cond, n.Update)
+		sym.forget(n.Body...)
+		if n.Cond != nil {
+			if err := TypeCheck(n.Cond, sym); err != nil {
+				return err
+			}
+		}
+		if n.Update != nil {
+			if err := TypeCheck(n.Update, sym); err != nil {
+				return err
+			}
+		}
+	}
+	for _, stmt := range n.Body {
+		if err := TypeCheck(stmt, sym); err != nil {
+			return err
+		}
+	}
+	sym.forget(n)
+	return nil
+}
+
+func (b *Builder) emitForLoop(n *parser.ForLoopNode) {
+	if n.Type == "in" {
+		b.emitInLoop(n)
+	} else {
+		b.emitCstyle(n)
+	}
+}
+
+func (b *Builder) emitUpdateOrInit(n *parser.ForLoopNode, node parser.Node) {
+	if assign, ok := node.(*parser.AssignmentNode); ok {
+		b.emit(assign.Expr)
+		if isLocal, idx := b.SymbolTable.Resolve(assign.Name); isLocal {
+			b.Emit(bytecode.OpSetLocal, idx)
+		} else {
+			b.EmitName(bytecode.OpSetGlobal, assign.Name)
+		}
+		b.Emit(bytecode.OpPop, 0)
+	} else {
+		b.emit(node)
+		b.Emit(bytecode.OpPop, 0)
+	}
+}
+
+func (b *Builder) emitCstyle(n *parser.ForLoopNode) {
+	if n.Init != nil {
+		b.emitUpdateOrInit(n, n.Init)
+	}
+
+	startIdx := len(b.Instructions)
+	b.beginLoop()
+
+	if n.Cond != nil {
+		b.checkCondition(n.Cond, true)
+		b.emit(n.Cond)
+		jumpFalseIdx := len(b.Instructions)
+		b.Emit(bytecode.OpJumpIfFalse, 0)
+
+		b.emitBlock(n.Body)
+
+		updateIdx := len(b.Instructions)
+		if n.Update != nil {
+			b.emitUpdateOrInit(n, n.Update)
+		}
+
+		b.Emit(bytecode.OpJump, startIdx)
+		exitIdx := len(b.Instructions)
+		b.UpdateInstruction(jumpFalseIdx, exitIdx)
+		b.endLoop(updateIdx, exitIdx)
+	} else {
+		b.emitBlock(n.Body)
+
+		updateIdx := len(b.Instructions)
+		if n.Update != nil {
+			b.emitUpdateOrInit(n, n.Update)
+		}
+
+		b.Emit(bytecode.OpJump, startIdx)
+		b.endLoop(updateIdx, len(b.Instructions))
+	}
+}
+
+// emitInLoop evaluates the collection once and steps through it with
+// OpIterNext, which indexes arrays and strings and pulls from iterators.
+func (b *Builder) emitInLoop(n *parser.ForLoopNode) {
+	b.emit(n.Collection)
+	collIdx := b.SymbolTable.Define(n.LoopVar+"_coll", true)
+	b.Emit(bytecode.OpSetLocal, collIdx)
+
+	counterIdx := b.SymbolTable.Define(n.LoopVar+"_counter", true)
+	b.Emit(bytecode.OpConstant, b.AddConstant(0, "number"))
+	b.Emit(bytecode.OpSetLocal, counterIdx)
+
+	startIdx := len(b.Instructions)
+	b.beginLoop()
+
+	b.Emit(bytecode.OpGetLocal, counterIdx)
+	b.Emit(bytecode.OpGetLocal, collIdx)
+	iterIdx := len(b.Instructions)
+	b.Emit(bytecode.OpIterNext, 0)
+
+	loopVarIdx := b.defineLocal(n.LoopVar)
+	b.Emit(bytecode.OpSetLocal, loopVarIdx)
+
+	b.emitBlock(n.Body)
+	b.checkUnused("loop variable", n.LoopVar)
+
+	nextIdx := len(b.Instructions)
+	b.Emit(bytecode.OpGetLocal, counterIdx)
+	b.Emit(bytecode.OpConstant, b.AddConstant(1, "number"))
+	b.Emit(bytecode.OpAdd, 0)
+	b.Emit(bytecode.OpSetLocal, counterIdx)
+
+	b.Emit(bytecode.OpJump, startIdx)
+	exitIdx := len(b.Instructions)
+	b.UpdateInstruction(iterIdx, exitIdx)
+	b.endLoop(nextIdx, exitIdx)
+}
+
+// reachesEnd tells whether the code from start can run past its end: it
+// does not end in a return, or a jump goes to the end, as from an if whose
+// last statement returns.
+func (b *Builder) reachesEnd(start int) bool {
+	end := len(b.Instructions)
+	if end == start || b.Instructions[end-1].Op != bytecode.OpReturn {
+		return true
+	}
+	for _, inst := range b.Instructions[start:end] {
+		if bytecode.IsJump(inst.Op) && inst.Arg == end {
+			return true
+		}
+	}
+	return false
+}
+
+func typeCheckAssignment(n *parser.AssignmentNode, sym *SymbolTable) error {
+	if err := TypeCheck(n.Expr, sym); err != nil {
+		return err
+	}
+	switch _, isConst := sym.constant(n.Name); {
+	case n.Const && sym.inFunc():
+		return fmt.Errorf("const %s must be declared outside functions", n.Name)
+	case n.Const:
+		if err := sym.declareConst(n); err != nil {
+			return err
+		}
+	case isConst:
+		return fmt.Errorf("cannot assign to constant %s", n.Name)
+	}
+	typ := typeOf(n.Expr, sym)
+	if n.Type == "" {
+		// only declared variables keep the element type of an array
+		if _, ok := n.Expr.(*parser.TableLiteralNode); ok && elemType(typ) != "" {
+			typ = "array"
+		}
+	} else {
+		if err := sym.checkType(n.Type); err != nil {
+			return fmt.Errorf("%s: %v", n.Name, err)
+		}
+		if !sym.assignable(typ, n.Type) {
+			return fmt.Errorf("cannot assign %s to %s, which is %s", typ, n.Name, n.Type)
+		}
+		if err := sym.checkLiteral(n.Expr, n.Type); err != nil {
+			return err
+		}
+		typ = sym.resolve(n.Type)
+	}
+	if n.IsLocal {
+		sym.Define(n.Name, true)
+	} else if sym.Signature(n.Name) != nil {
+		delete(sym.root().Funcs, n.Name)
+	}
+	sym.setType(n.Name, typ)
+	return nil
+}
+
+func (b *Builder) emitAssignment(n *parser.AssignmentNode) {
+	b.emit(n.Expr)
+
+	if n.IsLocal {
+		if index := b.SymbolTable.Define(n.Name, true); index >= 0 {
+			b.Emit(bytecode.OpSetLocal, index)
+		} else {
+			b.EmitName(bytecode.OpSetGlobal, n.Name)
+		}
+	} else if isLocal, index := b.SymbolTable.Resolve(n.Name); isLocal {
+		b.Emit(bytecode.OpSetLocal, index)
+	} else {
+		b.EmitName(bytecode.OpSetGlobal, n.Name)
+	}
+}
+
+func typeCheckIndexAssign(n *parser.IndexAssignNode, sym *SymbolTable) error {
+	if err := TypeCheck(n.Table, sym); err != nil {
+		return err
+	}
+	if err := TypeCheck(n.Index, sym); err != nil {
+		return err
+	}
+	if err := TypeCheck(n.Value, sym); err != nil {
+		return err
+	}
+	if err := checkNotNil(n.Table, sym); err != nil {
+		return err
+	}
+	typ := typeOf(n.Table, sym)
+	if base, _ := sym.nonNil(typ); !sym.indexable(typ) || base == "string" {
+		return fmt.Errorf("cannot index %s", typ)
+	}
+	ftyp, err := sym.field(typ, n.Index)
+	if err != nil {
+		return err
+	}
+	if got := typeOf(n.Value, sym); !sym.assignable(got, ftyp) {
+		return fmt.Errorf("field %v of %s must be %s, got %s", n.Index.(*parser.LiteralNode).Value, typ, ftyp, got)
+	}
+	return sym.checkLiteral(n.Value, ftyp)
+}
+
+func (b *Builder) emitIndexAssign(n *parser.IndexAssignNode) {
+	b.emit(n.Table)
+	b.emit(n.Index)
+	b.emit(n.Value)
+	b.Emit(bytecode.OpSetIndex, 0)
+	b.Emit(bytecode.OpPop, 0)
+}
+
+func typeCheckIndexAccess(n *parser.IndexAccessNode, sym *SymbolTable) error {
+	if err := TypeCheck(n.Table, sym); err != nil {
+		return err
+	}
+	if err := TypeCheck(n.Index, sym); err != nil {
+		return err
+	}
+	if err := checkNotNil(n.Table, sym); err != nil {
+		return err
+	}
+	typ := typeOf(n.Table, sym)
+	if !sym.indexable(typ) {
+		return fmt.Errorf("cannot index %s", typ)
+	}
+	_, err := sym.field(typ, n.Index)
+	return err
+}
+
+func (b *Builder) emitIndexAccess(n *parser.IndexAccessNode) {
+	b.emit(n.Table)
+	b.emit(n.Index)
+	b.Emit(bytecode.OpGetIndex, 0)
+}
+
+func typeCheckExprStmt(n *parser.ExprStmtNode, sym *SymbolTable) error { return TypeCheck(n.Expr, sym) }
+func (b *Builder) emitExprStmt(n *parser.ExprStmtNode) {
+	b.emit(n.Expr)
+	b.Emit(bytecode.OpPop, 0)
+}
+
+func typeCheckCall(n *parser.CallNode, sym *SymbolTable) error {
+	if len(n.Args) > bytecode.MaxCallArgs {
+		return fmt.Errorf("too many arguments in call: %d, at most %d", len(n.Args), bytecode.MaxCallArgs)
+	}
+	for _, arg := range n.Args {
+		if err := TypeCheck(arg, sym); err != nil {
+			return err
+		}
+	}
+	if n.CallType == "direct" {
+		return checkCall(n, sym)
+	}
+	if err := TypeCheck(n.IndirectTarget, sym); err != nil {
+		return err
+	}
+	if typ := typeOf(n.IndirectTarget, sym); typ != "function" && typ != "any" {
+		return fmt.Errorf("cannot call %s", typ)
+	}
+	return nil
+}
+
+func (b *Builder) emitCall(n *parser.CallNode) {
+	for _, arg := range n.Args {
+		b.emit(arg)
+	}
+
+	if name, ok := b.moduleCall(n); ok {
+		b.checkDefined(name)
+		b.Emit(bytecode.OpCall, bytecode.CallOperand(b.nameConst(name), len(n.Args)))
+		return
+	}
+	if n.CallType == "direct" {
+		b.SymbolTable.Use(n.Target)
+		// a parameter or loop variable holds a function value, which is
+		// called like t.f(x)
+		if isLocal, idx := b.SymbolTable.Resolve(n.Target); isLocal {
+			b.Emit(bytecode.OpGetLocal, idx)
+			b.Emit(bytecode.OpCallIndirect, len(n.Args))
+			return
+		}
+		// calls by name reach builtins first, so the program's own
+		// function named like one is called through its value
+		if _, builtin := builtins.Builtins[n.Target]; builtin && b.isVariable(n.Target) {
+			b.emitVariable(&parser.VariableNode{Name: n.Target})
+			b.Emit(bytecode.OpCallIndirect, len(n.Args))
+			return
+		}
+		b.checkDefined(n.Target)
+		b.Emit(bytecode.OpCall, bytecode.CallOperand(b.nameConst(n.Target), len(n.Args)))
+	} else {
+		b.emit(n.IndirectTarget)
+		b.Emit(bytecode.OpCallIndirect, len(n.Args))
+	}
+}
+
+// moduleCall gives the builtin that a call like path.join(a, b) names, such
+// as path.join. When path is a local or global of the program it is
+// indexed instead.
+func (b *Builder) moduleCall(n *parser.CallNode) (string, bool) {
+	name, ok := moduleBuiltin(n)
+	if !ok {
+		return "", false
+	}
+	module := n.IndirectTarget.(*parser.IndexAccessNode).Table.(*parser.VariableNode).Name
+	return name, !b.isVariable(module)
+}
+
+// isVariable reports whether name is a local, function or global of the
+// program at this point, so that it wins over a builtin of that name.
+func (b *Builder) isVariable(name string) bool {
+	if isLocal, _ := b.SymbolTable.Resolve(name); isLocal {
+		return true
+	}
+	root := b.SymbolTable.root()
+	if _, ok := root.Locals[name]; ok {
+		return true
+	}
+	if _, ok := root.Globals[name]; ok {
+		return true
+	}
+	if _, ok := root.Funcs[name]; ok {
+		return true
+	}
+	// functions run after the top level, so they see all of its variables
+	return b.SymbolTable.inFunc() && b.topVars[name]
+}
+
+// declareTopVars records the variables the top level of nodes assigns, for
+// moduleCall.
+func (b *Builder) declareTopVars(nodes []parser.Node) {
+	b.topVars = topLevelVars(nodes)
+}
+
+// topLevelVars gives the names that nodes assign outside of functions.
+func topLevelVars(nodes []parser.Node) map[string]bool {
+	vars := make(map[string]bool)
+	inFunc := make(map[parser.Node]bool)
+	for _, node := range nodes {
+		parser.Walk(node, func(n parser.Node) {
+			switch fn := n.(type) {
+			case *parser.FuncDefNode:
+				markBody(fn.Body, inFunc)
+			case *parser.AnonymousFuncNode:
+				markBody(fn.Body, inFunc)
+			}
+		})
+	}
+	for _, node := range nodes {
+		parser.Walk(node, func(n parser.Node) {
+			if inFunc[n] {
+				return
+			}
+			switch n := n.(type) {
+			case *parser.AssignmentNode:
+				vars[n.Name] = true
+			case *parser.ForLoopNode:
+				if n.Type == "in" {
+					vars[n.LoopVar] = true
+				}
+			}
+		})
+	}
+	return vars
+}
+
+func markBody(body []parser.Node, marked map[parser.Node]bool) {
+	for _, stmt := range body {
+		parser.Walk(stmt, func(n parser.Node) { marked[n] = true })
+	}
+}
+
+// moduleBuiltin gives the name of the builtin a call of module.field would
+// reach when module is not a variable.
+func moduleBuiltin(n *parser.CallNode) (string, bool) {
+	access, ok := n.IndirectTarget.(*parser.IndexAccessNode)
+	if !ok || n.CallType == "direct" {
+		return "", false
+	}
+	module, ok := access.Table.(*parser.VariableNode)
+	if !ok {
+		return "", false
+	}
+	field, ok := access.Index.(*parser.LiteralNode)
+	if !ok || field.Type != "string" {
+		return "", false
+	}
+	name := module.Name + "." + field.Value.(string)
+	_, ok = builtins.Builtins[name]
+	return name, ok
+}
+
+func typeCheckTableLiteral(n *parser.TableLiteralNode, sym *SymbolTable) error {
+	for _, val := range n.Values {
+		if err := TypeCheck(val, sym); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Builder) emitTableLiteral(n *parser.TableLiteralNode) {
+	if n.IsArray {
+		for _, val := range n.Values {
+			b.emit(val)
+		}
+		b.Emit(bytecode.OpArray, len(n.Values))
+	} else {
+		b.Emit(bytecode.OpTable, 0)
+		for i, k := range n.Keys {
+			b.Emit(bytecode.OpConstant, b.AddConstant(k, "string"))
+			b.emit(n.Values[i])
+			b.Emit(bytecode.OpSetIndex, 0)
+		}
+	}
+}
+
+func typeCheckWhileLoop(n *parser.WhileLoopNode, sym *SymbolTable) error {
+	sym.forget(n)
+	if err := TypeCheck(n.Condition, sym); err != nil {
+		return err
+	}
+	restore := sym.narrow(n.Condition, true)
+	for _, stmt := range n.Body {
+		if err := TypeCheck(stmt, sym); err != nil {
+			restore()
+			return err
+		}
+	}
+	restore()
+	sym.forget(n)
+	return nil
+}
+
+func (b *Builder) emitWhileLoop(n *parser.WhileLoopNode) {
+	startIdx := len(b.Instructions)
+	b.beginLoop()
+
+	b.checkCondition(n.Condition, true)
+	b.emit(n.Condition)
+	jumpFalseIdx := len(b.Instructions)
+	b.Emit(bytecode.OpJumpIfFalse, 0)
+
+	b.emitBlock(n.Body)
+
+	b.Emit(bytecode.OpJump, startIdx)
+	exitIdx := len(b.Instructions)
+	b.UpdateInstruction(jumpFalseIdx, exitIdx)
+	b.endLoop(startIdx, exitIdx)
+}
+
+func typeCheckIf(n *parser.IfNode, sym *SymbolTable) error {
+	if err := typeCheckBranches(n, sym); err != nil {
+		return err
+	}
+	sym.forget(n)
+	// after if x == nil then return end, x is not nil
+	if len(n.Bodies) == 1 && len(n.ElseBody) == 0 && slices.ContainsFunc(n.Bodies[0], jumps) {
+		sym.narrow(n.Conditions[0], false)
+	}
+	return nil
+}
+
+// typeCheckBranches checks each branch from what was known before the if
+// and what the conditions tell of nil.
+func typeCheckBranches(n *parser.IfNode, sym *SymbolTable) error {
+	var undo []func()
+	defer func() {
+		for _, u := range slices.Backward(undo) {
+			u()
+		}
+	}()
+	for i, cond := range n.Conditions {
+		if err := TypeCheck(cond, sym); err != nil {
+			return err
+		}
+		sym.forget(n)
+		restore := sym.narrow(cond, true)
+		for _, stmt := range n.Bodies[i] {
+			if err := TypeCheck(stmt, sym); err != nil {
+				restore()
+				return err
+			}
+		}
+		restore()
+		sym.forget(n)
+		undo = append(undo, sym.narrow(cond, false))
+	}
+	for _, stmt := range n.ElseBody {
+		if err := TypeCheck(stmt, sym); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Builder) emitIf(n *parser.IfNode) {
+	var jumps []int
+	var endJumps []int
+	taken := false
+
+	for i, cond := range n.Conditions {
+		b.checkCondition(cond, false)
+		if v, ok := constValue(cond, b.SymbolTable); ok {
+			if !builtins.Truthy(v) {
+				b.skip(n.Bodies[i])
+				continue
+			}
+			b.emitBlock(n.Bodies[i])
+			b.skip(append(n.Bodies[i+1:], n.ElseBody)...)
+			taken = true
+			break
+		}
+		b.emit(cond)
+		jumpIdx := len(b.Instructions)
+		b.Emit(bytecode.OpJumpIfFalse, 0)
+		jumps = append(jumps, jumpIdx)
+
+		b.emitBlock(n.Bodies[i])
+
+		if i < len(n.Conditions)-1 || len(n.ElseBody) > 0 {
+			endJumpIdx := len(b.Instructions)
+			b.Emit(bytecode.OpJump, 0)
+			endJumps = append(endJumps, endJumpIdx)
+		}
+
+		b.UpdateInstruction(jumpIdx, len(b.Instructions))
+	}
+
+	if !taken && len(n.ElseBody) > 0 {
+		b.emitBlock(n.ElseBody)
+	}
+
+	finalIdx := len(b.Instructions)
+	for _, idx := range endJumps {
+		b.UpdateInstruction(idx, finalIdx)
+	}
+}
+
+func typeCheckFuncDef(n *parser.FuncDefNode, sym *SymbolTable) error {
+	sig, err := sym.newSignature(n.TypeParams, n.Params, n.ParamTypes, n.ReturnType)
+	if err != nil {
+		return fmt.Errorf("%s: %v", n.Name, err)
+	}
+	sym.Define(n.Name, false)
+	sym.declare(n.Name, sig)
+	return typeCheckBody(n.Params, sig, n.Body, sym)
+}
+
+func (b *Builder) emitFuncDef(n *parser.FuncDefNode) {
+	b.checkFuncName(n.Name)
+	outer := b.beginFunc()
+	prevSym, prevLoops := b.SymbolTable, b.LoopStack
+	b.SymbolTable = NewSymbolTable(prevSym, true)
+	b.LoopStack = nil
+
+	for _, param := range n.Params {
+		b.defineLocal(param)
+	}
+	b.SymbolTable.Return = n.ReturnType
+
+	b.emitParamChecks(n.Params, n.ParamTypes)
+	b.emitBlock(n.Body)
+
+	if b.reachesEnd(0) {
+		b.Emit(bytecode.OpConstant, b.AddConstant(nil, "nil"))
+		b.emitCheck(n.ReturnType)
+		b.Emit(bytecode.OpReturn, 0)
+	}
+	b.checkReturn(n.Name, n.ReturnType, n.Body)
+	b.checkUnused("parameter", n.Params...)
+
+	locals := b.SymbolTable.NextLocal
+	b.SymbolTable, b.LoopStack = prevSym, prevLoops
+	b.Emit(bytecode.OpMakeFunc, b.endFunc(outer, n.Name, locals, len(n.Params)+1))
+	b.EmitName(bytecode.OpSetGlobal, n.Name)
+}
+
+func typeCheckReturn(n *parser.ReturnNode, sym *SymbolTable) error {
+	typ := "nil"
+	if n.Value != nil {
+		if err := TypeCheck(n.Value, sym); err != nil {
+			return err
+		}
+		typ = typeOf(n.Value, sym)
+	}
+	want := sym.returnType()
+	if !sym.assignable(typ, want) {
+		return fmt.Errorf("cannot return %s from a function returning %s", typ, want)
+	}
+	if n.Value != nil {
+		return sym.checkLiteral(n.Value, want)
+	}
+	return nil
+}
+
+func (b *Builder) emitReturn(n *parser.ReturnNode) {
+	if n.Value != nil {
+		b.emit(n.Value)
+		if want := b.SymbolTable.returnType(); typeOf(n.Value, b.SymbolTable) != want {
+			b.emitCheck(want)
+		}
+	} else {
+		b.Emit(bytecode.OpConstant, b.AddConstant(nil, "nil"))
+	}
+	b.Emit(bytecode.OpReturn, 0)
+}
+
+func typeCheckBreak(n *parser.BreakNode, sym *SymbolTable) error { return nil }
+func (b *Builder) emitBreak(n *parser.BreakNode) {
+	if l := b.innerLoop(n.Pos, "break"); l != nil {
+		l.breaks = append(l.breaks, len(b.Instructions))
+	}
+	b.Emit(bytecode.OpJump, 0)
+}
+
+func typeCheckContinue(n *parser.ContinueNode, sym *SymbolTable) error { return nil }
+func (b *Builder) emitContinue(n *parser.ContinueNode) {
+	if l := b.innerLoop(n.Pos, "continue"); l != nil {
+		l.continues = append(l.continues, len(b.Instructions))
+	}
+	b.Emit(bytecode.OpJump, 0)
+}
+
+func (b *Builder) beginLoop() {
+	b.LoopStack = append(b.LoopStack, &loop{})
+}
+
+// endLoop points the continue jumps of the innermost loop at next and its
+// break jumps at exit.
+func (b *Builder) endLoop(next, exit int) {
+	l := b.LoopStack[len(b.LoopStack)-1]
+	for _, idx := range l.continues {
+		b.UpdateInstruction(idx, next)
+	}
+	for _, idx := range l.breaks {
+		b.UpdateInstruction(idx, exit)
+	}
+	b.LoopStack = b.LoopStack[:len(b.LoopStack)-1]
+}
+
+// innerLoop returns the loop a break or continue at pos belongs to, and
+// reports an error when it is outside of any loop.
+func (b *Builder) innerLoop(pos parser.Pos, stmt string) *loop {
+	if len(b.LoopStack) == 0 {
+		b.Errors = append(b.Errors, &parser.SourceError{Pos: pos, Len: len(stmt), Err: fmt.Errorf("%s outside of a loop", stmt)})
+		return nil
+	}
+	return b.LoopStack[len(b.LoopStack)-1]
+}
+
+func typeCheckAnonymousFunc(n *parser.AnonymousFuncNode, sym *SymbolTable) error {
+	sig, err := sym.newSignature(nil, n.Params, n.ParamTypes, n.ReturnType)
+	if err != nil {
+		return err
+	}
+	return typeCheckBody(n.Params, sig, n.Body, sym)
+}
+
+func (b *Builder) emitAnonymousFunc(n *parser.AnonymousFuncNode) {
+	outer := b.beginFunc()
+	prevSym, prevLoops := b.SymbolTable, b.LoopStack
+	b.SymbolTable = NewSymbolTable(prevSym, true)
+	b.LoopStack = nil
+
+	for _, param := range n.Params {
+		b.defineLocal(param)
+	}
+	b.SymbolTable.Return = n.ReturnType
+
+	b.emitParamChecks(n.Params, n.ParamTypes)
+	b.emitBlock(n.Body)
+
+	if b.reachesEnd(0) {
+		b.Emit(bytecode.OpConstant, b.AddConstant(nil, "nil"))
+		b.emitCheck(n.ReturnType)
+		b.Emit(bytecode.OpReturn, 0)
+	}
+	b.checkReturn("", n.ReturnType, n.Body)
+	b.checkUnused("parameter", n.Params...)
+
+	locals := b.SymbolTable.NextLocal
+	b.SymbolTable, b.LoopStack = prevSym, prevLoops
+	b.Emit(bytecode.OpMakeFunc, b.endFunc(outer, "", locals, len(n.Params)+1))
+}
+
+func typeCheckTypeDef(n *parser.TypeDefNode, sym *SymbolTable) error {
+	if builtinTypes[n.Name] {
+		return fmt.Errorf("cannot redefine built-in type %s", n.Name)
+	}
+	sym.defineType(n.Name, n.Type)
+	if err := sym.checkType(n.Type); err != nil {
+		return fmt.Errorf("type %s: %v", n.Name, err)
+	}
+	if typ := sym.resolve(n.Name); !builtinTypes[typ] && sym.shape(typ) == nil {
+		return fmt.Errorf("type %s refers to itself", n.Name)
+	}
+	return nil
+}
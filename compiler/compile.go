@@ -0,0 +1,92 @@
+package compiler
+
+import (
+	"lightlang/bytecode"
+	"lightlang/parser"
+)
+
+// Compile parses and emits source without running the optimizer. Errors
+// are *SourceError values naming file.
+func Compile(file string, source string) (*Builder, error) {
+	nodes, err := parser.ParseFile(file, source)
+	if err != nil {
+		return nil, parser.WrapError(err, file, "Parse Error", parser.Pos{})
+	}
+
+	builder := NewBuilder()
+	if Strict {
+		builder.Strict, builder.src = true, source
+		builder.declareGlobals(nodes)
+	}
+	builder.declareTopVars(nodes)
+	if err := declareFuncs(nodes, builder.SymbolTable); err != nil {
+		return nil, parser.WrapError(err, file, "Type Error", parser.Pos{})
+	}
+	for _, node := range nodes {
+		if err := TypeCheck(node, builder.SymbolTable); err != nil {
+			return nil, parser.WrapError(err, file, "Type Error", node.Position())
+		}
+	}
+	nodes, err = runASTPasses(builder, file, nodes)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		builder.EmitNode(node)
+	}
+	builder.Emit(bytecode.OpHalt, 0)
+	builder.link()
+	if len(builder.Errors) == 0 {
+		if err := runBytecodePasses(builder, file); err != nil {
+			return nil, err
+		}
+	}
+	return builder.result(file)
+}
+
+// CompileAppend compiles source as code added after instructions and
+// constants, for eval. The builder holds the whole program, so jumps,
+// function entries and constants of the new code follow on from the old.
+// The functions it defines are placed after it. The new code may return,
+// and otherwise returns the value of its last statement when that is an
+// expression.
+func CompileAppend(file, source string, instructions []bytecode.Instruction, constants []bytecode.Constant) (*Builder, error) {
+	nodes, err := parser.ParseChunk(source)
+	if err != nil {
+		return nil, parser.WrapError(err, file, "Parse Error", parser.Pos{})
+	}
+
+	builder := NewBuilder()
+	builder.Instructions = append(builder.Instructions, instructions...)
+	builder.Constants = append(builder.Constants, constants...)
+	builder.declareTopVars(nodes)
+	if err := declareFuncs(nodes, builder.SymbolTable); err != nil {
+		return nil, parser.WrapError(err, file, "Type Error", parser.Pos{})
+	}
+	for i, node := range nodes {
+		if err := TypeCheck(node, builder.SymbolTable); err != nil {
+			return nil, parser.WrapError(err, file, "Type Error", node.Position())
+		}
+		if last, ok := node.(*parser.ExprStmtNode); ok && i == len(nodes)-1 {
+			builder.EmitNode(last.Expr)
+			continue
+		}
+		builder.EmitNode(node)
+	}
+	builder.Emit(bytecode.OpReturn, 0)
+	builder.link()
+	return builder.result(file)
+}
+
+// result returns b, or the errors found while emitting it.
+func (b *Builder) result(file string) (*Builder, error) {
+	if len(b.Errors) == 1 {
+		return nil, parser.WrapError(b.Errors[0], file, "Type Error", parser.Pos{})
+	} else if len(b.Errors) > 1 {
+		return nil, parser.WrapError(b.Errors, file, "Type Error", parser.Pos{})
+	}
+	for _, w := range b.Warnings {
+		w.File = file
+	}
+	return b, nil
+}
@@ -0,0 +1,48 @@
+package compiler
+
+import (
+	"fmt"
+	"lightlang/parser"
+)
+
+// Constants are globals declared with const. Their values are known when
+// compiling, so reads of them fold like literals and the branches of an
+// if DEBUG then ... end they rule out are not emitted.
+
+// constant returns the value of the constant name, unless a local hides
+// it.
+func (s *SymbolTable) constant(name string) (interface{}, bool) {
+	if isLocal, _ := s.Resolve(name); isLocal {
+		return nil, false
+	}
+	v, ok := s.root().Consts[name]
+	return v, ok
+}
+
+// declareConst records the value of a const, which has to fold.
+func (s *SymbolTable) declareConst(n *parser.AssignmentNode) error {
+	v, ok := constValue(n.Expr, s)
+	if !ok {
+		return fmt.Errorf("const %s needs a value known when compiling", n.Name)
+	}
+	root := s.root()
+	if root.Consts == nil {
+		root.Consts = make(map[string]interface{})
+	}
+	root.Consts[n.Name] = v
+	return nil
+}
+
+// skip marks the locals read in branches that are left out as used, so
+// an if DEBUG then ... end does not make them look unused.
+func (b *Builder) skip(bodies ...[]parser.Node) {
+	for _, body := range bodies {
+		for _, stmt := range body {
+			parser.Walk(stmt, func(n parser.Node) {
+				if v, ok := n.(*parser.VariableNode); ok {
+					b.SymbolTable.Use(v.Name)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package compiler
+
+import (
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"lightlang/parser"
+	"math"
+	"strings"
+)
+
+// constValue evaluates an expression made only of literals and constants,
+// with the same results the VM would produce. It fails for anything that
+// could raise a runtime error, such as division by zero.
+func constValue(n parser.Node, sym *SymbolTable) (interface{}, bool) {
+	switch n := n.(type) {
+	case *parser.LiteralNode:
+		return n.Value, true
+	case *parser.VariableNode:
+		return sym.constant(n.Name)
+	case *parser.UnaryOpNode:
+		v, ok := constValue(n.Right, sym)
+		if !ok {
+			return nil, false
+		}
+		switch n.Op {
+		case "not":
+			return boolNumber(!builtins.Truthy(v)), true
+		case "~":
+			if a, ok := wholeNumber(v); ok {
+				return float64(^a), true
+			}
+		}
+		return nil, false
+	case *parser.BinaryOpNode:
+		l, ok := constValue(n.Left, sym)
+		if !ok {
+			return nil, false
+		}
+		r, ok := constValue(n.Right, sym)
+		if !ok {
+			return nil, false
+		}
+		switch n.Op {
+		case "and":
+			if builtins.Truthy(l) {
+				return r, true
+			}
+			return l, true
+		case "or":
+			if builtins.Truthy(l) {
+				return l, true
+			}
+			return r, true
+		case "==":
+			return boolNumber(l == r), true
+		case "!=":
+			return boolNumber(l != r), true
+		case "??":
+			if l == nil {
+				return r, true
+			}
+			return l, true
+		}
+		if ls, ok := l.(string); ok {
+			if rs, ok := r.(string); ok {
+				switch n.Op {
+				case "+":
+					return ls + rs, true
+				case "<", "<=", ">", ">=":
+					l, r = float64(strings.Compare(ls, rs)), 0.0
+				}
+			}
+		}
+		if a, ok := wholeNumber(l); ok {
+			if b, ok := wholeNumber(r); ok {
+				switch n.Op {
+				case "&":
+					return float64(a & b), true
+				case "|":
+					return float64(a | b), true
+				case "^":
+					return float64(a ^ b), true
+				case "<<", ">>":
+					if b < 0 {
+						return nil, false
+					}
+					if n.Op == "<<" {
+						return float64(a << b), true
+					}
+					return float64(a >> b), true
+				}
+			}
+		}
+		lf, ok1 := l.(float64)
+		rf, ok2 := r.(float64)
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		switch n.Op {
+		case "+":
+			return lf + rf, true
+		case "-":
+			return lf - rf, true
+		case "*":
+			return lf * rf, true
+		case "/":
+			if rf == 0 {
+				return nil, false
+			}
+			return lf / rf, true
+		case "<":
+			return boolNumber(lf < rf), true
+		case "<=":
+			return boolNumber(lf <= rf), true
+		case ">":
+			return boolNumber(lf > rf), true
+		case ">=":
+			return boolNumber(lf >= rf), true
+		}
+	}
+	return nil, false
+}
+
+// emitFolded emits n as a single constant if it can be evaluated at compile
+// time.
+func (b *Builder) emitFolded(n parser.Node) bool {
+	v, ok := constValue(n, b.SymbolTable)
+	if !ok {
+		return false
+	}
+	idx := b.AddConstant(v, getTypeString(v))
+	b.Emit(bytecode.OpConstant, idx)
+	return true
+}
+
+// wholeNumber gives v as the integer the bitwise ops work on.
+func wholeNumber(v interface{}) (int64, bool) {
+	f, ok := v.(float64)
+	if !ok || f != math.Trunc(f) || f < -1<<63 || f >= 1<<63 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+func boolNumber(ok bool) float64 {
+	if ok {
+		return 1.0
+	}
+	return 0.0
+}
@@ -0,0 +1,551 @@
+package compiler
+
+import (
+	"fmt"
+	"go/format"
+	"lightlang/builtins"
+	"lightlang/parser"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// goOps are the binary operators and the functions of package gort that
+// do them.
+var goOps = map[string]string{
+	"+": "Add", "-": "Sub", "*": "Mul", "/": "Div",
+	"==": "Eq", "!=": "Ne", "<": "Lt", "<=": "Lte", ">": "Gt", ">=": "Gte",
+	"&": "BitAnd", "|": "BitOr", "^": "BitXor", "<<": "Shl", ">>": "Shr",
+}
+
+// goScope holds the locals of the function being translated: parameters
+// and loop variables, as in the VM. A local declared again, like the
+// variable of a second loop, gets a new Go variable.
+type goScope struct {
+	locals map[string]string
+	vars   []string
+	// fn is set in functions, which run after the top level
+	fn bool
+}
+
+type goWriter struct {
+	file     string
+	taken    map[string]bool
+	globals  map[string]string
+	builtins map[string]string
+	scope    *goScope
+	// direct are the functions that calls by name go straight to, and
+	// funcs the Go functions written for them
+	direct map[string]*goFunc
+	funcs  strings.Builder
+	// topVars are the variables of the top level and assigned the ones
+	// assigned so far, see moduleCall
+	topVars  map[string]bool
+	assigned map[string]bool
+}
+
+type goFunc struct {
+	ident  string
+	params int
+}
+
+// GoSource translates a parsed program into a Go main package that runs
+// it with package gort. Line directives point the Go code back at file,
+// so runtime errors name the lightlang line. Nodes should have passed the
+// compiler, which GoSource does not check them with.
+func GoSource(file string, nodes []parser.Node) ([]byte, error) {
+	w := &goWriter{file: file, taken: make(map[string]bool), globals: make(map[string]string), builtins: make(map[string]string)}
+	w.topVars, w.assigned = topLevelVars(nodes), make(map[string]bool)
+	w.findDirect(nodes)
+	_, run, err := w.function(nil, nodes, false)
+	if err != nil {
+		return nil, parser.WrapError(err, file, "", parser.Pos{})
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "// Code generated by lightlang build --emit=go from %s. DO NOT EDIT.\n\n", file)
+	out.WriteString("package main\n\nimport \"lightlang/gort\"\n\n")
+	writeVars(&out, w.globals, func(name string) string { return "interface{}" })
+	writeVars(&out, w.builtins, func(name string) string { return "= gort.Lookup(" + strconv.Quote(name) + ")" })
+	out.WriteString("func main() {\n\tgort.Main(func() interface{} " + run + ")\n}\n\n")
+	out.WriteString(w.funcs.String())
+	src, err := format.Source([]byte(out.String()))
+	if err != nil {
+		return nil, fmt.Errorf("%s: generated invalid Go: %v", file, err)
+	}
+	return src, nil
+}
+
+func writeVars(out *strings.Builder, vars map[string]string, decl func(name string) string) {
+	if len(vars) == 0 {
+		return
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	out.WriteString("var (\n")
+	for _, name := range names {
+		fmt.Fprintf(out, "\t%s %s\n", vars[name], decl(name))
+	}
+	out.WriteString(")\n\n")
+}
+
+// ident makes a Go name for a lightlang name that no other name has.
+// Macros rename variables to names with a #, which Go does not allow.
+func (w *goWriter) ident(prefix, name string) string {
+	base := prefix + strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+	id := base
+	for i := 2; w.taken[id]; i++ {
+		id = base + strconv.Itoa(i)
+	}
+	w.taken[id] = true
+	return id
+}
+
+func (w *goWriter) global(name string) string {
+	id, ok := w.globals[name]
+	if !ok {
+		id = w.ident("g_", name)
+		w.globals[name] = id
+	}
+	return id
+}
+
+func (w *goWriter) builtin(name string) string {
+	id, ok := w.builtins[name]
+	if !ok {
+		id = w.ident("b_", name)
+		w.builtins[name] = id
+	}
+	return id
+}
+
+func (w *goWriter) define(name string) string {
+	id := w.ident("l_", name)
+	w.scope.locals[name] = id
+	w.scope.vars = append(w.scope.vars, id)
+	return id
+}
+
+func (w *goWriter) variable(name string) string {
+	if id, ok := w.scope.locals[name]; ok {
+		return id
+	}
+	return w.global(name)
+}
+
+// moduleCall is Builder.moduleCall for Go.
+func (w *goWriter) moduleCall(n *parser.CallNode) (string, bool) {
+	name, ok := moduleBuiltin(n)
+	if !ok {
+		return "", false
+	}
+	module := n.IndirectTarget.(*parser.IndexAccessNode).Table.(*parser.VariableNode).Name
+	return name, !w.isVariable(module)
+}
+
+// isVariable is Builder.isVariable for Go.
+func (w *goWriter) isVariable(name string) bool {
+	if _, ok := w.scope.locals[name]; ok {
+		return true
+	}
+	if _, ok := w.direct[name]; ok || w.assigned[name] {
+		return true
+	}
+	return w.scope.fn && w.topVars[name]
+}
+
+// findDirect picks the functions defined once, at the top level, whose
+// names are never assigned. Their value cannot change once defined, so a
+// call by name can skip looking it up.
+func (w *goWriter) findDirect(nodes []parser.Node) {
+	defs := make(map[string]int)
+	for _, n := range nodes {
+		parser.Walk(n, func(n parser.Node) {
+			switch n := n.(type) {
+			case *parser.FuncDefNode:
+				defs[n.Name]++
+			case *parser.AssignmentNode:
+				defs[n.Name] += 2
+			}
+		})
+	}
+	w.direct = make(map[string]*goFunc)
+	for _, n := range nodes {
+		fn, ok := n.(*parser.FuncDefNode)
+		if ok {
+			w.assigned[fn.Name] = true
+		}
+		if ok && defs[fn.Name] == 1 {
+			w.direct[fn.Name] = &goFunc{ident: w.ident("f_", fn.Name), params: len(fn.Params)}
+		}
+	}
+}
+
+// function translates a function body into the parameters and block of a
+// Go func. Parameters are passed as args []interface{}, or with direct as
+// one Go parameter each.
+func (w *goWriter) function(params []string, body []parser.Node, direct bool) (string, string, error) {
+	outer := w.scope
+	w.scope = &goScope{locals: make(map[string]string), fn: outer != nil}
+	defer func() { w.scope = outer }()
+
+	var head strings.Builder
+	sig := "args []interface{}"
+	if direct {
+		if len(params) > 0 {
+			ids := make([]string, len(params))
+			for i, param := range params {
+				ids[i] = w.define(param)
+			}
+			sig = strings.Join(ids, ", ") + " interface{}"
+		} else {
+			sig = ""
+		}
+	} else {
+		for i, param := range params {
+			fmt.Fprintf(&head, "%s := gort.Arg(args, %d)\n", w.define(param), i)
+		}
+	}
+	if outer != nil {
+		head.WriteString("gort.Enter()\ndefer gort.Leave()\n")
+	}
+	declared := len(w.scope.vars)
+	var b strings.Builder
+	if err := w.block(&b, body); err != nil {
+		return "", "", err
+	}
+	if len(body) == 0 {
+		b.WriteString("return nil\n")
+	} else if _, ok := body[len(body)-1].(*parser.ReturnNode); !ok {
+		b.WriteString("return nil\n")
+	}
+	for _, id := range w.scope.vars[declared:] {
+		fmt.Fprintf(&head, "var %s interface{}\n", id)
+	}
+	for _, id := range w.scope.vars {
+		fmt.Fprintf(&head, "_ = %s\n", id)
+	}
+	return sig, "{\n" + head.String() + b.String() + "}", nil
+}
+
+func (w *goWriter) funcLit(name string, params []string, body []parser.Node) (string, error) {
+	_, block, err := w.function(params, body, false)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("gort.Func(%s, %d, func(args []interface{}) interface{} %s)", strconv.Quote(name), len(params), block), nil
+}
+
+// directFunc writes n as the Go function fn and returns its value, which
+// calls that.
+func (w *goWriter) directFunc(n *parser.FuncDefNode, fn *goFunc) (string, error) {
+	sig, block, err := w.function(n.Params, n.Body, true)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&w.funcs, "func %s(%s) interface{} %s\n\n", fn.ident, sig, block)
+	args := make([]string, len(n.Params))
+	for i := range args {
+		args[i] = fmt.Sprintf("gort.Arg(args, %d)", i)
+	}
+	return fmt.Sprintf("gort.Func(%s, %d, func(args []interface{}) interface{} {\nreturn %s(%s)\n})", strconv.Quote(n.Name), len(n.Params), fn.ident, strings.Join(args, ", ")), nil
+}
+
+func (w *goWriter) block(b *strings.Builder, nodes []parser.Node) error {
+	for _, n := range nodes {
+		if pos := n.Position(); pos.Line > 0 {
+			fmt.Fprintf(b, "/*line %s:%d:%d*/ ", w.file, pos.Line, max(pos.Col, 1))
+		}
+		if err := w.stmt(b, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *goWriter) stmt(b *strings.Builder, n parser.Node) error {
+	switch n := n.(type) {
+	case *parser.AssignmentNode, *parser.IndexAssignNode, *parser.ExprStmtNode:
+		s, err := w.simple(n)
+		if err != nil {
+			return err
+		}
+		b.WriteString(s + "\n")
+	case *parser.FuncDefNode:
+		var fn string
+		var err error
+		if direct, ok := w.direct[n.Name]; ok {
+			fn, err = w.directFunc(n, direct)
+		} else {
+			fn, err = w.funcLit(n.Name, n.Params, n.Body)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "%s = %s\n", w.global(n.Name), fn)
+	case *parser.IfNode:
+		for i, cond := range n.Conditions {
+			c, err := w.expr(cond)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				b.WriteString(" else ")
+			}
+			fmt.Fprintf(b, "if gort.Truthy(%s) {\n", c)
+			if err := w.block(b, n.Bodies[i]); err != nil {
+				return err
+			}
+			b.WriteString("}")
+		}
+		if len(n.ElseBody) > 0 {
+			b.WriteString(" else {\n")
+			if err := w.block(b, n.ElseBody); err != nil {
+				return err
+			}
+			b.WriteString("}")
+		}
+		b.WriteString("\n")
+	case *parser.WhileLoopNode:
+		c, err := w.expr(n.Condition)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "for gort.Truthy(%s) {\n", c)
+		if err := w.block(b, n.Body); err != nil {
+			return err
+		}
+		b.WriteString("}\n")
+	case *parser.ForLoopNode:
+		return w.forLoop(b, n)
+	case *parser.ReturnNode:
+		if n.Value == nil {
+			b.WriteString("return nil\n")
+			return nil
+		}
+		v, err := w.expr(n.Value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "return %s\n", v)
+	case *parser.BreakNode:
+		b.WriteString("break\n")
+	case *parser.ContinueNode:
+		b.WriteString("continue\n")
+	case *parser.TypeDefNode:
+		// types are only checked when compiling
+		b.WriteString("\n")
+	case *parser.AsmNode:
+		return &parser.SourceError{Pos: n.Pos, Err: fmt.Errorf("asm blocks cannot be translated to Go")}
+	default:
+		return &parser.SourceError{Pos: n.Position(), Err: fmt.Errorf("cannot translate %T to Go", n)}
+	}
+	return nil
+}
+
+// simple translates a statement that Go allows in the header of a for
+// loop.
+func (w *goWriter) simple(n parser.Node) (string, error) {
+	switch n := n.(type) {
+	case *parser.AssignmentNode:
+		v, err := w.expr(n.Expr)
+		if err != nil {
+			return "", err
+		}
+		w.assigned[n.Name] = true
+		target := w.variable(n.Name)
+		if n.IsLocal {
+			target = w.define(n.Name)
+		}
+		return target + " = " + v, nil
+	case *parser.IndexAssignNode:
+		args, err := w.exprs(n.Table, n.Index, n.Value)
+		if err != nil {
+			return "", err
+		}
+		return "gort.SetIndex(" + args + ")", nil
+	case *parser.ExprStmtNode:
+		return w.simple(n.Expr)
+	case *parser.CallNode:
+		return w.expr(n)
+	}
+	v, err := w.expr(n)
+	if err != nil {
+		return "", err
+	}
+	return "_ = " + v, nil
+}
+
+func (w *goWriter) forLoop(b *strings.Builder, n *parser.ForLoopNode) error {
+	if n.Type == "in" {
+		coll, err := w.expr(n.Collection)
+		if err != nil {
+			return err
+		}
+		v := w.define(n.LoopVar)
+		fmt.Fprintf(b, "{\ncoll := %s\nfor i := 0; ; i++ {\nv, ok := gort.Next(coll, i)\nif !ok {\nbreak\n}\n%s = v\n", coll, v)
+		if err := w.block(b, n.Body); err != nil {
+			return err
+		}
+		b.WriteString("}\n}\n")
+		return nil
+	}
+	var init, cond, update string
+	var err error
+	if n.Init != nil {
+		if init, err = w.simple(n.Init); err != nil {
+			return err
+		}
+	}
+	cond = "true"
+	if n.Cond != nil {
+		if cond, err = w.expr(n.Cond); err != nil {
+			return err
+		}
+		cond = "gort.Truthy(" + cond + ")"
+	}
+	if n.Update != nil {
+		if update, err = w.simple(n.Update); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(b, "%s\nfor ; %s; %s {\n", init, cond, update)
+	if err := w.block(b, n.Body); err != nil {
+		return err
+	}
+	b.WriteString("}\n")
+	return nil
+}
+
+func (w *goWriter) exprs(nodes ...parser.Node) (string, error) {
+	parts := make([]string, len(nodes))
+	for i, n := range nodes {
+		var err error
+		if parts[i], err = w.expr(n); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(parts, ", "), nil
+}
+
+func (w *goWriter) expr(n parser.Node) (string, error) {
+	switch n := n.(type) {
+	case *parser.LiteralNode:
+		return goLiteral(n)
+	case *parser.VariableNode:
+		return w.variable(n.Name), nil
+	case *parser.UnaryOpNode:
+		right, err := w.expr(n.Right)
+		if err != nil {
+			return "", err
+		}
+		if n.Op == "~" {
+			return "gort.BitNot(" + right + ")", nil
+		}
+		return "gort.Not(" + right + ")", nil
+	case *parser.BinaryOpNode:
+		left, err := w.expr(n.Left)
+		if err != nil {
+			return "", err
+		}
+		right, err := w.expr(n.Right)
+		if err != nil {
+			return "", err
+		}
+		// the right side of a short circuit only runs when the left
+		// one does not decide
+		test := map[string]string{"and": "!gort.Truthy(v)", "or": "gort.Truthy(v)", "??": "v != nil"}[n.Op]
+		if test != "" {
+			return fmt.Sprintf("func(v interface{}) interface{} {\nif %s {\nreturn v\n}\nreturn %s\n}(%s)", test, right, left), nil
+		}
+		op, ok := goOps[n.Op]
+		if !ok {
+			return "", &parser.SourceError{Pos: n.Pos, Err: fmt.Errorf("unknown operator %s", n.Op)}
+		}
+		return fmt.Sprintf("gort.%s(%s, %s)", op, left, right), nil
+	case *parser.CallNode:
+		args, err := w.exprs(n.Args...)
+		if err != nil {
+			return "", err
+		}
+		if args != "" {
+			args = ", " + args
+		}
+		if name, ok := w.moduleCall(n); ok {
+			return "gort.Builtin(" + w.builtin(name) + args + ")", nil
+		}
+		if n.CallType != "direct" {
+			fn, err := w.expr(n.IndirectTarget)
+			if err != nil {
+				return "", err
+			}
+			return "gort.CallValue(" + fn + args + ")", nil
+		}
+		if _, ok := builtins.Builtins[n.Target]; ok && !w.isVariable(n.Target) {
+			return "gort.Builtin(" + w.builtin(n.Target) + args + ")", nil
+		}
+		if id, ok := w.scope.locals[n.Target]; ok {
+			return "gort.CallValue(" + id + args + ")", nil
+		}
+		if fn, ok := w.direct[n.Target]; ok && fn.params == len(n.Args) {
+			return fn.ident + "(" + strings.TrimPrefix(args, ", ") + ")", nil
+		}
+		return fmt.Sprintf("gort.Call(%s, %s%s)", w.global(n.Target), strconv.Quote(n.Target), args), nil
+	case *parser.TableLiteralNode:
+		values, err := w.exprs(n.Values...)
+		if err != nil {
+			return "", err
+		}
+		if n.IsArray {
+			return "[]interface{}{" + values + "}", nil
+		}
+		var kv []string
+		for i, k := range n.Keys {
+			v, err := w.expr(n.Values[i])
+			if err != nil {
+				return "", err
+			}
+			kv = append(kv, strconv.Quote(k), v)
+		}
+		return "gort.Table(" + strings.Join(kv, ", ") + ")", nil
+	case *parser.IndexAccessNode:
+		args, err := w.exprs(n.Table, n.Index)
+		if err != nil {
+			return "", err
+		}
+		return "gort.Index(" + args + ")", nil
+	case *parser.AnonymousFuncNode:
+		return w.funcLit("", n.Params, n.Body)
+	}
+	return "", &parser.SourceError{Pos: n.Position(), Err: fmt.Errorf("cannot translate %T to Go", n)}
+}
+
+func goLiteral(n *parser.LiteralNode) (string, error) {
+	switch v := n.Value.(type) {
+	case nil:
+		return "nil", nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case string:
+		return strconv.Quote(v), nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return "", &parser.SourceError{Pos: n.Pos, Err: fmt.Errorf("number %v cannot be written in Go", v)}
+		}
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s, nil
+	}
+	return "", &parser.SourceError{Pos: n.Pos, Err: fmt.Errorf("cannot translate %T constant to Go", n.Value)}
+}
@@ -0,0 +1,285 @@
+package compiler
+
+import (
+	"fmt"
+	"lightlang/parser"
+)
+
+// builtinResults are the types builtins return whatever they are given.
+var builtinResults = map[string]string{
+	"len": "number", "sqrt": "number", "abs": "number", "pow": "number",
+	"sin": "number", "cos": "number", "tan": "number", "log": "number",
+	"exp": "number", "floor": "number", "ceil": "number", "round": "number",
+	"clamp": "number", "lerp": "number", "max": "number", "min": "number",
+	"tick": "number", "time": "number", "random": "number", "find": "number",
+	"bool": "bool", "tostring": "string", "type": "string", "upper": "string", "lower": "string",
+	"substr": "string", "concat": "string", "replace": "string",
+	"split": "[string]", "keys": "[string]", "range": "[number]", "args": "[string]",
+	"bytes_of": "[number]", "repr": "string", "build": "string",
+	"set_timeout": "number", "set_interval": "number", "cancel": "bool", "events.emitter": "table", "events.emit": "number",
+	"stream.collect": "array", "bigint": "bigint", "decimal": "decimal",
+	"decimal.div": "decimal", "decimal.round": "decimal",
+	"vec_add": "[number]", "vec_sub": "[number]", "vec_mul": "[number]", "vec_div": "[number]",
+	"path.join": "string", "path.dir": "string", "path.base": "string", "path.ext": "string",
+	"path.clean": "string", "path.abs": "string", "glob": "[string]", "listdir": "[table]",
+	"tempfile": "string", "tempdir": "string", "flags.parse": "table", "benchmark": "table", "memstats": "table", "collect": "number",
+	"prompt": "string", "password": "string", "confirm": "bool",
+	"color.fg": "string", "color.bg": "string", "color.bold": "string", "color.dim": "string", "color.italic": "string",
+	"color.underline": "string", "color.black": "string", "color.red": "string", "color.green": "string",
+	"color.yellow": "string", "color.blue": "string", "color.magenta": "string", "color.cyan": "string",
+	"color.white": "string", "color.gray": "string", "color.enabled": "bool", "term_size": "table",
+	"ordered_table": "table", "bsearch": "number", "dot": "number", "norm": "number", "transpose": "array", "matmul": "array",
+}
+
+// typeOf infers the type of the expression n from literals, operators and
+// what is known of the variables it reads. It is "any" when that depends on
+// how the program runs.
+func typeOf(n parser.Node, sym *SymbolTable) string {
+	switch n := n.(type) {
+	case *parser.LiteralNode:
+		if n.Value == nil {
+			return "nil"
+		}
+		return n.Type
+	case *parser.TableLiteralNode:
+		if !n.IsArray {
+			return "table"
+		}
+		// [1, 2] is a [number], mixed or unknown elements make an array
+		elem := ""
+		for _, val := range n.Values {
+			typ := typeOf(val, sym)
+			if typ == "any" || elem != "" && typ != elem {
+				return "array"
+			}
+			elem = typ
+		}
+		if elem == "" {
+			return "array"
+		}
+		return "[" + elem + "]"
+	case *parser.AnonymousFuncNode:
+		return "function"
+	case *parser.VariableNode:
+		return sym.varType(n.Name)
+	case *parser.UnaryOpNode:
+		if n.Op == "~" {
+			return "number"
+		}
+		return "bool"
+	case *parser.BinaryOpNode:
+		switch n.Op {
+		case "-", "*", "/":
+			if typ := sym.exact(n); typ != "" {
+				return typ
+			}
+			return "number"
+		case "&", "|", "^", "<<", ">>":
+			return "number"
+		case "==", "!=", "<", "<=", ">", ">=":
+			return "bool"
+		case "+":
+			lt, _ := sym.nonNil(typeOf(n.Left, sym))
+			rt, _ := sym.nonNil(typeOf(n.Right, sym))
+			if elem := elemType(lt); elem != "" {
+				// concatenating, or appending an element
+				if sym.assignable(rt, lt) || rt != "array" && elemType(rt) == "" && sym.assignable(rt, elem) {
+					return lt
+				}
+				return "array"
+			}
+			if lt == "array" {
+				return "array"
+			}
+			if lt == "string" || rt == "string" {
+				return "string"
+			}
+			if typ := sym.exact(n); typ != "" {
+				return typ
+			}
+			if lt == "number" && rt == "number" {
+				return "number"
+			}
+		case "??":
+			lt, _ := sym.nonNil(typeOf(n.Left, sym))
+			if sym.assignable(typeOf(n.Right, sym), lt) {
+				return lt
+			}
+		}
+	case *parser.IndexAccessNode:
+		table, _ := sym.nonNil(typeOf(n.Table, sym))
+		if table == "string" {
+			return "string"
+		}
+		if elem := elemType(table); elem != "" {
+			return elem
+		}
+		typ, _ := sym.field(table, n.Index)
+		return typ
+	case *parser.CallNode:
+		if n.CallType != "direct" {
+			if fn, ok := n.IndirectTarget.(*parser.AnonymousFuncNode); ok && fn.ReturnType != "" {
+				return fn.ReturnType
+			}
+			return "any"
+		}
+		if typ, ok := builtinResults[n.Target]; ok {
+			return typ
+		}
+		if sig := sym.Signature(n.Target); sig != nil {
+			if len(sig.TypeParams) > 0 {
+				return subst(sig.Return, sig.TypeParams, sym.bind(sig, n.Args))
+			}
+			return sig.Return
+		}
+	}
+	return "any"
+}
+
+// numeric types can be used in arithmetic; booleans are numbers at run time.
+func (s *SymbolTable) numeric(typ string) bool {
+	typ, _ = s.nonNil(typ)
+	return typ == "number" || typ == "bool" || typ == "bigint" || typ == "decimal" || typ == "any"
+}
+
+// exact is the type of arithmetic n with a decimal or bigint operand,
+// decimal if there is one, or "" when there are neither.
+func (s *SymbolTable) exact(n *parser.BinaryOpNode) string {
+	lt, _ := s.nonNil(typeOf(n.Left, s))
+	rt, _ := s.nonNil(typeOf(n.Right, s))
+	switch {
+	case lt == "decimal" || rt == "decimal":
+		return "decimal"
+	case lt == "bigint" || rt == "bigint":
+		return "bigint"
+	}
+	return ""
+}
+
+func (s *SymbolTable) indexable(typ string) bool {
+	typ, _ = s.nonNil(typ)
+	return typ == "array" || typ == "table" || typ == "string" || typ == "any" || elemType(typ) != "" || s.shape(typ) != nil
+}
+
+// checkOperands rejects arithmetic and ordering of values that are not
+// numbers, which the VM would silently treat as 0, ordering a string
+// against a number, and in strict mode the
+// use of values that may be nil.
+func checkOperands(n *parser.BinaryOpNode, sym *SymbolTable) error {
+	switch n.Op {
+	case "+", "-", "*", "/", "<", "<=", ">", ">=", "&", "|", "^", "<<", ">>":
+	default:
+		return nil
+	}
+	if err := checkNotNil(n.Left, sym); err != nil {
+		return err
+	}
+	if err := checkNotNil(n.Right, sym); err != nil {
+		return err
+	}
+	if n.Op == "+" {
+		return nil
+	}
+	lt, rt := typeOf(n.Left, sym), typeOf(n.Right, sym)
+	switch n.Op {
+	case "<", "<=", ">", ">=":
+		l, _ := sym.nonNil(lt)
+		r, _ := sym.nonNil(rt)
+		switch {
+		case l == "string" && (r == "string" || r == "any"), r == "string" && l == "any":
+			return nil
+		case l == "string" && sym.numeric(r), r == "string" && sym.numeric(l):
+			return fmt.Errorf("cannot compare %s with %s", lt, rt)
+		}
+	}
+	if !sym.numeric(lt) || !sym.numeric(rt) {
+		return fmt.Errorf("cannot use %s %s %s, %s needs numbers", lt, n.Op, rt, n.Op)
+	}
+	return nil
+}
+
+// scope finds the table that declares the local name.
+func (s *SymbolTable) scope(name string) *SymbolTable {
+	for t := s; t != nil; t = t.Parent {
+		if _, ok := t.Locals[name]; ok {
+			return t
+		}
+		if t.IsFunc {
+			break
+		}
+	}
+	return nil
+}
+
+// inFunc tells whether s is the scope of a function body.
+func (s *SymbolTable) inFunc() bool {
+	for t := s; t != nil; t = t.Parent {
+		if t.IsFunc {
+			return true
+		}
+	}
+	return false
+}
+
+// varType is what is known of the variable name at this point of the
+// program. Globals are only followed at the top level, and not those that
+// functions assign, since a call can change them.
+func (s *SymbolTable) varType(name string) string {
+	if t := s.scope(name); t != nil {
+		if typ, ok := t.Types[name]; ok {
+			return typ
+		}
+		return "any"
+	}
+	if s.Signature(name) != nil {
+		return "function"
+	}
+	if v, ok := s.constant(name); ok {
+		return getTypeString(v)
+	}
+	root := s.root()
+	if s.inFunc() || root.dynamic || root.changed[name] {
+		return "any"
+	}
+	if typ, ok := root.Types[name]; ok {
+		return typ
+	}
+	return "any"
+}
+
+// setType records the type of a variable after an assignment.
+func (s *SymbolTable) setType(name, typ string) {
+	t := s.scope(name)
+	if t == nil {
+		t = s.root()
+		if s.inFunc() {
+			// any call may run this assignment from now on
+			if t.changed == nil {
+				t.changed = make(map[string]bool)
+			}
+			t.changed[name] = true
+			return
+		}
+	}
+	if t.Types == nil {
+		t.Types = make(map[string]string)
+	}
+	t.Types[name] = typ
+}
+
+// forget drops what is known of the variables assigned in nodes, for code
+// that may run in another order or not at all, like loop bodies and
+// branches.
+func (s *SymbolTable) forget(nodes ...parser.Node) {
+	for _, node := range nodes {
+		parser.Walk(node, func(n parser.Node) {
+			if a, ok := n.(*parser.AssignmentNode); ok {
+				t := s.scope(a.Name)
+				if t == nil {
+					t = s.root()
+				}
+				delete(t.Types, a.Name)
+			}
+		})
+	}
+}
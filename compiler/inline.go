@@ -0,0 +1,210 @@
+package compiler
+
+import (
+	"lightlang/builtins"
+	"lightlang/bytecode"
+)
+
+// Inline enables inlining of small functions into their call sites
+// (--inline).
+var Inline bool
+
+// maxInlineBody is the largest function body, without its return, that is
+// copied into a call site.
+const maxInlineBody = 8
+
+// pureEffect gives the net stack effect of instructions that have no side
+// effects and cannot jump. Only such instructions are inlined or moved.
+var pureEffect = map[bytecode.OpCode]int{
+	bytecode.OpConstant:  1,
+	bytecode.OpGetLocal:  1,
+	bytecode.OpGetGlobal: 1,
+	bytecode.OpAdd:       -1,
+	bytecode.OpSub:       -1,
+	bytecode.OpMul:       -1,
+	bytecode.OpDiv:       -1,
+	bytecode.OpCmpEq:     -1,
+	bytecode.OpCmpNe:     -1,
+	bytecode.OpCmpLt:     -1,
+	bytecode.OpCmpLte:    -1,
+	bytecode.OpCmpGt:     -1,
+	bytecode.OpCmpGte:    -1,
+	bytecode.OpNot:       0,
+}
+
+// inlineCandidates returns the bodies of functions that are bound once to a
+// global and compute a single expression of their parameters, keyed by the
+// global name.
+func (o *Optimizer) inlineCandidates() map[string][]bytecode.Instruction {
+	sets := make(map[string]int)
+	for _, inst := range o.Instructions {
+		if inst.Op == bytecode.OpSetGlobal {
+			sets[o.name(inst)]++
+		}
+	}
+
+	candidates := make(map[string][]bytecode.Instruction)
+	for i := 0; i+1 < len(o.Instructions); i++ {
+		if o.Instructions[i].Op != bytecode.OpMakeFunc || o.Instructions[i+1].Op != bytecode.OpSetGlobal {
+			continue
+		}
+		name := o.name(o.Instructions[i+1])
+		if _, ok := builtins.Builtins[name]; ok || sets[name] != 1 {
+			continue
+		}
+		idx := o.Instructions[i].Arg
+		if idx < 0 || idx >= len(o.Constants) {
+			continue
+		}
+		entry, ok := bytecode.ArgInt(o.Constants[idx].Value)
+		if !ok || entry < 0 {
+			continue
+		}
+		if body, ok := o.inlineBody(entry); ok {
+			candidates[name] = body
+		}
+	}
+	return candidates
+}
+
+// inlineBody returns the instructions of the function at entry up to its
+// return if they are pure and leave exactly one value.
+func (o *Optimizer) inlineBody(entry int) ([]bytecode.Instruction, bool) {
+	depth := 0
+	for ip := entry; ip < len(o.Instructions) && ip-entry <= maxInlineBody; ip++ {
+		inst := o.Instructions[ip]
+		if inst.Op == bytecode.OpReturn {
+			return o.Instructions[entry:ip], depth == 1
+		}
+		effect, ok := pureEffect[inst.Op]
+		if !ok || inst.Op == bytecode.OpGetLocal && inst.Arg < 0 {
+			return nil, false
+		}
+		if depth += effect; depth < 1 {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// callArgs splits the pure instructions before a call at ip into one run
+// per argument. It returns the index of the first argument instruction.
+func (o *Optimizer) callArgs(ip, count int) ([][]bytecode.Instruction, int, bool) {
+	args := make([][]bytecode.Instruction, count)
+	end := ip
+	for a := count - 1; a >= 0; a-- {
+		net := 0
+		start := end - 1
+		for ; start >= 0; start-- {
+			effect, ok := pureEffect[o.Instructions[start].Op]
+			if !ok {
+				return nil, 0, false
+			}
+			if net += effect; net == 1 {
+				break
+			}
+		}
+		if start < 0 {
+			return nil, 0, false
+		}
+		args[a] = o.Instructions[start:end]
+		end = start
+	}
+	return args, end, true
+}
+
+// doInlining replaces calls of small functions with their bodies, loading
+// the arguments where the body reads its parameters. Calls are visited in
+// order, so a call in an argument is inlined before the call around it.
+func (o *Optimizer) doInlining() bool {
+	candidates := o.inlineCandidates()
+	changed := false
+	for ip := 1; ip < len(o.Instructions); ip++ {
+		call := o.Instructions[ip]
+		if call.Op != bytecode.OpCall {
+			continue
+		}
+		body, ok := candidates[o.name(call)]
+		if !ok {
+			continue
+		}
+		args, start, ok := o.callArgs(ip, bytecode.CallCount(call.Arg))
+		if !ok {
+			continue
+		}
+		if code, ok := expandBody(body, args, call); ok && !o.targetsWithin(start+1, ip) {
+			o.splice(start, ip+1, code)
+			ip = start + len(code) - 1
+			changed = true
+		}
+	}
+	return changed
+}
+
+// expandBody substitutes args for the parameter loads of body. An argument
+// that takes more than one instruction may only be read once.
+func expandBody(body []bytecode.Instruction, args [][]bytecode.Instruction, call bytecode.Instruction) ([]bytecode.Instruction, bool) {
+	reads := make([]int, len(args))
+	for _, inst := range body {
+		if inst.Op == bytecode.OpGetLocal {
+			slot := inst.Arg
+			if slot >= len(args) {
+				return nil, false
+			}
+			if reads[slot]++; reads[slot] > 1 && len(args[slot]) > 1 {
+				return nil, false
+			}
+		}
+	}
+	var code []bytecode.Instruction
+	for _, inst := range body {
+		if inst.Op == bytecode.OpGetLocal {
+			code = append(code, args[inst.Arg]...)
+			continue
+		}
+		inst.Line, inst.Col = call.Line, call.Col
+		code = append(code, inst)
+	}
+	return code, true
+}
+
+// targetsWithin reports whether control can enter [from, to] other than by
+// falling through.
+func (o *Optimizer) targetsWithin(from, to int) bool {
+	targets := o.jumpTargets()
+	for ip := from; ip <= to; ip++ {
+		if targets[ip] {
+			return true
+		}
+	}
+	return false
+}
+
+// splice replaces instructions [from, to) with code and moves jumps and
+// function entries after them.
+func (o *Optimizer) splice(from, to int, code []bytecode.Instruction) {
+	delta := len(code) - (to - from)
+	remap := func(ip int) int {
+		if ip >= to {
+			return ip + delta
+		}
+		return ip
+	}
+	result := make([]bytecode.Instruction, 0, len(o.Instructions)+delta)
+	result = append(result, o.Instructions[:from]...)
+	result = append(result, code...)
+	result = append(result, o.Instructions[to:]...)
+	for i := range result {
+		if bytecode.IsJump(result[i].Op) {
+			result[i].Arg = remap(result[i].Arg)
+		}
+	}
+	for i, c := range o.Constants {
+		if c.Type == "funcptr" {
+			if e, ok := bytecode.ArgInt(c.Value); ok {
+				o.Constants[i].Value = float64(remap(e))
+			}
+		}
+	}
+	o.Instructions = result
+}
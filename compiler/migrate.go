@@ -0,0 +1,73 @@
+package compiler
+
+import (
+	"fmt"
+	"lightlang/bytecode"
+	"os"
+)
+
+// ReadBytecode reads a program with r, migrating the calls of files from
+// before 3.11, see MigrateCalls.
+func ReadBytecode(r *bytecode.BytecodeReader) ([]bytecode.Instruction, []bytecode.Constant, error) {
+	instructions, constants, err := r.ReadBytecode()
+	if err != nil || !bytecode.OldCalls(r.Major, r.Minor) {
+		return instructions, constants, err
+	}
+	return MigrateCalls(instructions, constants)
+}
+
+// LoadBytecode reads the bytecode file filename like ReadBytecode.
+func LoadBytecode(filename string) ([]bytecode.Instruction, []bytecode.Constant, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	return ReadBytecode(bytecode.NewBytecodeReader(file))
+}
+
+// MigrateCalls rewrites a program whose calls push their argument count as
+// a constant, as bytecode before 3.11 does, so the count is the operand of
+// the call. The constants become OpNop, so instruction indexes, which
+// function values hold, stay where they were.
+func MigrateCalls(instructions []bytecode.Instruction, constants []bytecode.Constant) ([]bytecode.Instruction, []bytecode.Constant, error) {
+	o := NewOptimizer(instructions, constants, nil)
+	if err := o.migrateCalls(); err != nil {
+		return nil, nil, err
+	}
+	o.doGarbageCollection()
+	return o.Instructions, o.Constants, nil
+}
+
+func (o *Optimizer) migrateCalls() error {
+	targets := o.jumpTargets()
+	for ip, inst := range o.Instructions {
+		if inst.Op != bytecode.OpCall && inst.Op != bytecode.OpCallIndirect {
+			continue
+		}
+		count, ok := o.callCount(ip - 1)
+		if !ok || targets[ip] {
+			return fmt.Errorf("instruction %d: the argument count of the call is not a constant before it", ip)
+		}
+		o.Instructions[ip-1] = bytecode.Instruction{Op: bytecode.OpNop, Line: inst.Line, Col: inst.Col}
+		if inst.Op == bytecode.OpCall {
+			o.Instructions[ip].Arg = bytecode.CallOperand(inst.Arg, count)
+		} else {
+			o.Instructions[ip].Arg = count
+		}
+	}
+	return nil
+}
+
+// callCount returns the argument count pushed by the instruction at ip.
+func (o *Optimizer) callCount(ip int) (int, bool) {
+	if ip < 0 || o.Instructions[ip].Op != bytecode.OpConstant {
+		return 0, false
+	}
+	idx := o.Instructions[ip].Arg
+	if idx < 0 || idx >= len(o.Constants) || o.Constants[idx].Type != "number" {
+		return 0, false
+	}
+	n, ok := bytecode.ArgInt(o.Constants[idx].Value)
+	return n, ok && n >= 0 && n <= bytecode.MaxCallArgs
+}
@@ -0,0 +1,124 @@
+package compiler
+
+import (
+	"fmt"
+	"lightlang/parser"
+	"slices"
+	"strings"
+)
+
+// A type written T? is a T or nil. Outside strict mode it is used as a T;
+// in strict mode a value that may be nil has to be checked against nil or
+// given a default with ?? before it is used as a number, a string or a
+// table.
+
+// nonNil returns typ without the ? of a nullable type, and whether it had
+// one.
+func (s *SymbolTable) nonNil(typ string) (string, bool) {
+	typ = s.resolve(typ)
+	base, ok := strings.CutSuffix(typ, "?")
+	if !ok {
+		return typ, false
+	}
+	return s.resolve(base), true
+}
+
+// mayBeNil tells whether strict mode rejects using a typ without checking
+// it first.
+func (s *SymbolTable) mayBeNil(typ string) bool {
+	_, nullable := s.nonNil(typ)
+	return nullable && Strict
+}
+
+func nilError(n parser.Node, typ string) error {
+	if v, ok := n.(*parser.VariableNode); ok {
+		return fmt.Errorf("%s may be nil, check it first or use ??", v.Name)
+	}
+	return fmt.Errorf("cannot use a %s here, it may be nil; check it first or use ??", typ)
+}
+
+// checkNotNil rejects n in strict mode if it may be nil.
+func checkNotNil(n parser.Node, sym *SymbolTable) error {
+	if typ := typeOf(n, sym); sym.mayBeNil(typ) {
+		return nilError(n, typ)
+	}
+	return nil
+}
+
+// nonNilWhen returns the variables that cannot be nil when cond is truth,
+// as in if x != nil then ... end.
+func nonNilWhen(cond parser.Node, truth bool) []string {
+	switch n := cond.(type) {
+	case *parser.VariableNode:
+		if truth {
+			return []string{n.Name}
+		}
+	case *parser.UnaryOpNode:
+		if n.Op == "not" {
+			return nonNilWhen(n.Right, !truth)
+		}
+	case *parser.BinaryOpNode:
+		switch {
+		case n.Op == "and" && truth:
+			return append(nonNilWhen(n.Left, true), nonNilWhen(n.Right, true)...)
+		case n.Op == "!=" && truth, n.Op == "==" && !truth:
+			l, lok := n.Left.(*parser.VariableNode)
+			r, rok := n.Right.(*parser.VariableNode)
+			switch {
+			case lok && isNilLiteral(n.Right):
+				return []string{l.Name}
+			case rok && isNilLiteral(n.Left):
+				return []string{r.Name}
+			}
+		}
+	}
+	return nil
+}
+
+func isNilLiteral(n parser.Node) bool {
+	lit, ok := n.(*parser.LiteralNode)
+	return ok && lit.Value == nil
+}
+
+// narrow drops the ? from the types of the variables cond shows are not
+// nil when it is truth. The returned func puts the old types back.
+func (s *SymbolTable) narrow(cond parser.Node, truth bool) func() {
+	var undo []func()
+	for _, name := range nonNilWhen(cond, truth) {
+		base, nullable := s.nonNil(s.varType(name))
+		if !nullable {
+			continue
+		}
+		t := s.scope(name)
+		if t == nil {
+			t = s.root()
+		}
+		old, had := t.Types[name]
+		t.Types[name] = base
+		undo = append(undo, func() {
+			if had {
+				t.Types[name] = old
+			} else {
+				delete(t.Types, name)
+			}
+		})
+	}
+	return func() {
+		for _, u := range slices.Backward(undo) {
+			u()
+		}
+	}
+}
+
+// optionalParams counts the trailing parameters of sig that may be nil,
+// which calls can leave out.
+func (s *SymbolTable) optionalParams(sig *Signature) int {
+	n := 0
+	for i := len(sig.Params) - 1; i >= 0; i-- {
+		if _, nullable := s.nonNil(sig.Params[i]); !nullable {
+			break
+		}
+		n++
+	}
+	return n
+}
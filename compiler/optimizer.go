@@ -0,0 +1,407 @@
+package compiler
+
+import (
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"math"
+	"strconv"
+)
+
+type Optimizer struct {
+	Instructions []bytecode.Instruction
+	Constants    []bytecode.Constant
+	SymbolTable  *SymbolTable
+	// KeepNames starts as the package KeepNames.
+	KeepNames bool
+}
+
+type globalInfo struct {
+	name  string
+	usage int
+}
+
+func NewOptimizer(instructions []bytecode.Instruction, constants []bytecode.Constant, sym *SymbolTable) *Optimizer {
+	return &Optimizer{
+		Instructions: instructions,
+		Constants:    constants,
+		SymbolTable:  sym,
+		KeepNames:    KeepNames,
+	}
+}
+
+// KeepNames leaves globals alone, keeping their names and stores nobody
+// reads, so a debugger (--debug-listen) or a host program can reach them.
+var KeepNames bool
+
+func (o *Optimizer) Optimize() ([]bytecode.Instruction, []bytecode.Constant) {
+	// code run by eval and load looks globals up by name and may change
+	// them, so they are kept as written
+	dynamic := o.callsEval()
+	o.KeepNames = o.KeepNames || dynamic
+	for {
+		originalLen := len(o.Instructions)
+
+		o.doConstantFolding()
+
+		if !o.KeepNames {
+			o.doNameScraping()
+		}
+
+		o.doCleanup()
+
+		inlined := Inline && !dynamic && o.doInlining()
+
+		o.doPeephole()
+
+		o.removeNops()
+
+		if len(o.Instructions) == originalLen && !inlined {
+			break
+		}
+	}
+
+	o.doGarbageCollection()
+
+	return o.Instructions, o.Constants
+}
+
+func (o *Optimizer) callsEval() bool {
+	for _, inst := range o.Instructions {
+		if inst.Op == bytecode.OpCall {
+			if name := o.name(inst); name == "eval" || name == "load" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (o *Optimizer) doNameScraping() {
+	builtinlist := make(map[string]bool)
+	for name := range builtins.Builtins {
+		builtinlist[name] = true
+	}
+	globalUsage := make(map[string]int)
+	localUsage := make(map[int]int)
+	constantUsage := make(map[int]int)
+	// names the program never sets belong to the host, see vm.Register
+	defined := make(map[string]bool)
+
+	localNameMap := make(map[int]string)
+
+	for _, inst := range o.Instructions {
+		switch inst.Op {
+		case bytecode.OpGetGlobal, bytecode.OpSetGlobal, bytecode.OpCall:
+			if name := o.name(inst); name != "" {
+				globalUsage[name]++
+				defined[name] = defined[name] || inst.Op == bytecode.OpSetGlobal
+			}
+		case bytecode.OpGetLocal, bytecode.OpSetLocal:
+			localUsage[inst.Arg]++
+		case bytecode.OpConstant:
+			if inst.Arg >= 0 && inst.Arg < len(o.Constants) {
+				constantUsage[inst.Arg]++
+			}
+		}
+	}
+
+	localCounter := 1
+	globalNameMap := make(map[string]string)
+	globalCounter := 1
+
+	var globalList []globalInfo
+	for name, usage := range globalUsage {
+		if builtinlist[name] || !defined[name] {
+			continue
+		}
+		globalList = append(globalList, globalInfo{name: name, usage: usage})
+	}
+
+	for i := 0; i < len(globalList); i++ {
+		for j := i + 1; j < len(globalList); j++ {
+			if globalList[j].usage > globalList[i].usage {
+				globalList[i], globalList[j] = globalList[j], globalList[i]
+			}
+		}
+	}
+
+	for _, info := range globalList {
+		newName := "g" + strconv.Itoa(globalCounter)
+		globalNameMap[info.name] = newName
+		globalCounter++
+	}
+
+	for localIdx := range localUsage {
+		newName := "l" + strconv.Itoa(localCounter)
+		localNameMap[localIdx] = newName
+		localCounter++
+	}
+
+	pool := bytecode.NewNamePool(&o.Constants)
+	for i, inst := range o.Instructions {
+		if !bytecode.IsNameOp(inst.Op) {
+			continue
+		}
+		if newName, exists := globalNameMap[o.name(inst)]; exists {
+			bytecode.SetConstIndex(&o.Instructions[i], pool.Add(newName))
+		}
+	}
+
+	if o.SymbolTable != nil {
+		for oldName, newName := range globalNameMap {
+			if typ, exists := o.SymbolTable.Globals[oldName]; exists {
+				o.SymbolTable.Globals[newName] = typ
+				delete(o.SymbolTable.Globals, oldName)
+			}
+		}
+
+		if len(localNameMap) > 0 {
+			oldNameToIdx := make(map[string]int)
+			for name, idx := range o.SymbolTable.Locals {
+				oldNameToIdx[name] = idx
+			}
+
+			newLocals := make(map[string]int)
+			for oldName, idx := range oldNameToIdx {
+				if newName, exists := localNameMap[idx]; exists {
+					newLocals[newName] = idx
+				} else {
+					newLocals[oldName] = idx
+				}
+			}
+			o.SymbolTable.Locals = newLocals
+		}
+	}
+}
+
+func (o *Optimizer) doConstantFolding() {
+	constantValues := make(map[string]interface{})
+	isConstant := make(map[string]bool)
+
+	constantUsed := make([]bool, len(o.Constants))
+
+	for i := 0; i < len(o.Instructions); i++ {
+		inst := o.Instructions[i]
+
+		if inst.Op == bytecode.OpConstant {
+			if inst.Arg >= 0 && inst.Arg < len(o.Constants) {
+				constantUsed[inst.Arg] = true
+			}
+		}
+
+		if inst.Op == bytecode.OpSetGlobal && i > 0 {
+			name := o.name(inst)
+			prev := o.Instructions[i-1]
+			if prev.Op == bytecode.OpConstant {
+				if prev.Arg >= 0 && prev.Arg < len(o.Constants) {
+					constantValues[name] = o.Constants[prev.Arg].Value
+					isConstant[name] = true
+				}
+			}
+		}
+	}
+
+	targets := o.jumpTargets()
+	for i := 0; i < len(o.Instructions); i++ {
+		if i+2 < len(o.Instructions) && !targets[i+1] && !targets[i+2] {
+			if o.Instructions[i].Op == bytecode.OpConstant &&
+				o.Instructions[i+1].Op == bytecode.OpConstant &&
+				isArithmeticOp(o.Instructions[i+2].Op) {
+
+				constIdx1 := o.Instructions[i].Arg
+				constIdx2 := o.Instructions[i+1].Arg
+
+				if constIdx1 >= 0 && constIdx1 < len(o.Constants) &&
+					constIdx2 >= 0 && constIdx2 < len(o.Constants) {
+
+					val1 := o.Constants[constIdx1].Value
+					val2 := o.Constants[constIdx2].Value
+
+					result, ok := performArithmetic(val1, val2, o.Instructions[i+2].Op)
+					if ok {
+						constIdx := len(o.Constants)
+						o.Constants = append(o.Constants, bytecode.Constant{
+							Value: result,
+							Type:  getTypeString(result),
+						})
+
+						o.Instructions[i] = bytecode.Instruction{
+							Op:   bytecode.OpConstant,
+							Arg:  constIdx,
+							Line: o.Instructions[i].Line,
+							Col:  o.Instructions[i].Col,
+						}
+
+						o.Instructions[i+1] = bytecode.Instruction{Op: bytecode.OpNop}
+						o.Instructions[i+2] = bytecode.Instruction{Op: bytecode.OpNop}
+					}
+				}
+			}
+		}
+	}
+}
+
+func (o *Optimizer) doCleanup() {
+	globalUsage := make(map[string]int)
+	localUsage := make(map[int]int)
+
+	for _, inst := range o.Instructions {
+		switch inst.Op {
+		case bytecode.OpGetGlobal, bytecode.OpCall:
+			if name := o.name(inst); name != "" {
+				globalUsage[name]++
+			}
+		case bytecode.OpSetGlobal:
+			name := o.name(inst)
+			if _, exists := globalUsage[name]; !exists {
+				globalUsage[name] = 0
+			}
+		case bytecode.OpGetLocal:
+			localUsage[inst.Arg]++
+		case bytecode.OpSetLocal:
+			if _, exists := localUsage[inst.Arg]; !exists {
+				localUsage[inst.Arg] = 0
+			}
+		}
+	}
+
+	// a store nobody reads becomes a pop, or disappears together with the
+	// constant or function it would have stored
+	targets := o.jumpTargets()
+	for i := range o.Instructions {
+		inst := o.Instructions[i]
+		dead := false
+		switch inst.Op {
+		case bytecode.OpSetGlobal:
+			count, exists := globalUsage[o.name(inst)]
+			dead = exists && count == 0 && !o.KeepNames
+		case bytecode.OpSetLocal:
+			count, exists := localUsage[inst.Arg]
+			dead = exists && count == 0
+		}
+		if !dead {
+			continue
+		}
+		if i > 0 && !targets[i] && isPurePush(o.Instructions[i-1].Op) {
+			o.Instructions[i-1] = bytecode.Instruction{Op: bytecode.OpNop}
+			o.Instructions[i] = bytecode.Instruction{Op: bytecode.OpNop}
+		} else {
+			o.Instructions[i] = bytecode.Instruction{Op: bytecode.OpPop, Line: inst.Line, Col: inst.Col}
+		}
+	}
+}
+
+func (o *Optimizer) doGarbageCollection() {
+	constantUsed := make([]bool, len(o.Constants))
+
+	for _, inst := range o.Instructions {
+		if idx := bytecode.ConstIndex(inst); bytecode.UsesConstant(inst.Op) && idx >= 0 && idx < len(o.Constants) {
+			constantUsed[idx] = true
+		}
+	}
+
+	oldToNew := make([]int, len(o.Constants))
+	newConstants := make([]bytecode.Constant, 0)
+
+	for i, used := range constantUsed {
+		if used {
+			oldToNew[i] = len(newConstants)
+			newConstants = append(newConstants, o.Constants[i])
+		} else {
+			oldToNew[i] = -1
+		}
+	}
+
+	for i, inst := range o.Instructions {
+		if !bytecode.UsesConstant(inst.Op) {
+			continue
+		}
+		if idx := bytecode.ConstIndex(inst); idx >= 0 && idx < len(oldToNew) && oldToNew[idx] != -1 {
+			bytecode.SetConstIndex(&o.Instructions[i], oldToNew[idx])
+		} else if inst.Op == bytecode.OpConstant {
+			o.Instructions[i].Arg = 0
+		}
+	}
+
+	o.Constants = newConstants
+}
+
+// name returns the global name used by inst.
+func (o *Optimizer) name(inst bytecode.Instruction) string {
+	return bytecode.ConstName(o.Constants, bytecode.ConstIndex(inst))
+}
+
+func isArithmeticOp(op bytecode.OpCode) bool {
+	return op == bytecode.OpAdd || op == bytecode.OpSub || op == bytecode.OpMul || op == bytecode.OpDiv
+}
+
+func performArithmetic(a, b interface{}, op bytecode.OpCode) (interface{}, bool) {
+	var fa, fb float64
+
+	switch v := a.(type) {
+	case float64:
+		fa = v
+	case int:
+		fa = float64(v)
+	case int64:
+		fa = float64(v)
+	default:
+		return nil, false
+	}
+
+	switch v := b.(type) {
+	case float64:
+		fb = v
+	case int:
+		fb = float64(v)
+	case int64:
+		fb = float64(v)
+	default:
+		return nil, false
+	}
+
+	var result float64
+	switch op {
+	case bytecode.OpAdd:
+		result = fa + fb
+	case bytecode.OpSub:
+		result = fa - fb
+	case bytecode.OpMul:
+		result = fa * fb
+	case bytecode.OpDiv:
+		if fb == 0 {
+			return nil, false
+		}
+		result = fa / fb
+	default:
+		return nil, false
+	}
+
+	if math.Trunc(result) == result && result >= -1<<53 && result < 1<<53 {
+		return int(result), true
+	}
+
+	return result, true
+}
+
+func getTypeString(val interface{}) string {
+	switch val.(type) {
+	case float64:
+		return "number"
+	case int, int64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case nil:
+		return "nil"
+	default:
+		return "any"
+	}
+}
+
+func OptimizeBytecode(instructions []bytecode.Instruction, constants []bytecode.Constant, sym *SymbolTable) ([]bytecode.Instruction, []bytecode.Constant) {
+	optimizer := NewOptimizer(instructions, constants, sym)
+	return optimizer.Optimize()
+}
@@ -0,0 +1,93 @@
+package compiler
+
+import (
+	"fmt"
+	"lightlang/bytecode"
+	"lightlang/parser"
+)
+
+// Pass is a project-specific step of Compile, added with RegisterPass, such
+// as a lint or instrumentation. Either function may be nil.
+type Pass struct {
+	Name string
+	// AST gets the statements of a file once they type check, and returns
+	// those to compile.
+	AST func(c *PassContext, nodes []parser.Node) ([]parser.Node, error)
+	// Bytecode gets the compiled program before OptimizeBytecode, and
+	// returns the instructions and constants to keep. Jump targets and
+	// function entries are instruction indexes, so passes that insert or
+	// remove instructions must fix them up.
+	Bytecode func(c *PassContext, instructions []bytecode.Instruction, constants []bytecode.Constant) ([]bytecode.Instruction, []bytecode.Constant, error)
+}
+
+// PassContext is what a pass is told about the compilation.
+type PassContext struct {
+	// File is the file being compiled.
+	File string
+	b    *Builder
+}
+
+// Warn adds a warning at pos, reported with those of the compiler, so
+// --werror turns it into an error.
+func (c *PassContext) Warn(pos parser.Pos, format string, args ...interface{}) {
+	c.b.warn(pos, format, args...)
+}
+
+var passes []Pass
+
+// RegisterPass adds p to the passes Compile runs. Passes run in the order
+// they are registered.
+func RegisterPass(p Pass) {
+	passes = append(passes, p)
+}
+
+// runASTPasses hands nodes to the AST passes in turn.
+func runASTPasses(b *Builder, file string, nodes []parser.Node) ([]parser.Node, error) {
+	c := &PassContext{File: file, b: b}
+	for _, p := range passes {
+		if p.AST == nil {
+			continue
+		}
+		var err error
+		if nodes, err = p.AST(c, nodes); err != nil {
+			return nil, parser.WrapError(passError(p, err), file, "Compile Error", parser.Pos{})
+		}
+	}
+	return nodes, nil
+}
+
+// runBytecodePasses hands the program b built to the bytecode passes in
+// turn.
+func runBytecodePasses(b *Builder, file string) error {
+	c := &PassContext{File: file, b: b}
+	for _, p := range passes {
+		if p.Bytecode == nil {
+			continue
+		}
+		instructions, constants, err := p.Bytecode(c, b.Instructions, b.Constants)
+		if err != nil {
+			return parser.WrapError(passError(p, err), file, "Compile Error", parser.Pos{})
+		}
+		if err := bytecode.VerifyProgram(instructions, constants); err != nil {
+			return fmt.Errorf("pass %s made invalid bytecode: %v", p.Name, err)
+		}
+		b.Instructions, b.Constants = instructions, constants
+	}
+	return nil
+}
+
+// passError names the pass in err, keeping the positions of a
+// *parser.SourceError or a parser.ErrorList.
+func passError(p Pass, err error) error {
+	switch e := err.(type) {
+	case *parser.SourceError:
+		e.Err = fmt.Errorf("pass %s: %w", p.Name, e.Err)
+	case parser.ErrorList:
+		for _, se := range e {
+			se.Err = fmt.Errorf("pass %s: %w", p.Name, se.Err)
+		}
+	default:
+		return fmt.Errorf("pass %s: %w", p.Name, err)
+	}
+	return err
+}
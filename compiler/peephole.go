@@ -0,0 +1,119 @@
+package compiler
+
+import (
+	"lightlang/bytecode"
+)
+
+// inverted maps comparisons to the one that gives the opposite result.
+// Orderings only differ from their negation for NaN, which lightlang code
+// cannot produce without a builtin.
+var inverted = map[bytecode.OpCode]bytecode.OpCode{
+	bytecode.OpCmpEq:  bytecode.OpCmpNe,
+	bytecode.OpCmpNe:  bytecode.OpCmpEq,
+	bytecode.OpCmpLt:  bytecode.OpCmpGte,
+	bytecode.OpCmpGte: bytecode.OpCmpLt,
+	bytecode.OpCmpGt:  bytecode.OpCmpLte,
+	bytecode.OpCmpLte: bytecode.OpCmpGt,
+}
+
+// isPurePush reports whether op only pushes a value, so it can be dropped
+// together with the instruction that consumes it.
+func isPurePush(op bytecode.OpCode) bool {
+	return op == bytecode.OpConstant || op == bytecode.OpGetLocal || op == bytecode.OpMakeFunc
+}
+
+// jumpTargets returns the instructions that control can reach other than
+// by falling through: jump targets and function entries.
+func (o *Optimizer) jumpTargets() map[int]bool {
+	targets := make(map[int]bool)
+	for _, inst := range o.Instructions {
+		if bytecode.IsJump(inst.Op) {
+			targets[inst.Arg] = true
+		}
+	}
+	for _, c := range o.Constants {
+		if c.Type == "funcptr" {
+			if e, ok := bytecode.ArgInt(c.Value); ok {
+				targets[e] = true
+			}
+		}
+	}
+	return targets
+}
+
+// doPeephole threads jumps to jumps, drops values that are pushed and
+// popped right away and turns a comparison followed by OpNot into the
+// inverted comparison. Removed instructions become OpNop.
+func (o *Optimizer) doPeephole() {
+	insts := o.Instructions
+	for i := range insts {
+		if !bytecode.IsJump(insts[i].Op) {
+			continue
+		}
+		t := insts[i].Arg
+		for steps := 0; steps < len(insts) && t >= 0 && t < len(insts) && insts[t].Op == bytecode.OpJump; steps++ {
+			t = insts[t].Arg
+		}
+		insts[i].Arg = t
+	}
+
+	targets := o.jumpTargets()
+	for i := 0; i+1 < len(insts); i++ {
+		if targets[i+1] {
+			continue
+		}
+		next := insts[i+1].Op
+		switch {
+		case isPurePush(insts[i].Op) && next == bytecode.OpPop:
+			insts[i] = bytecode.Instruction{Op: bytecode.OpNop}
+			insts[i+1] = bytecode.Instruction{Op: bytecode.OpNop}
+		case next == bytecode.OpNot:
+			if inv, ok := inverted[insts[i].Op]; ok {
+				insts[i].Op = inv
+				insts[i+1] = bytecode.Instruction{Op: bytecode.OpNop}
+			}
+		}
+	}
+}
+
+// removeNops deletes OpNop instructions. Jumps and function entries that
+// pointed at a removed instruction move to the one after it.
+func (o *Optimizer) removeNops() {
+	newIndex := make([]int, len(o.Instructions)+1)
+	n := 0
+	for i, inst := range o.Instructions {
+		newIndex[i] = n
+		if inst.Op != bytecode.OpNop {
+			n++
+		}
+	}
+	newIndex[len(o.Instructions)] = n
+	if n == len(o.Instructions) {
+		return
+	}
+	remap := func(ip int) int {
+		if ip < 0 || ip >= len(newIndex) {
+			return ip
+		}
+		return newIndex[ip]
+	}
+
+	result := make([]bytecode.Instruction, 0, n)
+	for _, inst := range o.Instructions {
+		if inst.Op == bytecode.OpNop {
+			continue
+		}
+		if bytecode.IsJump(inst.Op) {
+			inst.Arg = remap(inst.Arg)
+		}
+		result = append(result, inst)
+	}
+	for i, c := range o.Constants {
+		if c.Type == "funcptr" {
+			if e, ok := bytecode.ArgInt(c.Value); ok {
+				o.Constants[i].Value = float64(remap(e))
+			}
+		}
+	}
+	o.Instructions = result
+}
@@ -0,0 +1,163 @@
+package compiler
+
+import (
+	"fmt"
+	"lightlang/parser"
+	"strings"
+	"unicode"
+)
+
+// Shape is the type of a table with known fields, written
+// "{name: string, age: number}". Type definitions give shapes names.
+type Shape struct {
+	Names  []string
+	Fields map[string]string
+}
+
+func parseShape(typ string) (*Shape, error) {
+	if !strings.HasPrefix(typ, "{") || !strings.HasSuffix(typ, "}") {
+		return nil, fmt.Errorf("unknown type %s", typ)
+	}
+	shape := &Shape{Fields: make(map[string]string)}
+	for _, field := range splitTypes(typ[1 : len(typ)-1]) {
+		name, ftyp, ok := strings.Cut(field, ":")
+		name, ftyp = strings.TrimSpace(name), strings.TrimSpace(ftyp)
+		if !ok || ftyp == "" || !isIdent(name) {
+			return nil, fmt.Errorf("invalid field %q in %s", field, typ)
+		}
+		if _, ok := shape.Fields[name]; ok {
+			return nil, fmt.Errorf("field %s is declared twice in %s", name, typ)
+		}
+		shape.Names = append(shape.Names, name)
+		shape.Fields[name] = ftyp
+	}
+	return shape, nil
+}
+
+// splitTypes splits a list of types or fields at the commas and newlines
+// outside brackets.
+func splitTypes(list string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range list {
+		switch c {
+		case '{', '[', '(', '<':
+			depth++
+		case '}', ']', ')', '>':
+			depth--
+		case ',', '\n':
+			if depth == 0 {
+				parts = append(parts, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, list[start:])
+	var out []string
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func isIdent(s string) bool {
+	for i, c := range s {
+		if !(unicode.IsLetter(c) || c == '_' || i > 0 && unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// resolve follows type definitions that name other types. Names of
+// shapes are kept, so messages can use them.
+func (s *SymbolTable) resolve(typ string) string {
+	defs := s.root().TypeDefs
+	for range len(defs) {
+		def, ok := defs[typ]
+		if !ok || strings.HasPrefix(def, "{") {
+			break
+		}
+		typ = def
+	}
+	return typ
+}
+
+// shape returns the fields of typ, or nil if it is not a shape.
+func (s *SymbolTable) shape(typ string) *Shape {
+	typ = s.resolve(typ)
+	if def, ok := s.root().TypeDefs[typ]; ok {
+		typ = def
+	}
+	if !strings.HasPrefix(typ, "{") {
+		return nil
+	}
+	shape, err := parseShape(typ)
+	if err != nil {
+		return nil
+	}
+	return shape
+}
+
+// field returns the type of the field that index names in a table of type
+// typ. It is "any" unless typ is a shape and index a string literal, and
+// an error if the shape has no such field.
+func (s *SymbolTable) field(typ string, index parser.Node) (string, error) {
+	typ, _ = s.nonNil(typ)
+	key, ok := index.(*parser.LiteralNode)
+	shape := s.shape(typ)
+	if !ok || key.Type != "string" || shape == nil {
+		return "any", nil
+	}
+	ftyp, ok := shape.Fields[key.Value.(string)]
+	if !ok {
+		return "any", fmt.Errorf("%s has no field %s", typ, key.Value)
+	}
+	return ftyp, nil
+}
+
+// checkLiteral checks the fields of a table literal used where a shape is
+// expected. Fields that may be nil can be left out.
+func (s *SymbolTable) checkLiteral(n parser.Node, want string) error {
+	lit, ok := n.(*parser.TableLiteralNode)
+	if !ok {
+		return nil
+	}
+	if elem := elemType(s.resolve(want)); elem != "" && lit.IsArray {
+		for i, val := range lit.Values {
+			if got := typeOf(val, s); !s.assignable(got, elem) {
+				return fmt.Errorf("element %d of %s must be %s, got %s", i+1, want, elem, got)
+			}
+			if err := s.checkLiteral(val, elem); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	shape := s.shape(want)
+	if lit.IsArray || shape == nil {
+		return nil
+	}
+	has := make(map[string]bool, len(lit.Keys))
+	for i, key := range lit.Keys {
+		ftyp, ok := shape.Fields[key]
+		if !ok {
+			return fmt.Errorf("%s has no field %s", want, key)
+		}
+		has[key] = true
+		if got := typeOf(lit.Values[i], s); !s.assignable(got, ftyp) {
+			return fmt.Errorf("field %s of %s must be %s, got %s", key, want, ftyp, got)
+		}
+		if err := s.checkLiteral(lit.Values[i], ftyp); err != nil {
+			return err
+		}
+	}
+	for _, name := range shape.Names {
+		if !has[name] && !s.assignable("nil", shape.Fields[name]) {
+			return fmt.Errorf("%s needs field %s", want, name)
+		}
+	}
+	return nil
+}
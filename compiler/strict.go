@@ -0,0 +1,77 @@
+package compiler
+
+import (
+	"fmt"
+	"lightlang/builtins"
+	"lightlang/parser"
+	"strings"
+	"unicode/utf8"
+)
+
+// Strict makes reading a name that is never assigned a compile error
+// (--strict).
+var Strict bool
+
+// declareGlobals records every name the program assigns or defines, so
+// strict mode accepts globals that are read before their assignment.
+func (b *Builder) declareGlobals(nodes []parser.Node) {
+	if b.globals == nil {
+		b.globals = make(map[string]bool)
+	}
+	for _, node := range nodes {
+		parser.Walk(node, func(n parser.Node) {
+			switch n := n.(type) {
+			case *parser.AssignmentNode:
+				b.globals[n.Name] = true
+			case *parser.FuncDefNode:
+				b.globals[n.Name] = true
+			case *parser.ForLoopNode:
+				if n.Type == "in" {
+					b.globals[n.LoopVar] = true
+				}
+			}
+		})
+	}
+}
+
+func (b *Builder) checkDefined(name string) {
+	if !b.Strict || b.globals[name] {
+		return
+	}
+	if isLocal, _ := b.SymbolTable.Resolve(name); isLocal {
+		return
+	}
+	if _, ok := builtins.Builtins[name]; ok {
+		return
+	}
+	pos := pointAt(b.src, b.pos, name)
+	b.Errors = append(b.Errors, &parser.SourceError{Pos: pos, Len: len(name), Err: fmt.Errorf("undefined: %s", name)})
+}
+
+// pointAt moves a statement-level position to the first whole-word use of
+// name on the same line.
+func pointAt(src string, pos parser.Pos, name string) parser.Pos {
+	lines := strings.Split(src, "\n")
+	if pos.Line <= 0 || pos.Line > len(lines) {
+		return pos
+	}
+	line := lines[pos.Line-1]
+	from := max(pos.Col-1, 0)
+	for from < len(line) {
+		i := strings.Index(line[from:], name)
+		if i < 0 {
+			break
+		}
+		i += from
+		end := i + len(name)
+		if (i == 0 || !isIdentByte(line[i-1])) && (end == len(line) || !isIdentByte(line[end])) {
+			return parser.Pos{Line: pos.Line, Col: i + 1}
+		}
+		from = end
+	}
+	return pos
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= utf8.RuneSelf
+}
@@ -0,0 +1,395 @@
+package compiler
+
+import (
+	"fmt"
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"lightlang/parser"
+	"slices"
+	"strings"
+)
+
+// Signature is what a function declares about its parameters and result.
+// Types are "any" where none is declared.
+type Signature struct {
+	// TypeParams are the type variables of a generic function, bound at
+	// each call from the arguments.
+	TypeParams []string
+	Params     []string
+	Return     string
+}
+
+// builtinTypes are the types an annotation can name.
+var builtinTypes = map[string]bool{
+	"any": true, "number": true, "string": true, "bool": true, "nil": true,
+	"array": true, "table": true, "function": true, "bigint": true, "decimal": true,
+}
+
+// checkType checks that typ is a built-in or defined type, one of the type
+// variables vars, or an array, shape or nullable of such types.
+func (s *SymbolTable) checkType(typ string, vars ...string) error {
+	if base, ok := strings.CutSuffix(typ, "?"); ok {
+		return s.checkType(base, vars...)
+	}
+	if builtinTypes[typ] || slices.Contains(vars, typ) {
+		return nil
+	}
+	if _, ok := s.root().TypeDefs[typ]; ok {
+		return nil
+	}
+	if elem := elemType(typ); elem != "" {
+		return s.checkType(elem, vars...)
+	}
+	shape, err := parseShape(typ)
+	if err != nil {
+		return err
+	}
+	for _, name := range shape.Names {
+		if err := s.checkType(shape.Fields[name], vars...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// elemType returns the element type of an array type like "[number]", or
+// "" for other types.
+func elemType(typ string) string {
+	if len(typ) > 2 && typ[0] == '[' && typ[len(typ)-1] == ']' {
+		return strings.TrimSpace(typ[1 : len(typ)-1])
+	}
+	return ""
+}
+
+// newSignature checks the declared types of a function.
+func (s *SymbolTable) newSignature(typeParams, params, types []string, ret string) (*Signature, error) {
+	sig := &Signature{TypeParams: typeParams, Params: make([]string, len(params)), Return: "any"}
+	for _, t := range typeParams {
+		if !isIdent(t) {
+			return nil, fmt.Errorf("invalid type parameter %s", t)
+		}
+	}
+	for i := range params {
+		sig.Params[i] = "any"
+		if i < len(types) && types[i] != "" {
+			if err := s.checkType(types[i], typeParams...); err != nil {
+				return nil, fmt.Errorf("parameter %s: %v", params[i], err)
+			}
+			sig.Params[i] = types[i]
+		}
+	}
+	if ret != "" {
+		if err := s.checkType(ret, typeParams...); err != nil {
+			return nil, fmt.Errorf("return type: %v", err)
+		}
+		sig.Return = ret
+	}
+	return sig, nil
+}
+
+// assignable tells whether a value of type from can be used as a to. Tables
+// fit any shape, and a shape fits another when it has the fields of it. In
+// strict mode a T? is not a T.
+func (s *SymbolTable) assignable(from, to string) bool {
+	return s.assignableAt(from, to, 0)
+}
+
+func (s *SymbolTable) assignableAt(from, to string, depth int) bool {
+	from, to = s.resolve(from), s.resolve(to)
+	if from == "any" || to == "any" || from == to || depth > 16 {
+		return true
+	}
+	fb, fnull := s.nonNil(from)
+	tb, tnull := s.nonNil(to)
+	if fnull || tnull {
+		if tnull && from == "nil" {
+			return true
+		}
+		if fnull && !tnull && Strict {
+			return false
+		}
+		return s.assignableAt(fb, tb, depth+1)
+	}
+	if fe, te := elemType(from), elemType(to); fe != "" || te != "" {
+		switch {
+		case fe != "" && te != "":
+			return s.assignableAt(fe, te, depth+1)
+		case fe != "":
+			return to == "array"
+		}
+		return from == "array"
+	}
+	fs, ts := s.shape(from), s.shape(to)
+	switch {
+	case fs != nil && ts != nil:
+		for _, name := range ts.Names {
+			ftyp, ok := fs.Fields[name]
+			if !ok {
+				ftyp = "nil"
+			}
+			if !s.assignableAt(ftyp, ts.Fields[name], depth+1) {
+				return false
+			}
+		}
+		return true
+	case fs != nil:
+		return to == "table"
+	case ts != nil:
+		return from == "table"
+	}
+	return false
+}
+
+// returnType is the declared return type of the function being checked.
+func (s *SymbolTable) returnType() string {
+	for t := s; t != nil; t = t.Parent {
+		if t.IsFunc {
+			return t.Return
+		}
+	}
+	return "any"
+}
+
+// defineType names a type, see TypeDefs.
+func (s *SymbolTable) defineType(name, typ string) {
+	root := s.root()
+	if root.TypeDefs == nil {
+		root.TypeDefs = make(map[string]string)
+	}
+	root.TypeDefs[name] = typ
+}
+
+func (s *SymbolTable) root() *SymbolTable {
+	for s.Parent != nil {
+		s = s.Parent
+	}
+	return s
+}
+
+// declare records the signature of the global function name. A nil sig
+// marks a name bound more than once, whose calls are not checked.
+func (s *SymbolTable) declare(name string, sig *Signature) {
+	root := s.root()
+	if root.Funcs == nil {
+		root.Funcs = make(map[string]*Signature)
+	}
+	if old, ok := root.Funcs[name]; ok && old == nil {
+		return
+	}
+	root.Funcs[name] = sig
+}
+
+// Signature returns the signature of the global function name, or nil if
+// name is not known to always be that function.
+func (s *SymbolTable) Signature(name string) *Signature {
+	if isLocal, _ := s.Resolve(name); isLocal {
+		return nil
+	}
+	return s.root().Funcs[name]
+}
+
+// declareFuncs records types, constants and the signatures of functions
+// before any code is checked, so uses may come before definitions.
+// Functions that are also assigned or defined twice are left unchecked.
+func declareFuncs(nodes []parser.Node, sym *SymbolTable) error {
+	root := sym.root()
+	for _, node := range nodes {
+		switch def := node.(type) {
+		case *parser.TypeDefNode:
+			if _, ok := root.TypeDefs[def.Name]; ok {
+				return &parser.SourceError{Pos: def.Pos, Err: fmt.Errorf("type %s is defined twice", def.Name)}
+			}
+			root.defineType(def.Name, def.Type)
+		case *parser.AssignmentNode:
+			if !def.Const {
+				continue
+			}
+			if _, ok := root.Consts[def.Name]; ok {
+				return &parser.SourceError{Pos: def.Pos, Err: fmt.Errorf("const %s is declared twice", def.Name)}
+			}
+			if err := root.declareConst(def); err != nil {
+				return &parser.SourceError{Pos: def.Pos, Err: err}
+			}
+		}
+	}
+	binds := make(map[string]int)
+	defs := make(map[string]*parser.FuncDefNode)
+	for _, node := range nodes {
+		parser.Walk(node, func(n parser.Node) {
+			switch n := n.(type) {
+			case *parser.AssignmentNode:
+				binds[n.Name]++
+			case *parser.FuncDefNode:
+				binds[n.Name]++
+				defs[n.Name] = n
+			case *parser.ForLoopNode:
+				if n.Type == "in" {
+					binds[n.LoopVar]++
+				}
+			}
+		})
+	}
+	for name, def := range defs {
+		if binds[name] != 1 {
+			sym.declare(name, nil)
+			continue
+		}
+		sig, err := sym.newSignature(def.TypeParams, def.Params, def.ParamTypes, def.ReturnType)
+		if err != nil {
+			return &parser.SourceError{Pos: def.Pos, Err: fmt.Errorf("%s: %v", name, err)}
+		}
+		sym.declare(name, sig)
+	}
+	return nil
+}
+
+// checkCall checks a direct call against the signature of its target.
+func checkCall(n *parser.CallNode, sym *SymbolTable) error {
+	if _, ok := builtins.Builtins[n.Target]; ok {
+		return nil
+	}
+	if n.Target == "eval" || n.Target == "load" {
+		sym.root().dynamic = true
+	}
+	sig := sym.Signature(n.Target)
+	if sig == nil {
+		typ := sym.varType(n.Target)
+		if sym.mayBeNil(typ) {
+			return nilError(&parser.VariableNode{Name: n.Target}, typ)
+		}
+		if base, _ := sym.nonNil(typ); base != "function" && base != "any" {
+			return fmt.Errorf("cannot call %s, it is %s", n.Target, typ)
+		}
+		return nil
+	}
+	if least := len(sig.Params) - sym.optionalParams(sig); len(n.Args) < least || len(n.Args) > len(sig.Params) {
+		if least < len(sig.Params) {
+			return fmt.Errorf("%s expects %d to %d arguments, got %d", n.Target, least, len(sig.Params), len(n.Args))
+		}
+		return fmt.Errorf("%s expects %d arguments, got %d", n.Target, len(sig.Params), len(n.Args))
+	}
+	params := sig.Params
+	if len(sig.TypeParams) > 0 {
+		bound := sym.bind(sig, n.Args)
+		params = make([]string, len(sig.Params))
+		for i, typ := range sig.Params {
+			params[i] = subst(typ, sig.TypeParams, bound)
+		}
+	}
+	for i, arg := range n.Args {
+		if got := typeOf(arg, sym); !sym.assignable(got, params[i]) {
+			return fmt.Errorf("argument %d of %s must be %s, got %s", i+1, n.Target, params[i], got)
+		}
+		if err := sym.checkLiteral(arg, params[i]); err != nil {
+			return fmt.Errorf("argument %d of %s: %v", i+1, n.Target, err)
+		}
+	}
+	return nil
+}
+
+// typeCheckBody checks the statements of a function with its parameters
+// in scope. Errors point at the statement they are found in.
+func typeCheckBody(params []string, sig *Signature, body []parser.Node, sym *SymbolTable) error {
+	fsym := NewSymbolTable(sym, true)
+	fsym.Return = sig.Return
+	for i, param := range params {
+		fsym.Define(param, true)
+		fsym.setType(param, sym.resolve(sig.Params[i]))
+	}
+	for _, stmt := range body {
+		if err := TypeCheck(stmt, fsym); err != nil {
+			return parser.WrapError(err, "", "", stmt.Position())
+		}
+	}
+	return nil
+}
+
+// emitCheck checks at runtime that the value on top of the stack is a typ.
+// Typed functions can be called from untyped code, so the compiler cannot
+// prove this for their parameters and results.
+func (b *Builder) emitCheck(typ string) {
+	if typ == "" {
+		return
+	}
+	// element types, shapes and type variables are only checked when
+	// compiling
+	typ, nullable := b.SymbolTable.nonNil(typ)
+	switch {
+	case builtinTypes[typ]:
+	case elemType(typ) != "":
+		typ = "array"
+	case b.SymbolTable.shape(typ) != nil:
+		typ = "table"
+	default:
+		typ = "any"
+	}
+	if typ != "any" {
+		if nullable {
+			typ += "?"
+		}
+		b.Emit(bytecode.OpCheckType, b.AddConstant(typ, "string"))
+	}
+}
+
+func (b *Builder) emitParamChecks(params, types []string) {
+	for i, typ := range types {
+		if typ == "" || typ == "any" {
+			continue
+		}
+		b.Emit(bytecode.OpGetLocal, b.SymbolTable.Locals[params[i]])
+		b.emitCheck(typ)
+		b.Emit(bytecode.OpPop, 0)
+	}
+}
+
+// bind infers the type variables of a generic function from the arguments
+// of a call. Variables the arguments do not pin down are left out.
+func (s *SymbolTable) bind(sig *Signature, args []parser.Node) map[string]string {
+	bound := make(map[string]string)
+	for i, arg := range args {
+		if i < len(sig.Params) {
+			s.unify(sig.Params[i], typeOf(arg, s), sig.TypeParams, bound)
+		}
+	}
+	return bound
+}
+
+func (s *SymbolTable) unify(param, arg string, vars []string, bound map[string]string) {
+	arg = s.resolve(arg)
+	if slices.Contains(vars, param) {
+		if _, ok := bound[param]; !ok && arg != "any" {
+			bound[param] = arg
+		}
+		return
+	}
+	if pe, ae := elemType(param), elemType(arg); pe != "" && ae != "" {
+		s.unify(pe, ae, vars, bound)
+	}
+}
+
+// subst replaces the type variables vars in typ with what they are bound
+// to, or any.
+func subst(typ string, vars []string, bound map[string]string) string {
+	var b strings.Builder
+	for i := 0; i < len(typ); {
+		j := i
+		for j < len(typ) && (typ[j] == '_' || 'a' <= typ[j]|0x20 && typ[j]|0x20 <= 'z' || j > i && '0' <= typ[j] && typ[j] <= '9') {
+			j++
+		}
+		if j == i {
+			b.WriteByte(typ[i])
+			i++
+			continue
+		}
+		word := typ[i:j]
+		// field names of shapes are not types
+		if rest := strings.TrimLeft(typ[j:], " "); slices.Contains(vars, word) && !strings.HasPrefix(rest, ":") {
+			if word = bound[word]; word == "" {
+				word = "any"
+			}
+		}
+		b.WriteString(word)
+		i = j
+	}
+	return b.String()
+}
@@ -0,0 +1,159 @@
+package compiler
+
+import (
+	"fmt"
+	"lightlang/builtins"
+	"lightlang/parser"
+	"strings"
+)
+
+func (b *Builder) warn(pos parser.Pos, format string, args ...interface{}) {
+	b.Warnings = append(b.Warnings, &parser.SourceError{
+		Pos:      pos,
+		Kind:     "Warning",
+		Severity: parser.SeverityWarning,
+		Err:      fmt.Errorf(format, args...),
+	})
+}
+
+// emitBlock emits a statement list, warning once about statements that
+// follow a return, break or continue, or an if whose branches all end in
+// one.
+func (b *Builder) emitBlock(stmts []parser.Node) {
+	for i, stmt := range stmts {
+		b.EmitNode(stmt)
+		if jumps(stmt) {
+			if i+1 < len(stmts) {
+				pos := b.pos
+				if next := stmts[i+1].Position(); next.Line > 0 {
+					pos = next
+				}
+				b.warn(pos, "unreachable code")
+			}
+			for _, rest := range stmts[i+1:] {
+				b.EmitNode(rest)
+			}
+			return
+		}
+	}
+}
+
+// jumps reports whether stmt always leaves its block.
+func jumps(stmt parser.Node) bool {
+	switch stmt := stmt.(type) {
+	case *parser.ReturnNode, *parser.BreakNode, *parser.ContinueNode:
+		return true
+	case *parser.IfNode:
+		return allBranches(stmt, func(body []parser.Node) bool {
+			for _, s := range body {
+				if jumps(s) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	return false
+}
+
+// returns reports whether every path through stmts ends in a return.
+func returns(stmts []parser.Node) bool {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *parser.ReturnNode:
+			return true
+		case *parser.IfNode:
+			if allBranches(stmt, returns) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allBranches reports whether ok holds for every branch of an if, which
+// needs an else to cover every path.
+func allBranches(n *parser.IfNode, ok func([]parser.Node) bool) bool {
+	if n.ElseBody == nil {
+		return false
+	}
+	for _, body := range n.Bodies {
+		if !ok(body) {
+			return false
+		}
+	}
+	return ok(n.ElseBody)
+}
+
+// checkReturn warns when a function declared to return a value can reach
+// the end of its body, where it returns nil.
+func (b *Builder) checkReturn(name, typ string, body []parser.Node) {
+	if typ == "" || b.SymbolTable.assignable("nil", typ) || returns(body) {
+		return
+	}
+	if name == "" {
+		name = "function"
+	}
+	b.warn(b.pos, "%s can end without returning a %s", name, typ)
+}
+
+// defineLocal declares a parameter or loop variable, warning when it hides
+// an outer local, a function or a builtin.
+func (b *Builder) defineLocal(name string) int {
+	sym := b.SymbolTable
+	if _, ok := sym.Locals[name]; !ok && !strings.HasPrefix(name, "_") {
+		root := sym
+		for root.Parent != nil {
+			root = root.Parent
+		}
+		if isLocal, _ := sym.Resolve(name); isLocal {
+			b.warn(b.pos, "%s shadows an outer variable", name)
+		} else if _, ok := root.Globals[name]; ok {
+			b.warn(b.pos, "%s shadows function %s", name, name)
+		} else if _, ok := builtins.Builtins[name]; ok {
+			b.warn(b.pos, "%s shadows builtin %s", name, name)
+		}
+	}
+	return sym.Define(name, true)
+}
+
+// checkFuncName warns when a function is named like a builtin: calls by
+// that name reach the function, no longer the builtin.
+func (b *Builder) checkFuncName(name string) {
+	if _, ok := builtins.Builtins[name]; ok {
+		b.warn(b.pos, "function %s shadows builtin %s", name, name)
+	}
+}
+
+// checkUnused warns about names that were declared in the current scope but
+// never read. Names starting with an underscore are exempt.
+func (b *Builder) checkUnused(kind string, names ...string) {
+	for _, name := range names {
+		if !b.SymbolTable.Used[name] && !strings.HasPrefix(name, "_") {
+			b.warn(b.pos, "%s %s is never used", kind, name)
+		}
+	}
+}
+
+// checkCondition warns about conditions made only of literals. A bare true
+// is allowed since "while true do" is the way to write an endless loop.
+func (b *Builder) checkCondition(cond parser.Node, allowTrue bool) {
+	if lit, ok := cond.(*parser.LiteralNode); ok && allowTrue && lit.Value == true {
+		return
+	}
+	if isConstant(cond) {
+		b.warn(b.pos, "condition is constant")
+	}
+}
+
+func isConstant(n parser.Node) bool {
+	switch n := n.(type) {
+	case *parser.LiteralNode:
+		return true
+	case *parser.UnaryOpNode:
+		return isConstant(n.Right)
+	case *parser.BinaryOpNode:
+		return isConstant(n.Left) && isConstant(n.Right)
+	}
+	return false
+}
@@ -0,0 +1,366 @@
+// Package gort is the runtime of lightlang programs translated to Go with
+// "lightlang build --emit=go". Values are the Go values builtins use:
+// float64, string, bool, nil, []interface{}, map[string]interface{} and
+// the objects of builtins. The operations follow the VM, so a translated
+// program prints what the interpreted one does.
+package gort
+
+import (
+	"fmt"
+	"lightlang/builtins"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// Function is a lightlang function. A function value is the table
+// {type: "function", entry: *Function}, which is how builtins know
+// functions.
+type Function struct {
+	Name   string
+	Params int
+	Fn     func(args []interface{}) interface{}
+}
+
+func (f *Function) String() string {
+	if f.Name == "" {
+		return "anonymous"
+	}
+	return f.Name
+}
+
+// Func makes the value of a function taking params arguments.
+func Func(name string, params int, fn func(args []interface{}) interface{}) interface{} {
+	return map[string]interface{}{"type": "function", "entry": &Function{Name: name, Params: params, Fn: fn}}
+}
+
+func function(v interface{}) (*Function, bool) {
+	t, ok := v.(map[string]interface{})
+	if !ok || t["type"] != "function" {
+		return nil, false
+	}
+	f, ok := t["entry"].(*Function)
+	return f, ok
+}
+
+// Arg is argument i of a call, or nil when there are fewer.
+func Arg(args []interface{}, i int) interface{} {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+// value converts what builtins return the way the VM does: integers
+// become numbers.
+func value(x interface{}) interface{} {
+	switch x := x.(type) {
+	case int:
+		return float64(x)
+	case int64:
+		return float64(x)
+	case int32:
+		return float64(x)
+	}
+	return x
+}
+
+func number(x interface{}) float64 {
+	if n, ok := x.(float64); ok {
+		return n
+	}
+	return 0
+}
+
+// object reports whether x is a table, an array, a function or an object
+// of builtins rather than nil, a number, a bool or a string.
+func object(x interface{}) bool {
+	switch x.(type) {
+	case nil, float64, bool, string:
+		return false
+	}
+	return true
+}
+
+// small holds the whole numbers from 0 to 1023 already boxed, since
+// putting a float64 in an interface allocates.
+var small = func() (boxed [1024]interface{}) {
+	for i := range boxed {
+		boxed[i] = float64(i)
+	}
+	return boxed
+}()
+
+// num boxes n, without allocating for small whole numbers.
+func num(n float64) interface{} {
+	if i := int(n); i >= 0 && i < len(small) && float64(i) == n {
+		return small[i]
+	}
+	return n
+}
+
+func boolNumber(ok bool) interface{} {
+	if ok {
+		return small[1]
+	}
+	return small[0]
+}
+
+// Truthy is the test of if, while, not, and and or.
+func Truthy(x interface{}) bool {
+	if n, ok := x.(float64); ok {
+		return n != 0
+	}
+	return builtins.Truthy(x)
+}
+
+func Not(x interface{}) interface{} { return boolNumber(!Truthy(x)) }
+
+// Add adds numbers and joins anything else as text. Arrays are
+// concatenated, or get the other value appended.
+func Add(a, b interface{}) interface{} {
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			return num(x + y)
+		}
+	}
+	if x, ok := a.([]interface{}); ok {
+		if y, ok := b.([]interface{}); ok {
+			return slices.Concat(x, y)
+		}
+		return append(slices.Clip(x), b)
+	}
+	_, as := a.(string)
+	_, bs := b.(string)
+	if !as && !bs {
+		if res, ok, err := builtins.Arith('+', a, b); ok {
+			return check(res, err)
+		}
+	}
+	return check(ctx.Display(a)).(string) + check(ctx.Display(b)).(string)
+}
+
+func Sub(a, b interface{}) interface{} {
+	return arith('-', a, b, func(x, y float64) float64 { return x - y })
+}
+func Mul(a, b interface{}) interface{} {
+	return arith('*', a, b, func(x, y float64) float64 { return x * y })
+}
+
+func arith(op byte, a, b interface{}, fast func(x, y float64) float64) interface{} {
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			return num(fast(x, y))
+		}
+	}
+	if res, ok, err := builtins.Arith(op, a, b); ok {
+		return check(res, err)
+	}
+	return fast(number(a), number(b))
+}
+
+func Div(a, b interface{}) interface{} {
+	if object(a) || object(b) {
+		if res, ok, err := builtins.Arith('/', a, b); ok {
+			return check(res, err)
+		}
+	}
+	if number(b) == 0 {
+		Throw(fmt.Errorf("div by zero"))
+	}
+	return number(a) / number(b)
+}
+
+func Eq(a, b interface{}) interface{} { return boolNumber(builtins.Equal(a, b)) }
+func Ne(a, b interface{}) interface{} { return boolNumber(!builtins.Equal(a, b)) }
+
+// compare applies test to a and b: strings are compared by their bytes,
+// bigints and decimals exactly and anything else as numbers. A string and
+// anything else throw.
+func compare(a, b interface{}, test func(x, y float64) bool) interface{} {
+	x, aok := a.(string)
+	y, bok := b.(string)
+	if aok && bok {
+		return boolNumber(test(float64(strings.Compare(x, y)), 0))
+	}
+	if aok || bok {
+		Throw(fmt.Errorf("cannot compare %s and %s", typeName(a), typeName(b)))
+	}
+	if object(a) || object(b) {
+		if c, ok := builtins.Compare(a, b); ok {
+			return boolNumber(test(float64(c), 0))
+		}
+	}
+	return boolNumber(test(number(a), number(b)))
+}
+
+func Lt(a, b interface{}) interface{} {
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			return boolNumber(x < y)
+		}
+	}
+	return compare(a, b, func(x, y float64) bool { return x < y })
+}
+
+func Lte(a, b interface{}) interface{} {
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			return boolNumber(x <= y)
+		}
+	}
+	return compare(a, b, func(x, y float64) bool { return x <= y })
+}
+
+func Gt(a, b interface{}) interface{} {
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			return boolNumber(x > y)
+		}
+	}
+	return compare(a, b, func(x, y float64) bool { return x > y })
+}
+
+func Gte(a, b interface{}) interface{} {
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			return boolNumber(x >= y)
+		}
+	}
+	return compare(a, b, func(x, y float64) bool { return x >= y })
+}
+
+// integer gives the value of x for a bitwise op.
+func integer(x interface{}) int64 {
+	n, ok := x.(float64)
+	if !ok || n != math.Trunc(n) || n < -1<<63 || n >= 1<<63 {
+		Throw(fmt.Errorf("bitwise operators need whole numbers, got %s", builtins.Repr(x, "")))
+	}
+	return int64(n)
+}
+
+func BitAnd(a, b interface{}) interface{} { return float64(integer(a) & integer(b)) }
+func BitOr(a, b interface{}) interface{}  { return float64(integer(a) | integer(b)) }
+func BitXor(a, b interface{}) interface{} { return float64(integer(a) ^ integer(b)) }
+func BitNot(a interface{}) interface{}    { return float64(^integer(a)) }
+
+func Shl(a, b interface{}) interface{} {
+	x, n := integer(a), shift(b)
+	return float64(x << n)
+}
+
+func Shr(a, b interface{}) interface{} {
+	x, n := integer(a), shift(b)
+	return float64(x >> n)
+}
+
+func shift(b interface{}) uint64 {
+	n := integer(b)
+	if n < 0 {
+		Throw(fmt.Errorf("negative shift count %d", n))
+	}
+	return uint64(n)
+}
+
+// Table makes a table from keys and values in turn.
+func Table(kv ...interface{}) interface{} {
+	t := make(map[string]interface{}, max(len(kv)/2, 4))
+	for i := 0; i+1 < len(kv); i += 2 {
+		t[kv[i].(string)] = kv[i+1]
+	}
+	return t
+}
+
+// Index is t[i]: an element of an array, a field of a table or a
+// character of a string, and nil when there is none.
+func Index(t, i interface{}) interface{} {
+	switch t := t.(type) {
+	case []interface{}:
+		n := int(number(i))
+		if n >= 0 && n < len(t) {
+			return value(t[n])
+		}
+	case map[string]interface{}:
+		return value(t[builtins.TableKey(i)])
+	case *builtins.OrderedTable:
+		return value(t.Get(builtins.TableKey(i)))
+	case string:
+		if r, ok := builtins.RuneAt(t, int(number(i))); ok {
+			return r
+		}
+	}
+	return nil
+}
+
+// SetIndex is t[i] = v. Arrays do not grow, and values other than tables
+// and arrays throw.
+func SetIndex(t, i, v interface{}) {
+	switch t := t.(type) {
+	case []interface{}:
+		n := int(number(i))
+		if n >= 0 && n < len(t) {
+			t[n] = v
+		}
+	case map[string]interface{}:
+		t[builtins.TableKey(i)] = v
+	case *builtins.OrderedTable:
+		t.Set(builtins.TableKey(i), v)
+	default:
+		Throw(fmt.Errorf("cannot set index of %s", typeName(t)))
+	}
+}
+
+// Next is element i of the collection of a for-in loop, and false past
+// its end.
+func Next(coll interface{}, i int) (interface{}, bool) {
+	switch c := coll.(type) {
+	case []interface{}:
+		if i < len(c) {
+			return value(c[i]), true
+		}
+	case string:
+		return builtins.RuneAt(c, i)
+	case map[string]interface{}:
+		if i < len(c) {
+			return value(c[builtins.NumberKey(float64(i))]), true
+		}
+	case builtins.Iterator:
+		v, ok, err := c.Next()
+		if err != nil {
+			Throw(err)
+		}
+		return value(v), ok
+	default:
+		Throw(fmt.Errorf("cannot loop over %s", typeName(coll)))
+	}
+	return nil, false
+}
+
+// typeName is the name type annotations use for the type of x.
+func typeName(x interface{}) string {
+	switch x := x.(type) {
+	case nil:
+		return "nil"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case *big.Int:
+		return "bigint"
+	case *builtins.Decimal:
+		return "decimal"
+	case *builtins.OrderedTable:
+		return "table"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		if x["type"] == "function" {
+			return "function"
+		}
+		return "table"
+	}
+	return fmt.Sprintf("%T", x)
+}
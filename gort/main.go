@@ -0,0 +1,194 @@
+package gort
+
+import (
+	"errors"
+	"fmt"
+	"lightlang/builtins"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// MaxCallDepth bounds nested calls. Unlike the VM, tail calls count too.
+var MaxCallDepth = 100000
+
+var depth int
+
+// atExit are the functions registered with atexit, lightlang functions or
+// Go func() error.
+var atExit []interface{}
+
+var ctx = &builtins.Context{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+
+func init() {
+	ctx.CallFunction = callFunction
+	ctx.AtExit = func(fn interface{}) { atExit = append(atExit, fn) }
+}
+
+// Error is a runtime error of a translated program, with the line of the
+// lightlang source it happened at when that is known.
+type Error struct {
+	Err  error
+	File string
+	Line int
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return "Runtime Error: " + e.Err.Error()
+	}
+	return fmt.Sprintf("%s:%d: Runtime Error: %v", e.File, e.Line, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Throw stops the program with err. Translated code has no error results;
+// errors unwind as panics up to Main, or to the builtin that called back
+// into the program.
+func Throw(err error) {
+	var e *Error
+	if errors.As(err, &e) {
+		panic(e)
+	}
+	panic(&Error{Err: err})
+}
+
+func check(res interface{}, err error) interface{} {
+	if err != nil {
+		Throw(err)
+	}
+	return res
+}
+
+// locate sets the place of e to the innermost frame of translated code on
+// the stack. Translated code is package main, with line directives that
+// point into the lightlang source.
+func locate(e *Error) {
+	if e.Line > 0 {
+		return
+	}
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		f, more := frames.Next()
+		if strings.HasPrefix(f.Function, "main.") && f.Function != "main.main" {
+			e.File, e.Line = f.File, f.Line
+			// the go tool makes the file absolute
+			if wd, err := os.Getwd(); err == nil {
+				if rel, err := filepath.Rel(wd, f.File); err == nil && !strings.HasPrefix(rel, "..") {
+					e.File = rel
+				}
+			}
+			return
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+// protect runs fn and returns the error it throws.
+func protect(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			locate(e)
+			err = e
+		}
+	}()
+	fn()
+	return nil
+}
+
+// Lookup returns the builtin called name.
+func Lookup(name string) builtins.BuiltinFunc {
+	fn, ok := builtins.Builtins[name]
+	if !ok {
+		panic(fmt.Sprintf("gort: no builtin %s", name))
+	}
+	return fn
+}
+
+// Builtin calls fn, a builtin from Lookup.
+func Builtin(fn builtins.BuiltinFunc, args ...interface{}) interface{} {
+	return value(check(fn(ctx, args)))
+}
+
+// Call calls the function fn held by the global name, as a call by name
+// does.
+func Call(fn interface{}, name string, args ...interface{}) interface{} {
+	f, ok := function(fn)
+	if !ok {
+		Throw(fmt.Errorf("function '%s' not found", name))
+	}
+	return call(f, args)
+}
+
+// CallValue calls the function value fn.
+func CallValue(fn interface{}, args ...interface{}) interface{} {
+	f, ok := function(fn)
+	if !ok {
+		Throw(fmt.Errorf("cannot call non-function"))
+	}
+	return call(f, args)
+}
+
+func call(f *Function, args []interface{}) interface{} {
+	if len(args) > f.Params {
+		args = args[:f.Params]
+	}
+	return f.Fn(args)
+}
+
+// Enter starts a call of a function, and Leave ends it.
+func Enter() {
+	if depth >= MaxCallDepth {
+		Throw(fmt.Errorf("stack overflow: more than %d nested calls", MaxCallDepth))
+	}
+	depth++
+}
+
+func Leave() { depth-- }
+
+// callFunction lets builtins call functions of the program.
+func callFunction(fn interface{}, args []interface{}) (res interface{}, err error) {
+	f, ok := function(fn)
+	if !ok {
+		return nil, fmt.Errorf("cannot call non-function")
+	}
+	err = protect(func() { res = call(f, args) })
+	return res, err
+}
+
+// Main runs the top level of a translated program and then the functions
+// registered with atexit, and exits with the status of the program.
+func Main(run func() interface{}) {
+	err := protect(func() { run() })
+	hooks := atExit
+	atExit = nil
+	for _, fn := range slices.Backward(hooks) {
+		var hookErr error
+		if f, ok := fn.(func() error); ok {
+			hookErr = f()
+		} else {
+			_, hookErr = callFunction(fn, nil)
+		}
+		if err == nil {
+			err = hookErr
+		}
+	}
+	if err == nil {
+		os.Exit(0)
+	}
+	var exit *builtins.ExitError
+	if errors.As(err, &exit) {
+		os.Exit(exit.Code)
+	}
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
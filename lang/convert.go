@@ -0,0 +1,347 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"lightlang/vm"
+	"reflect"
+	"strconv"
+)
+
+// ToValue converts a Go value to a lightlang value. Numbers, strings and
+// booleans convert directly, slices and arrays become arrays, and maps and
+// structs become tables. Struct fields are named by their lightlang tag,
+// such as `lightlang:"id"`, or else by the field name; a tag of "-" skips
+// the field. An error becomes its message.
+func ToValue(x interface{}) (vm.Value, error) {
+	if v, ok := x.(vm.Value); ok {
+		return v, nil
+	}
+	res, err := toGo(reflect.ValueOf(x), "value")
+	if err != nil {
+		return vm.NilValue, err
+	}
+	return vm.ValueOf(res), nil
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// toGo converts rv to the Go values the VM keeps in tables and arrays.
+func toGo(rv reflect.Value, path string) (interface{}, error) {
+	if !rv.IsValid() {
+		return nil, nil
+	}
+	if rv.Type().Implements(errorType) {
+		if (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface) && rv.IsNil() {
+			return nil, nil
+		}
+		return rv.Interface().(error).Error(), nil
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return toGo(rv.Elem(), path)
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil, nil
+		}
+		arr := make([]interface{}, rv.Len())
+		for i := range arr {
+			elem, err := toGo(rv.Index(i), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = elem
+		}
+		return arr, nil
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		t := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key, err := mapKey(iter.Key(), path)
+			if err != nil {
+				return nil, err
+			}
+			elem, err := toGo(iter.Value(), path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			t[key] = elem
+		}
+		return t, nil
+	case reflect.Struct:
+		if v, ok := rv.Interface().(vm.Value); ok {
+			return v.Interface(), nil
+		}
+		t := make(map[string]interface{}, rv.NumField())
+		for i := 0; i < rv.NumField(); i++ {
+			name, ok := fieldName(rv.Type().Field(i))
+			if !ok {
+				continue
+			}
+			elem, err := toGo(rv.Field(i), path+"."+name)
+			if err != nil {
+				return nil, err
+			}
+			t[name] = elem
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("%s: cannot convert %s to a lightlang value", path, rv.Type())
+}
+
+// mapKey formats a Go map key the way the VM formats table keys.
+func mapKey(k reflect.Value, path string) (string, error) {
+	switch k.Kind() {
+	case reflect.String:
+		return k.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(k.Float(), 'g', -1, 64), nil
+	}
+	return "", fmt.Errorf("%s: cannot use %s as a table key", path, k.Type())
+}
+
+// fieldName returns the table key of an exported struct field.
+func fieldName(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	tag := f.Tag.Get("lightlang")
+	if tag == "-" {
+		return "", false
+	}
+	if tag != "" {
+		return tag, true
+	}
+	return f.Name, true
+}
+
+// FromValue stores v in the Go value target points to, converting tables
+// to maps or structs and arrays to slices or arrays the way ToValue
+// converts them back. Table keys that match no struct field are ignored.
+func FromValue(v vm.Value, target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("FromValue needs a non-nil pointer, got %T", target)
+	}
+	return fromGo(v.Interface(), rv.Elem(), "value")
+}
+
+var valueType = reflect.TypeOf(vm.Value{})
+
+func fromGo(x interface{}, rv reflect.Value, path string) error {
+	if rv.Type() == valueType {
+		rv.Set(reflect.ValueOf(vm.ValueOf(x)))
+		return nil
+	}
+	if rv.Type() == errorType {
+		switch x := x.(type) {
+		case nil:
+			rv.Set(reflect.Zero(errorType))
+		case string:
+			rv.Set(reflect.ValueOf(errors.New(x)))
+		default:
+			return mismatch(x, rv, path)
+		}
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Interface:
+		if x == nil {
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+		if !reflect.TypeOf(x).AssignableTo(rv.Type()) {
+			return mismatch(x, rv, path)
+		}
+		rv.Set(reflect.ValueOf(x))
+	case reflect.Pointer:
+		if x == nil {
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+		elem := reflect.New(rv.Type().Elem())
+		if err := fromGo(x, elem.Elem(), path); err != nil {
+			return err
+		}
+		rv.Set(elem)
+	case reflect.Bool:
+		switch x := x.(type) {
+		case bool:
+			rv.SetBool(x)
+		case float64:
+			// comparisons produce numbers
+			rv.SetBool(x != 0)
+		default:
+			return mismatch(x, rv, path)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := x.(float64)
+		if !ok || n != float64(int64(n)) || rv.OverflowInt(int64(n)) {
+			return mismatch(x, rv, path)
+		}
+		rv.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := x.(float64)
+		if !ok || n < 0 || n != float64(uint64(n)) || rv.OverflowUint(uint64(n)) {
+			return mismatch(x, rv, path)
+		}
+		rv.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		n, ok := x.(float64)
+		if !ok {
+			return mismatch(x, rv, path)
+		}
+		rv.SetFloat(n)
+	case reflect.String:
+		s, ok := x.(string)
+		if !ok {
+			return mismatch(x, rv, path)
+		}
+		rv.SetString(s)
+	case reflect.Slice, reflect.Array:
+		if x == nil && rv.Kind() == reflect.Slice {
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+		arr, ok := x.([]interface{})
+		if !ok {
+			return mismatch(x, rv, path)
+		}
+		if rv.Kind() == reflect.Array {
+			if len(arr) != rv.Len() {
+				return fmt.Errorf("%s: cannot convert an array of %d to %s", path, len(arr), rv.Type())
+			}
+		} else {
+			rv.Set(reflect.MakeSlice(rv.Type(), len(arr), len(arr)))
+		}
+		for i, elem := range arr {
+			if err := fromGo(elem, rv.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if x == nil {
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+		t, ok := x.(map[string]interface{})
+		if !ok || isFunction(t) {
+			return mismatch(x, rv, path)
+		}
+		m := reflect.MakeMapWithSize(rv.Type(), len(t))
+		for key, elem := range t {
+			k := reflect.New(rv.Type().Key()).Elem()
+			if err := parseKey(key, k, path); err != nil {
+				return err
+			}
+			e := reflect.New(rv.Type().Elem()).Elem()
+			if err := fromGo(elem, e, path+"."+key); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, e)
+		}
+		rv.Set(m)
+	case reflect.Struct:
+		t, ok := x.(map[string]interface{})
+		if !ok || isFunction(t) {
+			return mismatch(x, rv, path)
+		}
+		for i := 0; i < rv.NumField(); i++ {
+			name, ok := fieldName(rv.Type().Field(i))
+			if !ok {
+				continue
+			}
+			elem, ok := t[name]
+			if !ok {
+				continue
+			}
+			if err := fromGo(elem, rv.Field(i), path+"."+name); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s: cannot convert to %s", path, rv.Type())
+	}
+	return nil
+}
+
+// parseKey is the inverse of mapKey.
+func parseKey(key string, k reflect.Value, path string) error {
+	var err error
+	switch k.Kind() {
+	case reflect.String:
+		k.SetString(key)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(key, 10, k.Type().Bits()); err == nil {
+			k.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		if n, err = strconv.ParseUint(key, 10, k.Type().Bits()); err == nil {
+			k.SetUint(n)
+		}
+	case reflect.Float32, reflect.Float64:
+		var n float64
+		if n, err = strconv.ParseFloat(key, k.Type().Bits()); err == nil {
+			k.SetFloat(n)
+		}
+	default:
+		return fmt.Errorf("%s: cannot use %s as a map key", path, k.Type())
+	}
+	if err != nil {
+		return fmt.Errorf("%s: cannot convert key %q to %s", path, key, k.Type())
+	}
+	return nil
+}
+
+func isFunction(t map[string]interface{}) bool {
+	return t["type"] == "function" && t["entry"] != nil
+}
+
+func mismatch(x interface{}, rv reflect.Value, path string) error {
+	return fmt.Errorf("%s: cannot convert %s to %s", path, kindName(x), rv.Type())
+}
+
+// kindName names the lightlang type of x for errors.
+func kindName(x interface{}) string {
+	switch x := x.(type) {
+	case nil:
+		return "nil"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		if isFunction(x) {
+			return "function"
+		}
+		return "table"
+	}
+	return fmt.Sprintf("%T", x)
+}
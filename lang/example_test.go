@@ -0,0 +1,28 @@
+package lang_test
+
+import (
+	"fmt"
+	"lightlang/lang"
+	"lightlang/vm"
+	"time"
+)
+
+func Example() {
+	prog, err := lang.Compile("func add(a, b) return a + b; end")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	v := vm.New(vm.WithTimeout(time.Second))
+	if err := v.Run(prog); err != nil {
+		fmt.Println(err)
+		return
+	}
+	sum, err := v.Call("add", 1.0, 2.0)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(sum)
+	// Output: 3
+}
@@ -0,0 +1,56 @@
+// Package lang compiles lightlang source for embedding. Run the result on
+// a VM from package vm:
+//
+//	prog, err := lang.Compile("func add(a, b) return a + b; end")
+//	if err != nil {
+//		return err
+//	}
+//	v := vm.New(vm.WithTimeout(time.Second))
+//	if err := v.Run(prog); err != nil {
+//		return err
+//	}
+//	sum, err := v.Call("add", 1.0, 2.0)
+package lang
+
+import (
+	"lightlang/bytecode"
+	"lightlang/compiler"
+	"lightlang/vm"
+	"os"
+)
+
+// Program is a compiled program. Any number of VMs can run one at once.
+type Program = vm.Program
+
+// Compile compiles and optimizes source code. Global names are kept so
+// the host can reach them.
+func Compile(src string) (*Program, error) {
+	return compile("", src)
+}
+
+// CompileFile compiles the file at path. Errors and runtime errors of the
+// program name the file.
+func CompileFile(path string) (*Program, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return compile(path, string(src))
+}
+
+func compile(file, src string) (*Program, error) {
+	builder, err := compiler.Compile(file, src)
+	if err != nil {
+		return nil, err
+	}
+	instructions, constants := builder.Bytecode()
+	// Globals are looked up by name through vm.Call and vm.Global.
+	opt := compiler.NewOptimizer(instructions, constants, builder.SymbolTable)
+	opt.KeepNames = true
+	instructions, constants = opt.Optimize()
+	prog := &Program{Instructions: instructions, Constants: constants}
+	if file != "" {
+		prog.SourceMap = bytecode.NewSourceMap(instructions, file)
+	}
+	return prog, nil
+}
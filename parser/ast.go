@@ -0,0 +1,141 @@
+package parser
+
+// Node is a statement or expression of a parsed program. Every node embeds
+// the Pos where it starts.
+type Node interface {
+	Position() Pos
+}
+
+type LiteralNode struct {
+	Pos
+	Value interface{}
+	Type  string
+}
+type VariableNode struct {
+	Pos
+	Name string
+}
+type UnaryOpNode struct {
+	Pos
+	Op    string
+	Right Node
+}
+type BinaryOpNode struct {
+	Pos
+	Left  Node
+	Op    string
+	Right Node
+}
+
+// AssignmentNode.Type is the type declared with "let name: type = ...",
+// if any. Let is set for "let" and "const", and Const for
+// "const name = ...".
+type AssignmentNode struct {
+	Pos
+	Name    string
+	Type    string
+	Expr    Node
+	IsLocal bool
+	Let     bool
+	Const   bool
+	Index   int
+}
+type IndexAssignNode struct {
+	Pos
+	Table Node
+	Index Node
+	Value Node
+}
+type ExprStmtNode struct {
+	Pos
+	Expr Node
+}
+type CallNode struct {
+	Pos
+	Target         string
+	Args           []Node
+	CallType       string
+	IndirectTarget Node
+}
+type TableLiteralNode struct {
+	Pos
+	Keys    []string
+	Values  []Node
+	IsArray bool
+}
+type IndexAccessNode struct {
+	Pos
+	Table Node
+	Index Node
+}
+type WhileLoopNode struct {
+	Pos
+	Condition Node
+	Body      []Node
+}
+type IfNode struct {
+	Pos
+	Conditions []Node
+	Bodies     [][]Node
+	ElseBody   []Node
+}
+
+// FuncDefNode and AnonymousFuncNode keep declared types as written, like
+// "number" or "[string]". ParamTypes has an entry per parameter, empty
+// when none is declared, and ReturnType is empty when none is declared.
+// TypeParams are the names in "func first<T>(...)".
+type FuncDefNode struct {
+	Pos
+	Name       string
+	TypeParams []string
+	Params     []string
+	ParamTypes []string
+	ReturnType string
+	Body       []Node
+	Doc        string
+}
+type AnonymousFuncNode struct {
+	Pos
+	Params     []string
+	ParamTypes []string
+	ReturnType string
+	Body       []Node
+}
+type ForLoopNode struct {
+	Pos
+	Init       Node
+	Cond       Node
+	Update     Node
+	Body       []Node
+	LoopVar    string
+	Collection Node
+	Type       string
+}
+type ReturnNode struct {
+	Pos
+	Value Node
+}
+
+// TypeDefNode names a type: "type User = {name: string, age: number}".
+type TypeDefNode struct {
+	Pos
+	Name string
+	Type string
+}
+type BreakNode struct{ Pos }
+type ContinueNode struct{ Pos }
+
+// AsmNode is an "asm ... end" block of instructions written as in a
+// listing. Code holds its lines, the first of which is the one after the
+// asm keyword.
+type AsmNode struct {
+	Pos
+	Code string
+}
+
+// BlockNode is a "do ... end" block given to a macro after its arguments.
+// Macros are expanded while parsing, so it never reaches the compiler.
+type BlockNode struct {
+	Pos
+	Body []Node
+}
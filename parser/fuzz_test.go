@@ -0,0 +1,21 @@
+package parser_test
+
+import (
+	"lightlang/parser"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// FuzzParse checks that no source panics the parser.
+func FuzzParse(f *testing.F) {
+	files, _ := filepath.Glob("../tests/*.ll")
+	for _, file := range files {
+		if src, err := os.ReadFile(file); err == nil {
+			f.Add(string(src))
+		}
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		parser.Parse(src)
+	})
+}
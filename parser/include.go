@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadInclude reads the files named by include statements. Hosts that
+// must not let programs read files, like the playground, replace it with
+// one that fails.
+var ReadInclude = os.ReadFile
+
+// isInclude reports whether an include statement starts at the current
+// position, so a function named include can still be called.
+func (p *Parser) isInclude() bool {
+	if !p.matchKeyword("include") {
+		return false
+	}
+	i := p.pos + len("include")
+	for i < len(p.input) && (p.input[i] == ' ' || p.input[i] == '\t') {
+		i++
+	}
+	return i < len(p.input) && p.input[i] == '"'
+}
+
+// parseInclude reads `include "path"` and returns the statements of the
+// file it names, found relative to the file being parsed. A file is only
+// included once, so files may include each other. The statements take the
+// position of the include statement; errors in parsing them point into
+// the included file.
+func (p *Parser) parseInclude() ([]Node, error) {
+	at := p.lines.pos(p.stmtStart)
+	p.pos += len("include")
+	p.skipWhitespace()
+	start := p.pos
+	end := strings.IndexAny(p.input[start+1:], "\"\n")
+	if end < 0 || p.input[start+1+end] != '"' {
+		return nil, p.errorf("unterminated file name in include")
+	}
+	name := p.input[start+1 : start+1+end]
+	p.pos = start + end + 2
+	p.consumeTerminator()
+
+	path := name
+	if !filepath.IsAbs(path) {
+		base := filepath.Dir(p.file)
+		if info, err := os.Stat(p.file); err == nil && info.IsDir() {
+			base = p.file
+		}
+		path = filepath.Join(base, path)
+	}
+	key, err := filepath.Abs(path)
+	if err != nil {
+		key = path
+	}
+	if p.included == nil {
+		p.included = make(map[string]bool)
+		if p.file != "" {
+			if self, err := filepath.Abs(p.file); err == nil {
+				p.included[self] = true
+			}
+		}
+	}
+	if p.included[key] {
+		return nil, nil
+	}
+	p.included[key] = true
+
+	data, err := ReadInclude(path)
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("cannot include %s: %v", name, err)
+	}
+	if p.macros == nil {
+		p.macros = make(map[string]*Macro)
+	}
+	sub := NewParser(normalizeNewlines(string(data)))
+	sub.file, sub.included, sub.macros = path, p.included, p.macros
+	nodes, err := sub.ParseProgram()
+	if err != nil {
+		sub.errors = append(sub.errors, sub.locate(err))
+	}
+	if len(sub.errors) > 0 {
+		for _, se := range sub.errors {
+			if se.File == "" {
+				se.File = path
+			}
+		}
+		p.errors = append(p.errors, sub.errors...)
+		return nil, nil
+	}
+	for _, n := range nodes {
+		Walk(n, func(c Node) {
+			if pc, ok := c.(positioned); ok {
+				pc.setPos(at)
+			}
+		})
+	}
+	return nodes, nil
+}
+
+func normalizeNewlines(source string) string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	return strings.ReplaceAll(source, "\r", "\n")
+}
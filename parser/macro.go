@@ -0,0 +1,329 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxMacroDepth bounds macros expanding into other macros, which would
+// otherwise never end for a macro that uses itself.
+const maxMacroDepth = 100
+
+// Macro is a "macro name(params) ... end" definition. Its body is a
+// template that every use of the macro is expanded from: parameters are
+// replaced by the arguments of the use, and names the body declares with
+// let, const, for or as parameters are renamed, so they neither hide nor
+// are hidden by names in the arguments.
+type Macro struct {
+	Name   string
+	Params []string
+	Body   []Node
+}
+
+// isMacroDef reports whether "macro" at the current position starts a
+// definition rather than being a name.
+func (p *Parser) isMacroDef() bool {
+	i := p.pos + len("macro")
+	for i < len(p.input) && (p.input[i] == ' ' || p.input[i] == '\t') {
+		i++
+	}
+	start := i
+	i = identEnd(p.input, i)
+	for i < len(p.input) && (p.input[i] == ' ' || p.input[i] == '\t') {
+		i++
+	}
+	return i > start && i < len(p.input) && p.input[i] == '('
+}
+
+func (p *Parser) parseMacroDef() error {
+	def, err := p.parseFunctionDef()
+	if err != nil {
+		return err
+	}
+	fn := def.(*FuncDefNode)
+	if len(fn.TypeParams) > 0 || fn.ReturnType != "" || strings.Join(fn.ParamTypes, "") != "" {
+		return p.errorf("macro %s cannot declare types", fn.Name)
+	}
+	if p.macros == nil {
+		p.macros = make(map[string]*Macro)
+	}
+	p.macros[fn.Name] = &Macro{Name: fn.Name, Params: fn.Params, Body: fn.Body}
+	return nil
+}
+
+// parseMacroBlock parses a use of a macro followed by a "do ... end"
+// block, which is given to the macro as its last argument. It returns nil
+// when the statement at the current position is not one.
+func (p *Parser) parseMacroBlock() (Node, error) {
+	start := p.pos
+	end := identEnd(p.input, start)
+	if p.macros[p.input[start:end]] == nil {
+		return nil, nil
+	}
+	i := end
+	for i < len(p.input) && (p.input[i] == ' ' || p.input[i] == '\t') {
+		i++
+	}
+	if i >= len(p.input) || p.input[i] != '(' {
+		return nil, nil
+	}
+	depth := 0
+	for ; i < len(p.input) && p.input[i] != '\n'; i++ {
+		switch p.input[i] {
+		case '"':
+			for i++; i < len(p.input) && p.input[i] != '"' && p.input[i] != '\n'; i++ {
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	if depth != 0 || i >= len(p.input) {
+		return nil, nil
+	}
+	closing := i + 1
+	for i = closing; i < len(p.input) && (p.input[i] == ' ' || p.input[i] == '\t'); i++ {
+	}
+	if !p.matchKeywordAtPos("do", i) {
+		return nil, nil
+	}
+
+	p.exprFrom = start
+	call, err := p.expr(p.input[start:closing])
+	if err != nil {
+		return nil, err
+	}
+	p.pos = i + len("do")
+	body, err := p.parseBlockUntil([]string{"end"})
+	if err != nil {
+		return nil, err
+	}
+	if !p.matchKeyword("end") {
+		return nil, p.errorf("expected 'end' to close the block of %s", p.input[start:end])
+	}
+	p.pos += 3
+	c := call.(*CallNode)
+	c.Args = append(c.Args, &BlockNode{Pos: p.lines.pos(i), Body: body})
+	return &ExprStmtNode{Expr: c}, nil
+}
+
+type expander struct {
+	macros map[string]*Macro
+	uses   int
+	depth  int
+}
+
+// expandMacros replaces the uses of macros in nodes by their expansions.
+func (p *Parser) expandMacros(nodes []Node) ([]Node, error) {
+	if len(p.macros) == 0 {
+		return nodes, nil
+	}
+	e := &expander{macros: p.macros}
+	return e.block(nodes)
+}
+
+func (e *expander) macro(n Node) (*CallNode, *Macro) {
+	call, ok := n.(*CallNode)
+	if !ok || call.CallType != "direct" {
+		return nil, nil
+	}
+	return call, e.macros[call.Target]
+}
+
+func (e *expander) block(nodes []Node) ([]Node, error) {
+	var out []Node
+	for _, n := range nodes {
+		if stmt, ok := n.(*ExprStmtNode); ok {
+			if call, m := e.macro(stmt.Expr); m != nil {
+				body, err := e.expand(call, m)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, body...)
+				continue
+			}
+		}
+		n, err := e.node(n)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func (e *expander) node(n Node) (Node, error) {
+	if call, m := e.macro(n); m != nil {
+		body, err := e.expand(call, m)
+		if err != nil {
+			return nil, err
+		}
+		if len(body) == 1 {
+			if stmt, ok := body[0].(*ExprStmtNode); ok {
+				return stmt.Expr, nil
+			}
+		}
+		return nil, &SourceError{Pos: call.Pos, Err: fmt.Errorf("macro %s is used as a value, but its body is not one expression", m.Name)}
+	}
+	if b, ok := n.(*BlockNode); ok {
+		return nil, &SourceError{Pos: b.Pos, Err: fmt.Errorf("a do block can only follow the arguments of a macro")}
+	}
+	return Rebuild(n, e.node, e.block)
+}
+
+// expand returns the statements a use of m expands to, with the macros
+// they use expanded in turn.
+func (e *expander) expand(call *CallNode, m *Macro) ([]Node, error) {
+	if len(call.Args) != len(m.Params) {
+		return nil, &SourceError{Pos: call.Pos, Err: fmt.Errorf("macro %s expects %d arguments, got %d", m.Name, len(m.Params), len(call.Args))}
+	}
+	if e.depth >= maxMacroDepth {
+		return nil, &SourceError{Pos: call.Pos, Err: fmt.Errorf("macro %s expands more than %d levels deep", m.Name, maxMacroDepth)}
+	}
+	e.uses++
+	s := &substitution{macro: m, pos: call.Pos, args: make(map[string]Node), names: make(map[string]string)}
+	for i, param := range m.Params {
+		s.args[param] = call.Args[i]
+	}
+	for _, n := range m.Body {
+		Walk(n, func(n Node) {
+			switch n := n.(type) {
+			case *AssignmentNode:
+				if n.Let {
+					s.rename(n.Name, e.uses)
+				}
+			case *ForLoopNode:
+				if n.Type == "in" {
+					s.rename(n.LoopVar, e.uses)
+				} else if init, ok := n.Init.(*AssignmentNode); ok {
+					s.rename(init.Name, e.uses)
+				}
+			case *FuncDefNode:
+				for _, param := range n.Params {
+					s.rename(param, e.uses)
+				}
+			case *AnonymousFuncNode:
+				for _, param := range n.Params {
+					s.rename(param, e.uses)
+				}
+			}
+		})
+	}
+	body, err := s.block(m.Body)
+	if err != nil {
+		return nil, err
+	}
+	e.depth++
+	defer func() { e.depth-- }()
+	return e.block(body)
+}
+
+// substitution makes one expansion of a macro from its template.
+type substitution struct {
+	macro *Macro
+	pos   Pos
+	args  map[string]Node
+	// names maps the names the body declares to the ones they get
+	names map[string]string
+}
+
+// rename gives name a name that source text cannot spell, unique to use.
+func (s *substitution) rename(name string, use int) {
+	if _, ok := s.args[name]; !ok {
+		s.names[name] = fmt.Sprintf("%s#%d", name, use)
+	}
+}
+
+// name returns what a name written in the body becomes, failing when it
+// must stay a name but the argument for it is not one.
+func (s *substitution) name(name, what string) (string, error) {
+	if arg, ok := s.args[name]; ok {
+		v, ok := arg.(*VariableNode)
+		if !ok {
+			return "", &SourceError{Pos: s.pos, Err: fmt.Errorf("macro %s uses its argument %s as %s, which must be a name", s.macro.Name, name, what)}
+		}
+		return v.Name, nil
+	}
+	if renamed, ok := s.names[name]; ok {
+		return renamed, nil
+	}
+	return name, nil
+}
+
+func (s *substitution) block(nodes []Node) ([]Node, error) {
+	var out []Node
+	for _, n := range nodes {
+		if stmt, ok := n.(*ExprStmtNode); ok {
+			if v, ok := stmt.Expr.(*VariableNode); ok {
+				if b, ok := s.args[v.Name].(*BlockNode); ok {
+					out = append(out, Clone(b).(*BlockNode).Body...)
+					continue
+				}
+			}
+		}
+		n, err := s.node(n)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func (s *substitution) node(n Node) (Node, error) {
+	if v, ok := n.(*VariableNode); ok {
+		if arg, ok := s.args[v.Name]; ok {
+			if _, ok := arg.(*BlockNode); ok {
+				return nil, &SourceError{Pos: s.pos, Err: fmt.Errorf("macro %s uses the block %s as a value", s.macro.Name, v.Name)}
+			}
+			return Clone(arg), nil
+		}
+	}
+	out, err := Rebuild(n, s.node, s.block)
+	if err != nil {
+		return nil, err
+	}
+	switch c := out.(type) {
+	case *VariableNode:
+		c.Name, err = s.name(c.Name, "a name")
+	case *AssignmentNode:
+		c.Name, err = s.name(c.Name, "the target of an assignment")
+	case *ForLoopNode:
+		if c.Type == "in" {
+			c.LoopVar, err = s.name(c.LoopVar, "a loop variable")
+		}
+	case *FuncDefNode:
+		c.Name, err = s.name(c.Name, "a function name")
+		for i := range c.Params {
+			if c.Params[i], err = s.name(c.Params[i], "a parameter"); err != nil {
+				break
+			}
+		}
+	case *AnonymousFuncNode:
+		for i := range c.Params {
+			if c.Params[i], err = s.name(c.Params[i], "a parameter"); err != nil {
+				break
+			}
+		}
+	case *CallNode:
+		if arg, ok := s.args[c.Target]; ok && c.CallType == "direct" {
+			if v, ok := arg.(*VariableNode); ok {
+				c.Target = v.Name
+			} else {
+				c.Target, c.CallType, c.IndirectTarget = "", "indirect", Clone(arg)
+			}
+		} else if renamed, ok := s.names[c.Target]; ok && c.CallType == "direct" {
+			c.Target = renamed
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// errors in the expansion point at the use of the macro
+	out.(positioned).setPos(s.pos)
+	return out, nil
+}
@@ -0,0 +1,2062 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Parser struct {
+	input     string
+	pos       int
+	line      int
+	doc       []string
+	lines     lineIndex
+	stmtStart int
+	exprFrom  int
+	errors    ErrorList
+	macros    map[string]*Macro
+	// file is the path of the source, which includes are relative to, and
+	// included holds the files included so far.
+	file     string
+	included map[string]bool
+}
+
+func NewParser(input string) *Parser {
+	return &Parser{input: input, pos: 0, line: 1, lines: newLineIndex(input)}
+}
+
+func Parse(source string) ([]Node, error) {
+	return parse("", source, (*Parser).ParseProgram)
+}
+
+// ParseFile parses the source of file, whose include statements name files
+// relative to it.
+func ParseFile(file, source string) ([]Node, error) {
+	return parse(file, source, (*Parser).ParseProgram)
+}
+
+// ParseChunk parses source like the body of a function, so it may return,
+// for code run by eval and load.
+func ParseChunk(source string) ([]Node, error) {
+	return parse("", source, func(p *Parser) ([]Node, error) { return p.parseBlockUntil(nil) })
+}
+
+func parse(file, source string, program func(p *Parser) ([]Node, error)) ([]Node, error) {
+	p := NewParser(normalizeNewlines(source))
+	p.file = file
+	nodes, err := program(p)
+	if err == nil && len(p.errors) == 0 {
+		nodes, err = p.expandMacros(nodes)
+	}
+	if err != nil {
+		p.errors = append(p.errors, p.locate(err))
+	}
+	switch len(p.errors) {
+	case 0:
+		return nodes, nil
+	case 1:
+		return nil, p.errors[0]
+	}
+	return nil, p.errors
+}
+
+func (p *Parser) errorf(format string, args ...interface{}) error {
+	end := identEnd(p.input, p.pos)
+	return &SourceError{Pos: p.lines.pos(p.pos), Len: max(1, end-p.pos), Err: fmt.Errorf(format, args...)}
+}
+
+// locate gives err an absolute position. Expression errors are relative to
+// the expression text, which is searched for from the current statement.
+func (p *Parser) locate(err error) *SourceError {
+	se, ok := err.(*SourceError)
+	if !ok {
+		return &SourceError{Pos: p.lines.pos(min(p.pos, len(p.input))), Err: err}
+	}
+	if se.expr != "" {
+		base := p.stmtStart
+		if i := strings.Index(p.input[p.stmtStart:], se.expr); i >= 0 {
+			base += i
+		}
+		se.Pos = p.lines.pos(base + se.offset)
+		se.expr = ""
+	}
+	return se
+}
+
+// fail records a statement error and skips ahead so parsing can go on. A stray block keyword after an earlier error is most likely
+// fallout from that error and is skipped silently.
+func (p *Parser) fail(err error, start int) {
+	cascade := len(p.errors) > 0 && (p.matchKeywordAtPos("end", start) ||
+		p.matchKeywordAtPos("else", start) || p.matchKeywordAtPos("elseif", start))
+	se := p.locate(err)
+	if !cascade {
+		p.errors = append(p.errors, se)
+	}
+	// resume after the line the error was found on, which may be before
+	// p.pos when an unbalanced bracket swallowed the following lines
+	p.pos = start
+	if se.Line > 0 && se.Line <= len(p.lines) {
+		p.pos = max(start, min(p.lines[se.Line-1]+se.Col-1, len(p.input)))
+	}
+	for p.pos < len(p.input) && p.input[p.pos] != '\n' && p.input[p.pos] != ';' {
+		p.pos++
+	}
+	if p.pos < len(p.input) {
+		p.pos++
+	}
+}
+
+func (p *Parser) mark(node Node, offset int) Node {
+	if n, ok := node.(positioned); ok {
+		n.setPos(p.lines.pos(offset))
+	}
+	return node
+}
+
+func (p *Parser) ParseProgram() ([]Node, error) {
+	var nodes []Node
+	for p.pos < len(p.input) {
+		p.skipWhitespace()
+		if p.pos >= len(p.input) {
+			break
+		}
+		start := p.pos
+		p.stmtStart, p.exprFrom = start, start
+		if p.isLangPragma() {
+			if err := p.parseLangPragma(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.matchKeyword("macro") && p.isMacroDef() {
+			p.pos += len("macro")
+			if err := p.parseMacroDef(); err != nil {
+				p.fail(err, start)
+			}
+			continue
+		}
+		if p.isInclude() {
+			included, err := p.parseInclude()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			nodes = append(nodes, included...)
+			continue
+		}
+		if p.isAsm() {
+			stmt, err := p.parseAsm()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			nodes = append(nodes, p.mark(stmt, start))
+			continue
+		}
+		if !p.matchKeyword("func") {
+			p.doc = nil
+		}
+
+		if p.matchKeyword("func") {
+			p.pos += 4
+			fnNode, err := p.parseFunctionDef()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			nodes = append(nodes, p.mark(fnNode, start))
+			continue
+		}
+		if p.matchKeyword("if") {
+			p.pos += 2 // <-- consume "if" critical bug number 99999
+			ifNode, err := p.parseIfStatement()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			nodes = append(nodes, p.mark(ifNode, start))
+			continue
+		}
+		if p.matchKeyword("while") {
+			p.pos += 5
+			whileNode, err := p.parseWhileLoop()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			nodes = append(nodes, p.mark(whileNode, start))
+			continue
+		}
+		if p.matchKeyword("for") {
+			p.pos += 3
+			forNode, err := p.parseForLoop()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			nodes = append(nodes, p.mark(forNode, start))
+			continue
+		}
+		if p.matchKeyword("let") || p.matchKeyword("const") {
+			isConst := p.matchKeyword("const")
+			if isConst {
+				p.pos += 5
+			} else {
+				p.pos += 3
+			}
+			stmt, err := p.parseLetAssignment()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			stmt.(*AssignmentNode).Const = isConst
+			nodes = append(nodes, p.mark(stmt, start))
+			p.consumeTerminator()
+			continue
+		}
+		if p.matchKeyword("type") && p.isTypeDef() {
+			p.pos += 4
+			stmt, err := p.parseTypeDef()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			nodes = append(nodes, p.mark(stmt, start))
+			p.consumeTerminator()
+			continue
+		}
+
+		stmt, err := p.parseMacroBlock()
+		if stmt == nil && err == nil {
+			stmt, err = p.parseAssignmentOrExpr()
+		}
+		if err != nil {
+			p.fail(err, start)
+			continue
+		}
+		if stmt != nil {
+			nodes = append(nodes, p.mark(stmt, start))
+		}
+		p.consumeTerminator()
+	}
+	return nodes, nil
+}
+
+// isTypeDef tells "type Name = ..." from uses of the type builtin.
+func (p *Parser) isTypeDef() bool {
+	i := p.pos + 4
+	for i < len(p.input) && (p.input[i] == ' ' || p.input[i] == '\t') {
+		i++
+	}
+	start := i
+	i = identEnd(p.input, i)
+	if i == start {
+		return false
+	}
+	for i < len(p.input) && (p.input[i] == ' ' || p.input[i] == '\t') {
+		i++
+	}
+	return i < len(p.input) && p.input[i] == '=' && (i+1 == len(p.input) || p.input[i+1] != '=')
+}
+
+func (p *Parser) parseTypeDef() (Node, error) {
+	p.skipWhitespace()
+	start := p.pos
+	p.pos = identEnd(p.input, p.pos)
+	name := p.input[start:p.pos]
+	p.skipWhitespace()
+	p.pos++ // =
+	p.skipWhitespace()
+	typ := p.readType()
+	if typ == "" {
+		return nil, p.errorf("expected type after '=' in type %s", name)
+	}
+	return &TypeDefNode{Name: name, Type: typ}, nil
+}
+
+func (p *Parser) parseLetAssignment() (Node, error) {
+	p.skipWhitespace()
+	start := p.pos
+	p.pos = identEnd(p.input, p.pos)
+	if start == p.pos {
+		return nil, p.errorf("expected variable name after let")
+	}
+	varName := p.input[start:p.pos]
+	p.skipWhitespace()
+	typ := ""
+	if p.pos < len(p.input) && p.input[p.pos] == ':' {
+		p.pos++
+		p.skipWhitespace()
+		if typ = p.readType(); typ == "" {
+			return nil, p.errorf("expected type of %s", varName)
+		}
+		p.skipWhitespace()
+	}
+	if p.pos >= len(p.input) || p.input[p.pos] != '=' {
+		return nil, p.errorf("expected '=' in assignment")
+	}
+	p.pos++ // let the = DIE
+	p.skipWhitespace()
+	exprStr := p.readUntilTerminator()
+	exprNode, err := p.expr(exprStr)
+	if err != nil {
+		return nil, err
+	}
+	return &AssignmentNode{
+		Name: varName,
+		Type: typ,
+		Expr: exprNode,
+		Let:  true,
+	}, nil
+}
+
+func (p *Parser) parseAssignmentOrExpr() (Node, error) {
+	p.skipWhitespace()
+	if p.pos >= len(p.input) {
+		return nil, nil
+	}
+
+	start := p.pos
+
+	for p.pos < len(p.input) {
+		ch := p.input[p.pos]
+		if ch == ';' || ch == '\n' || ch == '\r' {
+			break
+		}
+		if ch == '"' {
+			p.pos++
+			for p.pos < len(p.input) && p.input[p.pos] != '"' && p.input[p.pos] != '\n' {
+				p.pos++
+			}
+			if p.pos < len(p.input) && p.input[p.pos] == '"' {
+				p.pos++
+			}
+			continue
+		}
+		if ch == '=' {
+			if p.pos+1 < len(p.input) && p.input[p.pos+1] == '=' {
+				p.pos += 2
+				continue
+			}
+			break
+		}
+		p.pos++
+	}
+
+	leftStr := strings.TrimSpace(p.input[start:p.pos])
+
+	if strings.HasPrefix(strings.TrimSpace(leftStr), "func") {
+		exprNode, err := p.expr(leftStr)
+		if err != nil {
+			return nil, err
+		}
+		return &ExprStmtNode{Expr: exprNode}, nil
+	}
+
+	if p.pos < len(p.input) && p.input[p.pos] == '=' {
+		p.pos++ // Skip the '='
+		p.skipWhitespace()
+		rightStr := p.readUntilTerminator()
+
+		// t[i] = v, t.a.b = v and grid[i][j] = v set the last index of
+		// what the rest of the target loads
+		if strings.ContainsAny(leftStr, "[.") {
+			target, err := p.expr(leftStr)
+			if err != nil {
+				return nil, err
+			}
+			if access, ok := target.(*IndexAccessNode); ok {
+				valueNode, err := p.expr(rightStr)
+				if err != nil {
+					return nil, err
+				}
+				return &IndexAssignNode{
+					Table: access.Table,
+					Index: access.Index,
+					Value: valueNode,
+				}, nil
+			}
+		}
+
+		if !isVariable(leftStr) {
+			return nil, p.errorf("invalid left side of assignment: %s", leftStr)
+		}
+		valueNode, err := p.expr(rightStr)
+		if err != nil {
+			return nil, err
+		}
+		return &AssignmentNode{
+			Name: leftStr,
+			Expr: valueNode,
+		}, nil
+	}
+
+	if leftStr == "" {
+		return nil, nil
+	}
+	exprNode, err := p.expr(leftStr)
+	if err != nil {
+		return nil, err
+	}
+	return &ExprStmtNode{Expr: exprNode}, nil
+}
+
+func (p *Parser) parseIfStatement() (Node, error) {
+	// if* <cond> then* <body> [elseif <cond> then <body>] [else <body>]? end* * means the keyword is REQUIRED
+	var conditions []Node
+	var bodies [][]Node
+
+	p.skipWhitespace()
+	condStr := p.readUntilKeyword("then")
+	condNode, err := p.expr(condStr)
+	if err != nil {
+		return nil, err
+	}
+	conditions = append(conditions, condNode)
+
+	body, err := p.parseBlockUntil([]string{"elseif", "else", "end"})
+	if err != nil {
+		return nil, err
+	}
+	bodies = append(bodies, body)
+
+	for p.matchKeyword("elseif") {
+		p.pos += 7
+		p.skipWhitespace()
+		condStr := p.readUntilKeyword("then")
+		condNode, err := p.expr(condStr)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condNode)
+
+		body, err := p.parseBlockUntil([]string{"elseif", "else", "end"})
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, body)
+	}
+
+	var elseBody []Node
+	if p.matchKeyword("else") {
+		p.pos += 4
+		var err error
+		elseBody, err = p.parseBlockUntil([]string{"end"})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !p.matchKeyword("end") {
+		return nil, p.errorf("expected 'end' to close if")
+	}
+	p.pos += 3
+	p.consumeTerminator()
+
+	return &IfNode{
+		Conditions: conditions,
+		Bodies:     bodies,
+		ElseBody:   elseBody,
+	}, nil
+}
+
+func (p *ExprParser) parseFunctionExpression() (Node, error) {
+	if err := p.consume("LPAREN"); err != nil {
+		return nil, err
+	}
+
+	var params, types []string
+	if !p.match("RPAREN") {
+		for {
+			if p.match("WORD") {
+				param := p.advance().Value
+				params = append(params, param)
+			} else {
+				return nil, p.errorf("expected parameter name")
+			}
+			typ := ""
+			if p.match("COLON") {
+				p.advance()
+				if typ = p.parseType(); typ == "" {
+					return nil, p.errorf("expected type of parameter %s", params[len(params)-1])
+				}
+			}
+			types = append(types, typ)
+
+			if p.match("COMMA") {
+				p.advance()
+				continue
+			} else if p.match("RPAREN") {
+				break
+			} else {
+				return nil, p.errorf("expected ',' or ')' in parameter list")
+			}
+		}
+	}
+	if err := p.consume("RPAREN"); err != nil {
+		return nil, err
+	}
+	ret := ""
+	if p.match("COLON") {
+		p.advance()
+		if ret = p.parseType(); ret == "" {
+			return nil, p.errorf("expected return type")
+		}
+	}
+
+	p.skipWhitespace()
+
+	var body []Node
+
+	if p.match("KW", "do") {
+		p.advance()
+		for !p.match("KW", "end") {
+			stmt, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			body = append(body, &ExprStmtNode{Pos: stmt.Position(), Expr: stmt})
+
+			p.skipWhitespace()
+			if p.match("SEMICOLON") {
+				p.advance()
+			}
+			p.skipWhitespace()
+		}
+		if err := p.consume("KW", "end"); err != nil {
+			return nil, err
+		}
+	} else {
+		p.skipWhitespace()
+		if p.match("KW", "return") {
+			p.advance()
+			p.skipWhitespace()
+			expr, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			body = []Node{&ReturnNode{Pos: expr.Position(), Value: expr}}
+			p.skipWhitespace()
+			if p.match("KW", "end") {
+				p.advance()
+			}
+		} else {
+			expr, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			body = []Node{&ReturnNode{Pos: expr.Position(), Value: expr}}
+			p.skipWhitespace()
+			if p.match("KW", "end") {
+				p.advance()
+			}
+		}
+	}
+
+	return &AnonymousFuncNode{
+		Params:     params,
+		ParamTypes: types,
+		ReturnType: ret,
+		Body:       body,
+	}, nil
+}
+
+// parseType reads a type annotation like readType does and returns its
+// text.
+func (p *ExprParser) parseType() string {
+	if !p.match("WORD") && !p.match("LITERAL") && !p.match("KW", "func") && !p.match("LBRACK") && !p.match("LBRACE") {
+		return ""
+	}
+	start, depth := p.pos, 0
+scan:
+	for p.pos < len(p.tokens) {
+		t := p.tokens[p.pos]
+		switch {
+		case t.Type == "OP" && t.Value == "<":
+			depth++
+		case t.Type == "LBRACK" || t.Type == "LBRACE" || t.Type == "LPAREN":
+			if depth == 0 && p.pos > start {
+				break scan
+			}
+			depth++
+		case t.Type == "RBRACK" || t.Type == "RBRACE" || t.Type == "RPAREN" || t.Type == "OP" && t.Value == ">":
+			if depth == 0 {
+				break scan
+			}
+			depth--
+		case t.Type == "OP" && t.Value == ">>":
+			// closes two levels, as in Box<Box<number>>
+			if depth < 2 {
+				break scan
+			}
+			depth -= 2
+		case depth == 0 && p.pos > start:
+			break scan
+		}
+		p.pos++
+	}
+	last := p.tokens[p.pos-1]
+	return p.src[p.tokens[start].Offset : last.Offset+len(last.Value)]
+}
+
+func (p *Parser) parseForLoop() (Node, error) {
+	p.skipWhitespace()
+	savedPos := p.pos
+
+	loopType := ""
+
+	tempPos := p.pos
+
+	start := tempPos
+	tempPos = identEnd(p.input, tempPos)
+	if start < tempPos {
+		for tempPos < len(p.input) && (p.input[tempPos] == ' ' || p.input[tempPos] == '\t') {
+			tempPos++
+		}
+
+		if tempPos < len(p.input) && p.input[tempPos] == '=' {
+			tempPos++
+
+			semicolonCount := 0
+			inString := false
+			for tempPos < len(p.input) {
+				ch := p.input[tempPos]
+				if ch == '"' {
+					inString = !inString
+				} else if !inString {
+					if ch == ';' {
+						semicolonCount++
+						if semicolonCount == 2 {
+							loopType = "cstyle"
+							break
+						}
+					} else if p.matchKeywordAtPos("do", tempPos) {
+						break
+					}
+				}
+				tempPos++
+			}
+		}
+	}
+
+	p.pos = savedPos
+
+	if loopType == "" {
+		testStr := p.input[p.pos:]
+		doPos := strings.Index(strings.ToLower(testStr), " do")
+		if doPos > 0 {
+			segment := testStr[:doPos]
+			if strings.Contains(strings.ToLower(segment), " in ") {
+				loopType = "in"
+			}
+		}
+	}
+
+	if loopType == "" {
+		loopType = "cstyle"
+	}
+
+	switch loopType {
+	case "cstyle":
+		return p.parseCstyleForLoop()
+	case "in":
+		return p.parseInForLoop()
+	default:
+		return p.parseCstyleForLoop()
+	}
+}
+
+func (p *Parser) parseCstyleForLoop() (Node, error) {
+	p.skipWhitespace()
+
+	hasParen := false
+	if p.pos < len(p.input) && p.input[p.pos] == '(' {
+		hasParen = true
+		p.pos++
+		p.skipWhitespace()
+	}
+
+	var initNode Node = nil
+	if !p.matchKeyword(";") {
+		var initStr string
+		if hasParen {
+			initStr = p.readUntil(";")
+		} else {
+			start := p.pos
+			p.exprFrom = start
+			for p.pos < len(p.input) {
+				if p.input[p.pos] == ';' {
+					initStr = strings.TrimSpace(p.input[start:p.pos])
+					p.pos++
+					break
+				}
+				if p.matchKeywordAtPos("do", p.pos) {
+					p.pos = start
+					initStr = ""
+					break
+				}
+				p.pos++
+			}
+		}
+
+		if strings.TrimSpace(initStr) != "" {
+			if strings.Contains(initStr, "=") && !strings.Contains(strings.ToLower(initStr), "let") {
+				parts := strings.SplitN(initStr, "=", 2)
+				if len(parts) == 2 {
+					varName := strings.TrimSpace(parts[0])
+					exprStr := strings.TrimSpace(parts[1])
+					at := p.find(varName)
+					exprNode, err := p.expr(exprStr)
+					if err != nil {
+						return nil, err
+					}
+					initNode = p.mark(&AssignmentNode{
+						Name: varName,
+						Expr: exprNode,
+					}, at)
+				}
+			} else {
+				var err error
+				initNode, err = p.expr(initStr)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else {
+		p.pos++
+	}
+
+	p.skipWhitespace()
+
+	var condNode Node = nil
+	if !p.matchKeyword(";") {
+		var condStr string
+		if hasParen {
+			condStr = p.readUntil(";")
+		} else {
+			start := p.pos
+			p.exprFrom = start
+			for p.pos < len(p.input) {
+				if p.input[p.pos] == ';' {
+					condStr = strings.TrimSpace(p.input[start:p.pos])
+					p.pos++
+					break
+				}
+				if p.matchKeywordAtPos("do", p.pos) {
+					p.pos = start
+					condStr = ""
+					break
+				}
+				p.pos++
+			}
+		}
+
+		if strings.TrimSpace(condStr) != "" {
+			var err error
+			condNode, err = p.expr(condStr)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		p.pos++
+	}
+
+	p.skipWhitespace()
+
+	var updateNode Node = nil
+	var updateStr string
+
+	if hasParen {
+		updateStr = p.readUntil(")")
+		p.pos++
+		p.skipWhitespace()
+	} else {
+		start := p.pos
+		p.exprFrom = start
+		for p.pos < len(p.input) && !p.matchKeywordAtPos("do", p.pos) {
+			p.pos++
+		}
+		if p.pos > start {
+			updateStr = strings.TrimSpace(p.input[start:p.pos])
+		}
+	}
+
+	if strings.TrimSpace(updateStr) != "" {
+		if strings.Contains(updateStr, "=") {
+			parts := strings.SplitN(updateStr, "=", 2)
+			if len(parts) == 2 {
+				varName := strings.TrimSpace(parts[0])
+				exprStr := strings.TrimSpace(parts[1])
+				at := p.find(varName)
+				exprNode, err := p.expr(exprStr)
+				if err != nil {
+					return nil, err
+				}
+				updateNode = p.mark(&AssignmentNode{
+					Name: varName,
+					Expr: exprNode,
+				}, at)
+			}
+		} else {
+			var err error
+			updateNode, err = p.expr(updateStr)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !p.matchKeyword("do") {
+		return nil, p.errorf("expected 'do' after for loop condition")
+	}
+	p.pos += 2
+	p.skipWhitespace()
+
+	body, err := p.parseBlockUntil([]string{"end"})
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.matchKeyword("end") {
+		return nil, p.errorf("expected 'end' for for loop")
+	}
+	p.pos += 3
+	p.consumeTerminator()
+
+	return &ForLoopNode{
+		Init:   initNode,
+		Cond:   condNode,
+		Update: updateNode,
+		Body:   body,
+		Type:   "cstyle",
+	}, nil
+}
+
+func (p *Parser) parseInForLoop() (Node, error) {
+	p.skipWhitespace()
+
+	start := p.pos
+	p.pos = identEnd(p.input, p.pos)
+	if start == p.pos {
+		return nil, p.errorf("expected variable name in for loop")
+	}
+	loopVar := p.input[start:p.pos]
+
+	p.skipWhitespace()
+
+	if !p.matchKeyword("in") {
+		return nil, p.errorf("expected 'in' in for loop")
+	}
+	p.pos += 2
+
+	p.skipWhitespace()
+
+	startPos := p.pos
+	p.exprFrom = startPos
+	for p.pos < len(p.input) && !p.matchKeywordAtPos("do", p.pos) {
+		p.pos++
+	}
+
+	if p.pos >= len(p.input) {
+		return nil, p.errorf("expected 'do' after for loop collection")
+	}
+
+	collectionStr := strings.TrimSpace(p.input[startPos:p.pos])
+	collectionNode, err := p.expr(collectionStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.matchKeyword("do") {
+		return nil, p.errorf("expected 'do' after for loop collection")
+	}
+	p.pos += 2
+
+	body, err := p.parseBlockUntil([]string{"end"})
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.matchKeyword("end") {
+		return nil, p.errorf("expected 'end' for for loop")
+	}
+	p.pos += 3
+	p.consumeTerminator()
+
+	return &ForLoopNode{
+		LoopVar:    loopVar,
+		Collection: collectionNode,
+		Body:       body,
+		Type:       "in",
+	}, nil
+}
+
+func (p *Parser) matchKeywordAtPos(kw string, pos int) bool {
+	if pos+len(kw) > len(p.input) {
+		return false
+	}
+	sub := p.input[pos : pos+len(kw)]
+	if sub != kw {
+		return false
+	}
+	nextIdx := pos + len(kw)
+	if nextIdx >= len(p.input) {
+		return true
+	}
+	return identEnd(p.input, nextIdx) == nextIdx
+}
+
+func (p *Parser) readUntil(stopChar string) string {
+	start := p.pos
+	p.exprFrom = start
+	for p.pos < len(p.input) && string(p.input[p.pos]) != stopChar {
+		if p.input[p.pos] == '-' && p.pos+1 < len(p.input) && p.input[p.pos+1] == '-' {
+			p.pos += 2
+			for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		p.pos++
+	}
+	result := p.input[start:p.pos]
+	if p.pos < len(p.input) && string(p.input[p.pos]) == stopChar {
+		p.pos++
+	}
+	return strings.TrimSpace(result)
+}
+
+func (p *Parser) parseWhileLoop() (Node, error) {
+	p.skipWhitespace()
+	condStr := p.readUntilKeyword("do")
+	condNode, err := p.expr(condStr)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := p.parseBlockUntil([]string{"end"})
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.matchKeyword("end") {
+		return nil, p.errorf("expected 'end' for while loop")
+	}
+	p.pos += 3
+	p.consumeTerminator()
+
+	return &WhileLoopNode{Condition: condNode, Body: body}, nil
+}
+
+func (p *Parser) parseFunctionDef() (Node, error) {
+	doc := strings.Join(p.doc, "\n")
+	p.doc = nil
+	p.skipWhitespace()
+	start := p.pos
+	p.pos = identEnd(p.input, p.pos)
+	name := p.input[start:p.pos]
+	p.skipWhitespace()
+
+	var typeParams []string
+	if p.pos < len(p.input) && p.input[p.pos] == '<' {
+		end := strings.IndexByte(p.input[p.pos:], '>')
+		if end < 0 {
+			return nil, p.errorf("unclosed type parameters of %s", name)
+		}
+		for _, t := range strings.Split(p.input[p.pos+1:p.pos+end], ",") {
+			if t = strings.TrimSpace(t); t == "" {
+				return nil, p.errorf("expected type parameter name")
+			}
+			typeParams = append(typeParams, t)
+		}
+		p.pos += end + 1
+		p.skipWhitespace()
+	}
+
+	if p.pos >= len(p.input) || p.input[p.pos] != '(' {
+		return nil, p.errorf("expect '(' in function definition")
+	}
+	p.pos++
+	var params, types []string
+
+	for {
+		p.skipWhitespace()
+		if p.pos >= len(p.input) {
+			return nil, p.errorf("unclosed parameters")
+		}
+		if p.input[p.pos] == ')' {
+			p.pos++
+			break
+		}
+		argStart := p.pos
+		p.pos = identEnd(p.input, p.pos)
+		if argStart == p.pos {
+			return nil, p.errorf("expected parameter name")
+		}
+		params = append(params, p.input[argStart:p.pos])
+		p.skipWhitespace()
+		typ := ""
+		if p.pos < len(p.input) && p.input[p.pos] == ':' {
+			p.pos++
+			p.skipWhitespace()
+			if typ = p.readType(); typ == "" {
+				return nil, p.errorf("expected type of parameter %s", params[len(params)-1])
+			}
+			p.skipWhitespace()
+		}
+		types = append(types, typ)
+		if p.pos < len(p.input) {
+			if p.input[p.pos] == ',' {
+				p.pos++
+				continue
+			} else if p.input[p.pos] == ')' {
+				p.pos++
+				break
+			}
+		}
+	}
+	ret := ""
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+	if p.pos < len(p.input) && p.input[p.pos] == ':' {
+		p.pos++
+		p.skipWhitespace()
+		if ret = p.readType(); ret == "" {
+			return nil, p.errorf("expected return type of %s", name)
+		}
+	}
+
+	body, err := p.parseBlockUntil([]string{"end"})
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.matchKeyword("end") {
+		return nil, p.errorf("expected 'end' to close function")
+	}
+	p.pos += 3
+	p.consumeTerminator()
+
+	return &FuncDefNode{Name: name, TypeParams: typeParams, Params: params, ParamTypes: types, ReturnType: ret, Body: body, Doc: doc}, nil
+}
+
+// readType reads a type annotation, like "number", "[string]" or
+// "{name: string}", and returns its text.
+func (p *Parser) readType() string {
+	start, depth := p.pos, 0
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		switch {
+		case c == '<':
+			depth++
+		case c == '[' || c == '{' || c == '(':
+			if depth == 0 && p.pos > start {
+				return strings.TrimSpace(p.input[start:p.pos])
+			}
+			depth++
+		case c == ']' || c == '}' || c == '>' || c == ')':
+			if depth == 0 {
+				return strings.TrimSpace(p.input[start:p.pos])
+			}
+			depth--
+		case depth == 0 && (c == '\n' || c == ';'):
+			return strings.TrimSpace(p.input[start:p.pos])
+		case depth == 0 && !(unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) || c == '_' || c == '?' || c == '.' || c >= utf8.RuneSelf):
+			return strings.TrimSpace(p.input[start:p.pos])
+		}
+		p.pos++
+	}
+	return strings.TrimSpace(p.input[start:p.pos])
+}
+
+func (p *Parser) parseBlockUntil(stopKeywords []string) ([]Node, error) {
+	var nodes []Node
+	for p.pos < len(p.input) {
+		p.skipWhitespace()
+		if p.pos >= len(p.input) {
+			if stopKeywords == nil {
+				break
+			}
+			return nil, p.errorf("unexpected EOF, expected block end")
+		}
+
+		matched := false
+		for _, kw := range stopKeywords {
+			if p.matchKeyword(kw) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			break
+		}
+		start := p.pos
+		p.stmtStart, p.exprFrom = start, start
+		if stopKeywords == nil && p.matchKeyword("macro") && p.isMacroDef() {
+			p.pos += len("macro")
+			if err := p.parseMacroDef(); err != nil {
+				p.fail(err, start)
+			}
+			continue
+		}
+		if p.isInclude() {
+			if stopKeywords != nil {
+				p.fail(p.errorf("include can only be used at the top level of a file"), start)
+				continue
+			}
+			included, err := p.parseInclude()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			nodes = append(nodes, included...)
+			continue
+		}
+		if p.isAsm() {
+			stmt, err := p.parseAsm()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			nodes = append(nodes, p.mark(stmt, start))
+			continue
+		}
+		if !p.matchKeyword("func") {
+			p.doc = nil
+		}
+
+		if p.matchKeyword("func") {
+			p.pos += 4
+			fnNode, err := p.parseFunctionDef()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			nodes = append(nodes, p.mark(fnNode, start))
+			continue
+		}
+		if p.matchKeyword("if") {
+			p.pos += 2 // <-- consume "if" critical bug number 99999
+			ifNode, err := p.parseIfStatement()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			nodes = append(nodes, p.mark(ifNode, start))
+			continue
+		}
+		if p.matchKeyword("while") {
+			p.pos += 5
+			whileNode, err := p.parseWhileLoop()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			nodes = append(nodes, p.mark(whileNode, start))
+			continue
+		}
+		if p.matchKeyword("for") {
+			p.pos += 3
+			forNode, err := p.parseForLoop()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			nodes = append(nodes, p.mark(forNode, start))
+			continue
+		}
+
+		if p.matchKeyword("return") {
+			p.pos += 6
+			p.skipWhitespace()
+			nextChar := ""
+			if p.pos < len(p.input) {
+				nextChar = string(p.input[p.pos])
+			}
+			if nextChar == ";" || nextChar == "\n" || nextChar == "" || isStopKeyword(p.input[p.pos:]) {
+				nodes = append(nodes, p.mark(&ReturnNode{Value: nil}, start))
+			} else {
+				exprStr := p.readUntilTerminator()
+				expr, err := p.expr(exprStr)
+				if err != nil {
+					p.fail(err, start)
+					continue
+				}
+				nodes = append(nodes, p.mark(&ReturnNode{Value: expr}, start))
+			}
+			continue
+		}
+		if p.matchKeyword("break") {
+			p.pos += 5
+			nodes = append(nodes, p.mark(&BreakNode{}, start))
+			p.consumeTerminator()
+			continue
+		}
+		if p.matchKeyword("continue") {
+			p.pos += 8
+			nodes = append(nodes, p.mark(&ContinueNode{}, start))
+			p.consumeTerminator()
+			continue
+		}
+
+		if p.matchKeyword("let") || p.matchKeyword("const") {
+			isConst := p.matchKeyword("const")
+			if isConst {
+				p.pos += 5
+			} else {
+				p.pos += 3
+			}
+			stmt, err := p.parseLetAssignment()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			stmt.(*AssignmentNode).Const = isConst
+			nodes = append(nodes, p.mark(stmt, start))
+			p.consumeTerminator()
+			continue
+		}
+		if p.matchKeyword("type") && p.isTypeDef() {
+			p.pos += 4
+			stmt, err := p.parseTypeDef()
+			if err != nil {
+				p.fail(err, start)
+				continue
+			}
+			nodes = append(nodes, p.mark(stmt, start))
+			p.consumeTerminator()
+			continue
+		}
+
+		stmt, err := p.parseMacroBlock()
+		if stmt == nil && err == nil {
+			stmt, err = p.parseAssignmentOrExpr()
+		}
+		if err != nil {
+			p.fail(err, start)
+			continue
+		}
+		if stmt != nil {
+			nodes = append(nodes, p.mark(stmt, start))
+		}
+		p.consumeTerminator()
+	}
+	return nodes, nil
+}
+
+func (p *ExprParser) skipWhitespace() {
+	for p.pos < len(p.tokens) && (p.tokens[p.pos].Type == "WHITESPACE" || p.tokens[p.pos].Type == "COMMENT") {
+		p.pos++
+	}
+}
+
+// expr parses s, an expression of the current statement, with its nodes
+// at their places in the source.
+func (p *Parser) expr(s string) (Node, error) {
+	n, err := parseExpression(s)
+	if err != nil {
+		return nil, err
+	}
+	base, starts := p.find(s), newLineIndex(s)
+	Walk(n, func(c Node) {
+		if pc, ok := c.(positioned); ok {
+			if pos := pc.Position(); pos.Line > 0 && pos.Line <= len(starts) {
+				pc.setPos(p.lines.pos(base + starts[pos.Line-1] + pos.Col - 1))
+			}
+		}
+	})
+	return n, nil
+}
+
+// find returns the offset of s in the current statement, searching on
+// from where the text of the last expression was read or found.
+func (p *Parser) find(s string) int {
+	if i := strings.Index(p.input[p.exprFrom:], s); i >= 0 {
+		p.exprFrom += i + len(s)
+		return p.exprFrom - len(s)
+	}
+	if i := strings.Index(p.input[p.stmtStart:], s); i >= 0 {
+		return p.stmtStart + i
+	}
+	return p.stmtStart
+}
+
+func parseExpression(s string) (Node, error) {
+	tokens := Tokenize(s)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	parser := &ExprParser{tokens: tokens, pos: 0, src: s}
+	return parser.parseOr()
+}
+
+type Token struct {
+	Type   string
+	Value  string
+	Line   int
+	Col    int
+	Offset int
+}
+
+func Tokenize(s string) []Token {
+	var tokens []Token
+	line, lineStart, scanned := 1, 0, 0
+	add := func(typ, val string, start int) {
+		for ; scanned < start; scanned++ {
+			if s[scanned] == '\n' {
+				line++
+				lineStart = scanned + 1
+			}
+		}
+		tokens = append(tokens, Token{Type: typ, Value: val, Line: line, Col: start - lineStart + 1, Offset: start})
+	}
+	for i := 0; i < len(s); {
+		ch := s[i]
+		if ch == ' ' || ch == '\t' {
+			i++
+			continue
+		}
+
+		if ch == '"' {
+			start := i
+			i++
+			for i < len(s) && s[i] != '"' {
+				i++
+			}
+			if i < len(s) {
+				i++
+			}
+			add("STRING", s[start:i], start)
+			continue
+		}
+
+		if unicode.IsDigit(rune(ch)) || (ch == '.' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1]))) {
+			start := i
+			for i < len(s) && (unicode.IsDigit(rune(s[i])) || s[i] == '.') {
+				i++
+			}
+			add("NUMBER", s[start:i], start)
+			continue
+		}
+
+		if r, _ := utf8.DecodeRuneInString(s[i:]); unicode.IsLetter(r) || r == '_' {
+			start := i
+			i = identEnd(s, i)
+			val := s[start:i]
+			if val == "and" || val == "or" || val == "not" || val == "func" || val == "do" || val == "end" || val == "return" {
+				add("KW", val, start)
+			} else if val == "true" || val == "false" || val == "nil" {
+				add("LITERAL", val, start)
+			} else {
+				add("WORD", val, start)
+			}
+			continue
+		}
+
+		if i+1 < len(s) {
+			two := s[i : i+2]
+			if two == "==" || two == "!=" || two == "<=" || two == ">=" || two == "??" || two == "<<" || two == ">>" {
+				add("OP", two, i)
+				i += 2
+				continue
+			}
+		}
+
+		switch ch {
+		case ';':
+			add("SEMICOLON", ";", i)
+			i++
+		case '+', '*', '/', '<', '>', '=', '&', '|', '^', '~':
+			add("OP", string(ch), i)
+			i++
+		case '-':
+			if i+1 < len(s) && s[i+1] == '-' {
+				for i < len(s) && s[i] != '\n' {
+					i++
+				}
+				continue
+			}
+			add("OP", string(ch), i)
+			i++
+		case '(':
+			add("LPAREN", "(", i)
+			i++
+		case ')':
+			add("RPAREN", ")", i)
+			i++
+		case '[':
+			add("LBRACK", "[", i)
+			i++
+		case ']':
+			add("RBRACK", "]", i)
+			i++
+		case ',':
+			add("COMMA", ",", i)
+			i++
+		case '{':
+			add("LBRACE", "{", i)
+			i++
+		case '}':
+			add("RBRACE", "}", i)
+			i++
+		case ':':
+			add("COLON", ":", i)
+			i++
+		case '.':
+			add("DOT", ".", i)
+			i++
+		default:
+			i++
+		}
+	}
+	return tokens
+}
+
+type ExprParser struct {
+	tokens []Token
+	pos    int
+	src    string
+}
+
+// at gives n the position of tok unless it has one. Positions are in the
+// expression text until Parser.expr moves them into the source.
+func (p *ExprParser) at(n Node, tok Token) Node {
+	if pn, ok := n.(positioned); ok && pn.Position().Line == 0 && tok.Line > 0 {
+		pn.setPos(Pos{Line: tok.Line, Col: tok.Col})
+	}
+	return n
+}
+
+func (p *ExprParser) errorf(format string, args ...interface{}) error {
+	offset, length := len(p.src), 1
+	if p.pos < len(p.tokens) {
+		offset, length = p.tokens[p.pos].Offset, len(p.tokens[p.pos].Value)
+	}
+	return &SourceError{Len: length, Err: fmt.Errorf(format, args...), expr: p.src, offset: offset}
+}
+
+// stringValue gives the text of a STRING token without its quotes.
+func (p *ExprParser) stringValue(tok Token) (string, error) {
+	if len(tok.Value) < 2 || tok.Value[len(tok.Value)-1] != '"' {
+		return "", p.errorf("unterminated string")
+	}
+	return tok.Value[1 : len(tok.Value)-1], nil
+}
+
+func (p *ExprParser) peek() Token {
+	if p.pos >= len(p.tokens) {
+		return Token{Type: "EOF"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *ExprParser) advance() Token {
+	if p.pos < len(p.tokens) {
+		t := p.tokens[p.pos]
+		p.pos++
+		return t
+	}
+	return Token{Type: "EOF"}
+}
+
+func (p *ExprParser) match(typ string, val ...string) bool {
+	if p.pos >= len(p.tokens) {
+		return false
+	}
+	t := p.tokens[p.pos]
+	if t.Type != typ {
+		return false
+	}
+	if len(val) > 0 && t.Value != val[0] {
+		return false
+	}
+	return true
+}
+
+func (p *ExprParser) consume(typ string, val ...string) error {
+	if !p.match(typ, val...) {
+		t := p.peek()
+		return p.errorf("expected %s %v but got %s %s", typ, val, t.Type, t.Value)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *ExprParser) parseOr() (Node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.match("KW", "or") || p.match("OP", "??") {
+		tok := p.advance()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = p.at(&BinaryOpNode{Left: left, Op: tok.Value, Right: right}, tok)
+	}
+	return left, nil
+}
+
+func (p *ExprParser) parseAnd() (Node, error) {
+	left, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+	for p.match("KW", "and") {
+		tok := p.advance()
+		right, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		left = p.at(&BinaryOpNode{Left: left, Op: "and", Right: right}, tok)
+	}
+	return left, nil
+}
+
+func (p *ExprParser) parseCompare() (Node, error) {
+	left, err := p.parseBitOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.match("OP", "==") || p.match("OP", "!=") || p.match("OP", "<") || p.match("OP", ">") || p.match("OP", "<=") || p.match("OP", ">=") {
+		tok := p.advance()
+		right, err := p.parseBitOr()
+		if err != nil {
+			return nil, err
+		}
+		return p.at(&BinaryOpNode{Left: left, Op: tok.Value, Right: right}, tok), nil
+	}
+	return left, nil
+}
+
+// The bitwise operators bind tighter than comparisons, so flags&MASK == 0
+// tests the masked bits: | loosest, then ^, &, and the shifts.
+func (p *ExprParser) parseBitOr() (Node, error)  { return p.parseLevel(p.parseBitXor, "|") }
+func (p *ExprParser) parseBitXor() (Node, error) { return p.parseLevel(p.parseBitAnd, "^") }
+func (p *ExprParser) parseBitAnd() (Node, error) { return p.parseLevel(p.parseShift, "&") }
+func (p *ExprParser) parseShift() (Node, error)  { return p.parseLevel(p.parseAdd, "<<", ">>") }
+
+// parseLevel parses left-associative uses of ops between operands read by
+// next.
+func (p *ExprParser) parseLevel(next func() (Node, error), ops ...string) (Node, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for p.match("OP") && slices.Contains(ops, p.peek().Value) {
+		tok := p.advance()
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = p.at(&BinaryOpNode{Left: left, Op: tok.Value, Right: right}, tok)
+	}
+	return left, nil
+}
+
+func (p *ExprParser) parseAdd() (Node, error) {
+	left, err := p.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for p.match("OP", "+") || p.match("OP", "-") {
+		tok := p.advance()
+		right, err := p.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		left = p.at(&BinaryOpNode{Left: left, Op: tok.Value, Right: right}, tok)
+	}
+	return left, nil
+}
+
+func (p *ExprParser) parseMul() (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.match("OP", "*") || p.match("OP", "/") {
+		tok := p.advance()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = p.at(&BinaryOpNode{Left: left, Op: tok.Value, Right: right}, tok)
+	}
+	return left, nil
+}
+
+func (p *ExprParser) parseUnary() (Node, error) {
+	if p.match("KW", "not") {
+		tok := p.advance()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return p.at(&UnaryOpNode{Op: "not", Right: right}, tok), nil
+	}
+	if p.match("OP", "~") {
+		tok := p.advance()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return p.at(&UnaryOpNode{Op: "~", Right: right}, tok), nil
+	}
+	if p.match("OP", "-") {
+		tok := p.advance()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return p.at(&BinaryOpNode{Left: &LiteralNode{Value: 0.0, Type: "number"}, Op: "-", Right: right}, tok), nil
+	}
+	return p.parseAccess()
+}
+
+func (p *ExprParser) parseAccess() (Node, error) {
+	start := p.peek()
+	node, err := p.parseBase()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if p.match("LPAREN") {
+			p.advance()
+			args := []Node{}
+			if !p.match("RPAREN") {
+				for {
+					arg, err := p.parseOr()
+					if err != nil {
+						return nil, err
+					}
+					args = append(args, arg)
+					if p.match("COMMA") {
+						p.advance()
+						continue
+					}
+					if p.match("RPAREN") {
+						break
+					}
+					return nil, p.errorf("expecting ')' or ',' in call")
+				}
+			}
+			p.advance() // )
+
+			if _, ok := node.(*AnonymousFuncNode); ok {
+				node = &CallNode{
+					Target:         "",
+					Args:           args,
+					CallType:       "indirect",
+					IndirectTarget: node,
+				}
+			} else if v, ok := node.(*VariableNode); ok {
+				node = &CallNode{Target: v.Name, Args: args, CallType: "direct"}
+			} else {
+				node = &CallNode{
+					Target:         "",
+					Args:           args,
+					CallType:       "indirect",
+					IndirectTarget: node,
+				}
+			}
+			node = p.at(node, start)
+			continue
+		}
+		if p.match("LBRACK") {
+			p.advance()
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			err = p.consume("RBRACK")
+			if err != nil {
+				return nil, err
+			}
+			node = p.at(&IndexAccessNode{Table: node, Index: index}, start)
+			continue
+		}
+		if p.match("DOT") {
+			p.advance()
+			if !p.match("WORD") && !p.match("KW") && !p.match("LITERAL") {
+				return nil, p.errorf("expected field name after '.'")
+			}
+			field := p.advance().Value
+			node = p.at(&IndexAccessNode{Table: node, Index: &LiteralNode{Value: field, Type: "string"}}, start)
+			continue
+		}
+		break
+	}
+	return node, nil
+}
+
+func (p *ExprParser) parseBase() (Node, error) {
+	tok := p.peek()
+	node, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return p.at(node, tok), nil
+}
+
+func (p *ExprParser) parseOperand() (Node, error) {
+	tok := p.peek()
+
+	if tok.Type == "STRING" {
+		val, err := p.stringValue(tok)
+		if err != nil {
+			return nil, err
+		}
+		p.advance()
+		return &LiteralNode{Value: val, Type: "string"}, nil
+	}
+	if tok.Type == "NUMBER" {
+		p.advance()
+		val, _ := strconv.ParseFloat(tok.Value, 64)
+		return &LiteralNode{Value: val, Type: "number"}, nil
+	}
+	if tok.Type == "WORD" || tok.Type == "KW" || tok.Type == "LITERAL" {
+		p.advance()
+		if tok.Value == "true" {
+			return &LiteralNode{Value: true, Type: "bool"}, nil
+		}
+		if tok.Value == "false" {
+			return &LiteralNode{Value: false, Type: "bool"}, nil
+		}
+		if tok.Value == "nil" {
+			return &LiteralNode{Value: nil, Type: "nil"}, nil
+		}
+		if tok.Value == "func" {
+			return p.parseFunctionExpression()
+		}
+		return &VariableNode{Name: tok.Value}, nil
+	}
+
+	if tok.Type == "LBRACE" {
+		p.advance()
+		keys := []string{}
+		values := []Node{}
+		for !p.match("RBRACE") {
+			keyTok := p.peek()
+			var keyStr string
+			if keyTok.Type == "STRING" {
+				var err error
+				if keyStr, err = p.stringValue(keyTok); err != nil {
+					return nil, err
+				}
+				p.advance()
+			} else if keyTok.Type == "WORD" {
+				keyStr = keyTok.Value
+				p.advance()
+			} else {
+				return nil, p.errorf("expected string key in table literal")
+			}
+			if !p.match("COLON") {
+				return nil, p.errorf("expected ':' after key")
+			}
+			p.advance()
+			val, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, keyStr)
+			values = append(values, val)
+			if p.match("COMMA") {
+				p.advance()
+			}
+		}
+		p.advance() // }
+		return &TableLiteralNode{Keys: keys, Values: values, IsArray: false}, nil
+	}
+
+	if tok.Type == "LBRACK" {
+		p.advance()
+		values := []Node{}
+		if !p.match("RBRACK") {
+			for {
+				val, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, val)
+				if p.match("COMMA") {
+					p.advance()
+					continue
+				}
+				if p.match("RBRACK") {
+					break
+				}
+				return nil, p.errorf("expected ',' or ']' in array")
+			}
+		}
+		p.advance() // ]
+		return &TableLiteralNode{Values: values, IsArray: true}, nil
+	}
+
+	if tok.Type == "LPAREN" {
+		p.advance()
+		if p.match("KW", "func") {
+			p.advance()
+			return p.parseFunctionExpression()
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.match("RPAREN") {
+			return nil, p.errorf("expected ')' in expression")
+		}
+		p.advance()
+		return node, nil
+	}
+
+	if tok.Type == "EOF" {
+		return nil, p.errorf("unexpected end of expression")
+	}
+	return nil, p.errorf("unexpected token in expression: %s", tok.Value)
+}
+
+func (p *Parser) skipWhitespace() {
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		if c == ' ' || c == '\t' || c == '\r' {
+			p.pos++
+		} else if c == '\n' {
+			p.line++
+			p.pos++
+		} else if strings.HasPrefix(p.input[p.pos:], "///") {
+			p.pos += 3
+			start := p.pos
+			for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+				p.pos++
+			}
+			p.doc = append(p.doc, strings.TrimPrefix(p.input[start:p.pos], " "))
+		} else if c == '-' && p.pos+1 < len(p.input) && p.input[p.pos+1] == '-' {
+			p.pos += 2
+			for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+				p.pos++
+			}
+			if p.pos < len(p.input) && p.input[p.pos] == '\n' {
+				p.line++
+				p.pos++
+			}
+		} else {
+			break
+		}
+	}
+}
+
+func (p *Parser) matchKeyword(kw string) bool {
+	if p.pos+len(kw) > len(p.input) {
+		return false
+	}
+	sub := p.input[p.pos : p.pos+len(kw)]
+	if sub != kw {
+		return false
+	}
+	nextIdx := p.pos + len(kw)
+	if nextIdx >= len(p.input) {
+		return true
+	}
+	return identEnd(p.input, nextIdx) == nextIdx
+}
+
+func (p *Parser) consumeTerminator() {
+	p.skipWhitespace()
+	if p.pos < len(p.input) {
+		if p.input[p.pos] == ';' || p.input[p.pos] == '\n' {
+			p.pos++
+		}
+	}
+}
+
+func (p *Parser) readUntilTerminator() string {
+	start := p.pos
+	p.exprFrom = start
+	blockDepth := 0
+	parenDepth := 0
+	bracketDepth := 0
+	braceDepth := 0
+
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+
+		if c == '-' && p.pos+1 < len(p.input) && p.input[p.pos+1] == '-' {
+			p.pos += 2
+			for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+
+		if c == '"' {
+			p.pos++
+			for p.pos < len(p.input) && p.input[p.pos] != '"' {
+				p.pos++
+			}
+			if p.pos < len(p.input) {
+				p.pos++
+			}
+			continue
+		}
+
+		if p.matchKeywordAtPos("do", p.pos) || p.matchKeywordAtPos("then", p.pos) {
+			blockDepth++
+		} else if p.matchKeywordAtPos("end", p.pos) {
+			if blockDepth > 0 {
+				blockDepth--
+			}
+		}
+
+		if c == '(' {
+			parenDepth++
+		} else if c == ')' {
+			parenDepth--
+		} else if c == '[' {
+			bracketDepth++
+		} else if c == ']' {
+			bracketDepth--
+		} else if c == '{' {
+			braceDepth++
+		} else if c == '}' {
+			braceDepth--
+		}
+
+		if blockDepth == 0 && parenDepth == 0 && bracketDepth == 0 && braceDepth == 0 &&
+			(c == ';' || c == '\n' || c == '\r') {
+			break
+		}
+
+		p.pos++
+	}
+	res := strings.TrimSpace(p.input[start:p.pos])
+	return res
+}
+
+func (p *Parser) readUntilKeyword(kw string) string {
+	start := p.pos
+	p.exprFrom = start
+	parenDepth := 0
+	bracketDepth := 0
+	braceDepth := 0
+
+	for p.pos < len(p.input) {
+		if p.input[p.pos] == '(' {
+			parenDepth++
+		} else if p.input[p.pos] == ')' {
+			parenDepth--
+		} else if p.input[p.pos] == '[' {
+			bracketDepth++
+		} else if p.input[p.pos] == ']' {
+			bracketDepth--
+		} else if p.input[p.pos] == '{' {
+			braceDepth++
+		} else if p.input[p.pos] == '}' {
+			braceDepth--
+		}
+
+		if parenDepth == 0 && bracketDepth == 0 && braceDepth == 0 && p.matchKeyword(kw) {
+			break
+		}
+		p.pos++
+	}
+	res := strings.TrimSpace(p.input[start:p.pos])
+	if p.matchKeyword(kw) {
+		p.pos += len(kw)
+	}
+	return res
+}
+
+// identEnd returns the end of the letters, digits and underscores of s
+// starting at i.
+func identEnd(s string, i int) int {
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+func isVariable(s string) bool {
+	if s == "" {
+		return false
+	}
+	first, _ := utf8.DecodeRuneInString(s)
+	if !unicode.IsLetter(first) && first != '_' {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return false
+		}
+	}
+	kw := []string{"true", "false", "let", "const", "while", "do", "end", "if", "then", "else", "elseif", "func", "and", "or", "not", "return", "break", "continue"}
+	for _, k := range kw {
+		if s == k {
+			return false
+		}
+	}
+	return true
+}
+
+func isStopKeyword(s string) bool {
+	for _, kw := range []string{"end", "else", "elseif", "while", "if", "func", "return", "break", "continue"} {
+		if strings.HasPrefix(strings.TrimSpace(s), kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAsm reports whether an asm block starts at the current position: the
+// keyword alone on its line.
+func (p *Parser) isAsm() bool {
+	if !p.matchKeyword("asm") {
+		return false
+	}
+	rest := p.input[p.pos+len("asm"):]
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[:i]
+	}
+	rest = strings.TrimSpace(rest)
+	return rest == "" || strings.HasPrefix(rest, "--") || strings.HasPrefix(rest, ";")
+}
+
+// parseAsm reads the lines of an asm block up to one that is just "end".
+func (p *Parser) parseAsm() (Node, error) {
+	for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+		p.pos++
+	}
+	if p.pos < len(p.input) {
+		p.pos++
+	}
+	start := p.pos
+	for p.pos < len(p.input) {
+		end := strings.IndexByte(p.input[p.pos:], '\n')
+		if end < 0 {
+			end = len(p.input) - p.pos
+		}
+		line := p.input[p.pos : p.pos+end]
+		if strings.TrimSpace(line) == "end" {
+			code := p.input[start:p.pos]
+			p.pos += end
+			p.consumeTerminator()
+			return &AsmNode{Code: code}, nil
+		}
+		p.pos += end + 1
+	}
+	p.pos = start
+	return nil, p.errorf("expected 'end' to close asm block")
+}
@@ -0,0 +1,177 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Pos is a 1-based source position. The zero value means unknown.
+type Pos struct {
+	Line int
+	Col  int
+}
+
+func (p Pos) Position() Pos { return p }
+
+func (p *Pos) setPos(pos Pos) { *p = pos }
+
+type positioned interface {
+	Position() Pos
+	setPos(Pos)
+}
+
+// Severity tells errors from warnings and notes.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+// SourceError is an error tied to a place in a source file. It prints as
+// file.ll:12:5: Kind: message.
+type SourceError struct {
+	File string
+	Pos
+	// Len is the length of the offending span, 0 if unknown.
+	Len      int
+	Kind     string
+	Severity Severity
+	Err      error
+	// Trace is the call stack of a runtime error, innermost call first.
+	Trace []StackFrame
+
+	// expression errors are reported relative to the expression text and
+	// moved to absolute positions by the statement parser
+	expr   string
+	offset int
+}
+
+func (e *SourceError) Error() string {
+	msg := e.Err.Error()
+	if e.Kind != "" {
+		msg = e.Kind + ": " + msg
+	}
+	loc := e.File
+	if e.Line > 0 {
+		loc = fmt.Sprintf("%d:%d", e.Line, e.Col)
+		if e.File != "" {
+			loc = e.File + ":" + loc
+		}
+	}
+	if loc == "" {
+		return msg
+	}
+	return loc + ": " + msg
+}
+
+func (e *SourceError) Unwrap() error { return e.Err }
+
+// Snippet renders the offending line of src with the span underlined. When
+// the length is unknown the rest of the line is underlined.
+func (e *SourceError) Snippet(src string) string {
+	gutter, text, marker, ok := e.SnippetParts(src)
+	if !ok {
+		return ""
+	}
+	pad := strings.Repeat(" ", len(gutter))
+	return fmt.Sprintf("%s |\n%s | %s\n%s | %s", pad, gutter, text, pad, marker)
+}
+
+func (e *SourceError) SnippetParts(src string) (gutter, text, marker string, ok bool) {
+	lines := strings.Split(src, "\n")
+	if e.Line <= 0 || e.Line > len(lines) {
+		return "", "", "", false
+	}
+	text = strings.TrimRight(lines[e.Line-1], "\r")
+	col := max(1, min(e.Col, len(text)+1))
+	n := e.Len
+	if n <= 0 {
+		n = len(strings.TrimRight(text[col-1:], " \t"))
+	}
+	n = max(1, min(n, len(text)-col+1))
+
+	// keep tabs so the marker lines up with the source
+	indent := []byte(text[:col-1])
+	for i, c := range indent {
+		if c != '\t' {
+			indent[i] = ' '
+		}
+	}
+	return strconv.Itoa(e.Line), text, string(indent) + "^" + strings.Repeat("~", n-1), true
+}
+
+// WrapError turns err into a *SourceError for file, keeping any position
+// it already has.
+func WrapError(err error, file string, kind string, pos Pos) error {
+	if list, ok := err.(ErrorList); ok {
+		for _, se := range list {
+			if se.File == "" {
+				se.File = file
+			}
+			se.Kind = kind
+		}
+		return list
+	}
+	var se *SourceError
+	if !errors.As(err, &se) {
+		se = &SourceError{Pos: pos, Err: err}
+	}
+	if se.File == "" {
+		se.File = file
+	}
+	se.Kind = kind
+	return se
+}
+
+// ErrorList holds every diagnostic found in one pass.
+type ErrorList []*SourceError
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, e := range l {
+		errs[i] = e
+	}
+	return errs
+}
+
+// lineIndex maps byte offsets to line and column numbers.
+type lineIndex []int
+
+func newLineIndex(src string) lineIndex {
+	starts := lineIndex{0}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+func (idx lineIndex) pos(offset int) Pos {
+	line := sort.Search(len(idx), func(i int) bool { return idx[i] > offset }) - 1
+	if line < 0 {
+		line = 0
+	}
+	return Pos{Line: line + 1, Col: offset - idx[line] + 1}
+}
+
+// StackFrame is one call in a runtime traceback.
+type StackFrame struct {
+	Func string
+	Ip   int
+	File string
+	Pos
+}
@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a version of the language, as in "#lang 0.3".
+type Version struct {
+	Major, Minor int
+}
+
+// LangVersion is the version of the language this parser reads. A file
+// declares the version it was written for with a "#lang 0.3" line.
+var LangVersion = Version{0, 3}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// ParseVersion reads a version written as "major.minor".
+func ParseVersion(s string) (Version, error) {
+	major, minor, ok := strings.Cut(s, ".")
+	var v Version
+	var err1, err2 error
+	v.Major, err1 = strconv.Atoi(major)
+	v.Minor, err2 = strconv.Atoi(minor)
+	if !ok || err1 != nil || err2 != nil || v.Major < 0 || v.Minor < 0 {
+		return Version{}, fmt.Errorf("invalid version %q, want major.minor like %s", s, LangVersion)
+	}
+	return v, nil
+}
+
+// parseLangPragma reads the version after "#lang". A file written for a
+// newer or incompatible version of the language stops here, before it
+// fails on syntax this parser does not know.
+func (p *Parser) parseLangPragma() error {
+	p.pos += len("#lang")
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+	start := p.pos
+	for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+		p.pos++
+	}
+	text, _, _ := strings.Cut(p.input[start:p.pos], "--")
+	v, err := ParseVersion(strings.TrimSpace(text))
+	switch {
+	case err != nil:
+		p.pos = start
+		return p.errorf("#lang: %v", err)
+	case v.Major != LangVersion.Major:
+		p.pos = start
+		return p.errorf("this file is written for lightlang %s, which lightlang %s cannot read", v, LangVersion)
+	case v.Minor > LangVersion.Minor:
+		p.pos = start
+		return p.errorf("this file is written for lightlang %s, which is newer than this lightlang (%s)", v, LangVersion)
+	}
+	return nil
+}
+
+// isLangPragma reports whether a "#lang" line starts at the current
+// position.
+func (p *Parser) isLangPragma() bool {
+	rest := p.input[p.pos:]
+	return strings.HasPrefix(rest, "#lang") && (len(rest) == 5 || rest[5] == ' ' || rest[5] == '\t' || rest[5] == '\n')
+}
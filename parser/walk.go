@@ -0,0 +1,197 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Walk calls fn for n and every node below it, parents first.
+func Walk(n Node, fn func(Node)) {
+	if n == nil {
+		return
+	}
+	fn(n)
+	walkAll := func(nodes []Node) {
+		for _, c := range nodes {
+			Walk(c, fn)
+		}
+	}
+	switch n := n.(type) {
+	case *UnaryOpNode:
+		Walk(n.Right, fn)
+	case *BinaryOpNode:
+		Walk(n.Left, fn)
+		Walk(n.Right, fn)
+	case *AssignmentNode:
+		Walk(n.Expr, fn)
+	case *IndexAssignNode:
+		Walk(n.Table, fn)
+		Walk(n.Index, fn)
+		Walk(n.Value, fn)
+	case *IndexAccessNode:
+		Walk(n.Table, fn)
+		Walk(n.Index, fn)
+	case *ExprStmtNode:
+		Walk(n.Expr, fn)
+	case *CallNode:
+		walkAll(n.Args)
+		Walk(n.IndirectTarget, fn)
+	case *TableLiteralNode:
+		walkAll(n.Values)
+	case *ForLoopNode:
+		Walk(n.Init, fn)
+		Walk(n.Cond, fn)
+		Walk(n.Update, fn)
+		Walk(n.Collection, fn)
+		walkAll(n.Body)
+	case *WhileLoopNode:
+		Walk(n.Condition, fn)
+		walkAll(n.Body)
+	case *IfNode:
+		walkAll(n.Conditions)
+		for _, body := range n.Bodies {
+			walkAll(body)
+		}
+		walkAll(n.ElseBody)
+	case *FuncDefNode:
+		walkAll(n.Body)
+	case *AnonymousFuncNode:
+		walkAll(n.Body)
+	case *ReturnNode:
+		Walk(n.Value, fn)
+	case *BlockNode:
+		walkAll(n.Body)
+	}
+}
+
+// Rebuild returns a copy of n whose child expressions are replaced by what
+// node returns for them and whose statement lists by what block returns
+// for them, so a statement may become several. n itself is not changed.
+func Rebuild(n Node, node func(Node) (Node, error), block func([]Node) ([]Node, error)) (Node, error) {
+	var err error
+	one := func(c Node) Node {
+		if c == nil || err != nil {
+			return c
+		}
+		var out Node
+		out, err = node(c)
+		return out
+	}
+	each := func(cs []Node) []Node {
+		if cs == nil {
+			return nil
+		}
+		out := make([]Node, len(cs))
+		for i, c := range cs {
+			out[i] = one(c)
+		}
+		return out
+	}
+	stmts := func(cs []Node) []Node {
+		if cs == nil || err != nil {
+			return cs
+		}
+		var out []Node
+		out, err = block(cs)
+		return out
+	}
+	switch n := n.(type) {
+	case *LiteralNode:
+		c := *n
+		return &c, nil
+	case *VariableNode:
+		c := *n
+		return &c, nil
+	case *UnaryOpNode:
+		c := *n
+		c.Right = one(n.Right)
+		return &c, err
+	case *BinaryOpNode:
+		c := *n
+		c.Left, c.Right = one(n.Left), one(n.Right)
+		return &c, err
+	case *AssignmentNode:
+		c := *n
+		c.Expr = one(n.Expr)
+		return &c, err
+	case *IndexAssignNode:
+		c := *n
+		c.Table, c.Index, c.Value = one(n.Table), one(n.Index), one(n.Value)
+		return &c, err
+	case *IndexAccessNode:
+		c := *n
+		c.Table, c.Index = one(n.Table), one(n.Index)
+		return &c, err
+	case *ExprStmtNode:
+		c := *n
+		c.Expr = one(n.Expr)
+		return &c, err
+	case *CallNode:
+		c := *n
+		c.Args, c.IndirectTarget = each(n.Args), one(n.IndirectTarget)
+		return &c, err
+	case *TableLiteralNode:
+		c := *n
+		c.Keys, c.Values = slices.Clone(n.Keys), each(n.Values)
+		return &c, err
+	case *ForLoopNode:
+		c := *n
+		c.Init, c.Cond, c.Update, c.Collection = one(n.Init), one(n.Cond), one(n.Update), one(n.Collection)
+		c.Body = stmts(n.Body)
+		return &c, err
+	case *WhileLoopNode:
+		c := *n
+		c.Condition, c.Body = one(n.Condition), stmts(n.Body)
+		return &c, err
+	case *IfNode:
+		c := *n
+		c.Conditions = each(n.Conditions)
+		c.Bodies = make([][]Node, len(n.Bodies))
+		for i, body := range n.Bodies {
+			c.Bodies[i] = stmts(body)
+		}
+		c.ElseBody = stmts(n.ElseBody)
+		return &c, err
+	case *FuncDefNode:
+		c := *n
+		c.Params, c.ParamTypes, c.Body = slices.Clone(n.Params), slices.Clone(n.ParamTypes), stmts(n.Body)
+		return &c, err
+	case *AnonymousFuncNode:
+		c := *n
+		c.Params, c.ParamTypes, c.Body = slices.Clone(n.Params), slices.Clone(n.ParamTypes), stmts(n.Body)
+		return &c, err
+	case *ReturnNode:
+		c := *n
+		c.Value = one(n.Value)
+		return &c, err
+	case *TypeDefNode:
+		c := *n
+		return &c, nil
+	case *BreakNode:
+		c := *n
+		return &c, nil
+	case *AsmNode:
+		c := *n
+		return &c, nil
+	case *ContinueNode:
+		c := *n
+		return &c, nil
+	case *BlockNode:
+		c := *n
+		c.Body = stmts(n.Body)
+		return &c, err
+	}
+	return nil, fmt.Errorf("cannot rebuild %T", n)
+}
+
+// Clone returns a deep copy of n, which may be changed without changing n.
+func Clone(n Node) Node {
+	c, _ := Rebuild(n, func(c Node) (Node, error) { return Clone(c), nil }, func(cs []Node) ([]Node, error) {
+		out := make([]Node, len(cs))
+		for i, c := range cs {
+			out[i] = Clone(c)
+		}
+		return out, nil
+	})
+	return c
+}
@@ -0,0 +1,4 @@
+// Package term tells whether a file is a terminal and how big it is, and
+// switches terminals in and out of raw mode, for line editing and the
+// interactive builtins.
+package term
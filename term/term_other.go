@@ -0,0 +1,23 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package term
+
+import "errors"
+
+type State struct{}
+
+func IsTerminal(fd int) bool {
+	return false
+}
+
+func MakeRaw(fd int) (*State, error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+func Restore(fd int, state *State) error {
+	return nil
+}
+
+func Size(fd int) (cols, rows int, err error) {
+	return 0, 0, errors.New("terminal size is not supported on this platform")
+}
@@ -0,0 +1,64 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package term
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// State is the mode of a terminal, to restore after MakeRaw.
+type State = syscall.Termios
+
+func getTermios(fd int) (*State, error) {
+	var state State
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), ioctlGetTermios, uintptr(unsafe.Pointer(&state))); errno != 0 {
+		return nil, errno
+	}
+	return &state, nil
+}
+
+func setTermios(fd int, state *State) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), ioctlSetTermios, uintptr(unsafe.Pointer(state))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// IsTerminal tells whether fd is a terminal.
+func IsTerminal(fd int) bool {
+	_, err := getTermios(fd)
+	return err == nil
+}
+
+// MakeRaw turns off echo and line buffering but keeps output processing so
+// regular prints still translate \n into \r\n.
+func MakeRaw(fd int) (*State, error) {
+	old, err := getTermios(fd)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := setTermios(fd, &raw); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// Restore puts a terminal back in a mode from MakeRaw.
+func Restore(fd int, state *State) error {
+	return setTermios(fd, state)
+}
+
+// Size gives the columns and rows of the terminal fd.
+func Size(fd int) (cols, rows int, err error) {
+	var ws struct{ Row, Col, X, Y uint16 }
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); errno != 0 {
+		return 0, 0, errno
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
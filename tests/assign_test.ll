@@ -0,0 +1,41 @@
+func test_assign_dot_path()
+    let a = {"b": {"c": 1}}
+    a.b.c = 5
+    assert_eq(a.b.c, 5)
+    a.b.d = "new"
+    assert_eq(a.b, {"c": 5, "d": "new"})
+end
+
+func test_assign_nested_index()
+    let t = [[1, 2], [3, 4]]
+    let i = 1
+    let j = 0
+    t[i][j] = 9
+    t[0][i + j] = 8
+    assert_eq(t, [[1, 8], [9, 4]])
+end
+
+func test_assign_mixed_path()
+    let t = {"x": {"k": {"y": 0}, "list": [{"y": 1}]}}
+    let k = "k"
+    t.x[k].y = 7
+    t.x["list"][0].y = 2
+    assert_eq(t.x.k.y, 7)
+    assert_eq(t.x.list[0].y, 2)
+end
+
+let nested = {"x": {}}
+
+func set_through_missing_key()
+    nested.x.missing.y = 1
+end
+
+func set_through_missing_index()
+    nested["none"][0] = 1
+end
+
+func test_assign_through_nil()
+    assert_error(set_through_missing_key, "cannot set index of nil")
+    assert_error(set_through_missing_index, "cannot set index of nil")
+    assert_eq(nested, {"x": {}})
+end
@@ -0,0 +1,37 @@
+func double(x) return x * 2; end
+func inc(x) return x + 1; end
+
+func apply(f, x) return f(x); end
+
+func test_call_through_parameter()
+    assert_eq(apply(double, 5), 10)
+    assert_eq(apply(inc, 5), 6)
+    assert_eq(apply(func(x) return x * x end, 4), 16)
+end
+
+func test_call_through_loop_variable()
+    let results = []
+    for f in [double, inc] do
+        results = results + [f(10)]
+    end
+    assert_eq(results, [20, 11])
+end
+
+func test_call_from_table_key()
+    let handlers = {"start": func() return "started" end, "stop": func() return "stopped" end}
+    assert_eq(handlers["start"](), "started")
+    let name = "stop"
+    assert_eq(handlers[name](), "stopped")
+end
+
+func test_call_from_array_index()
+    let callbacks = [double, inc]
+    let x = 7
+    assert_eq(callbacks[0](x), 14)
+    assert_eq(callbacks[1](x), 8)
+end
+
+func test_call_through_dot_path()
+    let config = {"hooks": {"save": func(doc) return "saved " + doc end}}
+    assert_eq(config.hooks.save("a"), "saved a")
+end
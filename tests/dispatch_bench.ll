@@ -0,0 +1,77 @@
+-- Micro-benchmarks for the interpreter loop. Each one runs a small loop
+-- dominated by a few opcodes; run them with "lightlang bench tests" and
+-- compare against a saved baseline when changing dispatch.
+
+let counter = 0
+
+func bench_local_arith()
+    let i = 0
+    let x = 0
+    while i < 1000 do
+        x = x * 2 - x + 1
+        i = i + 1
+    end
+    return x
+end
+
+func bench_global_arith()
+    counter = 0
+    while counter < 1000 do
+        counter = counter + 1
+    end
+    return counter
+end
+
+func bench_compare_jump()
+    let i = 0
+    let n = 0
+    while i < 1000 do
+        if i ~= 500 then
+            n = n + 1
+        end
+        i = i + 1
+    end
+    return n
+end
+
+func id(x)
+    return x
+end
+
+func bench_call()
+    let i = 0
+    while i < 1000 do
+        id(i)
+        i = i + 1
+    end
+    return i
+end
+
+func bench_builtin_call()
+    let i = 0
+    while i < 1000 do
+        len("abc")
+        i = i + 1
+    end
+    return i
+end
+
+func bench_index()
+    let t = {}
+    let i = 0
+    while i < 1000 do
+        t["k"] = i
+        i = t["k"] + 1
+    end
+    return i
+end
+
+func bench_string_concat()
+    let i = 0
+    let s = ""
+    while i < 100 do
+        s = s + "x"
+        i = i + 1
+    end
+    return s
+end
@@ -0,0 +1,40 @@
+func test_break_inner_loop_only()
+    let pairs = 0
+    let i = 0
+    while i < 3 do
+        let j = 0
+        while true do
+            if j == 2 then
+                break
+            end
+            pairs = pairs + 1
+            j = j + 1
+        end
+        i = i + 1
+    end
+    assert_eq(pairs, 6)
+end
+
+func test_continue_in_nested_if()
+    let sum = 0
+    for i = 0; i < 10; i = i + 1 do
+        if i > 2 then
+            if i < 5 then
+                continue
+            end
+        end
+        sum = sum + i
+    end
+    assert_eq(sum, 0 + 1 + 2 + 5 + 6 + 7 + 8 + 9)
+end
+
+func test_break_in_for_in()
+    let seen = 0
+    for x in [1, 2, 3, 4] do
+        if x == 3 then
+            break
+        end
+        seen = seen + x
+    end
+    assert_eq(seen, 3)
+end
@@ -0,0 +1,129 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"sort"
+)
+
+// AllocProfiler records where a program allocates: for each kind of value
+// and the function and line that made it, how many times and about how
+// many bytes, as counted for MaxMemory. Growing a table or array counts
+// against the kind being grown.
+type AllocProfiler struct {
+	// Top is how many allocation sites Report lists; 0 lists them all.
+	Top int
+
+	sites map[allocSite]*allocStat
+	// total is every byte recorded so far, so that a builtin calling back
+	// into the program does not count its callback's allocations again.
+	total int
+}
+
+type allocSite struct {
+	entry int
+	file  string
+	line  int
+	kind  string
+}
+
+type allocStat struct {
+	count, bytes int
+}
+
+func NewAllocProfiler() *AllocProfiler {
+	return &AllocProfiler{Top: 20, sites: make(map[allocSite]*allocStat)}
+}
+
+// allocKind names the kind of value an op left on top of the stack.
+func allocKind(val Value) string {
+	switch r := val.Ref.(type) {
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		if r["type"] == "function" {
+			return "closure"
+		}
+		return "table"
+	case *builtins.OrderedTable:
+		return "table"
+	}
+	return "other"
+}
+
+// instrument wraps every op so that the bytes it allocates are recorded at
+// its line. Functions are not counted for MaxMemory, so making one counts
+// as an allocation of no bytes.
+func (p *AllocProfiler) instrument(v *VM, ops []opFunc) {
+	for ip, op := range ops {
+		makesFunc := v.Program.Instructions[ip].Op == bytecode.OpMakeFunc
+		ops[ip] = func(v *VM, f *Frame) error {
+			entry, depth := f.Entry, len(v.CallStack)
+			before, mark := v.allocated, p.total
+			err := op(v, f)
+			n := v.allocated - before - (p.total - mark)
+			if err != nil || v.Sp == 0 || len(v.CallStack) != depth || (n <= 0 && !makesFunc) {
+				return err
+			}
+			file, pos := v.position(ip)
+			site := allocSite{entry: entry, file: file, line: pos.Line, kind: allocKind(v.Stack[v.Sp-1])}
+			s, ok := p.sites[site]
+			if !ok {
+				s = &allocStat{}
+				p.sites[site] = s
+			}
+			s.count++
+			s.bytes += max(n, 0)
+			p.total += max(n, 0)
+			return nil
+		}
+	}
+}
+
+// Report prints the totals by kind and the sites that allocated the most.
+func (p *AllocProfiler) Report(w io.Writer, v *VM) {
+	kinds := make(map[string]*allocStat)
+	sites := make([]allocSite, 0, len(p.sites))
+	for site, s := range p.sites {
+		k, ok := kinds[site.kind]
+		if !ok {
+			k = &allocStat{}
+			kinds[site.kind] = k
+		}
+		k.count += s.count
+		k.bytes += s.bytes
+		sites = append(sites, site)
+	}
+	fmt.Fprintf(w, "allocations: %s in %d sites\n\n", formatBytes(p.total), len(sites))
+	fmt.Fprintf(w, "%-8s %10s %12s\n", "kind", "count", "bytes")
+	for _, kind := range []string{"table", "array", "string", "closure", "other"} {
+		if k, ok := kinds[kind]; ok {
+			fmt.Fprintf(w, "%-8s %10d %12d\n", kind, k.count, k.bytes)
+		}
+	}
+
+	sort.Slice(sites, func(i, j int) bool {
+		a, b := p.sites[sites[i]], p.sites[sites[j]]
+		if a.bytes != b.bytes {
+			return a.bytes > b.bytes
+		}
+		return a.count > b.count
+	})
+	if p.Top > 0 && len(sites) > p.Top {
+		sites = sites[:p.Top]
+	}
+	names := v.functionNames()
+	fmt.Fprintf(w, "\n%-8s %10s %12s  %-20s %s\n", "kind", "count", "bytes", "function", "line")
+	for _, site := range sites {
+		s := p.sites[site]
+		at := fmt.Sprintf("%d", site.line)
+		if site.file != "" {
+			at = fmt.Sprintf("%s:%d", site.file, site.line)
+		}
+		fmt.Fprintf(w, "%-8s %10d %12d  %-20s %s\n", site.kind, s.count, s.bytes, frameName(names, site.entry), at)
+	}
+}
@@ -0,0 +1,132 @@
+package vm
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Coverage records which source lines of the programs run by test -cover
+// executed. Instructions report their line the first time they run and then
+// put the uninstrumented op back, so covered code runs at full speed.
+type Coverage struct {
+	// files maps each file to its lines that have code, and whether the
+	// line ran.
+	files map[string]map[int]bool
+	// file names the program being loaded, for instructions that the
+	// source map does not place.
+	File string
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{files: make(map[string]map[int]bool)}
+}
+
+// instrument registers the lines of the loaded program and wraps its ops.
+func (c *Coverage) instrument(v *VM, ops []opFunc) {
+	for ip, op := range ops {
+		at, pos := v.position(ip)
+		if pos.Line == 0 {
+			continue
+		}
+		if at == "" {
+			at = c.File
+		}
+		lines := c.files[at]
+		if lines == nil {
+			lines = make(map[int]bool)
+			c.files[at] = lines
+		}
+		line := pos.Line
+		if _, ok := lines[line]; !ok {
+			lines[line] = false
+		}
+		ops[ip] = func(v *VM, f *Frame) error {
+			lines[line] = true
+			v.ops[ip] = op
+			return op(v, f)
+		}
+	}
+}
+
+type fileCoverage struct {
+	path         string
+	covered, all int
+}
+
+func (c *Coverage) summary() []fileCoverage {
+	var out []fileCoverage
+	for path, lines := range c.files {
+		fc := fileCoverage{path: path, all: len(lines)}
+		for _, hit := range lines {
+			if hit {
+				fc.covered++
+			}
+		}
+		out = append(out, fc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].path < out[j].path })
+	return out
+}
+
+func percentOf(n, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(n) / float64(total) * 100
+}
+
+// Report prints the line coverage of each file and the total.
+func (c *Coverage) Report(w io.Writer) {
+	covered, all := 0, 0
+	for _, fc := range c.summary() {
+		fmt.Fprintf(w, "  %-40s %5d/%-5d %6.1f%%\n", fc.path, fc.covered, fc.all, percentOf(fc.covered, fc.all))
+		covered += fc.covered
+		all += fc.all
+	}
+	fmt.Fprintf(w, "coverage: %.1f%% of lines (%d/%d)\n", percentOf(covered, all), covered, all)
+}
+
+// WriteHTML writes the sources with covered lines in green and missed lines
+// in red.
+func (c *Coverage) WriteHTML(w io.Writer) error {
+	esc := html.EscapeString
+	fmt.Fprintln(w, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>lightlang coverage</title>")
+	fmt.Fprintln(w, "<style>pre{line-height:1.3} .hit{background:#d4f7d4} .miss{background:#f7d4d4} .n{color:#888}</style></head>\n<body>")
+	for _, fc := range c.summary() {
+		src, err := os.ReadFile(fc.path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "<h2>%s: %.1f%%</h2>\n<pre>\n", esc(fc.path), percentOf(fc.covered, fc.all))
+		lines := c.files[fc.path]
+		for i, text := range strings.Split(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n") {
+			class := ""
+			if hit, ok := lines[i+1]; ok {
+				class = "miss"
+				if hit {
+					class = "hit"
+				}
+			}
+			fmt.Fprintf(w, "<span class=\"%s\"><span class=\"n\">%4d</span>  %s</span>\n", class, i+1, esc(text))
+		}
+		fmt.Fprintln(w, "</pre>")
+	}
+	_, err := fmt.Fprintln(w, "</body>\n</html>")
+	return err
+}
+
+func WriteCoverHTML(path string, c *Coverage) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := c.WriteHTML(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
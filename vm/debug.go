@@ -0,0 +1,215 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"lightlang/parser"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Debugger lets one client at a time attach to a running program over TCP
+// (run --debug-listen addr) and drive it with line commands, so it works
+// with nc or telnet. The program pauses when a client attaches and keeps
+// running when it detaches or disconnects.
+//
+// Commands are read while the program is paused; "pause" also stops a
+// running program.
+type Debugger struct {
+	// File names the program for instructions the source map does not
+	// place.
+	File string
+
+	attached atomic.Bool
+	pause    atomic.Bool
+
+	conn net.Conn
+	cmds chan string
+
+	breaks   map[int]bool
+	step     bool
+	lastLine int
+}
+
+const debugHelp = `commands:
+  c, continue      resume
+  s, step          run to the next line
+  b, break LINE    stop at LINE
+  clear LINE       remove the breakpoint at LINE
+  breaks           list the breakpoints
+  bt, stack        show the call stack
+  locals           show the local slots of the current function
+  p, print NAME    show a global
+  pause            stop the running program
+  detach           resume and disconnect`
+
+// ListenDebugger accepts debugger clients on addr in the background.
+func ListenDebugger(addr, file string) (*Debugger, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	d := &Debugger{File: file, breaks: make(map[int]bool)}
+	fmt.Fprintf(os.Stderr, "debugger listening on %s\n", ln.Addr())
+	go d.accept(ln)
+	return d, nil
+}
+
+func (d *Debugger) accept(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if d.attached.Load() {
+			fmt.Fprintln(conn, "another client is attached")
+			conn.Close()
+			continue
+		}
+		cmds := make(chan string)
+		d.conn, d.cmds = conn, cmds
+		d.pause.Store(true)
+		d.attached.Store(true)
+		go d.read(conn, cmds)
+	}
+}
+
+// read forwards the commands of a client until it disconnects. Then it
+// closes cmds and stops the program so that it detaches.
+func (d *Debugger) read(conn net.Conn, cmds chan<- string) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "pause" {
+			d.pause.Store(true)
+			continue
+		}
+		cmds <- line
+	}
+	close(cmds)
+	d.pause.Store(true)
+}
+
+// debug wraps every op so that the debugger can stop before it runs.
+func (d *Debugger) debug(v *VM, ops []opFunc) {
+	for ip, op := range ops {
+		ops[ip] = func(v *VM, f *Frame) error {
+			if d.attached.Load() {
+				d.hook(v, f, ip)
+			}
+			return op(v, f)
+		}
+	}
+}
+
+func (d *Debugger) hook(v *VM, f *Frame, ip int) {
+	_, pos := v.position(ip)
+	line := pos.Line
+	newLine := line != 0 && line != d.lastLine
+	if line != 0 {
+		d.lastLine = line
+	}
+	switch {
+	case d.pause.Swap(false):
+	case newLine && (d.step || d.breaks[line]):
+	default:
+		return
+	}
+	d.step = false
+	d.stopped(v, f, ip)
+}
+
+// stopped reports where the program is and runs commands until one of them
+// resumes it.
+func (d *Debugger) stopped(v *VM, f *Frame, ip int) {
+	file, pos := v.position(ip)
+	if file == "" {
+		file = d.File
+	}
+	names := v.functionNames()
+	where := fmt.Sprintf("ip %d", ip)
+	if pos.Line > 0 {
+		where = fmt.Sprintf("%s:%d (ip %d)", file, pos.Line, ip)
+	}
+	d.send("stopped at %s in %s", where, frameName(names, f.Entry))
+	for {
+		d.send("(debug)")
+		cmd, ok := <-d.cmds
+		if !ok {
+			d.detach()
+			return
+		}
+		name, arg, _ := strings.Cut(cmd, " ")
+		arg = strings.TrimSpace(arg)
+		switch name {
+		case "":
+		case "c", "continue":
+			return
+		case "s", "step":
+			d.step = true
+			return
+		case "b", "break", "clear":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n <= 0 {
+				d.send("%s needs a line number", name)
+				continue
+			}
+			if name == "clear" {
+				delete(d.breaks, n)
+				d.send("cleared line %d", n)
+			} else {
+				d.breaks[n] = true
+				d.send("breakpoint at line %d", n)
+			}
+		case "bt", "stack":
+			trace := v.traceback(ip)
+			if trace == nil {
+				trace = []parser.StackFrame{{Func: frameName(names, f.Entry), Ip: ip, File: file, Pos: pos}}
+			}
+			d.send("%s", FormatTraceback(d.File, trace))
+		case "locals":
+			for i, val := range f.Locals {
+				d.send("  slot %d = %s", i, val)
+			}
+		case "p", "print":
+			val, ok := v.Globals[arg]
+			if !ok {
+				d.send("%s is not defined", arg)
+				continue
+			}
+			d.send("%s = %s", arg, val)
+		case "breaks":
+			var lines []int
+			for n := range d.breaks {
+				lines = append(lines, n)
+			}
+			sort.Ints(lines)
+			d.send("breakpoints: %v", lines)
+		case "detach":
+			d.send("detached")
+			d.detach()
+			return
+		default:
+			d.send("%s", debugHelp)
+		}
+	}
+}
+
+func (d *Debugger) send(format string, args ...interface{}) {
+	fmt.Fprintf(d.conn, format+"\n", args...)
+}
+
+// detach forgets the client and its breakpoints; the program keeps going.
+func (d *Debugger) detach() {
+	d.conn.Close()
+	for range d.cmds {
+	}
+	d.breaks = make(map[int]bool)
+	d.step = false
+	d.pause.Store(false)
+	d.attached.Store(false)
+}
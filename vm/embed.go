@@ -0,0 +1,107 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"lightlang/builtins"
+	"time"
+)
+
+// Option configures a VM made by New.
+type Option func(*VM)
+
+// WithMaxCallDepth limits how many calls can be active at once.
+func WithMaxCallDepth(n int) Option { return func(v *VM) { v.MaxCallDepth = n } }
+
+// WithStackSize makes the operand stack start with room for n values.
+func WithStackSize(n int) Option { return func(v *VM) { v.Stack = make([]Value, n) } }
+
+// WithMaxStack limits how many values the operand stack may grow to.
+func WithMaxStack(n int) Option { return func(v *VM) { v.MaxStack = n } }
+
+// WithMaxSteps limits how many instructions each run may dispatch.
+func WithMaxSteps(n int) Option { return func(v *VM) { v.MaxSteps = n } }
+
+// WithTimeout limits how long each run may take.
+func WithTimeout(d time.Duration) Option { return func(v *VM) { v.Timeout = d } }
+
+// WithMaxMemory limits about how many bytes a program may hold.
+func WithMaxMemory(n int) Option { return func(v *VM) { v.MaxMemory = n } }
+
+// WithStdout sends the output of print and the other builtins to w.
+func WithStdout(w io.Writer) Option { return func(v *VM) { v.Stdout = w } }
+
+// WithStderr sets where builtins write errors, such as those of commands
+// run by exec_stream.
+func WithStderr(w io.Writer) Option { return func(v *VM) { v.Stderr = w } }
+
+// WithStdin makes input read from r.
+func WithStdin(r io.Reader) Option { return func(v *VM) { v.Stdin = r } }
+
+// WithPermissions limits what the IO builtins may do.
+func WithPermissions(p *builtins.Permissions) Option { return func(v *VM) { v.Perms = p } }
+
+// WithFiles bundles files with the program; read_file and csv_read look
+// in fsys before the disk.
+func WithFiles(fsys fs.FS) Option { return func(v *VM) { v.Files = fsys } }
+
+// New returns a VM for embedding lightlang, configured by opts.
+func New(opts ...Option) *VM {
+	v := NewVM()
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// Load makes prog the program of the VM. Globals are kept. prog is shared,
+// not copied, so other VMs may run it at the same time.
+func (v *VM) Load(prog *Program) {
+	v.Program = prog
+	v.ops = nil
+}
+
+// Run loads prog and runs its top level, which defines its functions for
+// Call.
+func (v *VM) Run(prog *Program) error {
+	v.Load(prog)
+	return v.RunContext(context.Background())
+}
+
+// Call calls the global function name after the program has run. The
+// arguments are Go values like those builtins receive: float64, string,
+// bool, nil, []interface{} and map[string]interface{}.
+func (v *VM) Call(name string, args ...interface{}) (res Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = NilValue, fmt.Errorf("%v", r)
+			v.CallStack = v.CallStack[:1]
+			v.Sp = 0
+		}
+	}()
+	if v.ops == nil {
+		return NilValue, fmt.Errorf("cannot call %s before the program runs", name)
+	}
+	fn, ok := v.Globals[name]
+	if !ok {
+		return NilValue, fmt.Errorf("function '%s' not found", name)
+	}
+	v.start(context.Background())
+	out, err := v.CallFunction(fn.Interface(), args)
+	if err != nil {
+		return NilValue, err
+	}
+	return valueOf(out), nil
+}
+
+// Global returns the value of global name, or nil if it is not set.
+func (v *VM) Global(name string) Value {
+	return v.Globals[name]
+}
+
+// SetGlobal sets global name to a Go value like those Call takes.
+func (v *VM) SetGlobal(name string, val interface{}) {
+	v.Globals[name] = valueOf(val)
+}
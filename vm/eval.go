@@ -0,0 +1,41 @@
+package vm
+
+import (
+	"fmt"
+	"lightlang/compiler"
+)
+
+// eval compiles code after the loaded program and runs it in a new frame.
+// Its top level shares the program's globals.
+func (v *VM) eval(code string) (interface{}, error) {
+	fn, err := v.load(code)
+	if err != nil {
+		return nil, err
+	}
+	return v.CallFunction(fn, nil)
+}
+
+// load compiles code like eval and returns it as a function without
+// parameters.
+func (v *VM) load(code string) (interface{}, error) {
+	entry, err := v.appendCode(code)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"type": "function", "entry": entry}, nil
+}
+
+// appendCode compiles code onto the end of the program and returns where
+// it starts.
+func (v *VM) appendCode(code string) (int, error) {
+	entry := len(v.Program.Instructions)
+	b, err := compiler.CompileAppend("<eval>", code, v.Program.Instructions, v.Program.Constants)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	// the program may be shared, so the VM moves to a copy with the code
+	instructions, constants := b.Bytecode()
+	v.Program = &Program{Instructions: instructions, Constants: constants, SourceMap: v.Program.SourceMap}
+	v.prepare()
+	return entry, nil
+}
@@ -0,0 +1,114 @@
+package vm
+
+import "lightlang/bytecode"
+
+// compareTests are the comparisons that can be fused with a following
+// OpJumpIfFalse.
+var compareTests = map[bytecode.OpCode]func(a, b Value) (bool, error){
+	bytecode.OpCmpEq:  func(a, b Value) (bool, error) { return a.equal(b), nil },
+	bytecode.OpCmpNe:  func(a, b Value) (bool, error) { return !a.equal(b), nil },
+	bytecode.OpCmpLt:  ordered(func(a, b float64) bool { return a < b }),
+	bytecode.OpCmpLte: ordered(func(a, b float64) bool { return a <= b }),
+	bytecode.OpCmpGt:  ordered(func(a, b float64) bool { return a > b }),
+	bytecode.OpCmpGte: ordered(func(a, b float64) bool { return a >= b }),
+}
+
+// fuse replaces the op at the start of common instruction sequences with a
+// superinstruction that does the work of the whole sequence and jumps past
+// it. The other ops of the sequence stay in place, so positions are
+// unchanged, and sequences that a jump enters in the middle are left alone.
+func (v *VM) fuse(ops []opFunc) {
+	insts := v.Program.Instructions
+	entered := make(map[int]bool)
+	for _, inst := range insts {
+		if bytecode.IsJump(inst.Op) {
+			entered[inst.Arg] = true
+		}
+	}
+	for _, c := range v.Program.Constants {
+		if c.Type == "funcptr" {
+			if e, ok := bytecode.ArgInt(c.Value); ok {
+				entered[e] = true
+			}
+		}
+	}
+	match := func(i int, seq ...bytecode.OpCode) bool {
+		if i+len(seq) > len(insts) {
+			return false
+		}
+		for j, op := range seq {
+			if insts[i+j].Op != op || j > 0 && entered[i+j] {
+				return false
+			}
+		}
+		return true
+	}
+	number := func(idx int) (float64, bool) {
+		if idx < 0 || idx >= len(v.Program.Constants) {
+			return 0, false
+		}
+		val := valueOf(v.Program.Constants[idx].Value)
+		return val.Num, val.Kind == KindNumber
+	}
+
+	for i := range insts {
+		switch {
+		case match(i, bytecode.OpGetLocal, bytecode.OpConstant, bytecode.OpAdd, bytecode.OpSetLocal):
+			if c, ok := number(insts[i+1].Arg); ok {
+				ops[i] = fusedLocalAdd(ops[i], insts[i].Arg, c, insts[i+3].Arg, i+4)
+			}
+		case match(i, bytecode.OpGetGlobal, bytecode.OpConstant, bytecode.OpAdd, bytecode.OpSetGlobal):
+			if c, ok := number(insts[i+1].Arg); ok {
+				src, dst := bytecode.ConstName(v.Program.Constants, insts[i].Arg), bytecode.ConstName(v.Program.Constants, insts[i+3].Arg)
+				ops[i] = fusedGlobalAdd(ops[i], src, c, dst, i+4)
+			}
+		case match(i, insts[i].Op, bytecode.OpJumpIfFalse):
+			if test, ok := compareTests[insts[i].Op]; ok {
+				ops[i] = fusedCompareJump(test, insts[i+1].Arg, i+2)
+			}
+		}
+	}
+}
+
+// fusedLocalAdd does "local = local + constant". Operands that are not
+// numbers take the normal path through the unfused ops.
+func fusedLocalAdd(unfused opFunc, src int, c float64, dst, next int) opFunc {
+	return func(v *VM, f *Frame) error {
+		x := f.Locals[src]
+		if x.Kind != KindNumber {
+			return unfused(v, f)
+		}
+		f.Locals[dst] = numberValue(x.Num + c)
+		f.Ip = next
+		return nil
+	}
+}
+
+func fusedGlobalAdd(unfused opFunc, src string, c float64, dst string, next int) opFunc {
+	return func(v *VM, f *Frame) error {
+		x := v.Globals[src]
+		if x.Kind != KindNumber {
+			return unfused(v, f)
+		}
+		v.Globals[dst] = numberValue(x.Num + c)
+		f.Ip = next
+		return nil
+	}
+}
+
+func fusedCompareJump(test func(a, b Value) (bool, error), target, next int) opFunc {
+	return func(v *VM, f *Frame) error {
+		b := v.pop()
+		a := v.pop()
+		ok, err := test(a, b)
+		if err != nil {
+			return err
+		}
+		if ok {
+			f.Ip = next
+		} else {
+			f.Ip = target
+		}
+		return nil
+	}
+}
@@ -0,0 +1,64 @@
+package vm_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"lightlang/compiler"
+	"lightlang/vm"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// FuzzCompileRun compiles and optimizes source, checks that the program
+// loads back from bytecode as the same program, and runs it within small
+// limits and without permissions. It fails on a panic, not on errors.
+func FuzzCompileRun(f *testing.F) {
+	files, _ := filepath.Glob("../tests/*.ll")
+	for _, file := range files {
+		if src, err := os.ReadFile(file); err == nil {
+			f.Add(string(src))
+		}
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		builder, err := compiler.Compile("fuzz.ll", src)
+		if err != nil {
+			return
+		}
+		instructions, constants := builder.Bytecode()
+		instructions, constants = compiler.OptimizeBytecode(instructions, constants, builder.SymbolTable)
+
+		var saved bytes.Buffer
+		if err := bytecode.NewBytecodeWriter(&saved).WriteBytecode(instructions, constants); err != nil {
+			t.Fatalf("cannot save compiled program: %v", err)
+		}
+		loaded, loadedConsts, err := bytecode.NewBytecodeReader(bytes.NewReader(saved.Bytes())).ReadBytecode()
+		if err != nil {
+			t.Fatalf("cannot load what was saved: %v", err)
+		}
+		var again bytes.Buffer
+		if err := bytecode.NewBytecodeWriter(&again).WriteBytecode(loaded, loadedConsts); err != nil {
+			t.Fatalf("cannot save what was loaded: %v", err)
+		}
+		if !bytes.Equal(saved.Bytes(), again.Bytes()) {
+			t.Fatal("saving a loaded program gives different bytes")
+		}
+
+		v := vm.New(
+			vm.WithMaxSteps(100_000),
+			vm.WithTimeout(100*time.Millisecond),
+			vm.WithMaxMemory(16<<20),
+			vm.WithPermissions(&builtins.Permissions{}),
+			vm.WithStdout(io.Discard),
+			vm.WithStderr(io.Discard),
+			vm.WithStdin(strings.NewReader("")),
+		)
+		v.Load(&vm.Program{Instructions: loaded, Constants: loadedConsts})
+		v.RunContext(context.Background())
+	})
+}
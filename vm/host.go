@@ -0,0 +1,40 @@
+package vm
+
+// HostFunc is a Go function that lightlang code calls by name. It can call
+// back into lightlang functions among its arguments with CallValue.
+type HostFunc func(args []Value) (Value, error)
+
+// Register makes fn callable from lightlang as name. Builtins and functions
+// the program defines with the same name come first.
+func (v *VM) Register(name string, fn HostFunc) {
+	if v.hosts == nil {
+		v.hosts = make(map[string]HostFunc)
+	}
+	v.hosts[name] = fn
+}
+
+func (v *VM) callHost(fn HostFunc, count int) error {
+	v.peakSp = max(v.peakSp, v.Sp)
+	base := v.Sp - count
+	if base < 0 {
+		return errStackUnderflow
+	}
+	args := make([]Value, count)
+	copy(args, v.Stack[base:v.Sp])
+	v.Sp = base
+	res, err := fn(args)
+	if err != nil {
+		return err
+	}
+	if v.MaxMemory > 0 || v.Allocs != nil {
+		in := make([]interface{}, count)
+		for i, arg := range args {
+			in[i] = arg.Interface()
+		}
+		if err := v.allocResult(res.Interface(), in); err != nil {
+			return err
+		}
+	}
+	v.push(res)
+	return nil
+}
@@ -0,0 +1,14 @@
+package vm
+
+// interner keeps one copy of each string constant. Go compares strings
+// that share their data pointer without reading the bytes, so equality
+// checks and map probes between interned strings are cheap.
+type interner map[string]string
+
+func (in interner) intern(s string) string {
+	if v, ok := in[s]; ok {
+		return v
+	}
+	in[s] = s
+	return s
+}
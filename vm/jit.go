@@ -0,0 +1,562 @@
+package vm
+
+import (
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"math"
+	"strings"
+)
+
+// The JIT compiles functions that only compute with numbers into Go
+// closures over float64 locals, which skip the operand stack and the
+// boxing of Values. A function qualifies when it only reads its
+// parameters and number globals, uses number constants, arithmetic,
+// bitwise ops, comparisons and not, and calls functions by name. Those
+// functions cannot change anything but their locals, so whenever compiled
+// code meets something it does not handle, like a division by zero, a
+// global that is not a number, a call of a function that does not qualify
+// or a deep recursion, the call is abandoned and run again by the
+// interpreter, which then does what it always does.
+
+// jitMaxDepth bounds nested compiled calls, which use the Go stack.
+// Deeper recursion is left to the interpreter.
+const jitMaxDepth = 10000
+
+// jitMaxMisses is how many times a call of a compiled function may fall
+// back to the interpreter before the function stops being compiled.
+const jitMaxMisses = 64
+
+type jitFunc struct {
+	entry  int
+	params int
+	// slots is the number of locals, the parameters and then the
+	// temporaries.
+	slots  int
+	start  *jitBlock
+	misses int
+}
+
+// jitBlock is a basic block: statements and then a return, a branch on
+// cond to next or alt, or a jump to next.
+type jitBlock struct {
+	// size is the number of instructions of the block, counted against
+	// MaxSteps.
+	size  int
+	stmts []jitStmt
+	ret   jitExpr
+	cond  jitCond
+	next  *jitBlock
+	alt   *jitBlock
+}
+
+type (
+	jitExpr func(r *jitRun, l []float64) float64
+	jitCond func(r *jitRun, l []float64) bool
+	jitStmt func(r *jitRun, l []float64)
+)
+
+// jitRun is the state of a call from the interpreter into compiled code.
+type jitRun struct {
+	v      *VM
+	funcs  []*jitFunc
+	failed bool
+	active bool
+	depth  int
+	limit  int
+	slab   []float64
+	sp     int
+}
+
+// compiled returns the compiled function at entry, or nil.
+func (r *jitRun) compiled(entry int) *jitFunc {
+	if entry < 0 || entry >= len(r.funcs) {
+		return nil
+	}
+	return r.funcs[entry]
+}
+
+// alloc returns n locals. Frames below keep their slices when the slab
+// grows.
+func (r *jitRun) alloc(n int) []float64 {
+	if r.sp+n > len(r.slab) {
+		r.slab = make([]float64, max(2*len(r.slab), 256, n))
+		r.sp = 0
+	}
+	l := r.slab[r.sp : r.sp+n : r.sp+n]
+	r.sp += n
+	return l
+}
+
+func (fn *jitFunc) call(r *jitRun, l []float64) float64 {
+	if r.depth >= r.limit {
+		r.failed = true
+		return 0
+	}
+	r.depth++
+	v := r.v
+	for b := fn.start; !r.failed; {
+		v.executed += b.size
+		if v.executed > v.nextCheck && v.checkpoint() != nil {
+			r.failed = true
+			break
+		}
+		for _, s := range b.stmts {
+			s(r, l)
+		}
+		switch {
+		case b.ret != nil:
+			res := b.ret(r, l)
+			r.depth--
+			return res
+		case b.cond != nil:
+			if b.cond(r, l) {
+				b = b.next
+			} else {
+				b = b.alt
+			}
+		default:
+			b = b.next
+		}
+	}
+	r.depth--
+	return 0
+}
+
+// runJIT calls the compiled fn with args. It returns false, having
+// changed nothing, when the interpreter has to make the call.
+func (v *VM) runJIT(fn *jitFunc, args []Value) (float64, bool) {
+	for _, a := range args[:fn.params] {
+		if a.Kind != KindNumber {
+			return 0, false
+		}
+	}
+	r := v.jitRun
+	if r.active {
+		// a signal handler called back in
+		r = &jitRun{v: v, funcs: v.jitRun.funcs}
+	}
+	r.active, r.failed, r.depth, r.sp = true, false, 0, 0
+	r.limit = min(v.MaxCallDepth-len(v.CallStack), jitMaxDepth)
+	l := r.alloc(fn.slots)
+	for i := range fn.params {
+		l[i] = args[i].Num
+	}
+	res := fn.call(r, l)
+	r.active = false
+	if r.failed {
+		if fn.misses++; fn.misses > jitMaxMisses {
+			r.funcs[fn.entry] = nil
+		}
+		return 0, false
+	}
+	return res, true
+}
+
+// compileJIT compiles the functions of the program that qualify. Their
+// entries index the result.
+func (v *VM) compileJIT() {
+	funcs := make([]*jitFunc, len(v.Program.Instructions))
+	for _, c := range v.Program.Constants {
+		entry, ok := bytecode.ArgInt(c.Value)
+		if c.Type != "funcptr" || !ok || c.Params == 0 || entry < 0 || entry >= len(funcs) || funcs[entry] != nil {
+			continue
+		}
+		jc := &jitCompiler{v: v, fn: &jitFunc{entry: entry, params: c.Params - 1, slots: c.Params - 1}}
+		if jc.compile() {
+			funcs[entry] = jc.fn
+		}
+	}
+	v.jitRun = &jitRun{v: v, funcs: funcs}
+}
+
+// jitCompiler turns the bytecode of one function into blocks.
+type jitCompiler struct {
+	v      *VM
+	fn     *jitFunc
+	blocks map[int]*jitBlock
+	stack  []jitOperand
+}
+
+// jitOperand is a value on the simulated operand stack. Comparisons and
+// not also have a cond, and locals and constants say what they are, so
+// common shapes get closures of their own.
+type jitOperand struct {
+	expr  jitExpr
+	cond  jitCond
+	local int
+	num   float64
+	isNum bool
+}
+
+func jitLocal(i int) jitOperand {
+	return jitOperand{expr: func(r *jitRun, l []float64) float64 { return l[i] }, local: i}
+}
+
+func jitConst(n float64) jitOperand {
+	return jitOperand{expr: func(*jitRun, []float64) float64 { return n }, local: -1, num: n, isNum: true}
+}
+
+func jitValue(e jitExpr) jitOperand {
+	return jitOperand{expr: e, local: -1}
+}
+
+func jitTest(c jitCond) jitOperand {
+	return jitOperand{
+		expr: func(r *jitRun, l []float64) float64 {
+			if c(r, l) {
+				return 1
+			}
+			return 0
+		},
+		cond:  c,
+		local: -1,
+	}
+}
+
+// truth is the test of if and while on o.
+func (o jitOperand) truth() jitCond {
+	if o.cond != nil {
+		return o.cond
+	}
+	e := o.expr
+	return func(r *jitRun, l []float64) bool { return e(r, l) != 0 }
+}
+
+func (jc *jitCompiler) compile() bool {
+	insts := jc.v.Program.Instructions
+	leaders := map[int]bool{jc.fn.entry: true}
+	seen := make(map[int]bool)
+	work := []int{jc.fn.entry}
+	for len(work) > 0 {
+		ip := work[len(work)-1]
+		work = work[:len(work)-1]
+	scan:
+		for ; !seen[ip]; ip++ {
+			if ip < 0 || ip >= len(insts) {
+				return false
+			}
+			seen[ip] = true
+			inst := insts[ip]
+			switch inst.Op {
+			case bytecode.OpReturn:
+			case bytecode.OpJump, bytecode.OpJumpIfFalse:
+				leaders[inst.Arg] = true
+				work = append(work, inst.Arg)
+				if inst.Op == bytecode.OpJumpIfFalse {
+					leaders[ip+1] = true
+					continue
+				}
+			default:
+				if !jitSupported(jc.v, inst) {
+					return false
+				}
+				continue
+			}
+			break scan
+		}
+	}
+	jc.blocks = make(map[int]*jitBlock, len(leaders))
+	for ip := range leaders {
+		jc.blocks[ip] = &jitBlock{}
+	}
+	for ip, b := range jc.blocks {
+		if !jc.block(ip, b, leaders) {
+			return false
+		}
+	}
+	jc.fn.start = jc.blocks[jc.fn.entry]
+	return true
+}
+
+// jitSupported reports whether the JIT handles inst, other than the
+// control flow ops.
+func jitSupported(v *VM, inst bytecode.Instruction) bool {
+	switch inst.Op {
+	case bytecode.OpConstant:
+		return valueOf(v.Program.Constants[inst.Arg].Value).Kind == KindNumber
+	case bytecode.OpCall:
+		_, ok := builtins.Builtins[bytecode.ConstName(v.Program.Constants, bytecode.CallName(inst.Arg))]
+		return !ok
+	case bytecode.OpCheckType:
+		want, _ := v.Program.Constants[inst.Arg].Value.(string)
+		base := strings.TrimSuffix(want, "?")
+		return base == "number" || base == "any" || base == "bool"
+	case bytecode.OpGetLocal, bytecode.OpSetLocal, bytecode.OpGetGlobal, bytecode.OpPop, bytecode.OpNop,
+		bytecode.OpAdd, bytecode.OpSub, bytecode.OpMul, bytecode.OpDiv, bytecode.OpNot,
+		bytecode.OpCmpEq, bytecode.OpCmpNe, bytecode.OpCmpLt, bytecode.OpCmpLte, bytecode.OpCmpGt, bytecode.OpCmpGte,
+		bytecode.OpBitAnd, bytecode.OpBitOr, bytecode.OpBitXor, bytecode.OpShl, bytecode.OpShr, bytecode.OpBitNot:
+		return true
+	}
+	return false
+}
+
+func (jc *jitCompiler) pop() (jitOperand, bool) {
+	if len(jc.stack) == 0 {
+		return jitOperand{}, false
+	}
+	o := jc.stack[len(jc.stack)-1]
+	jc.stack = jc.stack[:len(jc.stack)-1]
+	return o, true
+}
+
+// spill moves the operands still on the stack into new locals before a
+// local is set, so they keep the values they had when pushed.
+func (jc *jitCompiler) spill(b *jitBlock) {
+	for i, o := range jc.stack {
+		if o.isNum {
+			continue
+		}
+		slot, e := jc.fn.slots, o.expr
+		jc.fn.slots++
+		b.stmts = append(b.stmts, func(r *jitRun, l []float64) { l[slot] = e(r, l) })
+		jc.stack[i] = jitLocal(slot)
+	}
+}
+
+// block compiles the block starting at ip. The operand stack must be
+// empty where blocks meet, which holds for all but and, or and ??, which
+// the JIT leaves alone.
+func (jc *jitCompiler) block(ip int, b *jitBlock, leaders map[int]bool) bool {
+	insts := jc.v.Program.Instructions
+	consts := jc.v.Program.Constants
+	jc.stack = jc.stack[:0]
+	for ; ; ip++ {
+		if b.size > 0 && leaders[ip] {
+			if len(jc.stack) > 0 {
+				return false
+			}
+			b.next = jc.blocks[ip]
+			return true
+		}
+		b.size++
+		inst := insts[ip]
+		switch inst.Op {
+		case bytecode.OpConstant:
+			jc.stack = append(jc.stack, jitConst(valueOf(consts[inst.Arg].Value).Num))
+		case bytecode.OpGetLocal:
+			if inst.Arg >= jc.fn.params {
+				return false
+			}
+			jc.stack = append(jc.stack, jitLocal(inst.Arg))
+		case bytecode.OpSetLocal:
+			o, ok := jc.pop()
+			if !ok || inst.Arg >= jc.fn.params {
+				return false
+			}
+			jc.spill(b)
+			slot, e := inst.Arg, o.expr
+			b.stmts = append(b.stmts, func(r *jitRun, l []float64) { l[slot] = e(r, l) })
+		case bytecode.OpGetGlobal:
+			name := bytecode.ConstName(consts, inst.Arg)
+			jc.stack = append(jc.stack, jitValue(func(r *jitRun, l []float64) float64 {
+				g := r.v.Globals[name]
+				if g.Kind != KindNumber {
+					r.failed = true
+				}
+				return g.Num
+			}))
+		case bytecode.OpPop:
+			o, ok := jc.pop()
+			if !ok {
+				return false
+			}
+			if o.local < 0 && !o.isNum {
+				e := o.expr
+				b.stmts = append(b.stmts, func(r *jitRun, l []float64) { e(r, l) })
+			}
+		case bytecode.OpCheckType:
+			if len(jc.stack) == 0 {
+				return false
+			}
+		case bytecode.OpNot:
+			o, ok := jc.pop()
+			if !ok {
+				return false
+			}
+			c := o.truth()
+			jc.stack = append(jc.stack, jitTest(func(r *jitRun, l []float64) bool { return !c(r, l) }))
+		case bytecode.OpBitNot:
+			o, ok := jc.pop()
+			if !ok {
+				return false
+			}
+			e := o.expr
+			jc.stack = append(jc.stack, jitValue(func(r *jitRun, l []float64) float64 {
+				return float64(^jitInteger(r, e(r, l)))
+			}))
+		case bytecode.OpNop:
+		case bytecode.OpCall:
+			if !jc.call(bytecode.ConstName(consts, bytecode.CallName(inst.Arg)), bytecode.CallCount(inst.Arg)) {
+				return false
+			}
+		case bytecode.OpReturn:
+			o, ok := jc.pop()
+			if !ok || len(jc.stack) > 0 {
+				return false
+			}
+			b.ret = o.expr
+			return true
+		case bytecode.OpJump:
+			if len(jc.stack) > 0 {
+				return false
+			}
+			b.next = jc.blocks[inst.Arg]
+			return true
+		case bytecode.OpJumpIfFalse:
+			o, ok := jc.pop()
+			if !ok || len(jc.stack) > 0 {
+				return false
+			}
+			b.cond, b.next, b.alt = o.truth(), jc.blocks[ip+1], jc.blocks[inst.Arg]
+			return true
+		default:
+			y, ok := jc.pop()
+			if !ok {
+				return false
+			}
+			x, ok := jc.pop()
+			if !ok {
+				return false
+			}
+			res, ok := jitBinary(inst.Op, x, y)
+			if !ok {
+				return false
+			}
+			jc.stack = append(jc.stack, res)
+		}
+	}
+}
+
+// call compiles a call of the function in global name with n arguments.
+func (jc *jitCompiler) call(name string, n int) bool {
+	if n > len(jc.stack) {
+		return false
+	}
+	args := make([]jitExpr, n)
+	for i, o := range jc.stack[len(jc.stack)-n:] {
+		args[i] = o.expr
+	}
+	jc.stack = jc.stack[:len(jc.stack)-n]
+	jc.stack = append(jc.stack, jitValue(func(r *jitRun, l []float64) float64 {
+		entry, _ := r.v.Globals[name].Function()
+		fn := r.compiled(entry)
+		if fn == nil || fn.params > n {
+			r.failed = true
+			return 0
+		}
+		base := r.sp
+		locals := r.alloc(fn.slots)
+		for i, a := range args {
+			if x := a(r, l); i < fn.params {
+				locals[i] = x
+			}
+		}
+		res := fn.call(r, locals)
+		r.sp = base
+		return res
+	}))
+	return true
+}
+
+// jitInteger gives the value of x for a bitwise op, failing like integer.
+func jitInteger(r *jitRun, x float64) int64 {
+	if x != math.Trunc(x) || x < -1<<63 || x >= 1<<63 {
+		r.failed = true
+		return 0
+	}
+	return int64(x)
+}
+
+// jitBinary compiles an op of two operands. Arithmetic and comparisons of
+// a local and a constant or of two locals, the most common shapes, get
+// closures of their own.
+func jitBinary(op bytecode.OpCode, x, y jitOperand) (jitOperand, bool) {
+	a, b := x.expr, y.expr
+	if x.local >= 0 && y.local >= 0 {
+		i, j := x.local, y.local
+		switch op {
+		case bytecode.OpAdd:
+			return jitValue(func(r *jitRun, l []float64) float64 { return l[i] + l[j] }), true
+		case bytecode.OpSub:
+			return jitValue(func(r *jitRun, l []float64) float64 { return l[i] - l[j] }), true
+		case bytecode.OpMul:
+			return jitValue(func(r *jitRun, l []float64) float64 { return l[i] * l[j] }), true
+		case bytecode.OpCmpLt:
+			return jitTest(func(r *jitRun, l []float64) bool { return l[i] < l[j] }), true
+		case bytecode.OpCmpLte:
+			return jitTest(func(r *jitRun, l []float64) bool { return l[i] <= l[j] }), true
+		case bytecode.OpCmpGt:
+			return jitTest(func(r *jitRun, l []float64) bool { return l[i] > l[j] }), true
+		case bytecode.OpCmpGte:
+			return jitTest(func(r *jitRun, l []float64) bool { return l[i] >= l[j] }), true
+		}
+	}
+	if x.local >= 0 && y.isNum {
+		i, c := x.local, y.num
+		switch op {
+		case bytecode.OpAdd:
+			return jitValue(func(r *jitRun, l []float64) float64 { return l[i] + c }), true
+		case bytecode.OpSub:
+			return jitValue(func(r *jitRun, l []float64) float64 { return l[i] - c }), true
+		case bytecode.OpMul:
+			return jitValue(func(r *jitRun, l []float64) float64 { return l[i] * c }), true
+		case bytecode.OpCmpLt:
+			return jitTest(func(r *jitRun, l []float64) bool { return l[i] < c }), true
+		case bytecode.OpCmpLte:
+			return jitTest(func(r *jitRun, l []float64) bool { return l[i] <= c }), true
+		case bytecode.OpCmpGt:
+			return jitTest(func(r *jitRun, l []float64) bool { return l[i] > c }), true
+		case bytecode.OpCmpGte:
+			return jitTest(func(r *jitRun, l []float64) bool { return l[i] >= c }), true
+		}
+	}
+	switch op {
+	case bytecode.OpAdd:
+		return jitValue(func(r *jitRun, l []float64) float64 { return a(r, l) + b(r, l) }), true
+	case bytecode.OpSub:
+		return jitValue(func(r *jitRun, l []float64) float64 { return a(r, l) - b(r, l) }), true
+	case bytecode.OpMul:
+		return jitValue(func(r *jitRun, l []float64) float64 { return a(r, l) * b(r, l) }), true
+	case bytecode.OpDiv:
+		return jitValue(func(r *jitRun, l []float64) float64 {
+			p, q := a(r, l), b(r, l)
+			if q == 0 {
+				r.failed = true
+				return 0
+			}
+			return p / q
+		}), true
+	case bytecode.OpCmpEq:
+		return jitTest(func(r *jitRun, l []float64) bool { return a(r, l) == b(r, l) }), true
+	case bytecode.OpCmpNe:
+		return jitTest(func(r *jitRun, l []float64) bool { return a(r, l) != b(r, l) }), true
+	case bytecode.OpCmpLt:
+		return jitTest(func(r *jitRun, l []float64) bool { return a(r, l) < b(r, l) }), true
+	case bytecode.OpCmpLte:
+		return jitTest(func(r *jitRun, l []float64) bool { return a(r, l) <= b(r, l) }), true
+	case bytecode.OpCmpGt:
+		return jitTest(func(r *jitRun, l []float64) bool { return a(r, l) > b(r, l) }), true
+	case bytecode.OpCmpGte:
+		return jitTest(func(r *jitRun, l []float64) bool { return a(r, l) >= b(r, l) }), true
+	case bytecode.OpBitAnd:
+		return jitValue(func(r *jitRun, l []float64) float64 { return float64(jitInteger(r, a(r, l)) & jitInteger(r, b(r, l))) }), true
+	case bytecode.OpBitOr:
+		return jitValue(func(r *jitRun, l []float64) float64 { return float64(jitInteger(r, a(r, l)) | jitInteger(r, b(r, l))) }), true
+	case bytecode.OpBitXor:
+		return jitValue(func(r *jitRun, l []float64) float64 { return float64(jitInteger(r, a(r, l)) ^ jitInteger(r, b(r, l))) }), true
+	case bytecode.OpShl, bytecode.OpShr:
+		shl := op == bytecode.OpShl
+		return jitValue(func(r *jitRun, l []float64) float64 {
+			p, n := jitInteger(r, a(r, l)), jitInteger(r, b(r, l))
+			if n < 0 {
+				r.failed = true
+				return 0
+			}
+			if shl {
+				return float64(p << uint64(n))
+			}
+			return float64(p >> uint64(n))
+		}), true
+	}
+	return jitOperand{}, false
+}
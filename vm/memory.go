@@ -0,0 +1,240 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"lightlang/builtins"
+	"math"
+	"reflect"
+	"unsafe"
+)
+
+// DefaultMaxMemory is the memory limit of new VMs, set by --max-memory.
+var DefaultMaxMemory int
+
+// ErrMemoryLimit is returned, wrapped, when a run goes past MaxMemory.
+var ErrMemoryLimit = errors.New("memory limit exceeded")
+
+// Approximate sizes of what programs allocate: an interface{} element of an
+// array or the stack, and the overhead of a table entry besides its key.
+const (
+	elemSize  = 16
+	entrySize = 48
+)
+
+// The VM counts the bytes its ops and builtins allocate. When the count
+// would take the program past MaxMemory, it measures what the program
+// still holds and errors if that is over the limit too, so garbage does not
+// count against it.
+
+// startMemory sets up MaxMemory for a new run.
+func (v *VM) startMemory() {
+	v.allocated = 0
+	v.memCheck = math.MaxInt
+	if v.MaxMemory > 0 {
+		v.memCheck = v.MaxMemory - v.liveMemory()
+	}
+}
+
+// alloc records n bytes allocated by the program.
+func (v *VM) alloc(n int) error {
+	v.allocated += n
+	if v.allocated > v.memCheck {
+		return v.checkMemory()
+	}
+	return nil
+}
+
+func (v *VM) checkMemory() error {
+	live := v.liveMemory()
+	v.allocated = 0
+	v.memCheck = v.MaxMemory - live
+	if live > v.MaxMemory {
+		return fmt.Errorf("%w: using about %s of %s", ErrMemoryLimit, formatBytes(live), formatBytes(v.MaxMemory))
+	}
+	return nil
+}
+
+// allocResult records what a builtin allocated for its result, leaving out
+// what it shares with its arguments, like the array that append grows in
+// place.
+func (v *VM) allocResult(res interface{}, args []interface{}) error {
+	switch r := res.(type) {
+	case []interface{}:
+		for _, arg := range args {
+			a, ok := arg.([]interface{})
+			if ok && cap(a) > 0 && unsafe.SliceData(a) == unsafe.SliceData(r) {
+				n := 0
+				for _, e := range r[min(len(a), len(r)):] {
+					n += sizeOf(e, nil)
+				}
+				return v.alloc(n)
+			}
+		}
+	case map[string]interface{}:
+		for _, arg := range args {
+			if a, ok := arg.(map[string]interface{}); ok && reflect.ValueOf(a).UnsafePointer() == reflect.ValueOf(r).UnsafePointer() {
+				return nil
+			}
+		}
+	case *builtins.StringBuilder:
+		// append adds its arguments to the builder
+		n := 0
+		for _, arg := range args {
+			if s, ok := arg.(string); ok {
+				n += len(s)
+			}
+		}
+		return v.alloc(n)
+	}
+	return v.alloc(sizeOf(res, nil))
+}
+
+// liveMemory measures the values the program can still reach.
+func (v *VM) liveMemory() int {
+	seen := make(map[unsafe.Pointer]bool)
+	n := 0
+	for name, val := range v.Globals {
+		n += entrySize + len(name) + sizeOf(val.Ref, seen)
+	}
+	for _, val := range v.Stack[:v.Sp] {
+		n += sizeOf(val.Ref, seen)
+	}
+	for _, f := range v.CallStack {
+		n += len(f.Locals) * int(unsafe.Sizeof(Value{}))
+		for _, val := range f.Locals {
+			n += sizeOf(val.Ref, seen)
+		}
+	}
+	return n
+}
+
+// countValues counts the strings, arrays, tables and functions the program
+// can still reach, each once, and about how many bytes they hold.
+func (v *VM) countValues() builtins.ValueCounts {
+	var c builtins.ValueCounts
+	seen := make(map[unsafe.Pointer]bool)
+	var count func(val interface{})
+	count = func(val interface{}) {
+		switch t := val.(type) {
+		case string:
+			c.Strings++
+		case []interface{}:
+			if cap(t) == 0 || seen[unsafe.Pointer(unsafe.SliceData(t))] {
+				return
+			}
+			seen[unsafe.Pointer(unsafe.SliceData(t))] = true
+			c.Arrays++
+			for _, e := range t {
+				count(e)
+			}
+		case map[string]interface{}:
+			p := reflect.ValueOf(t).UnsafePointer()
+			if seen[p] {
+				return
+			}
+			seen[p] = true
+			if t["type"] == "function" {
+				c.Functions++
+				return
+			}
+			c.Tables++
+			for _, e := range t {
+				count(e)
+			}
+		case *builtins.OrderedTable:
+			if seen[unsafe.Pointer(t)] {
+				return
+			}
+			seen[unsafe.Pointer(t)] = true
+			c.Tables++
+			for _, k := range t.Keys() {
+				count(t.Get(k))
+			}
+		}
+	}
+	for _, val := range v.Globals {
+		count(val.Ref)
+	}
+	for _, val := range v.Stack[:v.Sp] {
+		count(val.Ref)
+	}
+	for _, f := range v.CallStack {
+		for _, val := range f.Locals {
+			count(val.Ref)
+		}
+	}
+	c.Bytes = v.liveMemory()
+	return c
+}
+
+// sizeOf approximates the bytes held by val. Arrays and tables in seen are
+// counted once; a nil seen counts them every time.
+func sizeOf(val interface{}, seen map[unsafe.Pointer]bool) int {
+	switch t := val.(type) {
+	case string:
+		return len(t)
+	case []interface{}:
+		if cap(t) == 0 {
+			return 0
+		}
+		if seen != nil {
+			p := unsafe.Pointer(unsafe.SliceData(t))
+			if seen[p] {
+				return 0
+			}
+			seen[p] = true
+		}
+		n := cap(t) * elemSize
+		for _, e := range t {
+			n += sizeOf(e, seen)
+		}
+		return n
+	case map[string]interface{}:
+		if seen != nil {
+			p := reflect.ValueOf(t).UnsafePointer()
+			if seen[p] {
+				return 0
+			}
+			seen[p] = true
+		}
+		n := entrySize
+		for k, e := range t {
+			n += entrySize + len(k) + sizeOf(e, seen)
+		}
+		return n
+	case *builtins.StringBuilder:
+		if seen != nil {
+			if seen[unsafe.Pointer(t)] {
+				return 0
+			}
+			seen[unsafe.Pointer(t)] = true
+		}
+		return t.Size()
+	case *builtins.OrderedTable:
+		if seen != nil {
+			if seen[unsafe.Pointer(t)] {
+				return 0
+			}
+			seen[unsafe.Pointer(t)] = true
+		}
+		n := entrySize
+		for _, k := range t.Keys() {
+			n += entrySize + elemSize + len(k) + sizeOf(t.Get(k), seen)
+		}
+		return n
+	}
+	return 0
+}
+
+func formatBytes(n int) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
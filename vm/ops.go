@@ -0,0 +1,555 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"math"
+	"slices"
+	"strings"
+)
+
+// opHandler compiles one instruction into the func that executes it. Work
+// that only depends on the operand, like resolving names and constants, is
+// done here once instead of on every execution.
+type opHandler func(v *VM, inst bytecode.Instruction) opFunc
+
+// errHalt stops the program at OpHalt.
+var errHalt = errors.New("halt")
+
+// handlers is the dispatch table of the VM, indexed by opcode. Opcodes
+// without a handler do nothing. The handlers of hot ops must not allocate
+// on their number paths.
+var handlers = [bytecode.OpCount]opHandler{
+	bytecode.OpConstant:         opConstant,
+	bytecode.OpTable:            static(opTable),
+	bytecode.OpArray:            opArray,
+	bytecode.OpCmpEq:            static(opCmpEq),
+	bytecode.OpCmpNe:            static(opCmpNe),
+	bytecode.OpCmpLt:            static(compare(ordered(func(a, b float64) bool { return a < b }))),
+	bytecode.OpCmpLte:           static(compare(ordered(func(a, b float64) bool { return a <= b }))),
+	bytecode.OpCmpGt:            static(compare(ordered(func(a, b float64) bool { return a > b }))),
+	bytecode.OpCmpGte:           static(compare(ordered(func(a, b float64) bool { return a >= b }))),
+	bytecode.OpAdd:              static(arith(genericAdd, func(a, b float64) float64 { return a + b })),
+	bytecode.OpSub:              static(arith(genericSub, func(a, b float64) float64 { return a - b })),
+	bytecode.OpMul:              static(arith(genericMul, func(a, b float64) float64 { return a * b })),
+	bytecode.OpDiv:              static(opDiv),
+	bytecode.OpNot:              static(opNot),
+	bytecode.OpSetGlobal:        opSetGlobal,
+	bytecode.OpGetGlobal:        opGetGlobal,
+	bytecode.OpSetLocal:         opSetLocal,
+	bytecode.OpGetLocal:         opGetLocal,
+	bytecode.OpGetIndex:         static(opGetIndex),
+	bytecode.OpSetIndex:         static(opSetIndex),
+	bytecode.OpCall:             opCall,
+	bytecode.OpCallIndirect:     opCallIndirect,
+	bytecode.OpReturn:           static(opReturn),
+	bytecode.OpMakeFunc:         opMakeFunc,
+	bytecode.OpJump:             opJump,
+	bytecode.OpJumpIfFalse:      opJumpIfFalse,
+	bytecode.OpPop:              static(opPop),
+	bytecode.OpHalt:             static(opHalt),
+	bytecode.OpCheckType:        opCheckType,
+	bytecode.OpJumpIfNotNil:     opJumpIfNotNil,
+	bytecode.OpJumpIfFalseOrPop: jumpOrPop(false),
+	bytecode.OpJumpIfTrueOrPop:  jumpOrPop(true),
+	bytecode.OpBitAnd:           static(bitwise(func(a, b int64) (int64, error) { return a & b, nil })),
+	bytecode.OpBitOr:            static(bitwise(func(a, b int64) (int64, error) { return a | b, nil })),
+	bytecode.OpBitXor:           static(bitwise(func(a, b int64) (int64, error) { return a ^ b, nil })),
+	bytecode.OpShl:              static(bitwise(shift(func(a int64, n uint64) int64 { return a << n }))),
+	bytecode.OpShr:              static(bitwise(shift(func(a int64, n uint64) int64 { return a >> n }))),
+	bytecode.OpBitNot:           static(opBitNot),
+	bytecode.OpIterNext:         opIterNext,
+}
+
+// static is the handler of ops that ignore their operand.
+func static(op opFunc) opHandler {
+	return func(*VM, bytecode.Instruction) opFunc { return op }
+}
+
+func opNop(v *VM, f *Frame) error { return nil }
+
+func opConstant(v *VM, inst bytecode.Instruction) opFunc {
+	val := v.Program.Constants[inst.Arg].Value
+	if s, ok := val.(string); ok {
+		val = v.strings.intern(s)
+	}
+	value := valueOf(val)
+	return func(v *VM, f *Frame) error {
+		v.push(value)
+		return nil
+	}
+}
+
+func opTable(v *VM, f *Frame) error {
+	v.push(Value{Kind: KindObject, Ref: make(map[string]interface{}, 4)})
+	return v.alloc(entrySize * 4)
+}
+
+func opArray(_ *VM, inst bytecode.Instruction) opFunc {
+	count := inst.Arg
+	return func(v *VM, f *Frame) error {
+		base := v.Sp - count
+		if base < 0 {
+			return errStackUnderflow
+		}
+		arr := make([]interface{}, count)
+		for i, val := range v.Stack[base:v.Sp] {
+			arr[i] = val.Interface()
+		}
+		v.Sp = base
+		v.push(Value{Kind: KindObject, Ref: arr})
+		return v.alloc(count * elemSize)
+	}
+}
+
+func opCmpEq(v *VM, f *Frame) error {
+	b := v.pop()
+	a := v.pop()
+	v.push(boolNumberValue(a.equal(b)))
+	return nil
+}
+
+func opCmpNe(v *VM, f *Frame) error {
+	b := v.pop()
+	a := v.pop()
+	v.push(boolNumberValue(!a.equal(b)))
+	return nil
+}
+
+// arith builds a binary arithmetic op. Two numbers take the fast path;
+// anything else goes through generic on the interface values.
+func arith(generic func(env *builtins.Context, a, b interface{}) (interface{}, error), fast func(a, b float64) float64) opFunc {
+	return func(v *VM, f *Frame) error {
+		b := v.pop()
+		a := v.pop()
+		if a.Kind == KindNumber && b.Kind == KindNumber {
+			v.push(numberValue(fast(a.Num, b.Num)))
+			return nil
+		}
+		res, err := generic(v.env, a.Interface(), b.Interface())
+		if err != nil {
+			return err
+		}
+		v.push(valueOf(res))
+		switch r := res.(type) {
+		case string:
+			return v.alloc(len(r))
+		case []interface{}:
+			return v.alloc(cap(r) * elemSize)
+		}
+		return nil
+	}
+}
+
+// compare builds an ordering op. Strings compare lexicographically and a
+// string against any other type is an error, see ordered.
+func compare(test func(a, b Value) (bool, error)) opFunc {
+	return func(v *VM, f *Frame) error {
+		b := v.pop()
+		a := v.pop()
+		ok, err := test(a, b)
+		if err != nil {
+			return err
+		}
+		v.push(boolNumberValue(ok))
+		return nil
+	}
+}
+
+// ordered makes a comparison of numbers work on Values: two strings are
+// compared by their bytes, which orders UTF-8 text by code point, bigints
+// exactly, and anything else as numbers. A string and anything else do not
+// compare.
+func ordered(test func(a, b float64) bool) func(a, b Value) (bool, error) {
+	return func(a, b Value) (bool, error) {
+		if a.Kind == KindString && b.Kind == KindString {
+			return test(float64(strings.Compare(a.Ref.(string), b.Ref.(string))), 0), nil
+		}
+		if a.Kind == KindString || b.Kind == KindString {
+			return false, fmt.Errorf("cannot compare %s and %s", a.typeName(), b.typeName())
+		}
+		if a.Kind == KindObject || b.Kind == KindObject {
+			if c, ok := builtins.Compare(a.Interface(), b.Interface()); ok {
+				return test(float64(c), 0), nil
+			}
+		}
+		return test(a.number(), b.number()), nil
+	}
+}
+
+// genericAdd adds numbers and joins anything else as text. An array plus
+// an array is a new array with the elements of both, and an array plus
+// anything else a copy with that value appended.
+func genericAdd(env *builtins.Context, a, b interface{}) (interface{}, error) {
+	switch av := a.(type) {
+	case []interface{}:
+		if bv, ok := b.([]interface{}); ok {
+			return slices.Concat(av, bv), nil
+		}
+		return append(slices.Clip(av), b), nil
+	case float64:
+		if bv, ok := b.(float64); ok {
+			return av + bv, nil
+		}
+	}
+	if _, ok := b.(string); !ok {
+		if _, ok := a.(string); !ok {
+			if res, ok, err := builtins.Arith('+', a, b); ok {
+				return res, err
+			}
+		}
+	}
+	as, err := env.Display(a)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := env.Display(b)
+	if err != nil {
+		return nil, err
+	}
+	return as + bs, nil
+}
+
+func genericSub(_ *builtins.Context, a, b interface{}) (interface{}, error) {
+	if res, ok, err := builtins.Arith('-', a, b); ok {
+		return res, err
+	}
+	return toFloat64(a) - toFloat64(b), nil
+}
+
+func genericMul(_ *builtins.Context, a, b interface{}) (interface{}, error) {
+	if res, ok, err := builtins.Arith('*', a, b); ok {
+		return res, err
+	}
+	return toFloat64(a) * toFloat64(b), nil
+}
+
+func opDiv(v *VM, f *Frame) error {
+	bv := v.pop()
+	av := v.pop()
+	if av.Kind == KindObject || bv.Kind == KindObject {
+		if res, ok, err := builtins.Arith('/', av.Interface(), bv.Interface()); ok {
+			if err != nil {
+				return err
+			}
+			v.push(valueOf(res))
+			return nil
+		}
+	}
+	a, b := av.number(), bv.number()
+	if b == 0 {
+		return fmt.Errorf("div by zero")
+	}
+	v.push(numberValue(a / b))
+	return nil
+}
+
+// integer gives the value of v for a bitwise op.
+func integer(v Value) (int64, error) {
+	n := v.number()
+	if v.Kind != KindNumber || n != math.Trunc(n) || n < -1<<63 || n >= 1<<63 {
+		return 0, fmt.Errorf("bitwise operators need whole numbers, got %s", v)
+	}
+	return int64(n), nil
+}
+
+func bitwise(op func(a, b int64) (int64, error)) opFunc {
+	return func(v *VM, f *Frame) error {
+		b, err := integer(v.pop())
+		if err != nil {
+			return err
+		}
+		a, err := integer(v.pop())
+		if err != nil {
+			return err
+		}
+		n, err := op(a, b)
+		if err != nil {
+			return err
+		}
+		v.push(numberValue(float64(n)))
+		return nil
+	}
+}
+
+func shift(op func(a int64, n uint64) int64) func(a, b int64) (int64, error) {
+	return func(a, b int64) (int64, error) {
+		if b < 0 {
+			return 0, fmt.Errorf("negative shift count %d", b)
+		}
+		return op(a, uint64(b)), nil
+	}
+}
+
+func opBitNot(v *VM, f *Frame) error {
+	a, err := integer(v.pop())
+	if err != nil {
+		return err
+	}
+	v.push(numberValue(float64(^a)))
+	return nil
+}
+
+func opNot(v *VM, f *Frame) error {
+	v.push(boolNumberValue(!v.pop().truthy()))
+	return nil
+}
+
+func opSetGlobal(v *VM, inst bytecode.Instruction) opFunc {
+	key := bytecode.ConstName(v.Program.Constants, inst.Arg)
+	return func(v *VM, f *Frame) error {
+		v.Globals[key] = v.pop()
+		return nil
+	}
+}
+
+func opGetGlobal(v *VM, inst bytecode.Instruction) opFunc {
+	name := bytecode.ConstName(v.Program.Constants, inst.Arg)
+	return func(v *VM, f *Frame) error {
+		v.push(v.Globals[name])
+		return nil
+	}
+}
+
+func opSetLocal(_ *VM, inst bytecode.Instruction) opFunc {
+	idx := inst.Arg
+	return func(v *VM, f *Frame) error {
+		f.Locals[idx] = v.pop()
+		return nil
+	}
+}
+
+func opGetLocal(_ *VM, inst bytecode.Instruction) opFunc {
+	idx := inst.Arg
+	return func(v *VM, f *Frame) error {
+		v.push(f.Locals[idx])
+		return nil
+	}
+}
+
+func opGetIndex(v *VM, f *Frame) error {
+	index := v.pop()
+	table := v.pop()
+	switch t := table.Ref.(type) {
+	case []interface{}:
+		i := int(index.number())
+		if i >= 0 && i < len(t) {
+			v.push(valueOf(t[i]))
+		} else {
+			v.push(NilValue)
+		}
+	case map[string]interface{}:
+		v.push(valueOf(t[index.key()]))
+	case *builtins.OrderedTable:
+		v.push(valueOf(t.Get(index.key())))
+	case string:
+		if r, ok := builtins.RuneAt(t, int(index.number())); ok {
+			v.push(Value{Kind: KindString, Ref: r})
+		} else {
+			v.push(NilValue)
+		}
+	default:
+		v.push(NilValue)
+	}
+	return nil
+}
+
+func opSetIndex(v *VM, f *Frame) error {
+	val := v.pop()
+	index := v.pop()
+	table := v.pop()
+	switch t := table.Ref.(type) {
+	case []interface{}:
+		i := int(index.number())
+		if i >= 0 && i < len(t) {
+			t[i] = val.Interface()
+		}
+		v.push(table)
+	case map[string]interface{}:
+		key := index.key()
+		t[key] = val.Interface()
+		v.push(table)
+		return v.alloc(entrySize + len(key))
+	case *builtins.OrderedTable:
+		key := index.key()
+		t.Set(key, val.Interface())
+		v.push(table)
+		return v.alloc(entrySize + len(key))
+	default:
+		return fmt.Errorf("cannot set index of %s", table.typeName())
+	}
+	return nil
+}
+
+// opCall calls a builtin, looked up once, or the function in a global.
+func opCall(v *VM, inst bytecode.Instruction) opFunc {
+	target := bytecode.ConstName(v.Program.Constants, bytecode.CallName(inst.Arg))
+	count := bytecode.CallCount(inst.Arg)
+	if fn, ok := builtins.Builtins[target]; ok {
+		return func(v *VM, f *Frame) error {
+			return v.callBuiltin(fn, count)
+		}
+	}
+	return func(v *VM, f *Frame) error {
+		return v.callNamed(f, target, count)
+	}
+}
+
+func opCallIndirect(v *VM, inst bytecode.Instruction) opFunc {
+	count := inst.Arg
+	return func(v *VM, f *Frame) error {
+		if entry, ok := v.pop().Function(); ok {
+			return v.enter(f, entry, count)
+		}
+		return fmt.Errorf("cannot call non-function")
+	}
+}
+
+func opReturn(v *VM, f *Frame) error {
+	frameSp := f.Sp
+	retVal := NilValue
+	if v.Sp > frameSp {
+		retVal = v.pop()
+	}
+	v.CallStack = v.CallStack[:len(v.CallStack)-1]
+	if len(v.CallStack) > 0 {
+		v.Sp = frameSp
+		v.push(retVal)
+	} else {
+		v.Sp = 0
+	}
+	return nil
+}
+
+func opMakeFunc(v *VM, inst bytecode.Instruction) opFunc {
+	entry := v.Program.Constants[inst.Arg].Value
+	return func(v *VM, f *Frame) error {
+		fnObj := map[string]interface{}{
+			"type":  "function",
+			"entry": entry,
+		}
+		v.push(Value{Kind: KindObject, Ref: fnObj})
+		return nil
+	}
+}
+
+func opJump(_ *VM, inst bytecode.Instruction) opFunc {
+	target := inst.Arg
+	return func(v *VM, f *Frame) error {
+		f.Ip = target
+		return nil
+	}
+}
+
+func opJumpIfFalse(_ *VM, inst bytecode.Instruction) opFunc {
+	target := inst.Arg
+	return func(v *VM, f *Frame) error {
+		if !v.pop().truthy() {
+			f.Ip = target
+		}
+		return nil
+	}
+}
+
+func opJumpIfNotNil(_ *VM, inst bytecode.Instruction) opFunc {
+	target := inst.Arg
+	return func(v *VM, f *Frame) error {
+		if v.Sp == 0 {
+			return errStackUnderflow
+		}
+		if v.Stack[v.Sp-1].Kind == KindNil {
+			v.Sp--
+		} else {
+			f.Ip = target
+		}
+		return nil
+	}
+}
+
+// jumpOrPop makes the handler of the ops that short-circuit and and or.
+func jumpOrPop(when bool) func(*VM, bytecode.Instruction) opFunc {
+	return func(_ *VM, inst bytecode.Instruction) opFunc {
+		target := inst.Arg
+		return func(v *VM, f *Frame) error {
+			if v.Sp == 0 {
+				return errStackUnderflow
+			}
+			if v.Stack[v.Sp-1].truthy() == when {
+				f.Ip = target
+			} else {
+				v.Sp--
+			}
+			return nil
+		}
+	}
+}
+
+// opIterNext steps a for-in loop: arrays, strings and tables are indexed
+// by the counter, as far as their length, and iterators are pulled from.
+func opIterNext(_ *VM, inst bytecode.Instruction) opFunc {
+	target := inst.Arg
+	return func(v *VM, f *Frame) error {
+		coll := v.pop()
+		i := int(v.pop().number())
+		switch c := coll.Ref.(type) {
+		case []interface{}:
+			if i < len(c) {
+				v.push(valueOf(c[i]))
+				return nil
+			}
+		case string:
+			if r, ok := builtins.RuneAt(c, i); ok {
+				v.push(Value{Kind: KindString, Ref: r})
+				return nil
+			}
+		case map[string]interface{}:
+			if i < len(c) {
+				v.push(valueOf(c[builtins.NumberKey(float64(i))]))
+				return nil
+			}
+		case builtins.Iterator:
+			val, ok, err := c.Next()
+			if err != nil {
+				return err
+			}
+			if ok {
+				v.push(valueOf(val))
+				return nil
+			}
+		default:
+			return fmt.Errorf("cannot loop over %s", coll.typeName())
+		}
+		f.Ip = target
+		return nil
+	}
+}
+
+func opPop(v *VM, f *Frame) error {
+	if v.Sp > 0 {
+		v.Sp--
+	}
+	return nil
+}
+
+func opHalt(v *VM, f *Frame) error { return errHalt }
+
+func opCheckType(v *VM, inst bytecode.Instruction) opFunc {
+	want, _ := v.Program.Constants[inst.Arg].Value.(string)
+	base, nullable := strings.CutSuffix(want, "?")
+	return func(v *VM, f *Frame) error {
+		if v.Sp == 0 {
+			return errStackUnderflow
+		}
+		got := v.Stack[v.Sp-1].typeName()
+		if nullable && got == "nil" {
+			return nil
+		}
+		// comparisons give numbers, so those pass for bool
+		if got != base && base != "any" && !(base == "bool" && got == "number") {
+			return fmt.Errorf("expected %s, got %s", want, got)
+		}
+		return nil
+	}
+}
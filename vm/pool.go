@@ -0,0 +1,102 @@
+package vm
+
+import (
+	"sync"
+	"time"
+)
+
+// Pool hands out VMs that have already run the top level of one program,
+// for running a function of it per request. It is safe for concurrent use.
+type Pool struct {
+	prog *Program
+	opts []Option
+	// MaxIdle, when above 0, limits how many VMs the pool keeps for reuse.
+	MaxIdle int
+
+	mu    sync.Mutex
+	idle  []*VM
+	stats PoolStats
+}
+
+// PoolStats counts what a Pool has done.
+type PoolStats struct {
+	// Gets is how many VMs were handed out, Created how many of those were
+	// new and Reused how many had been used before.
+	Gets, Created, Reused int
+	// Resets is how many VMs came back, and ResetTime the time spent
+	// clearing them and running the top level again.
+	Resets    int
+	ResetTime time.Duration
+	// Idle is how many VMs are waiting to be handed out.
+	Idle int
+}
+
+// NewPool returns a pool of VMs made with opts that run prog.
+func NewPool(prog *Program, opts ...Option) *Pool {
+	return &Pool{prog: prog, opts: opts}
+}
+
+// Get returns an idle VM, or a new one once it has run the top level.
+func (p *Pool) Get() (*VM, error) {
+	p.mu.Lock()
+	p.stats.Gets++
+	if n := len(p.idle); n > 0 {
+		v := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.stats.Reused++
+		p.mu.Unlock()
+		return v, nil
+	}
+	p.stats.Created++
+	p.mu.Unlock()
+
+	v := New(p.opts...)
+	if err := v.Run(p.prog); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Put gives v back. It is reset, which clears its globals and stacks and
+// restores the pool's options, and runs the top level again, so the next
+// Get finds it ready. A VM whose top level fails is dropped.
+func (p *Pool) Put(v *VM) {
+	start := time.Now()
+	v.Reset()
+	for _, opt := range p.opts {
+		opt(v)
+	}
+	err := v.Run(p.prog)
+	elapsed := time.Since(start)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.Resets++
+	p.stats.ResetTime += elapsed
+	if err == nil && (p.MaxIdle <= 0 || len(p.idle) < p.MaxIdle) {
+		p.idle = append(p.idle, v)
+	}
+}
+
+// Stats returns the counters of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats
+	s.Idle = len(p.idle)
+	return s
+}
+
+// Reset clears what runs left in v: globals, the stacks, the top level's
+// locals and the counters of vmstats. The program, options and host
+// functions stay.
+func (v *VM) Reset() {
+	clear(v.Globals)
+	clear(v.Stack)
+	v.Sp = 0
+	v.CallStack = nil
+	v.atExit = nil
+	v.timers = nil
+	clear(v.topLocals)
+	v.executed, v.peakSp, v.peakCalls = 0, 0, 0
+}
@@ -0,0 +1,209 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"lightlang/bytecode"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Profiler counts how often each opcode and function runs and how long
+// they take (run --profile). Like tracing, profiling turns off instruction
+// fusion so the time of each opcode is measured on its own.
+type Profiler struct {
+	// Folded, when set, is the file that receives the call stacks in the
+	// folded format read by flamegraph tools.
+	Folded string
+
+	ops   [bytecode.OpCount]opStat
+	funcs map[int]*funcStat
+	root  *profNode
+	stack []*profNode
+}
+
+type opStat struct {
+	count int
+	time  time.Duration
+}
+
+type funcStat struct {
+	calls, insts int
+	self         time.Duration
+}
+
+// profNode is a call path; time is spent in the function at the end of the
+// path itself, not in its callees.
+type profNode struct {
+	entry    int
+	time     time.Duration
+	children map[int]*profNode
+}
+
+func (n *profNode) child(entry int) *profNode {
+	c, ok := n.children[entry]
+	if !ok {
+		c = &profNode{entry: entry, children: make(map[int]*profNode)}
+		n.children[entry] = c
+	}
+	return c
+}
+
+func NewProfiler(folded string) *Profiler {
+	return &Profiler{
+		Folded: folded,
+		funcs:  make(map[int]*funcStat),
+		root:   &profNode{entry: -2, children: make(map[int]*profNode)},
+	}
+}
+
+// node returns the call path of the running frame, following the call
+// stack from where it last changed.
+func (p *Profiler) node(calls []Frame) *profNode {
+	if len(p.stack) > len(calls) {
+		p.stack = p.stack[:len(calls)]
+	}
+	for n := len(p.stack); n > 0 && p.stack[n-1].entry != calls[n-1].Entry; n-- {
+		p.stack = p.stack[:n-1]
+	}
+	for len(p.stack) < len(calls) {
+		parent := p.root
+		if len(p.stack) > 0 {
+			parent = p.stack[len(p.stack)-1]
+		}
+		p.stack = append(p.stack, parent.child(calls[len(p.stack)].Entry))
+	}
+	return p.stack[len(p.stack)-1]
+}
+
+func (p *Profiler) function(entry int) *funcStat {
+	s, ok := p.funcs[entry]
+	if !ok {
+		s = &funcStat{}
+		p.funcs[entry] = s
+	}
+	return s
+}
+
+// profile wraps every op so that its runs and time are recorded.
+func (p *Profiler) profile(v *VM, ops []opFunc) {
+	p.function(-1).calls = 1
+	for ip, op := range ops {
+		code := v.Program.Instructions[ip].Op
+		isCall := code == bytecode.OpCall || code == bytecode.OpCallIndirect
+		ops[ip] = func(v *VM, f *Frame) error {
+			node := p.node(v.CallStack)
+			depth := len(v.CallStack)
+			start := time.Now()
+			err := op(v, f)
+			elapsed := time.Since(start)
+
+			stat := &p.ops[code]
+			stat.count++
+			stat.time += elapsed
+			fn := p.function(node.entry)
+			fn.insts++
+			fn.self += elapsed
+			node.time += elapsed
+			switch {
+			case len(v.CallStack) > depth:
+				p.function(v.CallStack[len(v.CallStack)-1].Entry).calls++
+			case isCall && len(v.CallStack) == depth && f.Ip != ip+1:
+				p.function(f.Entry).calls++
+			}
+			return err
+		}
+	}
+}
+
+// Report prints the opcodes and functions sorted by the time spent in them.
+func (p *Profiler) Report(w io.Writer, v *VM) {
+	var total time.Duration
+	executed := 0
+	for _, s := range p.ops {
+		total += s.time
+		executed += s.count
+	}
+	percent := func(d time.Duration) float64 {
+		if total == 0 {
+			return 0
+		}
+		return float64(d) / float64(total) * 100
+	}
+
+	var codes []bytecode.OpCode
+	for op, s := range p.ops {
+		if s.count > 0 {
+			codes = append(codes, bytecode.OpCode(op))
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool { return p.ops[codes[i]].time > p.ops[codes[j]].time })
+	fmt.Fprintf(w, "profile: %s in %d executed instructions\n\n", FormatDuration(total), executed)
+	fmt.Fprintf(w, "%-14s %12s %12s %7s %10s\n", "opcode", "count", "time", "%", "ns/op")
+	for _, op := range codes {
+		s := p.ops[op]
+		fmt.Fprintf(w, "%-14s %12d %12s %6.1f%% %10.1f\n", op, s.count, FormatDuration(s.time), percent(s.time), float64(s.time)/float64(s.count))
+	}
+
+	names := v.functionNames()
+	var entries []int
+	for entry := range p.funcs {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return p.funcs[entries[i]].self > p.funcs[entries[j]].self })
+	fmt.Fprintf(w, "\n%-20s %10s %12s %12s %7s\n", "function", "calls", "instructions", "self", "%")
+	for _, entry := range entries {
+		s := p.funcs[entry]
+		fmt.Fprintf(w, "%-20s %10d %12d %12s %6.1f%%\n", frameName(names, entry), s.calls, s.insts, FormatDuration(s.self), percent(s.self))
+	}
+}
+
+// WriteFolded writes one line per call path with its time in nanoseconds,
+// like "<main>;count;sq 1200", for flamegraph.pl and compatible tools.
+func (p *Profiler) WriteFolded(w io.Writer, v *VM) error {
+	names := v.functionNames()
+	var lines []string
+	var walk func(n *profNode, path string)
+	walk = func(n *profNode, path string) {
+		if n != p.root {
+			if path != "" {
+				path += ";"
+			}
+			path += frameName(names, n.entry)
+			if n.time > 0 {
+				lines = append(lines, fmt.Sprintf("%s %d", path, n.time.Nanoseconds()))
+			}
+		}
+		for _, c := range n.children {
+			walk(c, path)
+		}
+	}
+	walk(p.root, "")
+	sort.Strings(lines)
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// Finish prints the report and writes the folded stacks when asked to.
+func (p *Profiler) Finish(v *VM) {
+	p.Report(v.Stderr, v)
+	if p.Folded == "" {
+		return
+	}
+	file, err := os.Create(p.Folded)
+	if err == nil {
+		err = p.WriteFolded(file, v)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(v.Stderr, "Error writing profile: %v\n", err)
+	}
+}
+
+func FormatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.2fms", float64(d.Microseconds())/1000)
+}
@@ -0,0 +1,38 @@
+package vm
+
+import (
+	"lightlang/bytecode"
+	"sync"
+)
+
+// Program is a compiled program: its instructions, constants and source
+// map. VMs never change a Program, so any number of them can run one at
+// once.
+type Program struct {
+	Instructions []bytecode.Instruction
+	Constants    []bytecode.Constant
+	// SourceMap, when set, gives the file and position of instructions
+	// loaded from bytecode.
+	SourceMap *bytecode.SourceMap
+
+	once sync.Once
+	code *code
+}
+
+// code is a program compiled to handlers.
+type code struct {
+	ops       []opFunc
+	functions map[int]bytecode.Function
+	maxLocals int
+}
+
+// compiled builds the handlers of p on first use. Handlers get the VM they
+// run on as an argument, so building them on a blank VM is enough.
+func (p *Program) compiled() *code {
+	p.once.Do(func() {
+		v := &VM{Program: p}
+		ops := v.precompile()
+		p.code = &code{ops: ops, functions: v.functions, maxLocals: v.maxLocals}
+	})
+	return p.code
+}
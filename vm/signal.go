@@ -0,0 +1,93 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// signals holds the handlers registered with on_signal. Signals arrive on
+// their own goroutine and wait in ch until the VM reaches a checkpoint,
+// where the handlers run between two instructions.
+type signals struct {
+	mu       sync.Mutex
+	handlers map[os.Signal]Value
+	ch       chan os.Signal
+}
+
+func (v *VM) onSignal(name string, fn interface{}) error {
+	sig, ok := signalNames[strings.ToUpper(strings.TrimPrefix(name, "SIG"))]
+	if !ok {
+		names := make([]string, 0, len(signalNames))
+		for n := range signalNames {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("on_signal: unknown signal %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	s := &v.signals
+	s.mu.Lock()
+	if s.handlers == nil {
+		s.handlers = make(map[os.Signal]Value)
+		s.ch = make(chan os.Signal, 8)
+	}
+	s.handlers[sig] = valueOf(fn)
+	s.mu.Unlock()
+	signal.Notify(s.ch, sig)
+	v.schedule()
+	return nil
+}
+
+// HandlesSignal tells whether the program has a handler for sig. A host
+// that reacts to signals itself, as the command line does to Ctrl-C,
+// should leave those to the program.
+func (v *VM) HandlesSignal(sig os.Signal) bool {
+	v.signals.mu.Lock()
+	defer v.signals.mu.Unlock()
+	_, ok := v.signals.handlers[sig]
+	return ok
+}
+
+func (v *VM) watchesSignals() bool {
+	return v.signals.ch != nil
+}
+
+// handleSignals runs the handlers of the signals that arrived since the
+// last checkpoint.
+func (v *VM) handleSignals() error {
+	for {
+		select {
+		case sig := <-v.signals.ch:
+			v.signals.mu.Lock()
+			fn := v.signals.handlers[sig]
+			v.signals.mu.Unlock()
+			if _, err := v.CallValue(fn, valueOf(signalName(sig))); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// stopSignals gives the signals back to the host when the program ends.
+func (v *VM) stopSignals() {
+	v.signals.mu.Lock()
+	defer v.signals.mu.Unlock()
+	if v.signals.ch != nil {
+		signal.Stop(v.signals.ch)
+	}
+	v.signals.handlers, v.signals.ch = nil, nil
+}
+
+func signalName(sig os.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return name
+		}
+	}
+	return sig.String()
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package vm
+
+import (
+	"os"
+	"syscall"
+)
+
+// signalNames are the signals on_signal can catch.
+var signalNames = map[string]os.Signal{
+	"INT":  os.Interrupt,
+	"TERM": syscall.SIGTERM,
+}
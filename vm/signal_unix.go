@@ -0,0 +1,18 @@
+//go:build unix
+
+package vm
+
+import (
+	"os"
+	"syscall"
+)
+
+// signalNames are the signals on_signal can catch.
+var signalNames = map[string]os.Signal{
+	"INT":  os.Interrupt,
+	"TERM": syscall.SIGTERM,
+	"HUP":  syscall.SIGHUP,
+	"QUIT": syscall.SIGQUIT,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
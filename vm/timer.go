@@ -0,0 +1,94 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// timer is a function set to run with set_timeout or set_interval. Timers
+// run once the top level of the program has finished, like an event loop:
+// the program ends when none are left.
+type timer struct {
+	id    int
+	due   time.Time
+	every time.Duration
+	fn    Value
+}
+
+func (v *VM) setTimer(fn interface{}, ms float64, repeat bool) int {
+	v.timerID++
+	d := time.Duration(ms * float64(time.Millisecond))
+	t := &timer{id: v.timerID, due: time.Now().Add(d), fn: valueOf(fn)}
+	if repeat {
+		t.every = d
+	}
+	v.timers = append(v.timers, t)
+	return t.id
+}
+
+func (v *VM) cancelTimer(id int) bool {
+	i := slices.IndexFunc(v.timers, func(t *timer) bool { return t.id == id })
+	if i < 0 {
+		return false
+	}
+	v.timers = slices.Delete(v.timers, i, i+1)
+	return true
+}
+
+// sleep waits for d for the wait builtin, returning early when the run's
+// context is done or its Timeout is reached.
+func (v *VM) sleep(d time.Duration) error {
+	late := !v.deadline.IsZero() && time.Now().Add(d).After(v.deadline)
+	if late {
+		d = time.Until(v.deadline)
+	}
+	if d > 0 {
+		sleep := time.NewTimer(d)
+		select {
+		case <-sleep.C:
+		case <-v.ctx.Done():
+			sleep.Stop()
+			return v.ctx.Err()
+		}
+	}
+	if late {
+		return fmt.Errorf("%w: ran longer than %s", ErrTimeout, v.Timeout)
+	}
+	return nil
+}
+
+// runTimers calls the timers as they come due until none are left, ctx is
+// done or the run reaches its Timeout.
+func (v *VM) runTimers(ctx context.Context) error {
+	for len(v.timers) > 0 {
+		t := slices.MinFunc(v.timers, func(a, b *timer) int { return a.due.Compare(b.due) })
+		late := !v.deadline.IsZero() && t.due.After(v.deadline)
+		if late {
+			t.due = v.deadline
+		}
+		if wait := time.Until(t.due); wait > 0 {
+			sleep := time.NewTimer(wait)
+			select {
+			case <-sleep.C:
+			case <-ctx.Done():
+				sleep.Stop()
+				return ctx.Err()
+			}
+		}
+		if late {
+			return fmt.Errorf("%w: ran longer than %s", ErrTimeout, v.Timeout)
+		}
+		if t.every > 0 {
+			t.due = t.due.Add(t.every)
+		} else {
+			v.cancelTimer(t.id)
+		}
+		v.topFrame()
+		if _, err := v.CallValue(t.fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"lightlang/bytecode"
+)
+
+// Tracer prints every instruction the VM executes along with the top of
+// the stack afterwards, and the calls and returns between functions
+// (run --trace). Tracing turns off instruction fusion so each instruction
+// shows up on its own.
+type Tracer struct {
+	Out io.Writer
+	// Func limits the trace to the instructions of one function; "<main>"
+	// is the top level.
+	Func string
+	// From and To limit the trace to an instruction range when To > 0.
+	From, To int
+}
+
+func (t *Tracer) wants(ip int, fn string) bool {
+	if t.To > 0 && (ip < t.From || ip > t.To) {
+		return false
+	}
+	return t.Func == "" || t.Func == fn
+}
+
+// functionNames maps function entries to the names of their constants.
+func (v *VM) functionNames() map[int]string {
+	names := make(map[int]string)
+	for _, c := range v.Program.Constants {
+		if c.Type == "funcptr" {
+			if entry, ok := bytecode.ArgInt(c.Value); ok {
+				names[entry] = c.Name
+			}
+		}
+	}
+	return names
+}
+
+// frameName names the function running at entry for tracebacks and traces.
+func frameName(names map[int]string, entry int) string {
+	name, ok := names[entry]
+	switch {
+	case entry < 0:
+		return "<main>"
+	case !ok:
+		return fmt.Sprintf("func@%d", entry)
+	case name == "":
+		return "<anonymous>"
+	}
+	return name
+}
+
+// trace wraps every op so that it is printed after it runs.
+func (t *Tracer) trace(v *VM, ops []opFunc) {
+	names := v.functionNames()
+	for ip, op := range ops {
+		inst := v.Program.Instructions[ip]
+		arg := bytecode.DescribeArg(inst, v.Program.Constants, len(v.Program.Instructions))
+		ops[ip] = func(v *VM, f *Frame) error {
+			entry, depth := f.Entry, len(v.CallStack)
+			fn := frameName(names, entry)
+			err := op(v, f)
+			if !t.wants(ip, fn) {
+				return err
+			}
+			top := ""
+			if v.Sp > 0 {
+				top = v.Stack[v.Sp-1].String()
+			}
+			fmt.Fprintf(t.Out, "[%5d] %-14s %-20s %s\n", ip, inst.Op, arg, top)
+			if err != nil {
+				return err
+			}
+			switch {
+			case len(v.CallStack) > depth:
+				callee := v.CallStack[len(v.CallStack)-1]
+				fmt.Fprintf(t.Out, "        call %s from %s, depth %d\n", frameName(names, callee.Entry), fn, len(v.CallStack)-1)
+			case len(v.CallStack) < depth:
+				fmt.Fprintf(t.Out, "        return %s from %s\n", top, fn)
+			case (inst.Op == bytecode.OpCall || inst.Op == bytecode.OpCallIndirect) && f.Ip != ip+1:
+				fmt.Fprintf(t.Out, "        tail call %s from %s\n", frameName(names, f.Entry), fn)
+			}
+			return nil
+		}
+	}
+}
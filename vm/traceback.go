@@ -0,0 +1,70 @@
+package vm
+
+import (
+	"fmt"
+	"lightlang/bytecode"
+	"lightlang/parser"
+	"strings"
+)
+
+// traceback lists the active calls, innermost first, with ip as the
+// failing instruction. Caller frames are reported at their call
+// instruction; frames that are not inside a call have finished and are
+// left out. It returns nil when the error happened at the top level.
+func (v *VM) traceback(ip int) []parser.StackFrame {
+	if len(v.CallStack) < 2 {
+		return nil
+	}
+	names := v.functionNames()
+
+	var trace []parser.StackFrame
+	for i := len(v.CallStack) - 1; i >= 0; i-- {
+		f := v.CallStack[i]
+		at := ip
+		if i < len(v.CallStack)-1 {
+			at = f.Ip - 1
+			if at < 0 || at >= len(v.Program.Instructions) || (v.Program.Instructions[at].Op != bytecode.OpCall && v.Program.Instructions[at].Op != bytecode.OpCallIndirect) {
+				continue
+			}
+		}
+		file, pos := v.position(at)
+		trace = append(trace, parser.StackFrame{Func: frameName(names, f.Entry), Ip: at, File: file, Pos: pos})
+	}
+	if len(trace) < 2 {
+		return nil
+	}
+	return trace
+}
+
+func FormatTraceback(file string, trace []parser.StackFrame) string {
+	var b strings.Builder
+	b.WriteString("traceback (most recent call first):")
+	width := 0
+	for _, f := range trace {
+		width = max(width, len(f.Func))
+	}
+	for i := 0; i < len(trace); i++ {
+		f := trace[i]
+		repeats := 0
+		for i+1 < len(trace) && trace[i+1] == f {
+			i++
+			repeats++
+		}
+		loc := f.File
+		if loc == "" {
+			loc = file
+		}
+		if f.Line > 0 {
+			pos := fmt.Sprintf("%d:%d", f.Line, f.Col)
+			if loc != "" {
+				pos = loc + ":" + pos
+			}
+			loc = pos
+		}
+		fmt.Fprintf(&b, "\n  %-*s  ip %-5d %s", width, f.Func, f.Ip, loc)
+		if repeats > 0 {
+			fmt.Fprintf(&b, "\n  ... repeated %d more times", repeats)
+		}
+	}
+	return b.String()
+}
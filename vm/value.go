@@ -0,0 +1,194 @@
+package vm
+
+import (
+	"fmt"
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"math/big"
+	"strconv"
+)
+
+// ValueKind tells which field of a Value holds its data.
+type ValueKind uint8
+
+const (
+	KindNil ValueKind = iota
+	KindNumber
+	KindBool
+	KindString
+	// KindObject covers tables, arrays and functions.
+	KindObject
+)
+
+// Value is a lightlang value on the VM stack, in locals and in globals.
+// Numbers and booleans are kept in Num so they move around without
+// allocating; strings and objects are held in Ref. Tables, arrays and
+// builtins still use interface{}, see valueOf and Interface.
+type Value struct {
+	Kind ValueKind
+	Num  float64
+	Ref  interface{}
+}
+
+var NilValue = Value{}
+
+func numberValue(n float64) Value {
+	return Value{Kind: KindNumber, Num: n}
+}
+
+// boolNumberValue is the result of comparisons and not, which are numbers.
+func boolNumberValue(ok bool) Value {
+	if ok {
+		return Value{Kind: KindNumber, Num: 1}
+	}
+	return Value{Kind: KindNumber}
+}
+
+// valueOf converts a value coming from a table, a constant or a builtin.
+// Integers become numbers.
+func valueOf(x interface{}) Value {
+	switch x := x.(type) {
+	case nil:
+		return NilValue
+	case float64:
+		return Value{Kind: KindNumber, Num: x}
+	case int:
+		return Value{Kind: KindNumber, Num: float64(x)}
+	case int64:
+		return Value{Kind: KindNumber, Num: float64(x)}
+	case int32:
+		return Value{Kind: KindNumber, Num: float64(x)}
+	case bool:
+		if x {
+			return Value{Kind: KindBool, Num: 1}
+		}
+		return Value{Kind: KindBool}
+	case string:
+		return Value{Kind: KindString, Ref: x}
+	}
+	return Value{Kind: KindObject, Ref: x}
+}
+
+// Interface converts v for tables and builtins.
+func (v Value) Interface() interface{} {
+	switch v.Kind {
+	case KindNumber:
+		return v.Num
+	case KindBool:
+		return v.Num != 0
+	case KindNil:
+		return nil
+	}
+	return v.Ref
+}
+
+// number is the numeric value of v for arithmetic. Anything that is not a
+// number counts as 0.
+func (v Value) number() float64 {
+	if v.Kind == KindNumber {
+		return v.Num
+	}
+	return 0
+}
+
+// truthy is builtins.Truthy for Values.
+func (v Value) truthy() bool {
+	switch v.Kind {
+	case KindNil:
+		return false
+	case KindNumber, KindBool:
+		return v.Num != 0
+	case KindString:
+		return v.Ref.(string) != ""
+	}
+	return true
+}
+
+// equal compares numbers, booleans, strings, bigints and decimals by value
+// and objects by identity.
+func (v Value) equal(w Value) bool {
+	if v.Kind == KindObject || w.Kind == KindObject {
+		return builtins.Equal(v.Interface(), w.Interface())
+	}
+	if v.Kind != w.Kind {
+		return false
+	}
+	switch v.Kind {
+	case KindNil:
+		return true
+	case KindNumber, KindBool:
+		return v.Num == w.Num
+	}
+	return v.Ref.(string) == w.Ref.(string)
+}
+
+// key converts v to a table key, like builtins.TableKey.
+func (v Value) key() string {
+	switch v.Kind {
+	case KindString:
+		return v.Ref.(string)
+	case KindNumber:
+		return builtins.NumberKey(v.Num)
+	}
+	return builtins.TableKey(v.Interface())
+}
+
+// function returns the entry of a function value.
+func (v Value) Function() (int, bool) {
+	fnMeta, ok := v.Ref.(map[string]interface{})
+	if !ok || fnMeta["type"] != "function" {
+		return 0, false
+	}
+	return bytecode.ArgInt(fnMeta["entry"])
+}
+
+// typeName is the name type annotations use for the type of v.
+func (v Value) typeName() string {
+	switch v.Kind {
+	case KindNil:
+		return "nil"
+	case KindNumber:
+		return "number"
+	case KindBool:
+		return "bool"
+	case KindString:
+		return "string"
+	}
+	switch ref := v.Ref.(type) {
+	case *big.Int:
+		return "bigint"
+	case *builtins.Decimal:
+		return "decimal"
+	case *builtins.OrderedTable:
+		return "table"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		if ref["type"] == "function" {
+			return "function"
+		}
+		return "table"
+	}
+	return fmt.Sprintf("%T", v.Ref)
+}
+
+// String formats v for traces. Strings are quoted.
+func (v Value) String() string {
+	switch v.Kind {
+	case KindNil:
+		return "nil"
+	case KindString:
+		return strconv.Quote(v.Ref.(string))
+	}
+	if entry, ok := v.Function(); ok {
+		return fmt.Sprintf("<function@%d>", entry)
+	}
+	return builtins.Repr(v.Interface(), "")
+}
+
+// ValueOf converts a Go value of the kinds builtins use: float64, string,
+// bool, nil, []interface{} and map[string]interface{}. lang.ToValue
+// converts other Go values.
+func ValueOf(x interface{}) Value {
+	return valueOf(x)
+}
@@ -0,0 +1,651 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"lightlang/builtins"
+	"lightlang/bytecode"
+	"lightlang/parser"
+	"math"
+	"os"
+	"slices"
+	"time"
+)
+
+type Table map[string]interface{}
+
+func NewTable() Table {
+	return make(Table)
+}
+
+type Frame struct {
+	Instructions []bytecode.Instruction
+	Ip           int
+	Sp           int
+	ArgCount     int
+	// Entry is the first instruction of the running function, -1 for the
+	// top level.
+	Entry int
+	// Locals holds the parameters and local variables, apart from the
+	// operand stack.
+	Locals []Value
+}
+
+type VM struct {
+	// Program is the loaded program. The VM does not change it.
+	Program   *Program
+	Stack     []Value
+	Sp        int
+	CallStack []Frame
+	Globals   map[string]Value
+	// MaxCallDepth limits how many calls can be active at once.
+	MaxCallDepth int
+	// MaxStack, when above 0, limits how many values the operand stack may
+	// grow to. It starts with DefaultStackSize and grows by half when full.
+	MaxStack int
+	// MaxSteps, when above 0, limits how many instructions each run may
+	// dispatch. A fused sequence counts as one.
+	MaxSteps int
+	// Timeout, when above 0, limits how long each run may take.
+	Timeout time.Duration
+	// MaxMemory, when above 0, limits about how many bytes of strings,
+	// tables and arrays a program may hold.
+	MaxMemory int
+	// Trace, when set, prints the instructions as they run.
+	Trace *Tracer
+	// Profile, when set, records the time spent in opcodes and functions.
+	Profile *Profiler
+	// Cover, when set, records the lines that run.
+	Cover *Coverage
+	// Allocs, when set, records the allocations of each line.
+	Allocs *AllocProfiler
+	// Debug, when set, lets a debugger client stop the program.
+	Debug *Debugger
+	// JIT compiles the functions that only compute with numbers to Go
+	// closures, unless one of the above is set. See jit.go.
+	JIT bool
+	// Stdin, Stdout and Stderr are used by input, print and the other
+	// builtins that do console IO. NewVM sets them to the process's.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Perms, when set, limits what the IO builtins may do.
+	Perms *builtins.Permissions
+	// Files, when set, holds files bundled with the program, which
+	// read_file and csv_read find before those on disk.
+	Files fs.FS
+	// Log, when set, is used by the log builtins instead of
+	// builtins.DefaultLogger.
+	Log *builtins.Logger
+	// Snapshots, when set, is where assert_snapshot keeps its values. The
+	// test runner sets it.
+	Snapshots *builtins.Snapshots
+	// env is passed to builtins, see start.
+	env     *builtins.Context
+	ops     []opFunc
+	jitRun  *jitRun
+	strings interner
+	// hosts are the functions added with Register.
+	hosts map[string]HostFunc
+	// functions maps function entries to what a call needs to set up the
+	// frame. Functions loaded from bytecode without a local count get
+	// maxLocals slots.
+	functions map[int]bytecode.Function
+	maxLocals int
+	// atExit are the functions registered with atexit, called in reverse
+	// when RunContext ends.
+	atExit  []Value
+	signals signals
+	// timers are waiting to run after the top level, see runTimers.
+	timers  []*timer
+	timerID int
+	// topLocals are the locals of the top level, kept between runs so the
+	// REPL sees them.
+	topLocals []Value
+	// executed, peakSp and peakCalls are reported by vmstats. peakSp is
+	// sampled at calls, which is where the stack grows the most.
+	executed  int
+	peakSp    int
+	peakCalls int
+	// stepLimit is the value of executed at which the run stops, and
+	// deadline the time. execute calls checkpoint when executed passes
+	// nextCheck.
+	stepLimit int
+	deadline  time.Time
+	nextCheck int
+	// ctx is the context of the running program.
+	ctx context.Context
+	// allocated counts the bytes allocated since the last memory check,
+	// which happens when it passes memCheck.
+	allocated int
+	memCheck  int
+}
+
+func NewVM() *VM {
+	return &VM{
+		Program:      &Program{},
+		Stack:        make([]Value, DefaultStackSize),
+		CallStack:    make([]Frame, 0, min(DefaultMaxCallDepth, initialCalls)),
+		Globals:      make(map[string]Value, 128),
+		Sp:           0,
+		MaxCallDepth: DefaultMaxCallDepth,
+		MaxStack:     DefaultMaxStack,
+		MaxSteps:     DefaultMaxSteps,
+		Timeout:      DefaultTimeout,
+		MaxMemory:    DefaultMaxMemory,
+		JIT:          DefaultJIT,
+		Stdin:        os.Stdin,
+		Stdout:       os.Stdout,
+		Stderr:       os.Stderr,
+		ctx:          context.Background(),
+	}
+}
+
+// DefaultMaxCallDepth, DefaultMaxSteps, DefaultTimeout and DefaultMaxStack
+// are the limits of new VMs, DefaultStackSize the values their operand
+// stack starts with and DefaultJIT whether they compile functions. The
+// command line sets them from --max-depth, --max-steps, --timeout,
+// --max-stack, --stack-size and --jit.
+var (
+	DefaultMaxCallDepth = 10000
+	DefaultMaxSteps     int
+	DefaultTimeout      time.Duration
+	DefaultStackSize    = 8192
+	DefaultMaxStack     int
+	DefaultJIT          bool
+)
+
+// initialCalls is how many frames the call stack of a new VM has room for
+// before it grows.
+const initialCalls = 64
+
+// ErrStackOverflow is returned, wrapped, when a call goes past MaxCallDepth
+// or the operand stack past MaxStack.
+var ErrStackOverflow = errors.New("stack overflow")
+
+// ErrBudgetExceeded is returned, wrapped, when a run uses up MaxSteps.
+var ErrBudgetExceeded = errors.New("execution budget exceeded")
+
+// ErrTimeout is returned, wrapped, when a run takes longer than Timeout.
+var ErrTimeout = errors.New("execution timed out")
+
+// checkInterval is how many instructions run between deadline and context
+// checks.
+const checkInterval = 1 << 14
+
+// startLimits sets up MaxSteps and Timeout for a new run.
+func (v *VM) startLimits() {
+	v.stepLimit = math.MaxInt
+	if v.MaxSteps > 0 {
+		v.stepLimit = v.executed + v.MaxSteps
+	}
+	v.deadline = time.Time{}
+	if v.Timeout > 0 {
+		v.deadline = time.Now().Add(v.Timeout)
+	}
+	v.schedule()
+}
+
+func (v *VM) schedule() {
+	v.nextCheck = v.stepLimit
+	if !v.deadline.IsZero() || v.ctx.Done() != nil || v.watchesSignals() {
+		v.nextCheck = min(v.nextCheck, v.executed+checkInterval)
+	}
+}
+
+// checkpoint stops the run when it is over its budget or deadline, or when
+// its context is done, and runs the handlers of signals that arrived.
+func (v *VM) checkpoint() error {
+	if err := v.ctx.Err(); err != nil {
+		return err
+	}
+	if err := v.handleSignals(); err != nil {
+		return err
+	}
+	if v.executed > v.stepLimit {
+		return fmt.Errorf("%w: more than %d instructions", ErrBudgetExceeded, v.MaxSteps)
+	}
+	if !v.deadline.IsZero() && time.Now().After(v.deadline) {
+		return fmt.Errorf("%w: ran longer than %s", ErrTimeout, v.Timeout)
+	}
+	v.schedule()
+	return nil
+}
+
+func (v *VM) overflow() error {
+	return fmt.Errorf("%w: more than %d nested calls", ErrStackOverflow, v.MaxCallDepth)
+}
+
+// enter calls the function at entry with the count arguments on top of the
+// stack. When the call is followed by a return the current frame is reused,
+// so tail calls run in constant space.
+func (v *VM) enter(f *Frame, entry, count int) error {
+	v.peakSp = max(v.peakSp, v.Sp)
+	base := v.Sp - count
+	if base < 0 {
+		return errStackUnderflow
+	}
+	if v.jitRun != nil {
+		if fn := v.jitRun.compiled(entry); fn != nil && count >= fn.params {
+			if res, ok := v.runJIT(fn, v.Stack[base:v.Sp]); ok {
+				v.Sp = base
+				v.push(numberValue(res))
+				return nil
+			}
+		}
+	}
+	locals := v.newLocals(entry, v.Stack[base:v.Sp])
+	v.Sp = base
+	if f.Entry >= 0 && f.Ip < len(v.Program.Instructions) && v.Program.Instructions[f.Ip].Op == bytecode.OpReturn {
+		f.Ip, f.ArgCount, f.Entry, f.Locals = entry, count, entry, locals
+		return nil
+	}
+	if len(v.CallStack) >= v.MaxCallDepth {
+		return v.overflow()
+	}
+	v.CallStack = append(v.CallStack, Frame{
+		Instructions: v.Program.Instructions,
+		Ip:           entry,
+		Sp:           base,
+		ArgCount:     count,
+		Entry:        entry,
+		Locals:       locals,
+	})
+	v.peakCalls = max(v.peakCalls, len(v.CallStack))
+	return nil
+}
+
+// callBuiltin calls fn with the count arguments on top of the stack.
+func (v *VM) callBuiltin(fn builtins.BuiltinFunc, count int) error {
+	v.peakSp = max(v.peakSp, v.Sp)
+	base := v.Sp - count
+	if base < 0 {
+		return errStackUnderflow
+	}
+	args := make([]interface{}, count)
+	for i, val := range v.Stack[base:v.Sp] {
+		args[i] = val.Interface()
+	}
+	v.Sp = base
+	res, err := fn(v.env, args)
+	if err != nil {
+		return err
+	}
+	if v.MaxMemory > 0 || v.Allocs != nil {
+		if err := v.allocResult(res, args); err != nil {
+			return err
+		}
+	}
+	v.push(valueOf(res))
+	return nil
+}
+
+// callNamed calls the function stored in global name.
+func (v *VM) callNamed(f *Frame, name string, count int) error {
+	if entry, ok := v.Globals[name].Function(); ok {
+		return v.enter(f, entry, count)
+	}
+	if fn, ok := v.hosts[name]; ok {
+		return v.callHost(fn, count)
+	}
+	return fmt.Errorf("function '%s' not found", name)
+}
+
+// newLocals allocates the local slots of a call of the function at entry
+// and copies args into them. Missing arguments are nil, and extra ones are
+// dropped when the function's parameter count is known.
+func (v *VM) newLocals(entry int, args []Value) []Value {
+	fn := v.functions[entry]
+	if fn.Params > 0 && len(args) > fn.Params-1 {
+		args = args[:fn.Params-1]
+	}
+	size := fn.Locals
+	if size == 0 {
+		size = v.maxLocals
+	}
+	locals := make([]Value, max(size, len(args)))
+	copy(locals, args)
+	return locals
+}
+
+type opFunc func(v *VM, f *Frame) error
+
+func toFloat64(val interface{}) float64 {
+	switch v := val.(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case int32:
+		return float64(v)
+	default:
+		return 0.0
+	}
+}
+
+// prepare takes the handlers the program shares, or builds the VM its own
+// when tracing, profiling, coverage or a debugger wrap them.
+func (v *VM) prepare() {
+	if v.Trace == nil && v.Profile == nil && v.Cover == nil && v.Allocs == nil && v.Debug == nil {
+		c := v.Program.compiled()
+		v.ops, v.functions, v.maxLocals = c.ops, c.functions, c.maxLocals
+		v.jitRun = nil
+		if v.JIT {
+			v.compileJIT()
+		}
+		return
+	}
+	v.ops = v.precompile()
+	v.jitRun = nil
+}
+
+func (v *VM) precompile() []opFunc {
+	if v.strings == nil {
+		v.strings = make(interner)
+	}
+	funcs, _ := bytecode.Functions(v.Program.Constants)
+	v.functions = make(map[int]bytecode.Function, len(funcs))
+	for _, fn := range funcs {
+		v.functions[fn.Entry] = fn
+	}
+	v.maxLocals = 0
+	ops := make([]opFunc, len(v.Program.Instructions))
+	for i, inst := range v.Program.Instructions {
+		if inst.Op == bytecode.OpGetLocal || inst.Op == bytecode.OpSetLocal {
+			v.maxLocals = max(v.maxLocals, inst.Arg+1)
+		}
+		ops[i] = v.makeOp(inst)
+	}
+	if v.Trace == nil && v.Profile == nil && v.Cover == nil && v.Allocs == nil && v.Debug == nil {
+		v.fuse(ops)
+	}
+	if v.Debug != nil {
+		v.Debug.debug(v, ops)
+	}
+	if v.Cover != nil {
+		v.Cover.instrument(v, ops)
+	}
+	if v.Trace != nil {
+		v.Trace.trace(v, ops)
+	}
+	if v.Allocs != nil {
+		v.Allocs.instrument(v, ops)
+	}
+	if v.Profile != nil {
+		v.Profile.profile(v, ops)
+	}
+	return ops
+}
+
+// makeOp compiles inst through the handler of its opcode.
+func (v *VM) makeOp(inst bytecode.Instruction) opFunc {
+	if int(inst.Op) < len(handlers) && handlers[inst.Op] != nil {
+		return handlers[inst.Op](v, inst)
+	}
+	return opNop
+}
+
+// RunContext executes the loaded program, then its timers, until they end
+// or ctx is done, in which case it returns ctx.Err() wrapped in a runtime
+// error. Either way it then calls the functions registered with atexit.
+func (v *VM) RunContext(ctx context.Context) error {
+	err := v.run(ctx, 0)
+	if err == nil {
+		err = v.runTimers(ctx)
+	}
+	v.timers = nil
+	if herr := v.exitHooks(); err == nil {
+		err = herr
+	}
+	v.stopSignals()
+	return err
+}
+
+// topFrame leaves only a finished top level on the call stack, for the
+// functions called after it.
+func (v *VM) topFrame() {
+	v.Sp = 0
+	v.CallStack = append(v.CallStack[:0], Frame{Instructions: v.Program.Instructions, Ip: len(v.Program.Instructions), Entry: -1, Locals: v.topLocals})
+}
+
+// exitHooks calls the atexit functions, last registered first, and returns
+// the first error. A failing hook does not stop the others.
+func (v *VM) exitHooks() error {
+	if len(v.atExit) == 0 {
+		return nil
+	}
+	hooks := v.atExit
+	v.atExit = nil
+	v.start(context.Background())
+	v.topFrame()
+	var first error
+	for _, fn := range slices.Backward(hooks) {
+		var err error
+		if f, ok := fn.Ref.(func() error); ok {
+			err = f()
+		} else {
+			_, err = v.CallValue(fn)
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// RunFrom executes the loaded program starting at ip while keeping globals,
+// which lets the repl append code and run only the new part.
+func (v *VM) RunFrom(ip int) error {
+	return v.RunFromContext(context.Background(), ip)
+}
+
+// RunFromContext is RunFrom stopping when ctx is done.
+func (v *VM) RunFromContext(ctx context.Context, ip int) error {
+	return v.run(ctx, ip)
+}
+
+// start prepares a run or a Call: it sets up the builtin context and the
+// limits.
+func (v *VM) start(ctx context.Context) {
+	v.ctx = ctx
+	v.env = &builtins.Context{
+		Stdin:        v.Stdin,
+		Stdout:       v.Stdout,
+		Stderr:       v.Stderr,
+		Perms:        v.Perms,
+		Files:        v.Files,
+		Log:          v.Log,
+		Snapshots:    v.Snapshots,
+		CallFunction: v.CallFunction,
+		Stats:        v.stats,
+		Values:       v.countValues,
+		Eval:         v.eval,
+		Load:         v.load,
+		AtExit:       func(fn interface{}) { v.atExit = append(v.atExit, valueOf(fn)) },
+		OnSignal:     v.onSignal,
+		SetTimer:     v.setTimer,
+		CancelTimer:  v.cancelTimer,
+		Sleep:        v.sleep,
+	}
+	v.startLimits()
+	v.startMemory()
+}
+
+func (v *VM) run(ctx context.Context, ip int) error {
+	v.prepare()
+	v.start(ctx)
+	v.Sp = 0
+	if len(v.topLocals) < v.maxLocals {
+		v.topLocals = append(v.topLocals, make([]Value, v.maxLocals-len(v.topLocals))...)
+	}
+	v.CallStack = append(v.CallStack[:0], Frame{Instructions: v.Program.Instructions, Ip: ip, Sp: 0, Entry: -1, Locals: v.topLocals})
+	return v.execute(0)
+}
+
+// execute runs until the call stack is back at depth. A panic in an op,
+// which only a malformed program causes, is returned as a runtime error.
+func (v *VM) execute(depth int) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = v.recovered(r)
+		}
+	}()
+	for len(v.CallStack) > depth {
+		f := &v.CallStack[len(v.CallStack)-1]
+		currentStackDepth := len(v.CallStack)
+		for f.Ip < len(v.ops) {
+			ip := f.Ip
+			op := v.ops[ip]
+			f.Ip++
+			v.executed++
+			if v.executed > v.nextCheck {
+				if err := v.checkpoint(); err != nil {
+					return v.errorAt(ip, err)
+				}
+				// a signal handler may have grown the call stack
+				f = &v.CallStack[len(v.CallStack)-1]
+			}
+			if err := op(v, f); err != nil {
+				if err == errHalt {
+					return nil
+				}
+				return v.errorAt(ip, err)
+			}
+			if len(v.CallStack) != currentStackDepth || f != &v.CallStack[currentStackDepth-1] {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+var errStackUnderflow = errors.New("stack underflow")
+
+// recovered turns a panic into an error at the instruction that was
+// running.
+func (v *VM) recovered(r interface{}) error {
+	err, ok := r.(error)
+	if !ok || (err != errStackUnderflow && !errors.Is(err, ErrStackOverflow)) {
+		err = fmt.Errorf("internal error: %v", r)
+	}
+	if n := len(v.CallStack); n > 0 {
+		if ip := v.CallStack[n-1].Ip - 1; ip >= 0 && ip < len(v.Program.Instructions) {
+			return v.errorAt(ip, err)
+		}
+	}
+	return err
+}
+
+// errorAt attaches the source position of instruction ip and the call
+// stack to err, unless a nested call already did.
+func (v *VM) errorAt(ip int, err error) error {
+	var se *parser.SourceError
+	if errors.As(err, &se) {
+		return err
+	}
+	file, pos := v.position(ip)
+	trace := v.traceback(ip)
+	if pos.Line == 0 && trace == nil {
+		return err
+	}
+	return &parser.SourceError{File: file, Pos: pos, Err: err, Trace: trace}
+}
+
+// position returns the source file, if known, and position of instruction ip.
+func (v *VM) position(ip int) (string, parser.Pos) {
+	if file, pos, ok := v.Program.SourceMap.Lookup(ip); ok {
+		return file, pos
+	}
+	inst := v.Program.Instructions[ip]
+	return "", parser.Pos{Line: inst.Line, Col: inst.Col}
+}
+
+// CallFunction calls a lightlang function from a builtin.
+func (v *VM) CallFunction(fn interface{}, args []interface{}) (interface{}, error) {
+	vals := make([]Value, len(args))
+	for i, arg := range args {
+		vals[i] = valueOf(arg)
+	}
+	res, err := v.CallValue(valueOf(fn), vals...)
+	if err != nil {
+		return nil, err
+	}
+	return res.Interface(), nil
+}
+
+// CallValue calls a lightlang function value, such as one passed to a host
+// function.
+func (v *VM) CallValue(fn Value, args ...Value) (Value, error) {
+	entry, ok := fn.Function()
+	if !ok {
+		return NilValue, fmt.Errorf("cannot call non-function")
+	}
+	depth := len(v.CallStack)
+	if depth >= v.MaxCallDepth {
+		return NilValue, v.overflow()
+	}
+	baseSp := v.Sp
+	locals := v.newLocals(entry, args)
+	v.CallStack = append(v.CallStack, Frame{
+		Instructions: v.Program.Instructions,
+		Ip:           entry,
+		Sp:           baseSp,
+		ArgCount:     len(args),
+		Entry:        entry,
+		Locals:       locals,
+	})
+	v.peakCalls = max(v.peakCalls, len(v.CallStack))
+	if err := v.execute(depth); err != nil {
+		v.CallStack = v.CallStack[:depth]
+		v.Sp = baseSp
+		return NilValue, err
+	}
+	return v.pop(), nil
+}
+
+func (v *VM) push(val Value) {
+	if v.Sp >= len(v.Stack) {
+		v.growStack()
+	}
+	v.Stack[v.Sp] = val
+	v.Sp++
+}
+
+// growStack makes the operand stack half as big again, up to MaxStack. At
+// MaxStack it panics, which execute returns as a stack overflow error.
+func (v *VM) growStack() {
+	size := max(len(v.Stack)+len(v.Stack)>>1, 64)
+	if v.MaxStack > 0 {
+		if len(v.Stack) >= v.MaxStack {
+			panic(fmt.Errorf("%w: more than %d values on the operand stack", ErrStackOverflow, v.MaxStack))
+		}
+		size = min(size, v.MaxStack)
+	}
+	newStack := make([]Value, size)
+	copy(newStack, v.Stack)
+	v.Stack = newStack
+}
+
+func (v *VM) stats() builtins.VMStats {
+	return builtins.VMStats{
+		Instructions:  v.executed,
+		StackDepth:    v.Sp,
+		PeakStack:     max(v.peakSp, v.Sp),
+		CallDepth:     len(v.CallStack),
+		PeakCallDepth: max(v.peakCalls, len(v.CallStack)),
+		Globals:       len(v.Globals),
+	}
+}
+
+func (v *VM) pop() Value {
+	if v.Sp <= 0 {
+		panic(errStackUnderflow)
+	}
+	v.Sp--
+	return v.Stack[v.Sp]
+}